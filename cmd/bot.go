@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange/okx"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange/paper"
+	"github.com/twoonefour/sigmaflow/internal/config"
+	"github.com/twoonefour/sigmaflow/internal/service/guard"
+	"github.com/twoonefour/sigmaflow/internal/service/journal"
+	"github.com/twoonefour/sigmaflow/internal/service/llm"
+	"github.com/twoonefour/sigmaflow/internal/service/notify"
+	"github.com/twoonefour/sigmaflow/internal/service/trade"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"github.com/twoonefour/sigmaflow/pkg/llm/anthropic"
+	"github.com/twoonefour/sigmaflow/pkg/llm/gemini"
+	"github.com/twoonefour/sigmaflow/pkg/llm/openai"
+	"log"
+	"time"
+)
+
+// bot is one strategy wired to its account.
+type bot struct {
+	name     string
+	pairs    []currency.Pair
+	schedule string
+	timeout  time.Duration // of a run, per pair
+	market   trade.Market  // the exchange, or the paper account on top of it
+	trade    *trade.Service
+	guard    *guard.Service
+	events   config.Notifications
+	webhook  *notify.Webhook
+}
+
+// Dependency Injection
+func di(cfg *config.Config, s config.Strategy, _llm *llm.Service, store *journal.Store) (*bot, error) {
+	market, feed, err := newMarket(cfg, s)
+	if err != nil {
+		return nil, err
+	}
+	b := &bot{
+		name:     s.Name,
+		pairs:    s.CurrencyPairs(),
+		schedule: s.Schedule,
+		timeout:  s.Timeout,
+		market:   market,
+		events:   cfg.Notifications,
+	}
+	if cfg.Notifications.Webhook != "" {
+		b.webhook = notify.NewWebhook(cfg.Notifications.Webhook)
+	}
+	frames := make([]trade.Strategy, 0, len(s.Context))
+	for _, f := range s.Context {
+		frames = append(frames, trade.Strategy{Timeframe: f.Timeframe, Lookback: f.Lookback, Warmup: f.Warmup, Indicators: f.Indicators})
+	}
+	opts := []trade.Option{
+		trade.WithMaxExposure(s.Risk.MaxExposure),
+		trade.WithJournal(store),
+		trade.WithStrategy(strategyOf(s)),
+		trade.WithContext(frames...),
+	}
+	guarded := b.market
+	if s.Advisory {
+		// 只给建议: 订单记录在影子组合中, 不发往交易所
+		shadow, err := newPaper(b.market, s, cfg.ShadowState(s))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, trade.WithAdvisory(shadow))
+		guarded = shadow
+	}
+	if s.GuardEnabled() {
+		b.guard = guard.NewService(guarded, store, guard.Options{Feed: feed, Strategy: s.Name, Advisory: s.Advisory})
+		if err = b.guard.Restore(context.Background(), store, b.pairs); err != nil {
+			return nil, err
+		}
+		opts = append(opts, trade.WithWatcher(b.guard))
+	}
+	b.trade = trade.NewTradeService(b.market, _llm, opts...)
+	return b, nil
+}
+
+// newMarket connects the account of s, wrapped in the paper account when s paper trades. The
+// feed streams prices to the guard, nil where it polls.
+func newMarket(cfg *config.Config, s config.Strategy) (trade.Market, guard.Feed, error) {
+	client, err := exchange.New(cfg.Account(s))
+	if err != nil {
+		return nil, nil, err
+	}
+	// only OKX streams prices, on other exchanges the guard polls
+	var feed guard.Feed
+	if _okx, ok := client.(*okx.Client); ok {
+		feed = okxFeed(_okx)
+	}
+	if !s.Paper.Enabled {
+		return client, feed, nil
+	}
+	// 本地模拟盘: 行情来自交易所, 成交与账本在本地
+	paperClient, err := newPaper(client, s, cfg.PaperState(s))
+	if err != nil {
+		return nil, nil, err
+	}
+	return paperClient, feed, nil
+}
+
+// newPaper opens a local ledger at statePath on the prices of market, with the paper
+// settings of s.
+func newPaper(market trade.Market, s config.Strategy, statePath string) (*paper.Client, error) {
+	return paper.NewPaperClient(market, paper.Options{
+		Initial:   map[currency.Coin]float64{currency.USDT: s.Paper.Cash},
+		Slippage:  s.Paper.SlippageRate(),
+		TakerFee:  s.Paper.FeeRate(),
+		StatePath: statePath,
+	})
+}
+
+// strategyOf returns the candle window of s.
+func strategyOf(s config.Strategy) trade.Strategy {
+	return trade.Strategy{Name: s.Name, Timeframe: s.Timeframe, Lookback: s.Lookback, Warmup: s.Warmup, Indicators: s.Indicators}
+}
+
+// okxFeed adapts the OKX tickers channel to guard.Feed.
+func okxFeed(c *okx.Client) guard.Feed {
+	return guard.FeedFunc(func(ctx context.Context, instIds ...string) (<-chan guard.Tick, error) {
+		tickers, err := c.SubscribeTickers(ctx, instIds...)
+		if err != nil {
+			return nil, err
+		}
+		ticks := make(chan guard.Tick)
+		go func() {
+			defer close(ticks)
+			for t := range tickers {
+				select {
+				case ticks <- guard.Tick{InstId: t.InstId, Price: t.Last.Float64()}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return ticks, nil
+	})
+}
+
+// newLLM builds the analysis service. Every provider is asked once per temperature; more than
+// one advisor enables consensus voting, with cfg.Quorum votes required.
+func newLLM(cfg config.LLM) (*llm.Service, error) {
+	temperatures := make([]*float64, 0, len(cfg.Temperatures))
+	for i := range cfg.Temperatures {
+		temperatures = append(temperatures, &cfg.Temperatures[i])
+	}
+	if len(temperatures) == 0 {
+		temperatures = append(temperatures, nil)
+	}
+
+	advisors := make([]llm.Advisor, 0)
+	for _, provider := range cfg.Providers {
+		for _, t := range temperatures {
+			advisor, err := newAdvisor(provider, cfg.Provider(provider), t)
+			if err != nil {
+				return nil, err
+			}
+			advisors = append(advisors, advisor)
+		}
+	}
+	if len(advisors) == 1 {
+		return llm.NewClient(advisors[0])
+	}
+	return llm.NewConsensusClient(llm.ConsensusRule{Quorum: cfg.Quorum}, advisors...)
+}
+
+// newAdvisor builds one LLM client; temperature nil keeps the provider default.
+func newAdvisor(provider string, p *config.Provider, temperature *float64) (llm.Advisor, error) {
+	switch provider {
+	case "gemini":
+		client, err := gemini.NewClient(p.APIKey, p.Model, p.Thinking())
+		if err != nil || temperature == nil {
+			return client, err
+		}
+		return client.WithTemperature(*temperature), nil
+	case "openai":
+		client, err := openai.NewClient(p.BaseURL, p.APIKey, p.Model, p.JSONMode == nil || *p.JSONMode)
+		if err != nil || temperature == nil {
+			return client, err
+		}
+		return client.WithTemperature(*temperature), nil
+	case "anthropic":
+		client, err := anthropic.NewClient(p.BaseURL, p.APIKey, p.Model, p.Thinking())
+		if err != nil || temperature == nil {
+			return client, err
+		}
+		return client.WithTemperature(*temperature), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q", provider)
+	}
+}
+
+// context bounds one run of the strategy.
+func (b *bot) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), b.timeout*time.Duration(len(b.pairs)))
+}
+
+// once runs the strategy a single time. With dryRun the pairs are only analyzed and the buys
+// sized to the budget: no order is placed and nothing is journaled or notified.
+func (b *bot) once(ctx context.Context, dryRun bool) ([]trade.PairDecision, error) {
+	if dryRun {
+		return b.trade.PlanPortfolio(ctx, b.pairs)
+	}
+	decisions, err := b.trade.ExecutePortfolio(ctx, b.pairs)
+	for _, d := range decisions {
+		instId := trade.InstId(d.Pair)
+		if d.Err != nil {
+			b.notify(ctx, config.EventError, fmt.Sprintf("%s 执行失败: %s", instId, d.Err.Error()))
+		} else if d.Result != nil {
+			text := fmt.Sprintf("%s %s 成交 %g @ %g, 订单 %s\n理由: %s", instId, d.Result.Side, d.Result.FilledSize, d.Result.AvgPrice, d.Result.OrderId, d.Decision.Reason)
+			if b.trade.Advisory() {
+				text = "建议 (影子组合) " + text
+			}
+			b.notify(ctx, config.EventTrade, text)
+		}
+	}
+	if err != nil {
+		b.notify(ctx, config.EventError, err.Error())
+	}
+	return decisions, err
+}
+
+// run is the scheduled run of the daemon, the outcome is logged.
+func (b *bot) run() {
+	ctx, cancel := b.context()
+	defer cancel()
+	decisions, err := b.once(ctx, false)
+	for _, d := range decisions {
+		if d.Decision != nil {
+			log.Println(fmt.Sprintf("[%s] %s AI决策:%s, 数量：%.2f %%, 理由：%s", b.name, trade.InstId(d.Pair), d.Decision.Action, d.Decision.PositionPct*100, d.Decision.Reason))
+		}
+		if d.Err != nil {
+			log.Println(fmt.Sprintf("[%s] %s 执行失败: %s", b.name, trade.InstId(d.Pair), d.Err.Error()))
+		}
+	}
+	if err != nil {
+		log.Println(fmt.Sprintf("[%s] %s", b.name, err.Error()))
+		return
+	}
+	coins := []currency.Coin{b.pairs[0].Base}
+	for _, p := range b.pairs {
+		coins = append(coins, p.Quote)
+	}
+	AfterOrder, err := b.trade.GetBalance(ctx, coins...)
+	if err != nil {
+		log.Println(fmt.Sprintf("[%s] %s", b.name, err.Error()))
+		return
+	}
+	for _, c := range coins {
+		log.Println(fmt.Sprintf("[%s] 目前剩余(单位USD) %s:%.2f", b.name, c.String(), AfterOrder.AccountAssets[c].EquityUSD))
+	}
+}
+
+// notify posts text to the webhook when event is enabled, prefixed with the strategy name.
+func (b *bot) notify(ctx context.Context, event, text string) {
+	if b.webhook == nil || !b.events.Notify(event) {
+		return
+	}
+	// the run context may be used up already
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if err := b.webhook.Send(ctx, fmt.Sprintf("[%s] %s", b.name, text)); err != nil {
+		log.Println(fmt.Sprintf("[%s] 通知失败: %s", b.name, err.Error()))
+	}
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange/paper"
+	"github.com/twoonefour/sigmaflow/internal/config"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/internal/service/backtest"
+	"github.com/twoonefour/sigmaflow/internal/service/journal"
+	"github.com/twoonefour/sigmaflow/internal/service/trade"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// newBots wires the strategy called name, every strategy for an empty name.
+func newBots(cfg *config.Config, name string, store *journal.Store) ([]*bot, error) {
+	strategies := cfg.Strategies
+	if name != "" {
+		s, err := strategyNamed(cfg, name)
+		if err != nil {
+			return nil, err
+		}
+		strategies = []config.Strategy{s}
+	}
+	_llm, err := newLLM(cfg.LLM)
+	if err != nil {
+		return nil, err
+	}
+	bots := make([]*bot, 0, len(strategies))
+	for _, s := range strategies {
+		b, err := di(cfg, s, _llm, store)
+		if err != nil {
+			return nil, fmt.Errorf("[%s] %w", s.Name, err)
+		}
+		bots = append(bots, b)
+	}
+	return bots, nil
+}
+
+// strategyNamed returns the strategy called name, the first one for an empty name.
+func strategyNamed(cfg *config.Config, name string) (config.Strategy, error) {
+	if name == "" {
+		return cfg.Strategies[0], nil
+	}
+	for _, s := range cfg.Strategies {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return config.Strategy{}, fmt.Errorf("unknown strategy %q", name)
+}
+
+// accountOf returns the account the manual commands of strategy s act on: its market, or the
+// shadow portfolio of an advisory strategy, which must never reach the exchange.
+func accountOf(cfg *config.Config, s config.Strategy) (trade.Market, error) {
+	market, _, err := newMarket(cfg, s)
+	if err != nil {
+		return nil, err
+	}
+	if !s.Advisory {
+		return market, nil
+	}
+	return newPaper(market, s, cfg.ShadowState(s))
+}
+
+// pairOf parses a coin like BTC into a pair of strategy s, its first pair for an empty coin.
+func pairOf(s config.Strategy, coin string) currency.Pair {
+	if coin == "" {
+		return s.CurrencyPairs()[0]
+	}
+	return currency.NewPair(currency.USDT, currency.Coin(strings.ToUpper(coin)))
+}
+
+// decisionView is one pair of a run.
+type decisionView struct {
+	Strategy    string             `json:"strategy"`
+	Pair        string             `json:"pair"`
+	Action      string             `json:"action,omitempty"`
+	PositionPct float64            `json:"position_pct"`
+	Amount      string             `json:"amount,omitempty"`
+	StopLoss    float64            `json:"stop_loss_price,omitempty"`
+	TakeProfit  float64            `json:"take_profit_price,omitempty"`
+	Reason      string             `json:"reason,omitempty"`
+	Order       *model.OrderResult `json:"order,omitempty"`
+	Advisory    bool               `json:"advisory,omitempty"` // Order was filled in the shadow portfolio
+	Error       string             `json:"error,omitempty"`
+}
+
+// onceCmd runs the strategies a single time and prints the decisions.
+func onceCmd(args []string) error {
+	f := newFlags("once")
+	name := f.String("strategy", "", "只运行该策略, 默认运行所有策略")
+	dryRun := f.Bool("dry-run", false, "只分析, 不下单也不写日志")
+	_ = f.Parse(args)
+	cfg, err := f.load()
+	if err != nil {
+		return err
+	}
+	store, err := journal.Open(cfg.Storage.Journal)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	bots, err := newBots(cfg, *name, store)
+	if err != nil {
+		return err
+	}
+	res := make([]decisionView, 0)
+	var errs []error
+	for _, b := range bots {
+		ctx, cancel := b.context()
+		decisions, err := b.once(ctx, *dryRun)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("[%s] %w", b.name, err))
+		}
+		for _, d := range decisions {
+			v := decisionView{Strategy: b.name, Pair: trade.InstId(d.Pair), Order: d.Result, Advisory: b.trade.Advisory()}
+			if d.Decision != nil {
+				v.Action, v.PositionPct, v.Amount, v.Reason = d.Decision.Action, d.Decision.PositionPct, d.Decision.Amount, d.Decision.Reason
+				v.StopLoss, v.TakeProfit = d.Decision.StopLossPrice, d.Decision.TakeProfitPrice
+			}
+			if d.Err != nil {
+				v.Error = d.Err.Error()
+			}
+			res = append(res, v)
+		}
+	}
+	err = f.output(res, func(w io.Writer) {
+		if *dryRun {
+			fmt.Fprintln(w, "试运行, 未下单")
+		}
+		fmt.Fprintln(w, "策略\t交易对\t决策\t仓位\t数量\t止损\t止盈\t订单\t理由")
+		for _, v := range res {
+			order := "-"
+			if v.Order != nil {
+				order = fmt.Sprintf("%s %g @ %g", v.Order.State, v.Order.FilledSize, v.Order.AvgPrice)
+				if v.Advisory {
+					order += " (影子组合)"
+				}
+			}
+			reason := v.Reason
+			if v.Error != "" {
+				reason = "失败: " + v.Error
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%.0f%%\t%s\t%g\t%g\t%s\t%s\n", v.Strategy, v.Pair, v.Action, v.PositionPct*100, v.Amount, v.StopLoss, v.TakeProfit, order, reason)
+		}
+	})
+	return errors.Join(append([]error{err}, errs...)...)
+}
+
+// balanceCmd prints the balance of the account of a strategy.
+func balanceCmd(args []string) error {
+	f := newFlags("balance")
+	name := f.String("strategy", "", "策略, 默认第一个")
+	_ = f.Parse(args)
+	cfg, err := f.loadMarket()
+	if err != nil {
+		return err
+	}
+	s, err := strategyNamed(cfg, *name)
+	if err != nil {
+		return err
+	}
+	market, err := accountOf(cfg, s)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	balance, err := market.GetBalance(ctx)
+	if err != nil {
+		return err
+	}
+	type assetView struct {
+		Coin          currency.Coin `json:"coin"`
+		Equity        float64       `json:"equity"`
+		EquityUSD     float64       `json:"equity_usd"`
+		AvgPrice      float64       `json:"avg_price,omitempty"`
+		UnrealizedPNL float64       `json:"unrealized_pnl,omitempty"`
+	}
+	res := struct {
+		Strategy    string      `json:"strategy"`
+		Account     string      `json:"account"`
+		Paper       bool        `json:"paper"`
+		Advisory    bool        `json:"advisory"` // the shadow portfolio of the strategy
+		TotalEquity float64     `json:"total_equity"`
+		Assets      []assetView `json:"assets"`
+	}{s.Name, s.Account, s.Paper.Enabled, s.Advisory, balance.TotalEquity, make([]assetView, 0, len(balance.AccountAssets))}
+	for c, a := range balance.AccountAssets {
+		res.Assets = append(res.Assets, assetView{c, a.Equity, a.EquityUSD, a.AVGPrice, a.UnrealizedPNL})
+	}
+	slices.SortFunc(res.Assets, func(a, b assetView) int {
+		if c := cmp.Compare(b.EquityUSD, a.EquityUSD); c != 0 {
+			return c
+		}
+		return strings.Compare(string(a.Coin), string(b.Coin))
+	})
+	return f.output(res, func(w io.Writer) {
+		account := res.Account
+		if res.Paper {
+			account += " (模拟盘)"
+		}
+		if res.Advisory {
+			account = res.Strategy + " 的影子组合"
+		}
+		fmt.Fprintf(w, "账户: %s, 总资产: %.2f USD\n", account, res.TotalEquity)
+		fmt.Fprintln(w, "币种\t数量\t价值(USD)\t均价\t未实现盈亏")
+		for _, a := range res.Assets {
+			fmt.Fprintf(w, "%s\t%g\t%.2f\t%g\t%.2f\n", a.Coin, a.Equity, a.EquityUSD, a.AvgPrice, a.UnrealizedPNL)
+		}
+	})
+}
+
+// candlesCmd prints the latest candles of a pair, newest first.
+func candlesCmd(args []string) error {
+	f := newFlags("candles")
+	name := f.String("strategy", "", "策略, 默认第一个")
+	coin := f.String("pair", "", "币种, 如 BTC, 默认策略的第一个交易对")
+	timeframe := f.String("timeframe", "", "K线周期, 默认策略的周期")
+	n := f.Int("n", 20, "K线数量")
+	_ = f.Parse(args)
+	cfg, err := f.loadMarket()
+	if err != nil {
+		return err
+	}
+	s, err := strategyNamed(cfg, *name)
+	if err != nil {
+		return err
+	}
+	tf := s.Timeframe
+	if *timeframe != "" {
+		if tf, err = model.ParseTimeframe(*timeframe); err != nil {
+			return err
+		}
+	}
+	market, _, err := newMarket(cfg, s)
+	if err != nil {
+		return err
+	}
+	pair := pairOf(s, *coin)
+	candles, err := market.GetCandle(pair, tf, *n)
+	if err != nil {
+		return err
+	}
+	return f.output(candles, func(w io.Writer) {
+		fmt.Fprintf(w, "%s %s\n", trade.InstId(pair), tf)
+		fmt.Fprintln(w, "时间\t开\t高\t低\t收\t成交量\t")
+		for _, c := range candles {
+			open := ""
+			if c.Confirm == "0" {
+				open = "未收盘"
+			}
+			fmt.Fprintf(w, "%s\t%g\t%g\t%g\t%g\t%g\t%s\n", tf.FormatTs(c.Ts), c.O, c.H, c.L, c.C, c.Vol, open)
+		}
+	})
+}
+
+// backtestCmd replays the model over past candles of a pair.
+func backtestCmd(args []string) error {
+	f := newFlags("backtest")
+	name := f.String("strategy", "", "策略, 默认第一个")
+	coin := f.String("pair", "", "币种, 如 BTC, 默认策略的第一个交易对")
+	file := f.String("file", "", "回测K线文件(.csv/.json)，文件不存在时从交易所下载并缓存")
+	candles := f.Int("candles", 500, "回测下载的K线数量")
+	cash := f.Float64("cash", 10000, "回测初始USDT")
+	fee := f.Float64("fee", 0.001, "回测手续费率")
+	_ = f.Parse(args)
+	if *file == "" {
+		return errors.New("backtest: -file is required")
+	}
+	cfg, err := f.load()
+	if err != nil {
+		return err
+	}
+	s, err := strategyNamed(cfg, *name)
+	if err != nil {
+		return err
+	}
+	market, _, err := newMarket(cfg, s)
+	if err != nil {
+		return err
+	}
+	_llm, err := newLLM(cfg.LLM)
+	if err != nil {
+		return err
+	}
+	pair := pairOf(s, *coin)
+	strategy := strategyOf(s).WithDefaults()
+	history, err := backtest.FetchCandles(market, pair, strategy.Timeframe, *candles, *file)
+	if err != nil {
+		return err
+	}
+	report, err := backtest.NewService(_llm, backtest.Options{
+		InitialCash: *cash,
+		Fee:         *fee,
+		Strategy:    strategy,
+	}).Run(context.Background(), pair, history)
+	if err != nil {
+		return err
+	}
+	type pointView struct {
+		Ts     string  `json:"ts"`
+		Equity float64 `json:"equity"`
+		Action string  `json:"action"`
+	}
+	res := struct {
+		Pair          string       `json:"pair"`
+		Timeframe     string       `json:"timeframe"`
+		InitialEquity float64      `json:"initial_equity"`
+		FinalEquity   float64      `json:"final_equity"`
+		TotalReturn   float64      `json:"total_return"`
+		MaxDrawdown   float64      `json:"max_drawdown"`
+		WinRate       float64      `json:"win_rate"`
+		Trades        int          `json:"trades"`
+		Errors        int          `json:"errors"`
+		Fills         []paper.Fill `json:"fills"`
+		EquityCurve   []pointView  `json:"equity_curve"`
+	}{
+		trade.InstId(pair), string(report.Timeframe), report.InitialEquity, report.FinalEquity,
+		report.TotalReturn, report.MaxDrawdown, report.WinRate, report.Trades, report.Errors,
+		report.Fills, make([]pointView, 0, len(report.EquityCurve)),
+	}
+	for _, p := range report.EquityCurve {
+		res.EquityCurve = append(res.EquityCurve, pointView{p.Ts, p.Equity, p.Action})
+	}
+	return f.output(res, func(w io.Writer) {
+		fmt.Fprint(w, report.String())
+	})
+}
+
+// recordView is a journal entry.
+type recordView struct {
+	ID            int64               `json:"id"`
+	Ts            time.Time           `json:"ts"`
+	Pair          string              `json:"pair"`
+	Strategy      string              `json:"strategy,omitempty"`
+	Decision      *model.Decision     `json:"decision,omitempty"`
+	Order         *model.OrderRequest `json:"order,omitempty"`
+	OrderResult   *model.OrderResult  `json:"order_result,omitempty"`
+	OrderError    string              `json:"order_error,omitempty"`
+	Error         string              `json:"error,omitempty"`
+	Prompt        string              `json:"prompt,omitempty"`
+	Response      string              `json:"response,omitempty"`
+	BalanceBefore *model.TradeData    `json:"balance_before,omitempty"`
+	BalanceAfter  *model.TradeData    `json:"balance_after,omitempty"`
+	Advisory      bool                `json:"advisory,omitempty"`
+}
+
+func viewOf(r *model.RunRecord) recordView {
+	return recordView{r.ID, r.Ts, r.Pair, r.Strategy, r.Decision, r.Order, r.OrderResult, r.OrderError, r.Error, r.Prompt, r.Response, r.BalanceBefore, r.BalanceAfter, r.Advisory}
+}
+
+// summary is the outcome of r in a few words.
+func (r recordView) summary() (action, outcome string) {
+	action = "-"
+	if r.Decision != nil {
+		action = r.Decision.Action
+	} else if r.Order != nil {
+		action = "手动"
+	}
+	if r.Advisory {
+		action = "建议 " + action
+	}
+	switch {
+	case r.Error != "":
+		outcome = "失败: " + r.Error
+	case r.OrderError != "":
+		outcome = "下单失败: " + r.OrderError
+	case r.OrderResult != nil:
+		outcome = fmt.Sprintf("%s %s %g @ %g", r.OrderResult.Side, r.OrderResult.State, r.OrderResult.FilledSize, r.OrderResult.AvgPrice)
+	case r.Decision != nil:
+		outcome = r.Decision.Reason
+	}
+	return action, outcome
+}
+
+// orDash returns s, or "-" when it is empty, e.g. the strategy of an entry journaled before
+// strategies were kept.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// journalCmd lists the journal or shows one entry of it.
+func journalCmd(args []string) error {
+	if len(args) == 0 || (args[0] != "list" && args[0] != "show") {
+		return errors.New("用法: sigmaflow journal list [-pair BTC] [-strategy 名称] [-n 20] | show <id>")
+	}
+	f := newFlags("journal " + args[0])
+	coin := f.String("pair", "", "只看该币种, 如 BTC")
+	name := f.String("strategy", "", "只看该策略")
+	n := f.Int("n", 20, "条数")
+	_ = f.Parse(args[1:])
+	cfg, err := f.loadMarket()
+	if err != nil {
+		return err
+	}
+	store, err := journal.Open(cfg.Storage.Journal)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	if args[0] == "show" {
+		id, err := strconv.ParseInt(f.Arg(0), 10, 64)
+		if err != nil {
+			return fmt.Errorf("journal show: invalid id %q", f.Arg(0))
+		}
+		r, err := store.Get(ctx, id)
+		if err != nil {
+			return fmt.Errorf("journal show %d: %w", id, err)
+		}
+		v := viewOf(r)
+		return f.output(v, func(w io.Writer) {
+			action, outcome := v.summary()
+			fmt.Fprintf(w, "#%d %s %s %s %s\n", v.ID, v.Ts.Local().Format(time.DateTime), v.Pair, orDash(v.Strategy), action)
+			if d := v.Decision; d != nil {
+				fmt.Fprintf(w, "仓位: %.0f%%, 数量: %s, 止损: %g, 止盈: %g\n", d.PositionPct*100, d.Amount, d.StopLossPrice, d.TakeProfitPrice)
+			}
+			if v.Order != nil {
+				fmt.Fprintf(w, "订单: %s %s %s\n", v.Order.InstId, v.Order.Side, v.Order.Size)
+			}
+			fmt.Fprintf(w, "结果: %s\n", outcome)
+			if v.Prompt != "" {
+				fmt.Fprintf(w, "\n--- prompt ---\n%s\n", v.Prompt)
+			}
+			if v.Response != "" {
+				fmt.Fprintf(w, "\n--- response ---\n%s\n", v.Response)
+			}
+		})
+	}
+
+	pair := ""
+	if *coin != "" {
+		pair = trade.InstId(pairOf(config.Strategy{}, *coin))
+	}
+	records, err := store.List(ctx, pair, *name, *n)
+	if err != nil {
+		return err
+	}
+	res := make([]recordView, 0, len(records))
+	for _, r := range records {
+		v := viewOf(r)
+		// the list stays short, show has the rest
+		v.Prompt, v.Response, v.BalanceBefore, v.BalanceAfter = "", "", nil, nil
+		res = append(res, v)
+	}
+	return f.output(res, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\t时间\t交易对\t策略\t决策\t结果")
+		for _, v := range res {
+			action, outcome := v.summary()
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", v.ID, v.Ts.Local().Format(time.DateTime), v.Pair, orDash(v.Strategy), action, outcome)
+		}
+	})
+}
+
+// orderCmd places a manual market order on the account of a strategy and journals it, in the
+// shadow portfolio for an advisory strategy (see accountOf). Exchange-side stops and the
+// levels watched by the guard are left alone.
+func orderCmd(args []string) error {
+	f := newFlags("order")
+	name := f.String("strategy", "", "策略, 默认第一个")
+	coin := f.String("pair", "", "币种, 如 BTC")
+	side := f.String("side", "", "buy 或 sell")
+	size := f.String("size", "", "买入为USDT金额, 卖出为币数量")
+	yes := f.Bool("yes", false, "不再确认")
+	_ = f.Parse(args)
+	if *coin == "" || (*side != "buy" && *side != "sell") || *size == "" {
+		return errors.New("用法: sigmaflow order -pair BTC -side buy|sell -size 数量 [-yes]")
+	}
+	if sz, err := strconv.ParseFloat(*size, 64); err != nil || sz <= 0 {
+		return fmt.Errorf("order: invalid size %q", *size)
+	}
+	cfg, err := f.loadMarket()
+	if err != nil {
+		return err
+	}
+	s, err := strategyNamed(cfg, *name)
+	if err != nil {
+		return err
+	}
+	pair := pairOf(s, *coin)
+	instId := trade.InstId(pair)
+	unit := pair.Quote
+	if *side == "buy" {
+		unit = pair.Base
+	}
+	if !*yes {
+		account := s.Account
+		switch {
+		case s.Advisory:
+			account = s.Name + " 的影子组合"
+		case s.Paper.Enabled:
+			account += " (模拟盘)"
+		}
+		fmt.Printf("%s: %s %s %s %s, 确认下单? [y/N] ", account, *side, instId, *size, unit)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return errors.New("已取消")
+		}
+	}
+	market, err := accountOf(cfg, s)
+	if err != nil {
+		return err
+	}
+	store, err := journal.Open(cfg.Storage.Journal)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	coins := []currency.Coin{pair.Base, pair.Quote}
+	before, err := market.GetBalance(ctx, coins...)
+	if err != nil {
+		return err
+	}
+	r := &model.RunRecord{
+		Ts:            time.Now(),
+		Pair:          instId,
+		Strategy:      s.Name,
+		Order:         &model.OrderRequest{InstId: instId, Side: *side, Size: *size},
+		BalanceBefore: before,
+		Advisory:      s.Advisory,
+	}
+	r.OrderResult, err = market.Order(ctx, instId, *side, *size)
+	if err != nil {
+		r.OrderError = err.Error()
+	}
+	r.BalanceAfter, _ = market.GetBalance(ctx, coins...)
+	if jerr := store.Record(context.WithoutCancel(ctx), r); jerr != nil {
+		err = errors.Join(err, jerr)
+	}
+	if err != nil {
+		return err
+	}
+	res := r.OrderResult
+	return f.output(res, func(w io.Writer) {
+		fmt.Fprintf(w, "订单 %s: %s %s %s, 成交 %g @ %g, 手续费 %g %s\n", res.OrderId, res.InstId, res.Side, res.State, res.FilledSize, res.AvgPrice, res.Fee, res.FeeCcy)
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange/paper"
+	"github.com/twoonefour/sigmaflow/internal/config"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"path/filepath"
+	"testing"
+)
+
+func TestAccountOf(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := config.Parse([]byte(`
+accounts:
+  main:
+    exchange: okx
+    api_key: k
+    secret_key: s
+    passphrase: p
+llm:
+  gemini:
+    api_key: g
+storage:
+  shadow: ` + filepath.Join(dir, "shadow.json") + `
+strategies:
+  - name: live
+    account: main
+  - name: advice
+    account: main
+    advisory: true
+    paper:
+      cash: 500
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// manual orders of an advisory strategy go to its shadow portfolio, never to the exchange
+	market, err := accountOf(cfg, cfg.Strategies[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := market.(*paper.Client); !ok {
+		t.Fatalf("expected the shadow portfolio, got %T", market)
+	}
+	balance, err := market.GetBalance(context.Background(), currency.USDT)
+	if err != nil || balance.AccountAssets[currency.USDT].Equity != 500 {
+		t.Errorf("expected the cash of the shadow portfolio, got %+v (%v)", balance, err)
+	}
+
+	market, err = accountOf(cfg, cfg.Strategies[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := market.(*paper.Client); ok {
+		t.Error("a live strategy should trade on the exchange")
+	}
+}
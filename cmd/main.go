@@ -2,105 +2,246 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/joho/godotenv"
-	"github.com/twoonefour/sigmaflow/internal/client/exchange/okx"
+	_ "github.com/twoonefour/sigmaflow/internal/client/exchange/binance"
+	_ "github.com/twoonefour/sigmaflow/internal/client/exchange/bybit"
+	"github.com/twoonefour/sigmaflow/internal/config"
 	"github.com/twoonefour/sigmaflow/internal/service/cron"
-	"github.com/twoonefour/sigmaflow/internal/service/llm"
-	"github.com/twoonefour/sigmaflow/internal/service/trade"
-	"github.com/twoonefour/sigmaflow/pkg/currency"
-	"github.com/twoonefour/sigmaflow/pkg/llm/gemini"
+	"github.com/twoonefour/sigmaflow/internal/service/journal"
+	"io"
 	"log"
 	"os"
-	"time"
+	"slices"
+	"strings"
+	"text/tabwriter"
 )
 
+// command is a subcommand of the CLI.
+type command struct {
+	name  string
+	args  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"run", "", "按计划运行所有策略(默认命令)", runCmd},
+	{"once", "[-strategy 名称] [-dry-run]", "立即运行一次, -dry-run 只分析不下单", onceCmd},
+	{"balance", "[-strategy 名称]", "查看账户余额", balanceCmd},
+	{"candles", "[-strategy 名称] [-pair BTC] [-timeframe 4H] [-n 20]", "查看K线", candlesCmd},
+	{"backtest", "-file 文件 [-pair BTC] [-candles 500] [-cash 10000] [-fee 0.001]", "回测, 文件不存在时从交易所下载并缓存", backtestCmd},
+	{"journal", "list [-pair BTC] [-strategy 名称] [-n 20] | show <id>", "查看运行日志", journalCmd},
+	{"order", "-pair BTC -side buy|sell -size 数量 [-yes]", "手动下市价单, 买入数量为USDT, 卖出数量为币", orderCmd},
+	{"config", "validate", "检查配置, 列出所有错误", configCmd},
+}
+
 func main() {
 	err := godotenv.Load()
 	if err != nil {
 		log.Println("注意: 未找到 .env 文件，将尝试使用系统环境变量")
 	}
-
-	geminiApiKey := os.Getenv("GEMINI_API_KEY")
-	okxSimulate := os.Getenv("OKX_SIMULATE")
-	var okxKey string
-	var okxSecret string
-	var okxPhrase string
-	okxPrefix := "OKX"
-	if okxSimulate == "1" {
-		okxPrefix += "_SIMULATE_"
-	}
-	okxKey = os.Getenv(okxPrefix + "API_KEY")
-	okxSecret = os.Getenv(okxPrefix + "API_SECRET")
-	okxPhrase = os.Getenv(okxPrefix + "API_PASSPHRASE")
-	debugPtr := flag.Bool("debug", false, "开启调试模式")
-	flag.Parse()
-	tradeService, err := di(geminiApiKey, okxKey, okxSecret, okxPhrase, okxSimulate)
-	if err != nil {
+	// without a command the bot runs, as it always did
+	name, args := legacy(os.Args[1:])
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage()
 		return
 	}
-	pair := currency.NewPair(currency.USDT, currency.BTC)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancel()
-	if *debugPtr {
-		if err = run(ctx, tradeService, pair); err != nil {
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if err = c.run(args); err != nil {
 			log.Println(err.Error())
+			os.Exit(1)
 		}
 		return
 	}
-	c := cron.NewService()
-	if err = c.AddCron("1 8 * * *", func() {
-		err := run(ctx, tradeService, pair)
-		if err != nil {
-			log.Println(err.Error())
+	fmt.Fprintf(os.Stderr, "未知命令 %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+// legacy maps the flags of the bot before it had commands onto them: -debug runs once and
+// -backtest FILE backtests FILE. Anything else runs the bot.
+func legacy(args []string) (string, []string) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return "run", args
+	}
+	for i, a := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		switch {
+		case name == "debug" && value != "false":
+			return "once", slices.Delete(slices.Clone(args), i, i+1)
+		case name == "backtest":
+			res := slices.Clone(args)
+			res[i] = "-file"
+			if hasValue {
+				res[i] += "=" + value
+			}
+			return "backtest", res
 		}
-	}); err != nil {
-		log.Println(err.Error())
-		return
 	}
-	select {}
+	return "run", args
 }
 
-// Dependency Injection
-func di(geminiApiKey, okxKey, okxSecret, okxPhrase, okxSimulate string) (*trade.Service, error) {
-	_okx, _ := okx.NewOkxClient(okxPhrase, okxSecret, okxKey, okxSimulate)
-	_gemini, err := gemini.NewClient(geminiApiKey, "gemini-2.5-pro", 32768)
-	if err != nil {
-		return nil, err
+func usage() {
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "用法: sigmaflow <命令> [-config 文件] [-json] [参数]")
+	fmt.Fprintln(w)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s %s\t%s\n", c.name, c.args, c.usage)
 	}
-	_llm, err := llm.NewClient(_gemini)
-	if err != nil {
-		return nil, err
+	_ = w.Flush()
+}
+
+// flags are the flags of a command, with the ones every command shares.
+type flags struct {
+	*flag.FlagSet
+	config *string
+	json   *bool
+}
+
+func newFlags(name string) *flags {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	return &flags{
+		FlagSet: fs,
+		config:  fs.String("config", os.Getenv("CONFIG"), "配置文件(YAML)，未指定时从环境变量读取"),
+		json:    fs.Bool("json", false, "以JSON格式输出"),
 	}
-	tradeService := trade.NewTradeService(_okx, _llm)
-	return tradeService, nil
 }
 
-func run(ctx context.Context, tradeService *trade.Service, pair currency.Pair) error {
-	candle, err := tradeService.GetCandle(pair)
+// load reads the config, every problem of it is in the error.
+func (f *flags) load() (*config.Config, error) {
+	return configured(config.Load(*f.config))
+}
+
+// loadMarket is load for the commands that do not ask the model, the LLM settings may be
+// missing.
+func (f *flags) loadMarket() (*config.Config, error) {
+	return configured(config.LoadMarket(*f.config))
+}
+
+func configured(cfg *config.Config, err error) (*config.Config, error) {
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("配置错误:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// output prints v as JSON with -json, otherwise text writes it for humans, in aligned columns.
+func (f *flags) output(v any, text func(w io.Writer)) error {
+	if *f.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
 	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	text(w)
+	return w.Flush()
+}
 
-	balance, err := tradeService.GetBalance(ctx, pair.Base, pair.Quote)
+// runCmd is the daemon: every strategy runs on its schedule until the process is stopped.
+func runCmd(args []string) error {
+	f := newFlags("run")
+	_ = f.Parse(args)
+	cfg, err := f.load()
 	if err != nil {
 		return err
 	}
-	completion, err := tradeService.AnalyzeMarket(ctx, currency.NewPair(currency.USDT, currency.BTC), balance, candle)
+	store, err := journal.Open(cfg.Storage.Journal)
 	if err != nil {
 		return err
 	}
-	log.Println(fmt.Sprintf("AI决策:%s, 数量：%.2f %%, 理由：%s", completion.Action, completion.PositionPct*100, completion.Reason))
-	err = tradeService.Order(*completion)
+	bots, err := newBots(cfg, "", store)
 	if err != nil {
 		return err
 	}
-	AfterOrder, err := tradeService.GetBalance(ctx, pair.Base, pair.Quote)
+	c := cron.NewService()
+	for _, b := range bots {
+		if err = c.AddCron(b.schedule, b.run); err != nil {
+			return fmt.Errorf("[%s] %w", b.name, err)
+		}
+		if b.guard != nil {
+			// 两次运行之间实时监控止损止盈
+			go func() {
+				if err := b.guard.Run(context.Background()); err != nil {
+					b.notify(context.Background(), config.EventError, fmt.Sprintf("止损监控退出: %s", err.Error()))
+					log.Println(err.Error())
+				}
+			}()
+		}
+	}
+	select {}
+}
+
+// configCmd is `config validate`: it loads the config like the bot does and lists every
+// problem found.
+func configCmd(args []string) error {
+	if len(args) == 0 || args[0] != "validate" {
+		return errors.New("用法: sigmaflow config validate [-config 文件] [-json]")
+	}
+	f := newFlags("config validate")
+	_ = f.Parse(args[1:])
+	type strategyView struct {
+		Name     string   `json:"name"`
+		Account  string   `json:"account"`
+		Exchange string   `json:"exchange"`
+		Paper    bool     `json:"paper"`
+		Advisory bool     `json:"advisory"`
+		Pairs    []string `json:"pairs"`
+		Schedule string   `json:"schedule"`
+	}
+	var res struct {
+		Valid      bool           `json:"valid"`
+		Errors     []string       `json:"errors,omitempty"`
+		Strategies []strategyView `json:"strategies,omitempty"`
+	}
+	cfg, err := config.Load(*f.config)
 	if err != nil {
-		return err
+		errs := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			errs = joined.Unwrap()
+		}
+		for _, e := range errs {
+			res.Errors = append(res.Errors, e.Error())
+		}
+	} else {
+		res.Valid = true
+		for _, s := range cfg.Strategies {
+			res.Strategies = append(res.Strategies, strategyView{s.Name, s.Account, cfg.Accounts[s.Account].Exchange, s.Paper.Enabled, s.Advisory, s.Pairs, s.Schedule})
+		}
+	}
+	err = f.output(res, func(w io.Writer) {
+		if !res.Valid {
+			fmt.Fprintf(w, "配置无效, %d 个错误:\n", len(res.Errors))
+			for _, e := range res.Errors {
+				fmt.Fprintln(w, "  "+e)
+			}
+			return
+		}
+		fmt.Fprintln(w, "策略\t账户\t交易所\t模式\t交易对\t计划")
+		for _, s := range res.Strategies {
+			mode := "实盘"
+			switch {
+			case s.Paper:
+				mode = "模拟盘"
+			case s.Advisory:
+				mode = "只给建议"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Name, s.Account, s.Exchange, mode, strings.Join(s.Pairs, ","), s.Schedule)
+		}
+		fmt.Fprintln(w, "配置有效")
+	})
+	if err == nil && !res.Valid {
+		// the errors are printed already, only the exit code is left
+		os.Exit(1)
 	}
-	log.Println(fmt.Sprintf("目前剩余(单位USD) %s:%.2f, %s:%.2f",
-		pair.Base.String(), AfterOrder.AccountAssets[pair.Base].EquityUSD, pair.Quote.String(), AfterOrder.AccountAssets[pair.Quote].EquityUSD))
-	return nil
+	return err
 }
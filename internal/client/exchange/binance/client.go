@@ -0,0 +1,270 @@
+package binance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"net/http"
+	"net/url"
+	"resty.dev/v3"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	restApiBase    = "https://api.binance.com"
+	testnetApiBase = "https://testnet.binance.vision"
+	recvWindow     = "5000"
+)
+
+// Client is a Binance spot account implementing trade.Market.
+type Client struct {
+	restClient *resty.Client
+	apiKey     string
+	secretKey  string
+
+	orders      exchange.OrderWait
+	instruments *exchange.Instruments // by symbol
+	limiter     *exchange.Limiter
+	retry       exchange.Retry
+}
+
+func init() {
+	exchange.Register("binance", func(a exchange.Account) (exchange.Client, error) {
+		c, err := NewBinanceClient(a.APIKey, a.SecretKey, a.Demo)
+		if err != nil {
+			return nil, err
+		}
+		if a.BaseURL != "" {
+			c.restClient.SetBaseURL(a.BaseURL)
+		}
+		return c, nil
+	})
+}
+
+func NewBinanceClient(apiKey, secretKey string, testnet bool) (*Client, error) {
+	_binanceClient := &Client{
+		apiKey:    apiKey,
+		secretKey: secretKey,
+		orders:    exchange.DefaultOrderWait("binance"),
+		limiter:   exchange.NewLimiter(endpoints, defaultEndpoint),
+		retry:     exchange.DefaultRetry("binance"),
+	}
+	_binanceClient.instruments = exchange.NewInstruments("binance", _binanceClient.getInstrument)
+	base := restApiBase
+	if testnet {
+		base = testnetApiBase
+	}
+	_binanceClient.restClient = exchange.NewRestClient(base).SetHeader("X-MBX-APIKEY", apiKey)
+	return _binanceClient, nil
+}
+
+// doRestyRequest sends req, signing its query with a timestamp when signed is set. Binance
+// takes the parameters of every method in the query string. Requests wait for the rate
+// limit of the endpoint and are retried with backoff while exchange.Retryable allows it.
+func (bc *Client) doRestyRequest(req *resty.Request, method, path string, signed bool) error {
+	params := req.QueryParams
+	if signed {
+		// the signature has to follow the exact query it signs, it is built by send
+		req.QueryParams = url.Values{}
+	}
+	return bc.retry.Do(req.Context(), bc.limiter, method, path, func() error {
+		return bc.send(req, method, path, params, signed)
+	})
+}
+
+// send signs params with a fresh timestamp and executes req once.
+func (bc *Client) send(req *resty.Request, method, path string, params url.Values, signed bool) error {
+	target := path
+	if signed {
+		query := url.Values{}
+		for k, v := range params {
+			query[k] = v
+		}
+		query.Set("timestamp", strconv.FormatInt(time.Now().UnixMilli(), 10))
+		query.Set("recvWindow", recvWindow)
+		encoded := query.Encode()
+		target += "?" + encoded + "&signature=" + Sign(encoded, bc.secretKey)
+	}
+	resp, err := req.Execute(method, target)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	if err = checkResponse(resp.StatusCode(), resp.Bytes()); err != nil {
+		return fmt.Errorf("[binance] %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// Symbol returns the Binance symbol of pair, e.g. BTCUSDT.
+func Symbol(pair currency.Pair) string {
+	return pair.Quote.String() + pair.Base.String()
+}
+
+// symbolOf turns an instId like BTC-USDT into BTCUSDT.
+func symbolOf(instId string) string {
+	return strings.ReplaceAll(instId, "-", "")
+}
+
+// intervals maps a timeframe to the kline interval, day and week klines close at 0:00 UTC.
+var intervals = map[model.Timeframe]string{
+	model.Minute1:  "1m",
+	model.Minute15: "15m",
+	model.Hour1:    "1h",
+	model.Hour4:    "4h",
+	model.Day1:     "1d",
+	model.Week1:    "1w",
+}
+
+// maxKlines is the page size limit of /api/v3/klines.
+const maxKlines = 1000
+
+// GetCandle returns the last period klines, newest first like okx.Client.
+func (bc *Client) GetCandle(pair currency.Pair, timeframe model.Timeframe, period int) ([]model.Candlestick, error) {
+	interval, ok := intervals[timeframe]
+	if !ok {
+		return nil, fmt.Errorf("[binance.GetCandle] unsupported timeframe %q", timeframe)
+	}
+	res := make([]model.Candlestick, 0, period)
+	var endTime int64
+	now := time.Now().UnixMilli()
+	for len(res) < period {
+		var rows [][]json.RawMessage
+		req := bc.restClient.R().SetResult(&rows).SetQueryParams(map[string]string{
+			"symbol":   Symbol(pair),
+			"interval": interval,
+			"limit":    strconv.Itoa(min(period-len(res), maxKlines)),
+		})
+		if endTime > 0 {
+			req.SetQueryParam("endTime", strconv.FormatInt(endTime, 10))
+		}
+		if err := bc.doRestyRequest(req, http.MethodGet, "/api/v3/klines", false); err != nil {
+			return nil, err
+		}
+		// the symbol has no older history
+		if len(rows) == 0 {
+			break
+		}
+		page := make([]model.Candlestick, len(rows))
+		for i, row := range rows {
+			candle, openTime, err := parseKline(row, now)
+			if err != nil {
+				return nil, err
+			}
+			// klines come oldest first
+			page[len(rows)-1-i] = candle
+			if i == 0 {
+				endTime = openTime - 1
+			}
+		}
+		res = append(res, page...)
+	}
+	return res, nil
+}
+
+// parseKline decodes one [openTime, open, high, low, close, volume, closeTime, ...] row. A
+// kline is confirmed once its close time has passed.
+func parseKline(row []json.RawMessage, now int64) (model.Candlestick, int64, error) {
+	if len(row) < 7 {
+		return model.Candlestick{}, 0, fmt.Errorf("[binance.parseKline] short kline %s", row)
+	}
+	var openTime, closeTime int64
+	if err := json.Unmarshal(row[0], &openTime); err != nil {
+		return model.Candlestick{}, 0, err
+	}
+	if err := json.Unmarshal(row[6], &closeTime); err != nil {
+		return model.Candlestick{}, 0, err
+	}
+	values := make([]float64, 5)
+	for i := range values {
+		var s string
+		if err := json.Unmarshal(row[i+1], &s); err != nil {
+			return model.Candlestick{}, 0, err
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return model.Candlestick{}, 0, err
+		}
+		values[i] = v
+	}
+	confirm := "0"
+	if closeTime < now {
+		confirm = "1"
+	}
+	return model.Candlestick{
+		Ts:      strconv.FormatInt(openTime, 10),
+		O:       values[0],
+		H:       values[1],
+		L:       values[2],
+		C:       values[3],
+		Vol:     values[4],
+		Confirm: confirm,
+	}, openTime, nil
+}
+
+// valuation is the quote every balance is valued in.
+var valuation = currency.USDT
+
+// GetBalance returns the balances of coin, or every non-zero balance without coin. Equity is
+// free plus locked; Binance reports no entry price, so AVGPrice and the PnL stay 0 and are
+// left out of the prompt. Balances
+// are valued in USDT at the last price and TotalEquity sums all of them.
+func (bc *Client) GetBalance(ctx context.Context, coin ...currency.Coin) (*model.TradeData, error) {
+	account := &AccountResponse{}
+	req := bc.restClient.R().WithContext(ctx).SetResult(account).SetQueryParam("omitZeroBalances", "true")
+	if err := bc.doRestyRequest(req, http.MethodGet, "/api/v3/account", true); err != nil {
+		return nil, err
+	}
+	prices, err := bc.getPrices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := &model.TradeData{AccountAssets: make(map[currency.Coin]*model.Asset)}
+	for _, b := range account.Balances {
+		c := currency.Coin(b.Asset)
+		equity := b.Free.Float64() + b.Locked.Float64()
+		price := 1.0
+		if c != valuation {
+			price = prices[b.Asset+valuation.String()]
+		}
+		res.TotalEquity += equity * price
+		if len(coin) > 0 && !slices.Contains(coin, c) {
+			continue
+		}
+		res.AccountAssets[c] = &model.Asset{
+			Currency:  c,
+			Equity:    equity,
+			EquityUSD: equity * price,
+		}
+	}
+	return res, nil
+}
+
+// getPrices returns the last price of every symbol.
+func (bc *Client) getPrices(ctx context.Context) (map[string]float64, error) {
+	var data []PriceData
+	req := bc.restClient.R().WithContext(ctx).SetResult(&data)
+	if err := bc.doRestyRequest(req, http.MethodGet, "/api/v3/ticker/price", false); err != nil {
+		return nil, err
+	}
+	res := make(map[string]float64, len(data))
+	for _, p := range data {
+		res[p.Symbol] = p.Price.Float64()
+	}
+	return res, nil
+}
+
+// GetPrice returns the last price of symbol.
+func (bc *Client) GetPrice(ctx context.Context, symbol string) (float64, error) {
+	data := &PriceData{}
+	req := bc.restClient.R().WithContext(ctx).SetResult(data).SetQueryParam("symbol", symbol)
+	if err := bc.doRestyRequest(req, http.MethodGet, "/api/v3/ticker/price", false); err != nil {
+		return 0, err
+	}
+	return data.Price.Float64(), nil
+}
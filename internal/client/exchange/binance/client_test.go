@@ -0,0 +1,220 @@
+package binance
+
+import (
+	"context"
+	"errors"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var signed = map[string]bool{
+	"/api/v3/account": true,
+	"/api/v3/order":   true,
+}
+
+// replay answers every request with a response recorded from the Binance API, picked by
+// route. Signed requests must carry the API key and a valid signature; the last order
+// request is kept in orders.
+func replay(t *testing.T, routes map[string]string, orders *url.Values) *Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.Method + " " + r.URL.Path
+		if r.URL.Path == "/api/v3/ticker/price" && r.URL.Query().Has("symbol") {
+			route += "?symbol"
+		}
+		file, ok := routes[route]
+		if !ok {
+			t.Errorf("unexpected request %s", route)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if signed[r.URL.Path] {
+			query, signature, _ := strings.Cut(r.URL.RawQuery, "&signature=")
+			if r.Header.Get("X-MBX-APIKEY") != "key" || signature != Sign(query, "secret") {
+				file = "error_signature.json"
+			}
+		}
+		if r.URL.Path == "/api/v3/order" && r.Method == http.MethodPost && orders != nil {
+			*orders = r.URL.Query()
+		}
+		data, err := os.ReadFile(filepath.Join("testdata", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasPrefix(file, "error_") {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	bc, _ := NewBinanceClient("key", "secret", false)
+	bc.restClient.SetBaseURL(server.URL)
+	bc.retry.MinBackoff = time.Millisecond
+	return bc
+}
+
+func TestGetCandle(t *testing.T) {
+	bc := replay(t, map[string]string{"GET /api/v3/klines": "klines.json"}, nil)
+	candles, err := bc.GetCandle(currency.NewPair(currency.USDT, currency.BTC), model.Day1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candles) != 3 {
+		t.Fatalf("expected 3 candles, got %d", len(candles))
+	}
+	newest := candles[0]
+	if newest.Ts != "1719878400000" || newest.O != 62900 || newest.C != 62135.47 || newest.Vol != 18038.6938 || newest.Confirm != "1" {
+		t.Errorf("klines should be newest first, got %+v", newest)
+	}
+	if candles[2].Ts != "1719705600000" {
+		t.Errorf("unexpected oldest candle %+v", candles[2])
+	}
+}
+
+func TestGetBalance(t *testing.T) {
+	bc := replay(t, map[string]string{
+		"GET /api/v3/account":      "account.json",
+		"GET /api/v3/ticker/price": "ticker_price.json",
+	}, nil)
+	balance, err := bc.GetBalance(context.Background(), currency.USDT, currency.BTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	btc := balance.AccountAssets[currency.BTC]
+	if btc == nil || btc.Equity != 0.02 || math.Abs(btc.EquityUSD-0.02*62135.47) > 1e-6 {
+		t.Errorf("unexpected BTC balance %+v", btc)
+	}
+	if usdt := balance.AccountAssets[currency.USDT]; usdt == nil || usdt.EquityUSD != 1250.5 {
+		t.Errorf("unexpected USDT balance %+v", usdt)
+	}
+	if _, ok := balance.AccountAssets["BNB"]; ok {
+		t.Error("only the requested coins should be listed")
+	}
+	want := 1250.5 + 0.02*62135.47 + 0.2*575.2
+	if math.Abs(balance.TotalEquity-want) > 1e-6 {
+		t.Errorf("total equity = %f, want %f", balance.TotalEquity, want)
+	}
+}
+
+func TestOrder(t *testing.T) {
+	var orders url.Values
+	bc := replay(t, map[string]string{
+		"GET /api/v3/exchangeInfo":        "exchange_info.json",
+		"GET /api/v3/ticker/price?symbol": "ticker_price_btcusdt.json",
+		"POST /api/v3/order":              "order_full.json",
+	}, &orders)
+	res, err := bc.Order(context.Background(), "BTC-USDT", "buy", "99.416704123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orders.Get("symbol") != "BTCUSDT" || orders.Get("side") != "BUY" || orders.Get("type") != "MARKET" || orders.Get("quoteOrderQty") != "99.41670412" {
+		t.Errorf("unexpected order %v", orders)
+	}
+	if res.OrderId != "28457812345" || res.State != model.OrderFilled || res.FilledSize != 0.0016 || res.Side != "buy" {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if math.Abs(res.AvgPrice-62135.44) > 1e-6 || math.Abs(res.Fee-0.0000016) > 1e-12 || res.FeeCcy != "BTC" || len(res.Fills) != 2 {
+		t.Errorf("unexpected fill details %+v", res)
+	}
+
+	if _, err = bc.Order(context.Background(), "BTC-USDT", "sell", "0.000001"); !errors.Is(err, model.ErrBelowMinimum) {
+		t.Errorf("a sell below the lot minimum should be rejected locally, got %v", err)
+	}
+	if _, err = bc.Order(context.Background(), "BTC-USDT", "buy", "4"); !errors.Is(err, model.ErrBelowMinimum) {
+		t.Errorf("a buy below the minimum notional should be rejected locally, got %v", err)
+	}
+}
+
+func TestOrderErrors(t *testing.T) {
+	routes := map[string]string{
+		"GET /api/v3/exchangeInfo":        "exchange_info.json",
+		"GET /api/v3/ticker/price?symbol": "ticker_price_btcusdt.json",
+		"POST /api/v3/order":              "error_insufficient.json",
+		"GET /api/v3/account":             "account.json",
+	}
+	bc := replay(t, routes, nil)
+	_, err := bc.Order(context.Background(), "BTC-USDT", "sell", "1")
+	if !errors.Is(err, ErrInsufficientBalance) || exchange.IsRetryable(err) {
+		t.Errorf("expected a fatal insufficient balance error, got %v", err)
+	}
+
+	bc.secretKey = "wrong"
+	if _, err = bc.GetBalance(context.Background()); !errors.Is(err, ErrInvalidSign) {
+		t.Errorf("expected an invalid signature error, got %v", err)
+	}
+}
+
+func TestOrderLostAnswer(t *testing.T) {
+	cases := []struct {
+		name  string
+		known bool // the lost order reached Binance
+		posts int
+	}{
+		{"placed", true, 1},
+		{"not placed", false, 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var posts int
+			var clientOrderIds []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				file := map[string]string{
+					"/api/v3/exchangeInfo": "exchange_info.json",
+					"/api/v3/ticker/price": "ticker_price_btcusdt.json",
+				}[r.URL.Path]
+				w.Header().Set("Content-Type", "application/json")
+				switch {
+				case r.URL.Path == "/api/v3/order" && r.Method == http.MethodPost:
+					posts++
+					clientOrderIds = append(clientOrderIds, r.URL.Query().Get("newClientOrderId"))
+					if posts == 1 {
+						w.WriteHeader(http.StatusBadGateway)
+						return
+					}
+					file = "order_full.json"
+				case r.URL.Path == "/api/v3/order":
+					if r.URL.Query().Get("origClientOrderId") != clientOrderIds[0] {
+						t.Errorf("the order should be looked up by its client id, got %s", r.URL.RawQuery)
+					}
+					if !c.known {
+						w.WriteHeader(http.StatusBadRequest)
+						w.Write([]byte(`{"code":-2013,"msg":"Order does not exist."}`))
+						return
+					}
+					w.Write([]byte(`{"symbol":"BTCUSDT","orderId":7,"executedQty":"0.0016","cummulativeQuoteQty":"99.4167","status":"FILLED","side":"BUY"}`))
+					return
+				}
+				data, err := os.ReadFile(filepath.Join("testdata", file))
+				if err != nil {
+					t.Fatal(err)
+				}
+				w.Write(data)
+			}))
+			defer server.Close()
+			bc, _ := NewBinanceClient("key", "secret", false)
+			bc.restClient.SetBaseURL(server.URL)
+			bc.retry.MinBackoff = time.Millisecond
+
+			res, err := bc.Order(context.Background(), "BTC-USDT", "buy", "100")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if posts != c.posts || res.State != model.OrderFilled || res.FilledSize != 0.0016 {
+				t.Errorf("expected %d orders sent and a filled result, got %d and %+v", c.posts, posts, res)
+			}
+			if posts == 2 && clientOrderIds[0] != clientOrderIds[1] {
+				t.Errorf("a resent order should keep its client id, got %v", clientOrderIds)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package binance
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"net/http"
+	"strings"
+)
+
+// The failures the Binance codes map onto, named after the Binance symbols.
+var (
+	ErrInsufficientBalance = exchange.ErrInsufficientBalance
+	ErrRateLimit           = exchange.ErrRateLimit
+	ErrInvalidSign         = exchange.ErrInvalidSign
+	ErrTimestampExpired    = exchange.ErrTimestampExpired
+	ErrUnknownSymbol       = exchange.ErrUnknownInstrument
+	ErrSymbolSuspended     = exchange.ErrInstrumentSuspended
+	ErrOrderNotFound       = exchange.ErrOrderNotFound
+)
+
+type codeInfo struct {
+	err       error
+	retryable bool
+}
+
+// knownCodes classifies the Binance error codes the bot runs into, see
+// https://developers.binance.com/docs/binance-spot-api-docs/errors
+var knownCodes = map[int]codeInfo{
+	-1003: {ErrRateLimit, true},
+	-1021: {ErrTimestampExpired, true},
+	-1022: {ErrInvalidSign, false},
+	-1121: {ErrUnknownSymbol, false},
+	-2013: {ErrOrderNotFound, false},
+}
+
+// APIError is a request Binance answered with an error code.
+type APIError struct {
+	Status int // HTTP status
+	Code   int
+	Msg    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("binance: code %d http %d: %s", e.Code, e.Status, e.Msg)
+}
+
+// Is matches the well-known errors. -2010 covers every rejected order, only its message
+// tells an insufficient balance apart.
+func (e *APIError) Is(target error) bool {
+	if target == ErrRateLimit && e.Status == http.StatusTooManyRequests {
+		return true
+	}
+	if target == ErrInsufficientBalance {
+		return e.Code == -2010 && strings.Contains(strings.ToLower(e.Msg), "insufficient balance")
+	}
+	info, ok := knownCodes[e.Code]
+	return ok && info.err == target
+}
+
+// Retryable reports whether sending the same request again may succeed. 418 is an IP ban
+// after ignoring 429s and is not retried.
+func (e *APIError) Retryable() bool {
+	if e.Status == http.StatusTooManyRequests || e.Status >= http.StatusInternalServerError {
+		return true
+	}
+	info, ok := knownCodes[e.Code]
+	return ok && info.retryable
+}
+
+// HTTPStatus returns the HTTP status, a 4xx for a rejected request and a 5xx when its
+// outcome is unknown.
+func (e *APIError) HTTPStatus() int {
+	return e.Status
+}
+
+// checkResponse returns an APIError for a non-2xx answer.
+func checkResponse(status int, body []byte) error {
+	if status >= http.StatusOK && status < http.StatusMultipleChoices {
+		return nil
+	}
+	apiErr := &APIError{Status: status}
+	if json.Unmarshal(body, apiErr) != nil || apiErr.Msg == "" {
+		apiErr.Msg = strings.TrimSpace(string(body))
+	}
+	return apiErr
+}
@@ -0,0 +1,212 @@
+package binance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Instrument returns the trading rules of symbol from /api/v3/exchangeInfo, cached for an
+// hour. It fails with ErrSymbolSuspended when the symbol does not trade.
+func (bc *Client) Instrument(ctx context.Context, symbol string) (model.Instrument, error) {
+	return bc.instruments.Get(ctx, symbol)
+}
+
+// getInstrument fetches the rules of symbol.
+func (bc *Client) getInstrument(ctx context.Context, symbol string) (map[string]exchange.Listing, error) {
+	info := &ExchangeInfoResponse{}
+	req := bc.restClient.R().WithContext(ctx).SetResult(info).SetQueryParam("symbol", symbol)
+	if err := bc.doRestyRequest(req, http.MethodGet, "/api/v3/exchangeInfo", false); err != nil {
+		return nil, err
+	}
+	res := make(map[string]exchange.Listing, len(info.Symbols))
+	for _, s := range info.Symbols {
+		res[s.Symbol] = listingOf(s)
+	}
+	return res, nil
+}
+
+func listingOf(s SymbolData) exchange.Listing {
+	l := exchange.Listing{
+		Instrument: model.Instrument{
+			InstId:    s.BaseAsset + "-" + s.QuoteAsset,
+			Base:      s.BaseAsset,
+			Quote:     s.QuoteAsset,
+			QuoteStep: math.Pow10(-s.QuoteAssetPrecision),
+		},
+		Trading: s.Status == "TRADING",
+	}
+	for _, f := range s.Filters {
+		switch f.FilterType {
+		case "LOT_SIZE":
+			l.LotSize = f.StepSize.Float64()
+			l.MinSize = f.MinQty.Float64()
+		case "PRICE_FILTER":
+			l.TickSize = f.TickSize.Float64()
+		case "NOTIONAL", "MIN_NOTIONAL":
+			l.MinNotional = f.MinNotional.Float64()
+		}
+	}
+	return l
+}
+
+// Order places a market order and waits for it to settle. instId is "COIN-QUOTE"; sz is the
+// quote amount to spend for a buy and the coin amount for a sell, rounded to the rules of
+// the symbol first.
+//
+// The order carries a client order id. When the answer is lost the order is looked up by it
+// and only placed again when Binance does not know it, so it cannot be placed twice.
+func (bc *Client) Order(ctx context.Context, instId, side, sz string) (*model.OrderResult, error) {
+	symbol := symbolOf(instId)
+	sz, err := bc.NormalizeOrder(ctx, instId, side, sz)
+	if err != nil {
+		return nil, err
+	}
+	clientOrderId := exchange.ClientOrderId()
+	params := map[string]string{
+		"symbol":           symbol,
+		"side":             strings.ToUpper(side),
+		"type":             "MARKET",
+		"newClientOrderId": clientOrderId,
+		"newOrderRespType": "FULL",
+	}
+	if side == "buy" {
+		params["quoteOrderQty"] = sz
+	} else {
+		params["quantity"] = sz
+	}
+
+	resp := &OrderResponse{}
+	place := func() error {
+		req := bc.restClient.R().WithContext(ctx).SetResult(resp).SetQueryParams(params)
+		return bc.doRestyRequest(req, http.MethodPost, "/api/v3/order", true)
+	}
+	var earlier *OrderResponse
+	found := func() (bool, error) {
+		o, err := bc.getOrder(ctx, symbol, clientOrderId)
+		if errors.Is(err, ErrOrderNotFound) {
+			return false, nil
+		}
+		earlier = o
+		return err == nil, err
+	}
+	if err = bc.retry.Place(ctx, place, found); err != nil {
+		return nil, err
+	}
+	if earlier != nil {
+		// the answer with the fills is lost, the order is all there is
+		log.Println(fmt.Sprintf("[binance.Order] %s 已提交, 使用先前的订单", clientOrderId))
+		resp = earlier
+	}
+	res := bc.orders.Settle(ctx, clientOrderId, resultOf(instId, resp), func() (*model.OrderResult, error) {
+		o, err := bc.getOrder(ctx, symbol, clientOrderId)
+		if err != nil {
+			return nil, err
+		}
+		o.Fills = resp.Fills
+		return resultOf(instId, o), nil
+	})
+	return res, nil
+}
+
+// getOrder looks an order of symbol up by its client order id, the answer has no fills. It
+// fails with ErrOrderNotFound when Binance does not know the order.
+func (bc *Client) getOrder(ctx context.Context, symbol, clientOrderId string) (*OrderResponse, error) {
+	o := &OrderResponse{}
+	req := bc.restClient.R().WithContext(ctx).SetResult(o).SetQueryParams(map[string]string{
+		"symbol":            symbol,
+		"origClientOrderId": clientOrderId,
+	})
+	if err := bc.doRestyRequest(req, http.MethodGet, "/api/v3/order", true); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// NormalizeOrder returns sz of a market order rounded to the rules of the symbol as Order
+// sends it, or the error Order would fail with, without placing the order.
+func (bc *Client) NormalizeOrder(ctx context.Context, instId, side, sz string) (string, error) {
+	symbol := symbolOf(instId)
+	amount, err := strconv.ParseFloat(sz, 64)
+	if err != nil {
+		return "", fmt.Errorf("[binance.Order] invalid size %q: %w", sz, err)
+	}
+	inst, err := bc.Instrument(ctx, symbol)
+	if err != nil {
+		return "", err
+	}
+	price, err := bc.GetPrice(ctx, symbol)
+	if err != nil {
+		return "", err
+	}
+	switch side {
+	case "buy":
+		amount = inst.FloorQuote(amount)
+		if price > 0 {
+			if err = inst.CheckSize(amount/price, price); err != nil {
+				return "", fmt.Errorf("[binance.Order] buy of %s %s: %w", inst.FormatQuote(amount), inst.Quote, err)
+			}
+		}
+		return inst.FormatQuote(amount), nil
+	case "sell":
+		amount = inst.FloorSize(amount)
+		if err = inst.CheckSize(amount, price); err != nil {
+			return "", fmt.Errorf("[binance.Order] sell: %w", err)
+		}
+		return inst.FormatSize(amount), nil
+	}
+	return "", fmt.Errorf("[binance.Order] unknown side %q", side)
+}
+
+// states maps the Binance order status to the model order states.
+var states = map[string]string{
+	"NEW":              model.OrderLive,
+	"PENDING_NEW":      model.OrderLive,
+	"PARTIALLY_FILLED": model.OrderPartiallyFilled,
+	"FILLED":           model.OrderFilled,
+	"CANCELED":         model.OrderCanceled,
+	"EXPIRED":          model.OrderCanceled,
+	"EXPIRED_IN_MATCH": model.OrderCanceled,
+	"REJECTED":         model.OrderCanceled,
+}
+
+func resultOf(instId string, o *OrderResponse) *model.OrderResult {
+	res := &model.OrderResult{
+		OrderId:    strconv.FormatInt(o.OrderId, 10),
+		InstId:     instId,
+		Side:       strings.ToLower(o.Side),
+		State:      states[o.Status],
+		FilledSize: o.ExecutedQty.Float64(),
+	}
+	if res.State == "" {
+		res.State = strings.ToLower(o.Status)
+	}
+	if res.FilledSize > 0 {
+		res.AvgPrice = o.CummulativeQuoteQty.Float64() / res.FilledSize
+	}
+	for _, f := range o.Fills {
+		res.Fills = append(res.Fills, model.OrderFill{
+			TradeId: strconv.FormatInt(f.TradeId, 10),
+			Ts:      strconv.FormatInt(o.TransactTime, 10),
+			Price:   f.Price.Float64(),
+			Size:    f.Qty.Float64(),
+			Fee:     f.Commission.Float64(),
+			FeeCcy:  f.CommissionAsset,
+		})
+		// commissions paid in another asset, e.g. BNB, are left out of the total
+		if res.FeeCcy == "" {
+			res.FeeCcy = f.CommissionAsset
+		}
+		if f.CommissionAsset == res.FeeCcy {
+			res.Fee += f.Commission.Float64()
+		}
+	}
+	return res
+}
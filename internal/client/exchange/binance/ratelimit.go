@@ -0,0 +1,23 @@
+package binance
+
+import (
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"net/http"
+	"time"
+)
+
+// defaultEndpoint applies to endpoints missing from endpoints.
+var defaultEndpoint = exchange.Endpoint{Requests: 20, Window: time.Second}
+
+// endpoints spread the request weight limit of 6000 per minute over the endpoints the client
+// uses by their weight, see https://developers.binance.com/docs/binance-spot-api-docs/rest-api/limits.
+// Orders are not idempotent: a client order id is only unique among open orders and a market
+// order does not stay open.
+var endpoints = map[string]exchange.Endpoint{
+	http.MethodGet + " /api/v3/klines":       {Requests: 40, Window: time.Second},
+	http.MethodGet + " /api/v3/account":      {Requests: 4, Window: time.Second},
+	http.MethodGet + " /api/v3/ticker/price": {Requests: 20, Window: time.Second},
+	http.MethodGet + " /api/v3/exchangeInfo": {Requests: 4, Window: time.Second},
+	http.MethodPost + " /api/v3/order":       {Requests: 50, Window: 10 * time.Second},
+	http.MethodGet + " /api/v3/order":        {Requests: 20, Window: time.Second},
+}
@@ -0,0 +1,15 @@
+package binance
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Sign returns the signature of a SIGNED endpoint: the HMAC-SHA256 of the query string,
+// hex encoded.
+func Sign(query, secretKey string) string {
+	h := hmac.New(sha256.New, []byte(secretKey))
+	h.Write([]byte(query))
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,64 @@
+package binance
+
+import (
+	"github.com/twoonefour/sigmaflow/pkg"
+)
+
+type BalanceData struct {
+	Asset  string          `json:"asset"`
+	Free   pkg.TextFloat64 `json:"free"`
+	Locked pkg.TextFloat64 `json:"locked"`
+}
+
+type AccountResponse struct {
+	CanTrade bool          `json:"canTrade"`
+	Balances []BalanceData `json:"balances"`
+}
+
+type PriceData struct {
+	Symbol string          `json:"symbol"`
+	Price  pkg.TextFloat64 `json:"price"`
+}
+
+type FillData struct {
+	Price           pkg.TextFloat64 `json:"price"`
+	Qty             pkg.TextFloat64 `json:"qty"`
+	Commission      pkg.TextFloat64 `json:"commission"`
+	CommissionAsset string          `json:"commissionAsset"`
+	TradeId         int64           `json:"tradeId"`
+}
+
+// OrderResponse is the FULL answer of a new order, and without fills the answer of an
+// order query.
+type OrderResponse struct {
+	Symbol              string          `json:"symbol"`
+	OrderId             int64           `json:"orderId"`
+	ClientOrderId       string          `json:"clientOrderId"`
+	TransactTime        int64           `json:"transactTime"`
+	ExecutedQty         pkg.TextFloat64 `json:"executedQty"`
+	CummulativeQuoteQty pkg.TextFloat64 `json:"cummulativeQuoteQty"`
+	Status              string          `json:"status"` // NEW, PARTIALLY_FILLED, FILLED, CANCELED, EXPIRED, REJECTED
+	Side                string          `json:"side"`
+	Fills               []FillData      `json:"fills"`
+}
+
+type FilterData struct {
+	FilterType  string          `json:"filterType"`
+	MinQty      pkg.TextFloat64 `json:"minQty"`
+	StepSize    pkg.TextFloat64 `json:"stepSize"`
+	TickSize    pkg.TextFloat64 `json:"tickSize"`
+	MinNotional pkg.TextFloat64 `json:"minNotional"`
+}
+
+type SymbolData struct {
+	Symbol              string       `json:"symbol"`
+	Status              string       `json:"status"` // TRADING, BREAK, ...
+	BaseAsset           string       `json:"baseAsset"`
+	QuoteAsset          string       `json:"quoteAsset"`
+	QuoteAssetPrecision int          `json:"quoteAssetPrecision"`
+	Filters             []FilterData `json:"filters"`
+}
+
+type ExchangeInfoResponse struct {
+	Symbols []SymbolData `json:"symbols"`
+}
@@ -0,0 +1,245 @@
+package bybit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"net/http"
+	"resty.dev/v3"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	restApiBase    = "https://api.bybit.com"
+	testnetApiBase = "https://api-testnet.bybit.com"
+	recvWindow     = "5000"
+	// accountType is the unified trading account, the only one v5 trades spot with
+	accountType = "UNIFIED"
+)
+
+// Client is a Bybit v5 unified trading account implementing trade.Market, it trades the
+// spot category.
+type Client struct {
+	restClient *resty.Client
+	apiKey     string
+	secretKey  string
+
+	orders      exchange.OrderWait
+	instruments *exchange.Instruments // by symbol
+	limiter     *exchange.Limiter
+	retry       exchange.Retry
+}
+
+func init() {
+	exchange.Register("bybit", func(a exchange.Account) (exchange.Client, error) {
+		c, err := NewBybitClient(a.APIKey, a.SecretKey, a.Demo)
+		if err != nil {
+			return nil, err
+		}
+		if a.BaseURL != "" {
+			c.restClient.SetBaseURL(a.BaseURL)
+		}
+		return c, nil
+	})
+}
+
+func NewBybitClient(apiKey, secretKey string, testnet bool) (*Client, error) {
+	_bybitClient := &Client{
+		apiKey:    apiKey,
+		secretKey: secretKey,
+		orders:    exchange.DefaultOrderWait("bybit"),
+		limiter:   exchange.NewLimiter(endpoints, defaultEndpoint),
+		retry:     exchange.DefaultRetry("bybit"),
+	}
+	_bybitClient.instruments = exchange.NewInstruments("bybit", _bybitClient.getInstrument)
+	base := restApiBase
+	if testnet {
+		base = testnetApiBase
+	}
+	_bybitClient.restClient = exchange.NewRestClient(base).SetHeader("X-BAPI-API-KEY", apiKey)
+	return _bybitClient, nil
+}
+
+// doRestyRequest signs and sends req, waiting for the rate limit of the endpoint and
+// retrying with backoff while exchange.Retryable allows it. A POST sends body as JSON.
+func (bc *Client) doRestyRequest(req *resty.Request, method, path string, body ...interface{}) error {
+	var payload string
+	if len(body) > 0 && method == http.MethodPost {
+		bodyBytes, err := json.Marshal(body[0])
+		if err != nil {
+			return err
+		}
+		payload = string(bodyBytes)
+		req.SetHeader("Content-Type", "application/json").SetBody(bodyBytes)
+	} else {
+		payload = req.QueryParams.Encode()
+	}
+	return bc.retry.Do(req.Context(), bc.limiter, method, path, func() error {
+		return bc.send(req, method, path, payload)
+	})
+}
+
+// send signs req with a fresh timestamp and executes it once.
+func (bc *Client) send(req *resty.Request, method, path, payload string) error {
+	ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	req.SetHeaders(map[string]string{
+		"X-BAPI-TIMESTAMP":   ts,
+		"X-BAPI-RECV-WINDOW": recvWindow,
+		"X-BAPI-SIGN":        Sign(ts, bc.apiKey, recvWindow, payload, bc.secretKey),
+	})
+	resp, err := req.Execute(method, path)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	if err = checkResponse(resp.StatusCode(), resp.Bytes()); err != nil {
+		return fmt.Errorf("[bybit] %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// Symbol returns the Bybit symbol of pair, e.g. BTCUSDT.
+func Symbol(pair currency.Pair) string {
+	return pair.Quote.String() + pair.Base.String()
+}
+
+// symbolOf turns an instId like BTC-USDT into BTCUSDT.
+func symbolOf(instId string) string {
+	return strings.ReplaceAll(instId, "-", "")
+}
+
+// intervals maps a timeframe to the kline interval, day and week klines start at 0:00 UTC.
+var intervals = map[model.Timeframe]string{
+	model.Minute1:  "1",
+	model.Minute15: "15",
+	model.Hour1:    "60",
+	model.Hour4:    "240",
+	model.Day1:     "D",
+	model.Week1:    "W",
+}
+
+// maxKlines is the page size limit of /v5/market/kline.
+const maxKlines = 1000
+
+// GetCandle returns the last period klines, newest first like okx.Client.
+func (bc *Client) GetCandle(pair currency.Pair, timeframe model.Timeframe, period int) ([]model.Candlestick, error) {
+	interval, ok := intervals[timeframe]
+	if !ok {
+		return nil, fmt.Errorf("[bybit.GetCandle] unsupported timeframe %q", timeframe)
+	}
+	res := make([]model.Candlestick, 0, period)
+	now := time.Now()
+	req := bc.restClient.R().SetQueryParams(map[string]string{
+		"category": "spot",
+		"symbol":   Symbol(pair),
+		"interval": interval,
+	})
+	for len(res) < period {
+		resp := &KlineResponse{}
+		req.SetResult(resp).SetQueryParam("limit", strconv.Itoa(min(period-len(res), maxKlines)))
+		if err := bc.doRestyRequest(req, http.MethodGet, "/v5/market/kline"); err != nil {
+			return nil, err
+		}
+		// the symbol has no older history
+		if len(resp.Result.List) == 0 {
+			break
+		}
+		for _, row := range resp.Result.List {
+			candle, err := parseKline(row, timeframe, now)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, candle)
+		}
+		oldest, _ := strconv.ParseInt(res[len(res)-1].Ts, 10, 64)
+		req.SetQueryParam("end", strconv.FormatInt(oldest-1, 10))
+	}
+	return res[:min(len(res), period)], nil
+}
+
+// parseKline decodes one [startTime, open, high, low, close, volume, turnover] row. Bybit
+// does not flag the running kline, it is confirmed once its timeframe has passed.
+func parseKline(row []string, timeframe model.Timeframe, now time.Time) (model.Candlestick, error) {
+	if len(row) < 6 {
+		return model.Candlestick{}, fmt.Errorf("[bybit.parseKline] short kline %v", row)
+	}
+	start, err := strconv.ParseInt(row[0], 10, 64)
+	if err != nil {
+		return model.Candlestick{}, err
+	}
+	values := make([]float64, 5)
+	for i := range values {
+		if values[i], err = strconv.ParseFloat(row[i+1], 64); err != nil {
+			return model.Candlestick{}, err
+		}
+	}
+	confirm := "0"
+	if !time.UnixMilli(start).Add(timeframe.Duration()).After(now) {
+		confirm = "1"
+	}
+	return model.Candlestick{
+		Ts:      row[0],
+		O:       values[0],
+		H:       values[1],
+		L:       values[2],
+		C:       values[3],
+		Vol:     values[4],
+		Confirm: confirm,
+	}, nil
+}
+
+// GetBalance returns the balances of coin in the unified account, or every balance without
+// coin. Bybit values the account and every coin in USD; it reports no entry price, so
+// AVGPrice stays 0.
+func (bc *Client) GetBalance(ctx context.Context, coin ...currency.Coin) (*model.TradeData, error) {
+	resp := &WalletResponse{}
+	req := bc.restClient.R().WithContext(ctx).SetResult(resp).SetQueryParam("accountType", accountType)
+	if len(coin) > 0 {
+		coins := make([]string, len(coin))
+		for i, c := range coin {
+			coins[i] = c.String()
+		}
+		req.SetQueryParam("coin", strings.Join(coins, ","))
+	}
+	if err := bc.doRestyRequest(req, http.MethodGet, "/v5/account/wallet-balance"); err != nil {
+		return nil, err
+	}
+	if len(resp.Result.List) == 0 {
+		return nil, fmt.Errorf("[bybit.GetBalance] no %s account", accountType)
+	}
+	wallet := resp.Result.List[0]
+	res := &model.TradeData{
+		AccountAssets: make(map[currency.Coin]*model.Asset),
+		TotalEquity:   wallet.TotalEquity.Float64(),
+	}
+	for _, c := range wallet.Coin {
+		res.AccountAssets[currency.Coin(c.Coin)] = &model.Asset{
+			Currency:      currency.Coin(c.Coin),
+			Equity:        c.Equity.Float64(),
+			EquityUSD:     c.UsdValue.Float64(),
+			TotalProfit:   c.CumRealisedPnl.Float64(),
+			UnrealizedPNL: c.UnrealisedPnl.Float64(),
+		}
+	}
+	return res, nil
+}
+
+// GetTicker returns the latest ticker of symbol.
+func (bc *Client) GetTicker(ctx context.Context, symbol string) (*TickerData, error) {
+	resp := &TickerResponse{}
+	req := bc.restClient.R().WithContext(ctx).SetResult(resp).SetQueryParams(map[string]string{
+		"category": "spot",
+		"symbol":   symbol,
+	})
+	if err := bc.doRestyRequest(req, http.MethodGet, "/v5/market/tickers"); err != nil {
+		return nil, err
+	}
+	if len(resp.Result.List) == 0 {
+		return nil, fmt.Errorf("[bybit.GetTicker] no ticker for %s", symbol)
+	}
+	return &resp.Result.List[0], nil
+}
@@ -0,0 +1,342 @@
+package bybit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mock is a Bybit v5 server: every request must be signed, the answer of a route comes from
+// routes and the bodies of the orders sent are kept in orders.
+type mock struct {
+	mu     sync.Mutex
+	routes map[string]func(r *http.Request) (int, string)
+	orders []map[string]string
+}
+
+func newMock(t *testing.T, routes map[string]func(r *http.Request) (int, string)) (*mock, *Client) {
+	m := &mock{routes: routes}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		payload := r.URL.RawQuery
+		if r.Method == http.MethodPost {
+			body, _ := io.ReadAll(r.Body)
+			payload = string(body)
+			var order map[string]string
+			json.Unmarshal(body, &order)
+			m.mu.Lock()
+			m.orders = append(m.orders, order)
+			m.mu.Unlock()
+		}
+		h := r.Header
+		if h.Get("X-BAPI-API-KEY") != "key" || h.Get("X-BAPI-SIGN") != Sign(h.Get("X-BAPI-TIMESTAMP"), "key", h.Get("X-BAPI-RECV-WINDOW"), payload, "secret") {
+			w.Write([]byte(`{"retCode":10004,"retMsg":"error sign! origin_string[...]","result":{},"time":1}`))
+			return
+		}
+		route, ok := m.routes[r.Method+" "+r.URL.Path]
+		if !ok {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		status, answer := route(r)
+		w.WriteHeader(status)
+		w.Write([]byte(answer))
+	}))
+	t.Cleanup(server.Close)
+	bc, _ := NewBybitClient("key", "secret", false)
+	bc.restClient.SetBaseURL(server.URL)
+	bc.orders.Poll = time.Millisecond
+	bc.retry.MinBackoff = time.Millisecond
+	return m, bc
+}
+
+func reply(body string) func(*http.Request) (int, string) {
+	return func(*http.Request) (int, string) {
+		return http.StatusOK, body
+	}
+}
+
+// market answers the instrument and ticker endpoints, BTCUSDT trades at 50000.
+var market = map[string]func(r *http.Request) (int, string){
+	"GET /v5/market/instruments-info": reply(`{"retCode":0,"retMsg":"OK","result":{"category":"spot","list":[
+		{"symbol":"BTCUSDT","baseCoin":"BTC","quoteCoin":"USDT","status":"Trading",
+		 "lotSizeFilter":{"basePrecision":"0.000001","quotePrecision":"0.00000001","minOrderQty":"0.000048","maxOrderQty":"71.73956243","minOrderAmt":"1","maxOrderAmt":"2000000"},
+		 "priceFilter":{"tickSize":"0.01"}}]}}`),
+	"GET /v5/market/tickers": reply(`{"retCode":0,"retMsg":"OK","result":{"category":"spot","list":[{"symbol":"BTCUSDT","lastPrice":"50000","bid1Price":"49999.99","ask1Price":"50000.01"}]}}`),
+}
+
+func with(routes map[string]func(r *http.Request) (int, string)) map[string]func(r *http.Request) (int, string) {
+	res := make(map[string]func(r *http.Request) (int, string))
+	for k, v := range market {
+		res[k] = v
+	}
+	for k, v := range routes {
+		res[k] = v
+	}
+	return res
+}
+
+func TestGetCandle(t *testing.T) {
+	day := int64(24 * time.Hour / time.Millisecond)
+	today := time.Now().UnixMilli() / day * day
+	var ends []string
+	_, bc := newMock(t, map[string]func(r *http.Request) (int, string){
+		"GET /v5/market/kline": func(r *http.Request) (int, string) {
+			q := r.URL.Query()
+			if q.Get("category") != "spot" || q.Get("symbol") != "BTCUSDT" || q.Get("interval") != "D" {
+				t.Errorf("unexpected query %s", r.URL.RawQuery)
+			}
+			ends = append(ends, q.Get("end"))
+			// two klines a page, newest first
+			newest := today
+			if end := q.Get("end"); end != "" {
+				newest, _ = strconv.ParseInt(end, 10, 64)
+				newest = newest + 1 - day
+			}
+			list := ""
+			for i := range min(2, mustAtoi(q.Get("limit"))) {
+				ts := strconv.FormatInt(newest-int64(i)*day, 10)
+				if list != "" {
+					list += ","
+				}
+				list += `["` + ts + `","100","110","90","105","12.5","1300"]`
+			}
+			return http.StatusOK, `{"retCode":0,"retMsg":"OK","result":{"symbol":"BTCUSDT","category":"spot","list":[` + list + `]}}`
+		},
+	})
+	candles, err := bc.GetCandle(currency.NewPair(currency.USDT, currency.BTC), model.Day1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candles) != 3 || len(ends) != 2 {
+		t.Fatalf("expected 3 candles in 2 pages, got %d in %d", len(candles), len(ends))
+	}
+	for i, c := range candles {
+		if c.Ts != strconv.FormatInt(today-int64(i)*day, 10) {
+			t.Errorf("candle %d starts at %s, want newest first", i, c.Ts)
+		}
+	}
+	if candles[0].Confirm != "0" || candles[1].Confirm != "1" {
+		t.Errorf("only the running kline should be unconfirmed, got %s %s", candles[0].Confirm, candles[1].Confirm)
+	}
+	if c := candles[1]; c.O != 100 || c.H != 110 || c.L != 90 || c.C != 105 || c.Vol != 12.5 {
+		t.Errorf("unexpected candle %+v", c)
+	}
+}
+
+func mustAtoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func TestGetBalance(t *testing.T) {
+	_, bc := newMock(t, map[string]func(r *http.Request) (int, string){
+		"GET /v5/account/wallet-balance": func(r *http.Request) (int, string) {
+			if q := r.URL.Query(); q.Get("accountType") != "UNIFIED" || q.Get("coin") != "USDT,BTC" {
+				t.Errorf("unexpected query %s", r.URL.RawQuery)
+			}
+			return http.StatusOK, `{"retCode":0,"retMsg":"OK","result":{"list":[{"accountType":"UNIFIED","totalEquity":"3031.53","coin":[
+				{"coin":"BTC","equity":"0.02","usdValue":"1000.5","walletBalance":"0.02","unrealisedPnl":"0","cumRealisedPnl":"-1.2"},
+				{"coin":"USDT","equity":"2031.03","usdValue":"2031.03","walletBalance":"2031.03","unrealisedPnl":"0","cumRealisedPnl":"0"}]}]}}`
+		},
+	})
+	balance, err := bc.GetBalance(context.Background(), currency.USDT, currency.BTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.TotalEquity != 3031.53 {
+		t.Errorf("total equity = %f", balance.TotalEquity)
+	}
+	if btc := balance.AccountAssets[currency.BTC]; btc == nil || btc.Equity != 0.02 || btc.EquityUSD != 1000.5 || btc.TotalProfit != -1.2 {
+		t.Errorf("unexpected BTC balance %+v", btc)
+	}
+	if usdt := balance.AccountAssets[currency.USDT]; usdt == nil || usdt.Equity != 2031.03 {
+		t.Errorf("unexpected USDT balance %+v", usdt)
+	}
+
+	bc.secretKey = "wrong"
+	if _, err = bc.GetBalance(context.Background()); !errors.Is(err, ErrInvalidSign) || exchange.IsRetryable(err) {
+		t.Errorf("expected a fatal invalid sign error, got %v", err)
+	}
+}
+
+// filled answers the order lookup live first and filled from then on, with two executions.
+func filled(t *testing.T) map[string]func(r *http.Request) (int, string) {
+	var lookups int
+	return map[string]func(r *http.Request) (int, string){
+		"GET /v5/order/realtime": func(r *http.Request) (int, string) {
+			if r.URL.Query().Get("orderLinkId") == "" {
+				t.Errorf("the order should be looked up by its orderLinkId, got %s", r.URL.RawQuery)
+			}
+			lookups++
+			if lookups == 1 {
+				return http.StatusOK, `{"retCode":0,"retMsg":"OK","result":{"list":[{"orderId":"1700","symbol":"BTCUSDT","side":"Buy","orderStatus":"New","cumExecQty":"0","cumExecValue":"0","cumExecFee":"0","avgPrice":""}]}}`
+			}
+			return http.StatusOK, `{"retCode":0,"retMsg":"OK","result":{"list":[{"orderId":"1700","symbol":"BTCUSDT","side":"Buy","orderStatus":"Filled","cumExecQty":"0.002","cumExecValue":"100","cumExecFee":"0.000002","avgPrice":"50000"}]}}`
+		},
+		"GET /v5/execution/list": func(r *http.Request) (int, string) {
+			if r.URL.Query().Get("orderId") != "1700" {
+				t.Errorf("unexpected executions query %s", r.URL.RawQuery)
+			}
+			return http.StatusOK, `{"retCode":0,"retMsg":"OK","result":{"list":[
+				{"execId":"e2","orderId":"1700","execPrice":"50010","execQty":"0.001","execFee":"0.000001","feeCurrency":"BTC","execTime":"2"},
+				{"execId":"e1","orderId":"1700","execPrice":"49990","execQty":"0.001","execFee":"0.000001","feeCurrency":"BTC","execTime":"1"}]}}`
+		},
+	}
+}
+
+func TestOrder(t *testing.T) {
+	routes := with(filled(t))
+	routes["POST /v5/order/create"] = reply(`{"retCode":0,"retMsg":"OK","result":{"orderId":"1700","orderLinkId":"x"}}`)
+	m, bc := newMock(t, routes)
+	res, err := bc.Order(context.Background(), "BTC-USDT", "buy", "100.123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := m.orders[0]
+	if order["side"] != "Buy" || order["orderType"] != "Market" || order["qty"] != "100.12345678" || order["marketUnit"] != "quoteCoin" || order["orderLinkId"] == "" {
+		t.Errorf("unexpected order %v", order)
+	}
+	if res.OrderId != "1700" || res.State != model.OrderFilled || res.FilledSize != 0.002 || res.AvgPrice != 50000 || res.Side != "buy" {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if math.Abs(res.Fee-0.000002) > 1e-12 || res.FeeCcy != "BTC" || len(res.Fills) != 2 || res.Fills[0].Price != 50010 {
+		t.Errorf("unexpected fill details %+v", res)
+	}
+
+	if _, err = bc.Order(context.Background(), "BTC-USDT", "sell", "0.00001"); !errors.Is(err, model.ErrBelowMinimum) {
+		t.Errorf("a sell below the minimum should be rejected locally, got %v", err)
+	}
+}
+
+func TestOrderRetry(t *testing.T) {
+	cases := []struct {
+		name   string
+		placed bool // whether the order lost with the answer went through
+		sends  int
+	}{
+		{"placed", true, 1},
+		{"not placed", false, 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			routes := with(filled(t))
+			var attempts int
+			routes["POST /v5/order/create"] = func(*http.Request) (int, string) {
+				attempts++
+				if attempts == 1 {
+					return http.StatusBadGateway, `bad gateway`
+				}
+				return http.StatusOK, `{"retCode":0,"retMsg":"OK","result":{"orderId":"1700","orderLinkId":"x"}}`
+			}
+			lookup := routes["GET /v5/order/realtime"]
+			routes["GET /v5/order/realtime"] = func(r *http.Request) (int, string) {
+				if attempts == 1 && !c.placed {
+					return http.StatusOK, `{"retCode":0,"retMsg":"OK","result":{"list":[]}}`
+				}
+				return lookup(r)
+			}
+			m, bc := newMock(t, routes)
+			res, err := bc.Order(context.Background(), "BTC-USDT", "buy", "100")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(m.orders) != c.sends || m.orders[0]["orderLinkId"] != m.orders[len(m.orders)-1]["orderLinkId"] {
+				t.Errorf("expected %d orders with the same orderLinkId, got %v", c.sends, m.orders)
+			}
+			if res.OrderId != "1700" || res.State != model.OrderFilled {
+				t.Errorf("the order should be picked up, got %+v", res)
+			}
+		})
+	}
+}
+
+func TestOrderRejected(t *testing.T) {
+	routes := with(nil)
+	routes["POST /v5/order/create"] = reply(`{"retCode":170131,"retMsg":"Insufficient balance.","result":{}}`)
+	m, bc := newMock(t, routes)
+	_, err := bc.Order(context.Background(), "BTC-USDT", "sell", "1")
+	if !errors.Is(err, ErrInsufficientBalance) || exchange.IsRetryable(err) {
+		t.Errorf("expected a fatal insufficient balance error, got %v", err)
+	}
+	if len(m.orders) != 1 {
+		t.Errorf("a rejected order must not be retried, sent %d times", len(m.orders))
+	}
+}
+
+func TestLimitOrder(t *testing.T) {
+	routes := with(map[string]func(r *http.Request) (int, string){
+		"POST /v5/order/create": reply(`{"retCode":0,"retMsg":"OK","result":{"orderId":"1800","orderLinkId":"x"}}`),
+		"GET /v5/order/realtime": reply(`{"retCode":0,"retMsg":"OK","result":{"list":[
+			{"orderId":"1800","symbol":"BTCUSDT","side":"Sell","orderStatus":"New","cumExecQty":"0","cumExecValue":"0","cumExecFee":"0","avgPrice":""}]}}`),
+		"POST /v5/order/cancel": reply(`{"retCode":0,"retMsg":"OK","result":{"orderId":"1800","orderLinkId":"x"}}`),
+	})
+	m, bc := newMock(t, routes)
+	res, err := bc.LimitOrder(context.Background(), "BTC-USDT", "sell", "0.0123456789", 61234.5678)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := m.orders[0]
+	if order["side"] != "Sell" || order["orderType"] != "Limit" || order["qty"] != "0.012345" || order["price"] != "61234.57" || order["timeInForce"] != "GTC" {
+		t.Errorf("unexpected order %v", order)
+	}
+	if res.OrderId != "1800" || res.State != model.OrderLive || res.Done() {
+		t.Errorf("the limit order should rest on the book, got %+v", res)
+	}
+	if err = bc.CancelOrder(context.Background(), "BTC-USDT", res.OrderId); err != nil {
+		t.Fatal(err)
+	}
+	if cancel := m.orders[1]; cancel["orderId"] != "1800" || cancel["symbol"] != "BTCUSDT" {
+		t.Errorf("unexpected cancel %v", cancel)
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	cases := []struct {
+		name      string
+		status    int
+		body      string
+		is        error
+		retryable bool
+	}{
+		{"ok", 200, `{"retCode":0,"retMsg":"OK","result":{}}`, nil, false},
+		{"rate limit", 200, `{"retCode":10006,"retMsg":"Too many visits!"}`, ErrRateLimit, true},
+		{"rate limit status", 429, `Too Many Requests`, ErrRateLimit, true},
+		{"timestamp", 200, `{"retCode":10002,"retMsg":"invalid request, please check your server timestamp or recv_window param"}`, ErrTimestampExpired, true},
+		{"unknown symbol", 200, `{"retCode":170121,"retMsg":"Invalid symbol."}`, ErrUnknownSymbol, false},
+		{"ip banned", 403, `access too frequent`, nil, false},
+		{"gateway", 502, `bad gateway`, nil, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := checkResponse(c.status, []byte(c.body))
+			if c.name == "ok" {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if c.is != nil && !errors.Is(err, c.is) {
+				t.Errorf("%v is not %v", err, c.is)
+			}
+			if exchange.IsRetryable(err) != c.retryable {
+				t.Errorf("retryable = %v, want %v", exchange.IsRetryable(err), c.retryable)
+			}
+		})
+	}
+}
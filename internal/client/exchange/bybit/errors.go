@@ -0,0 +1,115 @@
+package bybit
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"net/http"
+	"strings"
+)
+
+// The failures knownCodes maps the Bybit retCodes onto, named after the Bybit symbols.
+var (
+	ErrInsufficientBalance = exchange.ErrInsufficientBalance
+	ErrRateLimit           = exchange.ErrRateLimit
+	ErrInvalidSign         = exchange.ErrInvalidSign
+	ErrTimestampExpired    = exchange.ErrTimestampExpired
+	ErrUnknownSymbol       = exchange.ErrUnknownInstrument
+	ErrSymbolSuspended     = exchange.ErrInstrumentSuspended
+	ErrUnavailable         = exchange.ErrUnavailable
+	ErrDuplicateOrder      = exchange.ErrDuplicateOrder
+	ErrOrderNotFound       = exchange.ErrOrderNotFound
+)
+
+type codeInfo struct {
+	err       error
+	retryable bool // the same request may succeed later
+}
+
+// knownCodes classifies the Bybit error codes the bot runs into, see
+// https://bybit-exchange.github.io/docs/v5/error
+var knownCodes = map[int]codeInfo{
+	10002:  {ErrTimestampExpired, true}, // outside of the recv window
+	10004:  {ErrInvalidSign, false},
+	10006:  {ErrRateLimit, true},
+	10018:  {ErrRateLimit, true}, // ip rate limit
+	10016:  {ErrUnavailable, true},
+	110007: {ErrInsufficientBalance, false},
+	170131: {ErrInsufficientBalance, false},
+	170121: {ErrUnknownSymbol, false},
+	110072: {ErrDuplicateOrder, false},
+	170141: {ErrDuplicateOrder, false},
+	170136: {model.ErrBelowMinimum, false}, // quantity below the minimum
+	170140: {model.ErrBelowMinimum, false}, // value below the minimum
+}
+
+// APIError is a request Bybit answered with a non-zero retCode or a failed HTTP status.
+type APIError struct {
+	Status int // HTTP status
+	Code   int // retCode
+	Msg    string
+}
+
+func (e *APIError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("bybit:")
+	if e.Code != 0 {
+		sb.WriteString(fmt.Sprintf(" retCode %d", e.Code))
+	}
+	if e.Status != 0 && e.Status != http.StatusOK {
+		sb.WriteString(fmt.Sprintf(" http %d", e.Status))
+	}
+	if e.Msg != "" {
+		sb.WriteString(": " + e.Msg)
+	}
+	return sb.String()
+}
+
+// Is matches the well-known errors of the retCode of e.
+func (e *APIError) Is(target error) bool {
+	if target == ErrRateLimit && e.Status == http.StatusTooManyRequests {
+		return true
+	}
+	info, ok := knownCodes[e.Code]
+	return ok && info.err == target
+}
+
+// Retryable reports whether sending the same request again may succeed: the HTTP status is
+// 429 or 5xx, or the retCode is known to be retryable. A 403 is an ip ban and not retried.
+func (e *APIError) Retryable() bool {
+	if e.Status == http.StatusTooManyRequests || e.Status >= http.StatusInternalServerError {
+		return true
+	}
+	info, ok := knownCodes[e.Code]
+	return ok && info.retryable
+}
+
+// HTTPStatus returns the HTTP status, a failed request is usually a 200 with a retCode.
+func (e *APIError) HTTPStatus() int {
+	return e.Status
+}
+
+// envelope is the part every Bybit v5 answer shares.
+type envelope struct {
+	RetCode *int   `json:"retCode"`
+	RetMsg  string `json:"retMsg"`
+}
+
+// checkResponse returns an APIError when body is not a success.
+func checkResponse(status int, body []byte) error {
+	var env envelope
+	if err := json.Unmarshal(body, &env); err != nil || env.RetCode == nil {
+		if status != http.StatusOK {
+			return &APIError{Status: status, Msg: strings.TrimSpace(string(body))}
+		}
+		if err != nil {
+			return fmt.Errorf("[bybit.checkResponse] invalid response: %w", err)
+		}
+		return fmt.Errorf("[bybit.checkResponse] response without retCode")
+	}
+	if *env.RetCode == 0 && status == http.StatusOK {
+		return nil
+	}
+	return &APIError{Status: status, Code: *env.RetCode, Msg: env.RetMsg}
+}
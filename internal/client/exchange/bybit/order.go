@@ -0,0 +1,283 @@
+package bybit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Instrument returns the trading rules of symbol, cached for an hour. It fails with
+// ErrSymbolSuspended when the symbol does not trade.
+func (bc *Client) Instrument(ctx context.Context, symbol string) (model.Instrument, error) {
+	return bc.instruments.Get(ctx, symbol)
+}
+
+// getInstrument fetches the rules of symbol.
+func (bc *Client) getInstrument(ctx context.Context, symbol string) (map[string]exchange.Listing, error) {
+	resp := &InstrumentsResponse{}
+	req := bc.restClient.R().WithContext(ctx).SetResult(resp).SetQueryParams(map[string]string{
+		"category": "spot",
+		"symbol":   symbol,
+	})
+	if err := bc.doRestyRequest(req, http.MethodGet, "/v5/market/instruments-info"); err != nil {
+		return nil, err
+	}
+	res := make(map[string]exchange.Listing, len(resp.Result.List))
+	for _, d := range resp.Result.List {
+		res[d.Symbol] = exchange.Listing{
+			Instrument: model.Instrument{
+				InstId:      d.BaseCoin + "-" + d.QuoteCoin,
+				Base:        d.BaseCoin,
+				Quote:       d.QuoteCoin,
+				LotSize:     d.LotSizeFilter.BasePrecision.Float64(),
+				MinSize:     d.LotSizeFilter.MinOrderQty.Float64(),
+				TickSize:    d.PriceFilter.TickSize.Float64(),
+				MinNotional: d.LotSizeFilter.MinOrderAmt.Float64(),
+				QuoteStep:   d.LotSizeFilter.QuotePrecision.Float64(),
+			},
+			Trading: d.Status == "Trading",
+		}
+	}
+	return res, nil
+}
+
+// Order places a market order and waits for it to settle. instId is "COIN-QUOTE"; sz is the
+// quote amount to spend for a buy and the coin amount for a sell, rounded to the rules of
+// the symbol first. An order still live after the wait is returned as is.
+//
+// The order carries an orderLinkId. When the answer is lost the order is looked up by it and
+// only placed again when Bybit does not know it, so it cannot be placed twice.
+func (bc *Client) Order(ctx context.Context, instId, side, sz string) (*model.OrderResult, error) {
+	sz, err := bc.NormalizeOrder(ctx, instId, side, sz)
+	if err != nil {
+		return nil, err
+	}
+	body := map[string]string{
+		"category":   "spot",
+		"symbol":     symbolOf(instId),
+		"orderType":  "Market",
+		"qty":        sz,
+		"marketUnit": "quoteCoin",
+	}
+	if side == "sell" {
+		body["marketUnit"] = "baseCoin"
+	}
+	orderLinkId, err := bc.placeOrder(ctx, instId, side, body)
+	if err != nil {
+		return nil, err
+	}
+	return bc.waitOrder(ctx, instId, side, orderLinkId), nil
+}
+
+// NormalizeOrder returns sz of a market order rounded to the rules of the symbol as Order
+// sends it, or the error Order would fail with, without placing the order.
+func (bc *Client) NormalizeOrder(ctx context.Context, instId, side, sz string) (string, error) {
+	symbol := symbolOf(instId)
+	amount, err := strconv.ParseFloat(sz, 64)
+	if err != nil {
+		return "", fmt.Errorf("[bybit.Order] invalid size %q: %w", sz, err)
+	}
+	inst, err := bc.Instrument(ctx, symbol)
+	if err != nil {
+		return "", err
+	}
+	switch side {
+	case "buy":
+		amount = inst.FloorQuote(amount)
+		ticker, err := bc.GetTicker(ctx, symbol)
+		if err != nil {
+			return "", err
+		}
+		price := ticker.LastPrice.Float64()
+		if price <= 0 {
+			return "", fmt.Errorf("[bybit.Order] no price for %s", symbol)
+		}
+		if err = inst.CheckSize(amount/price, price); err != nil {
+			return "", fmt.Errorf("[bybit.Order] buy of %s %s: %w", inst.FormatQuote(amount), inst.Quote, err)
+		}
+		return inst.FormatQuote(amount), nil
+	case "sell":
+		amount = inst.FloorSize(amount)
+		if err = inst.CheckSize(amount, 0); err != nil {
+			return "", fmt.Errorf("[bybit.Order] sell: %w", err)
+		}
+		return inst.FormatSize(amount), nil
+	}
+	return "", fmt.Errorf("[bybit.Order] unknown side %q", side)
+}
+
+// LimitOrder places a good-till-canceled limit order of sz coins at price, both rounded to
+// the rules of the symbol, and returns its state right after placing. The order may rest on
+// the book; look it up with GetOrder and cancel it with CancelOrder.
+func (bc *Client) LimitOrder(ctx context.Context, instId, side, sz string, price float64) (*model.OrderResult, error) {
+	symbol := symbolOf(instId)
+	amount, err := strconv.ParseFloat(sz, 64)
+	if err != nil {
+		return nil, fmt.Errorf("[bybit.LimitOrder] invalid size %q: %w", sz, err)
+	}
+	if side != "buy" && side != "sell" {
+		return nil, fmt.Errorf("[bybit.LimitOrder] unknown side %q", side)
+	}
+	inst, err := bc.Instrument(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+	amount, price = inst.FloorSize(amount), inst.RoundPrice(price)
+	if err = inst.CheckSize(amount, price); err != nil {
+		return nil, fmt.Errorf("[bybit.LimitOrder] %s: %w", side, err)
+	}
+	orderLinkId, err := bc.placeOrder(ctx, instId, side, map[string]string{
+		"category":    "spot",
+		"symbol":      symbol,
+		"orderType":   "Limit",
+		"qty":         inst.FormatSize(amount),
+		"price":       inst.FormatPrice(price),
+		"timeInForce": "GTC",
+	})
+	if err != nil {
+		return nil, err
+	}
+	res, err := bc.getOrder(ctx, instId, "orderLinkId", orderLinkId)
+	if err != nil {
+		// the order is accepted, report what is known
+		log.Println(fmt.Sprintf("[bybit.LimitOrder] %s 查询订单状态失败: %s", orderLinkId, err.Error()))
+		return &model.OrderResult{InstId: instId, Side: side, State: model.OrderLive}, nil
+	}
+	return res, nil
+}
+
+// placeOrder sends the order in body with side and a new orderLinkId, which it returns. An
+// order whose answer is lost is looked up before it is sent again, see exchange.Retry.Place.
+func (bc *Client) placeOrder(ctx context.Context, instId, side string, body map[string]string) (string, error) {
+	orderLinkId := exchange.ClientOrderId()
+	body["side"] = strings.ToUpper(side[:1]) + side[1:]
+	body["orderLinkId"] = orderLinkId
+	place := func() error {
+		req := bc.restClient.R().WithContext(ctx).SetResult(&CreateOrderResponse{})
+		return bc.doRestyRequest(req, http.MethodPost, "/v5/order/create", body)
+	}
+	var placed bool
+	found := func() (bool, error) {
+		_, err := bc.getOrder(ctx, instId, "orderLinkId", orderLinkId)
+		if errors.Is(err, ErrOrderNotFound) {
+			return false, nil
+		}
+		placed = err == nil
+		return placed, err
+	}
+	err := bc.retry.Place(ctx, place, found)
+	if errors.Is(err, ErrDuplicateOrder) || err == nil && placed {
+		log.Println(fmt.Sprintf("[bybit.Order] %s 已提交, 使用先前的订单", orderLinkId))
+		return orderLinkId, nil
+	}
+	return orderLinkId, err
+}
+
+// waitOrder polls the order until it is filled or canceled, then fetches its fills.
+func (bc *Client) waitOrder(ctx context.Context, instId, side, orderLinkId string) *model.OrderResult {
+	res := &model.OrderResult{InstId: instId, Side: side, State: model.OrderLive}
+	res = bc.orders.Settle(ctx, orderLinkId, res, func() (*model.OrderResult, error) {
+		return bc.getOrder(ctx, instId, "orderLinkId", orderLinkId)
+	})
+	bc.orders.Fill(res, func() ([]model.OrderFill, error) {
+		return bc.GetFills(ctx, instId, res.OrderId)
+	})
+	return res
+}
+
+// GetOrder returns the state of orderId.
+func (bc *Client) GetOrder(ctx context.Context, instId, orderId string) (*model.OrderResult, error) {
+	return bc.getOrder(ctx, instId, "orderId", orderId)
+}
+
+// CancelOrder cancels the open order orderId.
+func (bc *Client) CancelOrder(ctx context.Context, instId, orderId string) error {
+	req := bc.restClient.R().WithContext(ctx).SetResult(&CreateOrderResponse{})
+	return bc.doRestyRequest(req, http.MethodPost, "/v5/order/cancel", map[string]string{
+		"category": "spot",
+		"symbol":   symbolOf(instId),
+		"orderId":  orderId,
+	})
+}
+
+// states maps the Bybit order status to the model order states.
+var states = map[string]string{
+	"New":                     model.OrderLive,
+	"Untriggered":             model.OrderLive,
+	"PartiallyFilled":         model.OrderPartiallyFilled,
+	"Filled":                  model.OrderFilled,
+	"Cancelled":               model.OrderCanceled,
+	"PartiallyFilledCanceled": model.OrderCanceled,
+	"Rejected":                model.OrderCanceled,
+	"Deactivated":             model.OrderCanceled,
+}
+
+// getOrder looks an order up by orderId or orderLinkId. A spot buy pays its fee in the coin
+// and a sell in the quote currency.
+func (bc *Client) getOrder(ctx context.Context, instId, idParam, id string) (*model.OrderResult, error) {
+	resp := &OrdersResponse{}
+	req := bc.restClient.R().WithContext(ctx).SetResult(resp).SetQueryParams(map[string]string{
+		"category": "spot",
+		"symbol":   symbolOf(instId),
+		idParam:    id,
+	})
+	if err := bc.doRestyRequest(req, http.MethodGet, "/v5/order/realtime"); err != nil {
+		return nil, err
+	}
+	if len(resp.Result.List) == 0 {
+		return nil, fmt.Errorf("[bybit.GetOrder] order %s: %w", id, ErrOrderNotFound)
+	}
+	o := resp.Result.List[0]
+	res := &model.OrderResult{
+		OrderId:    o.OrderId,
+		InstId:     instId,
+		Side:       strings.ToLower(o.Side),
+		State:      states[o.OrderStatus],
+		FilledSize: o.CumExecQty.Float64(),
+		AvgPrice:   o.AvgPrice.Float64(),
+		Fee:        o.CumExecFee.Float64(),
+	}
+	if res.State == "" {
+		res.State = strings.ToLower(o.OrderStatus)
+	}
+	if res.AvgPrice == 0 && res.FilledSize > 0 {
+		res.AvgPrice = o.CumExecValue.Float64() / res.FilledSize
+	}
+	coin, quote, _ := strings.Cut(instId, "-")
+	res.FeeCcy = coin
+	if res.Side == "sell" {
+		res.FeeCcy = quote
+	}
+	return res, nil
+}
+
+// GetFills returns the executions that filled orderId, newest first.
+func (bc *Client) GetFills(ctx context.Context, instId, orderId string) ([]model.OrderFill, error) {
+	resp := &ExecutionsResponse{}
+	req := bc.restClient.R().WithContext(ctx).SetResult(resp).SetQueryParams(map[string]string{
+		"category": "spot",
+		"symbol":   symbolOf(instId),
+		"orderId":  orderId,
+	})
+	if err := bc.doRestyRequest(req, http.MethodGet, "/v5/execution/list"); err != nil {
+		return nil, err
+	}
+	res := make([]model.OrderFill, len(resp.Result.List))
+	for i, e := range resp.Result.List {
+		res[i] = model.OrderFill{
+			TradeId: e.ExecId,
+			Ts:      e.ExecTime,
+			Price:   e.ExecPrice.Float64(),
+			Size:    e.ExecQty.Float64(),
+			Fee:     e.ExecFee.Float64(),
+			FeeCcy:  e.FeeCurrency,
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,26 @@
+package bybit
+
+import (
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"net/http"
+	"time"
+)
+
+// defaultEndpoint applies to endpoints missing from endpoints, the ip limit is 600 requests
+// per 5 seconds over all endpoints.
+var defaultEndpoint = exchange.Endpoint{Requests: 50, Window: 5 * time.Second}
+
+// endpoints are the limits of the endpoints the client uses, see
+// https://bybit-exchange.github.io/docs/v5/rate-limit
+// Orders are not idempotent: Bybit does not promise to reject a reused orderLinkId once the
+// order is closed. placeOrder looks a lost order up instead, see exchange.Retry.Place.
+var endpoints = map[string]exchange.Endpoint{
+	http.MethodGet + " /v5/market/kline":            {Requests: 50, Window: time.Second},
+	http.MethodGet + " /v5/market/tickers":          {Requests: 50, Window: time.Second},
+	http.MethodGet + " /v5/market/instruments-info": {Requests: 20, Window: time.Second},
+	http.MethodGet + " /v5/account/wallet-balance":  {Requests: 10, Window: time.Second},
+	http.MethodPost + " /v5/order/create":           {Requests: 10, Window: time.Second},
+	http.MethodPost + " /v5/order/cancel":           {Requests: 10, Window: time.Second},
+	http.MethodGet + " /v5/order/realtime":          {Requests: 50, Window: time.Second},
+	http.MethodGet + " /v5/execution/list":          {Requests: 50, Window: time.Second},
+}
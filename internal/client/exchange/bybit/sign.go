@@ -0,0 +1,15 @@
+package bybit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Sign returns the X-BAPI-SIGN header: the HMAC-SHA256 of timestamp, api key, recv window
+// and the query string of a GET or the JSON body of a POST, hex encoded.
+func Sign(timestamp, apiKey, recvWindow, payload, secretKey string) string {
+	h := hmac.New(sha256.New, []byte(secretKey))
+	h.Write([]byte(timestamp + apiKey + recvWindow + payload))
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,123 @@
+package bybit
+
+import (
+	"github.com/twoonefour/sigmaflow/pkg"
+)
+
+type KlineResponse struct {
+	RetCode int    `json:"retCode"`
+	RetMsg  string `json:"retMsg"`
+	Result  struct {
+		Symbol string     `json:"symbol"`
+		List   [][]string `json:"list"` // [startTime, open, high, low, close, volume, turnover], newest first
+	} `json:"result"`
+}
+
+type CoinData struct {
+	Coin           string          `json:"coin"`
+	Equity         pkg.TextFloat64 `json:"equity"`
+	UsdValue       pkg.TextFloat64 `json:"usdValue"`
+	WalletBalance  pkg.TextFloat64 `json:"walletBalance"`
+	UnrealisedPnl  pkg.TextFloat64 `json:"unrealisedPnl"`
+	CumRealisedPnl pkg.TextFloat64 `json:"cumRealisedPnl"`
+}
+
+type WalletData struct {
+	AccountType string          `json:"accountType"`
+	TotalEquity pkg.TextFloat64 `json:"totalEquity"`
+	Coin        []CoinData      `json:"coin"`
+}
+
+type WalletResponse struct {
+	RetCode int    `json:"retCode"`
+	RetMsg  string `json:"retMsg"`
+	Result  struct {
+		List []WalletData `json:"list"`
+	} `json:"result"`
+}
+
+type TickerData struct {
+	Symbol    string          `json:"symbol"`
+	LastPrice pkg.TextFloat64 `json:"lastPrice"`
+	Bid1Price pkg.TextFloat64 `json:"bid1Price"`
+	Ask1Price pkg.TextFloat64 `json:"ask1Price"`
+}
+
+type TickerResponse struct {
+	RetCode int    `json:"retCode"`
+	RetMsg  string `json:"retMsg"`
+	Result  struct {
+		List []TickerData `json:"list"`
+	} `json:"result"`
+}
+
+type InstrumentData struct {
+	Symbol        string `json:"symbol"`
+	BaseCoin      string `json:"baseCoin"`
+	QuoteCoin     string `json:"quoteCoin"`
+	Status        string `json:"status"` // Trading, PreLaunch, Delivering, Closed
+	LotSizeFilter struct {
+		BasePrecision  pkg.TextFloat64 `json:"basePrecision"`
+		QuotePrecision pkg.TextFloat64 `json:"quotePrecision"`
+		MinOrderQty    pkg.TextFloat64 `json:"minOrderQty"`
+		MinOrderAmt    pkg.TextFloat64 `json:"minOrderAmt"`
+	} `json:"lotSizeFilter"`
+	PriceFilter struct {
+		TickSize pkg.TextFloat64 `json:"tickSize"`
+	} `json:"priceFilter"`
+}
+
+type InstrumentsResponse struct {
+	RetCode int    `json:"retCode"`
+	RetMsg  string `json:"retMsg"`
+	Result  struct {
+		List []InstrumentData `json:"list"`
+	} `json:"result"`
+}
+
+type CreateOrderResponse struct {
+	RetCode int    `json:"retCode"`
+	RetMsg  string `json:"retMsg"`
+	Result  struct {
+		OrderId     string `json:"orderId"`
+		OrderLinkId string `json:"orderLinkId"`
+	} `json:"result"`
+}
+
+type OrderData struct {
+	OrderId      string          `json:"orderId"`
+	OrderLinkId  string          `json:"orderLinkId"`
+	Symbol       string          `json:"symbol"`
+	Side         string          `json:"side"`        // Buy, Sell
+	OrderStatus  string          `json:"orderStatus"` // New, PartiallyFilled, Filled, Cancelled, ...
+	CumExecQty   pkg.TextFloat64 `json:"cumExecQty"`
+	CumExecValue pkg.TextFloat64 `json:"cumExecValue"`
+	CumExecFee   pkg.TextFloat64 `json:"cumExecFee"`
+	AvgPrice     pkg.TextFloat64 `json:"avgPrice"`
+}
+
+type OrdersResponse struct {
+	RetCode int    `json:"retCode"`
+	RetMsg  string `json:"retMsg"`
+	Result  struct {
+		List []OrderData `json:"list"`
+	} `json:"result"`
+}
+
+type ExecutionData struct {
+	ExecId      string          `json:"execId"`
+	OrderId     string          `json:"orderId"`
+	ExecPrice   pkg.TextFloat64 `json:"execPrice"`
+	ExecQty     pkg.TextFloat64 `json:"execQty"`
+	ExecFee     pkg.TextFloat64 `json:"execFee"`
+	FeeCurrency string          `json:"feeCurrency"`
+	ExecTime    string          `json:"execTime"`
+}
+
+type ExecutionsResponse struct {
+	RetCode int    `json:"retCode"`
+	RetMsg  string `json:"retMsg"`
+	Result  struct {
+		List []ExecutionData `json:"list"`
+	} `json:"result"`
+}
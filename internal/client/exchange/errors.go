@@ -0,0 +1,60 @@
+// Package exchange holds what the exchange clients share: the well-known failures every
+// exchange reports, request rate limits, the retry policy and the order and instrument
+// handling the clients have in common.
+package exchange
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Well-known failures. The clients map their error codes onto these, so errors.Is works
+// the same on an error of any exchange.
+var (
+	ErrInsufficientBalance = errors.New("exchange: insufficient balance")
+	ErrRateLimit           = errors.New("exchange: rate limit reached")
+	ErrInvalidSign         = errors.New("exchange: invalid sign")
+	ErrTimestampExpired    = errors.New("exchange: request timestamp expired")
+	ErrUnknownInstrument   = errors.New("exchange: unknown instrument")
+	ErrInstrumentSuspended = errors.New("exchange: instrument suspended")
+	ErrUnavailable         = errors.New("exchange: service temporarily unavailable")
+	ErrDuplicateOrder      = errors.New("exchange: duplicated client order id")
+	ErrOrderNotFound       = errors.New("exchange: order not found")
+)
+
+// APIError is a request the exchange answered with an error, implemented by the APIError
+// of every client.
+type APIError interface {
+	error
+	// HTTPStatus is the status of the answer.
+	HTTPStatus() int
+	// Retryable reports whether sending the same request again may succeed.
+	Retryable() bool
+}
+
+// IsRetryable reports whether err is an APIError worth retrying.
+func IsRetryable(err error) bool {
+	var apiErr APIError
+	return errors.As(err, &apiErr) && apiErr.Retryable()
+}
+
+// Retryable reports whether a request that failed with err may be sent again. Requests the
+// exchange turned away (rate limit, busy, expired timestamp) are always retried; when the
+// outcome is unknown (a network error or a 5xx) only reads and idempotent requests are.
+func Retryable(method string, idempotent bool, err error) bool {
+	var apiErr APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPStatus() < http.StatusInternalServerError {
+		return apiErr.Retryable()
+	}
+	return method == http.MethodGet || idempotent
+}
+
+// Ambiguous reports whether a write that failed with err may have gone through anyway: the
+// answer was lost (a network error) or the exchange failed half way (a 5xx).
+func Ambiguous(err error) bool {
+	var apiErr APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatus() >= http.StatusInternalServerError
+	}
+	return err != nil
+}
@@ -0,0 +1,96 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"log"
+	"sync"
+	"time"
+)
+
+// Listing is the trading rules of an instrument as an exchange lists them.
+type Listing struct {
+	model.Instrument
+	Trading bool // false while the instrument is suspended or delisted
+}
+
+// InstrumentFetch fetches the listing of id. It may return the listings of other instruments
+// along with it, e.g. when the exchange only lists all of them at once, they are cached too.
+// id is missing from the result when the exchange does not list it.
+type InstrumentFetch func(ctx context.Context, id string) (map[string]Listing, error)
+
+// Instruments caches the trading rules of the instruments of a client by id.
+type Instruments struct {
+	Name  string        // log prefix, e.g. okx
+	TTL   time.Duration // how long a listing is cached
+	Retry time.Duration // how long a stale listing is used after a failed refresh
+	fetch InstrumentFetch
+
+	mu   sync.Mutex
+	byId map[string]listing
+}
+
+type listing struct {
+	Listing
+	listed  bool      // false for an id the exchange does not know, it is asked again after Retry
+	refresh time.Time // when it is fetched again
+}
+
+// NewInstruments caches the listings of fetch for an hour. Exchanges rarely change the
+// rules of a listed instrument.
+func NewInstruments(name string, fetch InstrumentFetch) *Instruments {
+	return &Instruments{Name: name, TTL: time.Hour, Retry: 30 * time.Second, fetch: fetch, byId: make(map[string]listing)}
+}
+
+// Get returns the rules of id, fetching them when they are not cached or stale. The cache is
+// not locked during the fetch, so orders of cached instruments do not wait for it. A failed
+// refresh keeps the stale rules and is tried again after Retry, it only fails when nothing
+// is cached. Get fails with ErrUnknownInstrument when the exchange does not list id and with
+// ErrInstrumentSuspended when it does not trade.
+func (c *Instruments) Get(ctx context.Context, id string) (model.Instrument, error) {
+	c.mu.Lock()
+	l, ok := c.byId[id]
+	c.mu.Unlock()
+	if !ok || !time.Now().Before(l.refresh) {
+		if err := c.refresh(ctx, id); err != nil {
+			return model.Instrument{}, err
+		}
+		c.mu.Lock()
+		l = c.byId[id]
+		c.mu.Unlock()
+	}
+	if !l.listed {
+		return model.Instrument{}, fmt.Errorf("[%s.Instrument] %s: %w", c.Name, id, ErrUnknownInstrument)
+	}
+	if !l.Trading {
+		return l.Instrument, fmt.Errorf("[%s.Instrument] %s: %w", c.Name, id, ErrInstrumentSuspended)
+	}
+	return l.Instrument, nil
+}
+
+// refresh fetches id into the cache.
+func (c *Instruments) refresh(ctx context.Context, id string) error {
+	fetched, err := c.fetch(ctx, id)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if err != nil {
+		l, ok := c.byId[id]
+		if !ok || !l.listed {
+			return err
+		}
+		// keep using the stale rules, they are still better than none
+		log.Println(fmt.Sprintf("[%s.Instrument] 更新交易规则失败, %s 后重试: %s", c.Name, c.Retry, err.Error()))
+		l.refresh = now.Add(c.Retry)
+		c.byId[id] = l
+		return nil
+	}
+	for k, v := range fetched {
+		c.byId[k] = listing{Listing: v, listed: true, refresh: now.Add(c.TTL)}
+	}
+	if _, ok := fetched[id]; !ok {
+		c.byId[id] = listing{refresh: now.Add(c.Retry)}
+	}
+	return nil
+}
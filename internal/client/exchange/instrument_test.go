@@ -0,0 +1,69 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"testing"
+	"time"
+)
+
+func TestInstrumentRefreshFailure(t *testing.T) {
+	var fetches int
+	var failure error
+	c := NewInstruments("test", func(ctx context.Context, id string) (map[string]Listing, error) {
+		fetches++
+		if failure != nil {
+			return nil, failure
+		}
+		return map[string]Listing{
+			"BTC-USDT":  {Instrument: model.Instrument{InstId: "BTC-USDT", LotSize: 0.00000001}, Trading: true},
+			"LUNA-USDT": {Instrument: model.Instrument{InstId: "LUNA-USDT"}},
+		}, nil
+	})
+	ctx := context.Background()
+	if _, err := c.Get(ctx, "BTC-USDT"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "LUNA-USDT"); !errors.Is(err, ErrInstrumentSuspended) || fetches != 1 {
+		t.Fatalf("expected the cached LUNA-USDT to be suspended, fetched %d times (%v)", fetches, err)
+	}
+
+	// the refresh fails, the stale rules are used and fetched again soon
+	failure = errors.New("operation failed")
+	expire(c, "BTC-USDT")
+	if inst, err := c.Get(ctx, "BTC-USDT"); err != nil || inst.LotSize != 0.00000001 {
+		t.Fatalf("expected the stale rules, got %+v (%v)", inst, err)
+	}
+	if fetches != 2 || time.Until(c.byId["BTC-USDT"].refresh) > c.Retry {
+		t.Fatalf("expected a retry after %s, fetched %d times, next at %s", c.Retry, fetches, c.byId["BTC-USDT"].refresh)
+	}
+	if _, err := c.Get(ctx, "ETH-USDT"); !errors.Is(err, failure) {
+		t.Fatalf("expected the failure without any rules cached, got %v", err)
+	}
+	failure = nil
+	expire(c, "BTC-USDT")
+	if _, err := c.Get(ctx, "BTC-USDT"); err != nil || fetches != 4 {
+		t.Fatalf("expected the refresh to be retried, fetched %d times (%v)", fetches, err)
+	}
+	if time.Until(c.byId["BTC-USDT"].refresh) <= c.Retry {
+		t.Errorf("a successful refresh should hold for %s", c.TTL)
+	}
+
+	// an unknown instrument is only asked for again after Retry
+	for range 2 {
+		if _, err := c.Get(ctx, "ETH-USDT"); !errors.Is(err, ErrUnknownInstrument) {
+			t.Fatalf("expected ErrUnknownInstrument, got %v", err)
+		}
+	}
+	if fetches != 5 {
+		t.Errorf("expected the unknown instrument to be cached, fetched %d times", fetches)
+	}
+}
+
+// expire makes the cached rules of id stale.
+func expire(c *Instruments, id string) {
+	l := c.byId[id]
+	l.refresh = time.Now()
+	c.byId[id] = l
+}
@@ -0,0 +1,160 @@
+package okx
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// protective algo order types placed by PlaceStop
+var stopOrdTypes = []string{"oco", "conditional"}
+
+// stopPrefix starts the algoClOrdId of the stops placed with tag, so CancelStops can tell
+// them from algo orders placed by hand or with another tag.
+func stopPrefix(tag string) string {
+	h := fnv.New32a()
+	h.Write([]byte(tag))
+	return fmt.Sprintf("sl%08x", h.Sum32())
+}
+
+// newAlgoClOrdId returns a client id for a stop placed with tag, OKX allows up to 32 letters
+// and digits.
+func newAlgoClOrdId(tag string) string {
+	return stopPrefix(tag) + strconv.FormatInt(time.Now().UnixMilli(), 36) + strconv.FormatUint(rand.Uint64(), 36)
+}
+
+// PlaceStop places a sell algo order for sz of instId that closes the position at market when
+// price falls to stopLoss or rises to takeProfit. A zero level is left out; with both set the
+// order is an OCO, otherwise a one-way conditional order. Size and prices are rounded to
+// the precision of the instrument. tag identifies the placer, e.g. the strategy, see
+// CancelStops.
+func (oc *Client) PlaceStop(ctx context.Context, instId, tag, sz string, stopLoss, takeProfit float64) (string, error) {
+	if stopLoss <= 0 && takeProfit <= 0 {
+		return "", fmt.Errorf("[client.PlaceStop] no stop loss or take profit for %s", instId)
+	}
+	size, err := strconv.ParseFloat(sz, 64)
+	if err != nil {
+		return "", fmt.Errorf("[client.PlaceStop] invalid size %q: %w", sz, err)
+	}
+	inst, err := oc.Instrument(ctx, instId)
+	if err != nil {
+		return "", err
+	}
+	size = inst.FloorSize(size)
+	if err = inst.CheckSize(size, 0); err != nil {
+		return "", fmt.Errorf("[client.PlaceStop] %w", err)
+	}
+	path := "/api/v5/trade/order-algo"
+	body := map[string]string{
+		"instId":  instId,
+		"tdMode":  "cash",
+		"side":    "sell",
+		"ordType": "conditional",
+		"sz":      inst.FormatSize(size),
+		// the id marks the stop as ours, see CancelStops
+		"algoClOrdId": newAlgoClOrdId(tag),
+	}
+	if stopLoss > 0 {
+		body["slTriggerPx"] = inst.FormatPrice(inst.RoundPrice(stopLoss))
+		body["slOrdPx"] = "-1"
+	}
+	if takeProfit > 0 {
+		body["tpTriggerPx"] = inst.FormatPrice(inst.RoundPrice(takeProfit))
+		body["tpOrdPx"] = "-1"
+	}
+	if stopLoss > 0 && takeProfit > 0 {
+		body["ordType"] = "oco"
+	}
+
+	resp := &AlgoOrderResponse{}
+	req := oc.restClient.R().WithContext(ctx).SetResult(resp)
+	if err = oc.doRestyRequest(req, http.MethodPost, path, body); err != nil {
+		return "", err
+	}
+	if len(resp.Data) == 0 {
+		return "", fmt.Errorf("[client.PlaceStop] empty response")
+	}
+	return resp.Data[0].AlgoId, nil
+}
+
+// GetPendingStops lists the untriggered stop orders of instId placed by PlaceStop with tag.
+func (oc *Client) GetPendingStops(ctx context.Context, instId, tag string) ([]PendingAlgoData, error) {
+	path := "/api/v5/trade/orders-algo-pending"
+	res := make([]PendingAlgoData, 0)
+	for _, ordType := range stopOrdTypes {
+		resp := &PendingAlgoResponse{}
+		req := oc.restClient.R().WithContext(ctx).SetResult(resp).SetQueryParams(map[string]string{
+			"instType": "SPOT",
+			"instId":   instId,
+			"ordType":  ordType,
+		})
+		if err := oc.doRestyRequest(req, http.MethodGet, path); err != nil {
+			return nil, err
+		}
+		for _, p := range resp.Data {
+			if strings.HasPrefix(p.AlgoClOrdId, stopPrefix(tag)) {
+				res = append(res, p)
+			}
+		}
+	}
+	return res, nil
+}
+
+// GetTriggeredStops returns the ids of the orders the stops of instId placed by PlaceStop
+// with tag triggered, as far back as OKX keeps the algo order history.
+func (oc *Client) GetTriggeredStops(ctx context.Context, instId, tag string) ([]string, error) {
+	path := "/api/v5/trade/orders-algo-history"
+	res := make([]string, 0)
+	for _, ordType := range stopOrdTypes {
+		resp := &AlgoHistoryResponse{}
+		req := oc.restClient.R().WithContext(ctx).SetResult(resp).SetQueryParams(map[string]string{
+			"instType": "SPOT",
+			"instId":   instId,
+			"ordType":  ordType,
+			"state":    "effective",
+		})
+		if err := oc.doRestyRequest(req, http.MethodGet, path); err != nil {
+			return nil, err
+		}
+		for _, a := range resp.Data {
+			if !strings.HasPrefix(a.AlgoClOrdId, stopPrefix(tag)) {
+				continue
+			}
+			if len(a.OrdIdList) > 0 {
+				res = append(res, a.OrdIdList...)
+			} else if a.OrdId != "" && a.OrdId != "0" {
+				res = append(res, a.OrdId)
+			}
+		}
+	}
+	return res, nil
+}
+
+// CancelStops cancels the pending stop orders of instId placed by PlaceStop with tag. Algo
+// orders placed by hand or with another tag, e.g. by another strategy on the account, are
+// left alone.
+func (oc *Client) CancelStops(ctx context.Context, instId, tag string) error {
+	pending, err := oc.GetPendingStops(ctx, instId, tag)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	path := "/api/v5/trade/cancel-algos"
+	body := make([]map[string]string, len(pending))
+	for i, p := range pending {
+		body[i] = map[string]string{
+			"algoId": p.AlgoId,
+			"instId": p.InstId,
+		}
+	}
+	resp := &AlgoOrderResponse{}
+	req := oc.restClient.R().WithContext(ctx).SetResult(resp)
+	return oc.doRestyRequest(req, http.MethodPost, path, body)
+}
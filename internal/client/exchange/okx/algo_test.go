@@ -0,0 +1,85 @@
+package okx
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCancelStops(t *testing.T) {
+	var placed string
+	var canceled []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if serveMarket(w, r) {
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v5/trade/order-algo":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			placed = body["algoClOrdId"]
+			w.Write([]byte(`{"code":"0","data":[{"algoId":"1","sCode":"0"}]}`))
+		case "/api/v5/trade/orders-algo-pending":
+			if r.URL.Query().Get("ordType") != "conditional" {
+				w.Write([]byte(`{"code":"0","data":[]}`))
+				return
+			}
+			// ours, one placed by hand and one of another strategy
+			w.Write([]byte(`{"code":"0","data":[
+				{"algoId":"1","instId":"BTC-USDT","algoClOrdId":"` + placed + `"},
+				{"algoId":"2","instId":"BTC-USDT","algoClOrdId":""},
+				{"algoId":"3","instId":"BTC-USDT","algoClOrdId":"` + newAlgoClOrdId("alts") + `"}]}`))
+		case "/api/v5/trade/cancel-algos":
+			json.NewDecoder(r.Body).Decode(&canceled)
+			w.Write([]byte(`{"code":"0","data":[{"algoId":"1","sCode":"0"}]}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+	oc, _ := NewOkxClient("pass", "secret", "key", "0")
+	oc.restClient.SetBaseURL(server.URL)
+	if _, err := oc.PlaceStop(context.Background(), "BTC-USDT", "majors", "0.01", 48000, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(placed) > 32 || !strings.HasPrefix(placed, stopPrefix("majors")) {
+		t.Fatalf("unexpected algoClOrdId %q", placed)
+	}
+	if err := oc.CancelStops(context.Background(), "BTC-USDT", "majors"); err != nil {
+		t.Fatal(err)
+	}
+	if len(canceled) != 1 || canceled[0]["algoId"] != "1" {
+		t.Errorf("only the stop of the strategy should be canceled, got %v", canceled)
+	}
+}
+
+func TestGetTriggeredStops(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v5/trade/orders-algo-history" || r.URL.Query().Get("state") != "effective" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.String())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("ordType") != "oco" {
+			w.Write([]byte(`{"code":"0","data":[]}`))
+			return
+		}
+		// ours, one placed by hand and one of another strategy
+		w.Write([]byte(`{"code":"0","data":[
+			{"algoId":"1","instId":"BTC-USDT","algoClOrdId":"` + newAlgoClOrdId("majors") + `","state":"effective","ordIdList":["42"]},
+			{"algoId":"2","instId":"BTC-USDT","algoClOrdId":"","state":"effective","ordId":"43"},
+			{"algoId":"3","instId":"BTC-USDT","algoClOrdId":"` + newAlgoClOrdId("alts") + `","state":"effective","ordId":"44"}]}`))
+	}))
+	defer server.Close()
+	oc, _ := NewOkxClient("pass", "secret", "key", "0")
+	oc.restClient.SetBaseURL(server.URL)
+	ordIds, err := oc.GetTriggeredStops(context.Background(), "BTC-USDT", "majors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ordIds) != 1 || ordIds[0] != "42" {
+		t.Errorf("expected only the order of the stop of the strategy, got %v", ordIds)
+	}
+}
@@ -4,46 +4,60 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/gorilla/websocket"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
 	"github.com/twoonefour/sigmaflow/internal/model"
 	"github.com/twoonefour/sigmaflow/pkg/currency"
 	"net/http"
 	"resty.dev/v3"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-type loginReq struct {
-	Op   string `json:"op"`
-	Args []args `json:"args"`
-}
-
-type args struct {
-	ApiKey     string `json:"apiKey"`
-	Passphrase string `json:"passphrase"`
-	Timestamp  string `json:"timestamp"`
-	Sign       string `json:"sign"`
-}
-
 type candlesResp struct {
 	Code string     `json:"code"`
 	Msg  string     `json:"msg"`
 	Data [][]string `json:"data"`
 }
 
-const wsApiBase = "wss://ws.okx.com:8443/ws/v5/public"
 const restApiBase = "https://www.okx.com"
 
 type Client struct {
-	// The websocket connection.
-	wsConn     []*websocket.Conn // use string to get a connection
 	restClient *resty.Client
-	wsRespChan chan map[string]interface{}
 	apiKey     string
 	secretKey  string
 	passPhrase string
 	simulate   bool
+
+	orders      exchange.OrderWait
+	instruments *exchange.Instruments
+	limiter     *exchange.Limiter
+	retry       exchange.Retry
+
+	wsBase  string // scheme and host of the websocket endpoints
+	wsMu    sync.Mutex
+	streams map[string]*Stream // by endpoint path
+}
+
+func init() {
+	exchange.Register("okx", func(a exchange.Account) (exchange.Client, error) {
+		simulate := "0"
+		if a.Demo {
+			simulate = "1"
+		}
+		c, err := NewOkxClient(a.Passphrase, a.SecretKey, a.APIKey, simulate)
+		if err != nil {
+			return nil, err
+		}
+		if a.BaseURL != "" {
+			c.restClient.SetBaseURL(a.BaseURL)
+		}
+		if a.WSURL != "" {
+			c.wsBase = a.WSURL
+		}
+		return c, nil
+	})
 }
 
 func NewOkxClient(passPhrase, secretKey, apiKey, simulate string) (*Client, error) {
@@ -51,130 +65,54 @@ func NewOkxClient(passPhrase, secretKey, apiKey, simulate string) (*Client, erro
 		apiKey:     apiKey,
 		passPhrase: passPhrase,
 		secretKey:  secretKey,
+		wsBase:     wsBase,
+		streams:    make(map[string]*Stream),
+		orders:     exchange.DefaultOrderWait("okx"),
+		limiter:    exchange.NewLimiter(endpoints, defaultEndpoint),
+		retry:      exchange.DefaultRetry("okx"),
 	}
+	_okxClient.instruments = exchange.NewInstruments("okx", _okxClient.listInstruments)
 	if simulate == "1" {
 		_okxClient.simulate = true
+		_okxClient.wsBase = wsSimulateBase
 	}
 
-	//if err := _okxClient.initWebsocketConn(); err != nil {
-	//	return nil, err
-	//}
-	_okxClient.restClient = resty.New().SetTimeout(5 * time.Second)
+	_okxClient.restClient = exchange.NewRestClient(restApiBase)
 	// _okxClient.restClient.SetProxy("http://127.0.0.1:10808")
 	_okxClient.restClient.SetHeaders(map[string]string{
 		"OK-ACCESS-PASSPHRASE": passPhrase,
 		"OK-ACCESS-KEY":        apiKey,
 	})
-	_okxClient.restClient.SetBaseURL(restApiBase)
 	return _okxClient, nil
 }
 
-func (oc *Client) newConn() (*websocket.Conn, error) {
-	conn, _, err := websocket.DefaultDialer.Dial(wsApiBase, nil)
-	if err != nil {
-		return nil, err
-	}
-	if err := oc.wsAuth(conn); err != nil {
-		return nil, err
-	}
-	return conn, nil
-}
-
-func (oc *Client) wsAuth(conn *websocket.Conn) error {
-	uriPath := "/users/self/verify"
-	ts := strconv.FormatInt(time.Now().Unix(), 10)
-	req := &loginReq{
-		Op: "login",
-		Args: []args{
-			{
-				ApiKey:     oc.apiKey,
-				Passphrase: oc.passPhrase,
-				Timestamp:  ts,
-				Sign:       AccessSign(ts, http.MethodGet, uriPath, "", oc.secretKey),
-			},
-		},
-	}
-
-	data, err := json.Marshal(req)
-	if err != nil {
-		return err
-	}
-
-	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
-		return err
-	}
-	return nil
-}
-
-func (oc *Client) readMessage(conn *websocket.Conn) ([]byte, error) {
-	messageType, data, err := conn.ReadMessage()
-	if err != nil {
-		return nil, err
-	}
-	var req map[string]interface{}
-	err = json.Unmarshal(data, &req)
-	if err != nil {
-		return nil, err
-	}
-	oc.wsRespChan <- req
-	fmt.Println(messageType)
-	//fmt.Printf("%v\n", req)
-	return nil, nil
-}
-
-func (oc *Client) initWebsocketConn() error {
-	oc.wsRespChan = make(chan map[string]interface{})
-	go oc.handleWsMessage()
-	connSlice := make([]*websocket.Conn, 5)
-	for i := 0; i < len(connSlice); i++ {
-		newConn, err := oc.newConn()
-		if err != nil {
-			return err
-		}
-		go func() {
-			_, err := oc.readMessage(newConn)
-			if err != nil {
-				fmt.Println("readMessage err:", err)
-			}
-		}()
-		connSlice[i] = newConn
-	}
-	oc.wsConn = connSlice
-	return nil
-}
-
-func (oc *Client) handleWsMessage() {
-	for {
-		select {
-		case msg := <-oc.wsRespChan:
-			fmt.Println("wsRespChan:", msg)
-		}
-	}
-}
-
+// doRestyRequest signs and sends req, waiting for the rate limit of the endpoint and
+// retrying with backoff while exchange.Retryable allows it.
 func (oc *Client) doRestyRequest(req *resty.Request, method, path string, body ...interface{}) error {
-	signPath := path
-	ts := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
 	var bodyStr string
 	if len(body) > 0 && method == http.MethodPost {
-		_body, ok := body[0].(map[string]string)
-		if !ok {
-			return fmt.Errorf("[client.doRestyRequest] Can not transform body to type (map[string]string)")
-		}
-		bodyBytes, err := json.Marshal(&_body)
+		bodyBytes, err := json.Marshal(body[0])
 		if err != nil {
 			return err
 		}
 		bodyStr = string(bodyBytes)
 		req.SetHeader("Content-Type", "application/json").SetBody(bodyBytes)
-	} else {
-		if req.QueryParams.Encode() != "" {
-			signPath += "?" + req.QueryParams.Encode()
-		}
 	}
 	if oc.simulate {
 		req.SetHeader("x-simulated-trading", "1")
 	}
+	return oc.retry.Do(req.Context(), oc.limiter, method, path, func() error {
+		return oc.send(req, method, path, bodyStr)
+	})
+}
+
+// send signs req with a fresh timestamp and executes it once.
+func (oc *Client) send(req *resty.Request, method, path, bodyStr string) error {
+	signPath := path
+	ts := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+	if method != http.MethodPost && req.QueryParams.Encode() != "" {
+		signPath += "?" + req.QueryParams.Encode()
+	}
 	req.SetHeaders(map[string]string{
 		"OK-ACCESS-SIGN":      AccessSign(ts, method, signPath, bodyStr, oc.secretKey),
 		"OK-ACCESS-TIMESTAMP": ts,
@@ -183,69 +121,89 @@ func (oc *Client) doRestyRequest(req *resty.Request, method, path string, body .
 	if err != nil {
 		return fmt.Errorf("request failed: %w", err)
 	}
-	if resp.StatusCode() != 200 {
-		return fmt.Errorf("unexpected status code: %d, response: %s", resp.StatusCode(), string(resp.Bytes()))
+	if err = checkResponse(resp.StatusCode(), resp.Bytes()); err != nil {
+		return fmt.Errorf("[okx] %s %s: %w", method, path, err)
 	}
 	return nil
 }
 
+// bars maps a timeframe to the OKX bar, day and week bars close at 0:00 UTC.
+var bars = map[model.Timeframe]string{
+	model.Minute1:  "1m",
+	model.Minute15: "15m",
+	model.Hour1:    "1H",
+	model.Hour4:    "4H",
+	model.Day1:     "1Dutc",
+	model.Week1:    "1Wutc",
+}
+
 // Fetch last [period, now] data
-func (oc *Client) GetCandle(pair currency.Pair, period int) ([]model.Candlestick, error) {
+func (oc *Client) GetCandle(pair currency.Pair, timeframe model.Timeframe, period int) ([]model.Candlestick, error) {
+	bar, ok := bars[timeframe]
+	if !ok {
+		return nil, fmt.Errorf("[okx.GetCandle] unsupported timeframe %q", timeframe)
+	}
 	m := make([]model.Candlestick, period)
 	resp := &candlesResp{}
 	req := oc.restClient.R().SetResult(resp).SetQueryParams(map[string]string{
 		"instId": pair.Quote.String() + "-" + pair.Base.String(),
-		"bar":    "1Dutc",
+		"bar":    bar,
+		"limit":  strconv.Itoa(min(period, 100)),
 	})
 	urlPath := "/api/v5/market/candles"
 	cnt := 0
 	for period > 0 {
 		err := oc.doRestyRequest(req, http.MethodGet, urlPath)
-		if err != nil || resp == nil || resp.Code != "0" {
+		if err != nil {
 			return nil, err
 		}
+		// the instrument has no older history
+		if len(resp.Data) == 0 {
+			break
+		}
+		if len(resp.Data) > period {
+			resp.Data = resp.Data[:period]
+		}
 		for i, data := range resp.Data {
-			o, err := strconv.ParseFloat(data[1], 64)
-			if err != nil {
-				return nil, err
-			}
-			h, err := strconv.ParseFloat(data[2], 64)
-			if err != nil {
-				return nil, err
-			}
-			l, err := strconv.ParseFloat(data[3], 64)
-			if err != nil {
-				return nil, err
-			}
-			c, err := strconv.ParseFloat(data[4], 64)
-			if err != nil {
-				return nil, err
-			}
-			vol, err := strconv.ParseFloat(data[5], 64)
+			m[i+cnt], err = parseCandle(data)
 			if err != nil {
 				return nil, err
 			}
-			m[i+cnt] = model.Candlestick{
-				Ts:  data[0],
-				O:   o,
-				H:   h,
-				L:   l,
-				C:   c,
-				Vol: vol,
-			}
 		}
 		period -= len(resp.Data)
 		cnt += len(resp.Data)
 		req.SetQueryParam("after", m[cnt-1].Ts)
-		req.SetQueryParam("limit", func(a, b int) string {
-			if a > b {
-				return strconv.Itoa(b)
-			}
-			return strconv.Itoa(a)
-		}(period, 100))
+		req.SetQueryParam("limit", strconv.Itoa(min(period, 100)))
 	}
 
-	return m, nil
+	return m[:cnt], nil
+}
+
+// parseCandle decodes one [ts, o, h, l, c, vol, volCcy, volCcyQuote, confirm] row, as sent
+// by both the REST and the websocket candle endpoints.
+func parseCandle(data []string) (model.Candlestick, error) {
+	if len(data) < 6 {
+		return model.Candlestick{}, fmt.Errorf("[okx.parseCandle] short candle %v", data)
+	}
+	var err error
+	values := make([]float64, 5)
+	for i := range values {
+		if values[i], err = strconv.ParseFloat(data[i+1], 64); err != nil {
+			return model.Candlestick{}, err
+		}
+	}
+	candle := model.Candlestick{
+		Ts:  data[0],
+		O:   values[0],
+		H:   values[1],
+		L:   values[2],
+		C:   values[3],
+		Vol: values[4],
+	}
+	if len(data) > 8 {
+		candle.Confirm = data[8]
+	}
+	return candle, nil
 }
 
 func (oc *Client) GetBalance(ctx context.Context, coin ...currency.Coin) (*model.TradeData, error) {
@@ -264,6 +222,9 @@ func (oc *Client) GetBalance(ctx context.Context, coin ...currency.Coin) (*model
 	if err != nil {
 		return nil, err
 	}
+	if len(m.Data) == 0 {
+		return nil, fmt.Errorf("[okx.GetBalance] empty response")
+	}
 
 	var res model.TradeData
 	accountAssets := make(map[currency.Coin]*model.Asset)
@@ -283,16 +244,3 @@ func (oc *Client) GetBalance(ctx context.Context, coin ...currency.Coin) (*model
 	res.AccountAssets = accountAssets
 	return &res, nil
 }
-
-func (oc *Client) Order(instId, side, sz string) error {
-	path := "/api/v5/trade/order"
-	req := oc.restClient.R()
-	body := map[string]string{
-		"instId":  instId,
-		"side":    side,
-		"sz":      sz,
-		"ordType": "market",
-		"tdMode":  "cash",
-	}
-	return oc.doRestyRequest(req, http.MethodPost, path, body)
-}
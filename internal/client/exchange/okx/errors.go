@@ -0,0 +1,159 @@
+package okx
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"net/http"
+	"strings"
+)
+
+// The failures knownCodes maps the OKX codes onto.
+var (
+	ErrInsufficientBalance = exchange.ErrInsufficientBalance
+	ErrRateLimit           = exchange.ErrRateLimit
+	ErrInvalidSign         = exchange.ErrInvalidSign
+	ErrTimestampExpired    = exchange.ErrTimestampExpired
+	ErrInstrumentSuspended = exchange.ErrInstrumentSuspended
+	ErrUnavailable         = exchange.ErrUnavailable
+	ErrDuplicateOrder      = exchange.ErrDuplicateOrder
+	ErrOrderNotFound       = exchange.ErrOrderNotFound
+)
+
+type codeInfo struct {
+	err       error
+	retryable bool // the same request may succeed later
+}
+
+// knownCodes classifies the OKX error codes the bot runs into, see
+// https://www.okx.com/docs-v5/en/#error-code
+var knownCodes = map[string]codeInfo{
+	"50001": {ErrUnavailable, true},
+	"50004": {ErrUnavailable, true}, // endpoint request timeout
+	"50013": {ErrUnavailable, true}, // systems are busy
+	"50011": {ErrRateLimit, true},
+	"50061": {ErrRateLimit, true}, // sub-account rate limit
+	"50102": {ErrTimestampExpired, true},
+	"50113": {ErrInvalidSign, false},
+	"51008": {ErrInsufficientBalance, false},
+	"51119": {ErrInsufficientBalance, false},
+	"51131": {ErrInsufficientBalance, false},
+	"51022": {ErrInstrumentSuspended, false},
+	"51016": {ErrDuplicateOrder, false},
+	"51603": {ErrOrderNotFound, false},
+}
+
+// ItemError is the outcome of one item of a request, e.g. one order of a batch.
+type ItemError struct {
+	SCode string `json:"sCode"`
+	SMsg  string `json:"sMsg"`
+}
+
+// APIError is a request OKX answered with a non-zero code, or one of its items with a
+// non-zero sCode.
+type APIError struct {
+	Status int    // HTTP status
+	Code   string // top level code, "1" or "2" when only some items failed
+	Msg    string
+	Items  []ItemError // the failed items
+}
+
+func (e *APIError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("okx:")
+	if e.Code != "" {
+		sb.WriteString(" code " + e.Code)
+	}
+	if e.Status != 0 && e.Status != http.StatusOK {
+		sb.WriteString(fmt.Sprintf(" http %d", e.Status))
+	}
+	if e.Msg != "" {
+		sb.WriteString(": " + e.Msg)
+	}
+	for _, item := range e.Items {
+		sb.WriteString(fmt.Sprintf(", sCode %s: %s", item.SCode, item.SMsg))
+	}
+	return sb.String()
+}
+
+// codes returns the top level code followed by the item codes.
+func (e *APIError) codes() []string {
+	res := []string{e.Code}
+	for _, item := range e.Items {
+		res = append(res, item.SCode)
+	}
+	return res
+}
+
+// Is matches the well-known errors of the codes of e.
+func (e *APIError) Is(target error) bool {
+	if target == ErrRateLimit && e.Status == http.StatusTooManyRequests {
+		return true
+	}
+	for _, code := range e.codes() {
+		if info, ok := knownCodes[code]; ok && info.err == target {
+			return true
+		}
+	}
+	return false
+}
+
+// Retryable reports whether sending the same request again may succeed: the HTTP status is
+// 429 or 5xx, or every known code of e is retryable.
+func (e *APIError) Retryable() bool {
+	if e.Status == http.StatusTooManyRequests || e.Status >= http.StatusInternalServerError {
+		return true
+	}
+	known := false
+	for _, code := range e.codes() {
+		info, ok := knownCodes[code]
+		if !ok {
+			continue
+		}
+		if !info.retryable {
+			return false
+		}
+		known = true
+	}
+	return known
+}
+
+// HTTPStatus returns the HTTP status, OKX answers most failures with a 200 and a code.
+func (e *APIError) HTTPStatus() int {
+	return e.Status
+}
+
+// envelope is the part every OKX REST answer shares.
+type envelope struct {
+	Code string          `json:"code"`
+	Msg  string          `json:"msg"`
+	Data json.RawMessage `json:"data"`
+}
+
+// checkResponse returns an APIError when body is not a success. Items are only looked at
+// when data is a list of objects, candles for one are a list of lists.
+func checkResponse(status int, body []byte) error {
+	var env envelope
+	if err := json.Unmarshal(body, &env); err != nil || env.Code == "" {
+		if status != http.StatusOK {
+			return &APIError{Status: status, Msg: strings.TrimSpace(string(body))}
+		}
+		if err != nil {
+			return fmt.Errorf("[okx.checkResponse] invalid response: %w", err)
+		}
+		return nil
+	}
+	apiErr := &APIError{Status: status, Code: env.Code, Msg: env.Msg}
+	var items []ItemError
+	if json.Unmarshal(env.Data, &items) == nil {
+		for _, item := range items {
+			if item.SCode != "" && item.SCode != "0" {
+				apiErr.Items = append(apiErr.Items, item)
+			}
+		}
+	}
+	if env.Code == "0" && len(apiErr.Items) == 0 && status == http.StatusOK {
+		return nil
+	}
+	return apiErr
+}
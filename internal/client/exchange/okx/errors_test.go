@@ -0,0 +1,69 @@
+package okx
+
+import (
+	"errors"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckResponse(t *testing.T) {
+	cases := []struct {
+		name      string
+		status    int
+		body      string
+		fail      bool
+		is        error
+		retryable bool
+	}{
+		{"success", 200, `{"code":"0","msg":"","data":[{"ordId":"1","sCode":"0"}]}`, false, nil, false},
+		{"candles", 200, `{"code":"0","msg":"","data":[["1","2","3","4","5","6"]]}`, false, nil, false},
+		{"item failed", 200, `{"code":"1","msg":"All operations failed","data":[{"sCode":"51008","sMsg":"Insufficient balance"}]}`, true, ErrInsufficientBalance, false},
+		{"rate limit", 429, `{"code":"50011","msg":"Too Many Requests"}`, true, ErrRateLimit, true},
+		{"rate limit status", 429, `Too Many Requests`, true, ErrRateLimit, true},
+		{"invalid sign", 401, `{"code":"50113","msg":"Invalid Sign"}`, true, ErrInvalidSign, false},
+		{"timestamp expired", 400, `{"code":"50102","msg":"Timestamp request expired"}`, true, ErrTimestampExpired, true},
+		{"suspended", 200, `{"code":"51022","msg":"Contract suspended","data":[]}`, true, ErrInstrumentSuspended, false},
+		{"busy", 200, `{"code":"50013","msg":"Systems are busy"}`, true, ErrUnavailable, true},
+		{"gateway", 502, `<html>bad gateway</html>`, true, nil, true},
+		{"unknown", 200, `{"code":"59999","msg":"something"}`, true, nil, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := checkResponse(c.status, []byte(c.body))
+			if !c.fail {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected an APIError, got %v", err)
+			}
+			if c.is != nil && !errors.Is(err, c.is) {
+				t.Errorf("%v should match %v", err, c.is)
+			}
+			if exchange.IsRetryable(err) != c.retryable {
+				t.Errorf("retryable = %v, want %v", exchange.IsRetryable(err), c.retryable)
+			}
+		})
+	}
+}
+
+func TestGetCandleError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"code":"51001","msg":"Instrument ID does not exist","data":[]}`))
+	}))
+	defer server.Close()
+	oc, _ := NewOkxClient("pass", "secret", "key", "0")
+	oc.restClient.SetBaseURL(server.URL)
+	candles, err := oc.GetCandle(currency.NewPair(currency.USDT, currency.BTC), model.Day1, 10)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "51001" {
+		t.Fatalf("expected the API error, got %v, %v", candles, err)
+	}
+}
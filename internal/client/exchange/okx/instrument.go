@@ -0,0 +1,109 @@
+package okx
+
+import (
+	"context"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"net/http"
+	"strconv"
+)
+
+// GetInstruments fetches the rules of every spot instrument.
+func (oc *Client) GetInstruments(ctx context.Context) ([]InstrumentData, error) {
+	path := "/api/v5/public/instruments"
+	resp := &InstrumentsResponse{}
+	req := oc.restClient.R().WithContext(ctx).SetResult(resp).SetQueryParam("instType", "SPOT")
+	if err := oc.doRestyRequest(req, http.MethodGet, path); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// Instrument returns the rules of instId, the list of every spot instrument is cached for an
+// hour. It fails with ErrInstrumentSuspended when the instrument does not trade.
+func (oc *Client) Instrument(ctx context.Context, instId string) (model.Instrument, error) {
+	return oc.instruments.Get(ctx, instId)
+}
+
+// listInstruments fetches the rules of every spot instrument, OKX has no lookup of one.
+func (oc *Client) listInstruments(ctx context.Context, _ string) (map[string]exchange.Listing, error) {
+	data, err := oc.GetInstruments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]exchange.Listing, len(data))
+	for _, d := range data {
+		res[d.InstId] = exchange.Listing{
+			Instrument: model.Instrument{
+				InstId:   d.InstId,
+				Base:     d.BaseCcy,
+				Quote:    d.QuoteCcy,
+				LotSize:  d.LotSz.Float64(),
+				MinSize:  d.MinSz.Float64(),
+				TickSize: d.TickSz.Float64(),
+				// OKX publishes no step for quote amounts, they are sent as is
+			},
+			Trading: d.State == "live",
+		}
+	}
+	return res, nil
+}
+
+// GetTicker returns the latest ticker of instId.
+func (oc *Client) GetTicker(ctx context.Context, instId string) (*Ticker, error) {
+	path := "/api/v5/market/ticker"
+	resp := &TickerResponse{}
+	req := oc.restClient.R().WithContext(ctx).SetResult(resp).SetQueryParam("instId", instId)
+	if err := oc.doRestyRequest(req, http.MethodGet, path); err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("[okx.GetTicker] no ticker for %s", instId)
+	}
+	return &resp.Data[0], nil
+}
+
+// NormalizeOrder returns sz of a market order as Order would send it, or the error Order
+// would fail with, without placing the order.
+func (oc *Client) NormalizeOrder(ctx context.Context, instId, side, sz string) (string, error) {
+	sz, _, err := oc.normalizeOrder(ctx, instId, side, sz)
+	return sz, err
+}
+
+// normalizeOrder rounds sz of a market order to the precision of instId and returns it with
+// its tgtCcy: a buy spends sz of the quote currency, a sell sells sz coins. Orders below the
+// instrument minimum fail with model.ErrBelowMinimum.
+func (oc *Client) normalizeOrder(ctx context.Context, instId, side, sz string) (string, string, error) {
+	amount, err := strconv.ParseFloat(sz, 64)
+	if err != nil {
+		return "", "", fmt.Errorf("[okx.Order] invalid size %q: %w", sz, err)
+	}
+	inst, err := oc.Instrument(ctx, instId)
+	if err != nil {
+		return "", "", err
+	}
+	switch side {
+	case "buy":
+		amount = inst.FloorQuote(amount)
+		ticker, err := oc.GetTicker(ctx, instId)
+		if err != nil {
+			return "", "", err
+		}
+		price := ticker.Last.Float64()
+		if price <= 0 {
+			return "", "", fmt.Errorf("[okx.Order] no price for %s", instId)
+		}
+		if err = inst.CheckSize(amount/price, price); err != nil {
+			return "", "", fmt.Errorf("[okx.Order] buy of %s %s: %w", inst.FormatQuote(amount), inst.Quote, err)
+		}
+		return inst.FormatQuote(amount), "quote_ccy", nil
+	case "sell":
+		amount = inst.FloorSize(amount)
+		if err = inst.CheckSize(amount, 0); err != nil {
+			return "", "", fmt.Errorf("[okx.Order] sell: %w", err)
+		}
+		return inst.FormatSize(amount), "base_ccy", nil
+	}
+	return "", "", fmt.Errorf("[okx.Order] unknown side %q", side)
+}
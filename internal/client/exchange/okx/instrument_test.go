@@ -0,0 +1,80 @@
+package okx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNormalizeOrder(t *testing.T) {
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v5/public/instruments" {
+			fetches.Add(1)
+		}
+		if !serveMarket(w, r) {
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+	oc, _ := NewOkxClient("pass", "secret", "key", "0")
+	oc.restClient.SetBaseURL(server.URL)
+
+	cases := []struct {
+		name   string
+		instId string
+		side   string
+		sz     string
+		want   string
+		tgtCcy string
+		err    error
+	}{
+		{"buy spends quote", "BTC-USDT", "buy", "123.4567", "123.4567", "quote_ccy", nil},
+		{"sell floored to lot", "BTC-USDT", "sell", "0.123456789", "0.12345678", "base_ccy", nil},
+		{"buy below minimum", "BTC-USDT", "buy", "0.4", "", "", model.ErrBelowMinimum},
+		{"sell below minimum", "BTC-USDT", "sell", "0.000009", "", "", model.ErrBelowMinimum},
+		{"suspended", "LUNA-USDT", "sell", "10", "", "", ErrInstrumentSuspended},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sz, tgtCcy, err := oc.normalizeOrder(context.Background(), c.instId, c.side, c.sz)
+			if c.err != nil {
+				if !errors.Is(err, c.err) {
+					t.Fatalf("expected %v, got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil || sz != c.want || tgtCcy != c.tgtCcy {
+				t.Fatalf("got %s %s (%v), want %s %s", sz, tgtCcy, err, c.want, c.tgtCcy)
+			}
+		})
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("instruments should be cached, fetched %d times", fetches.Load())
+	}
+}
+
+func TestPlaceStopPrecision(t *testing.T) {
+	var body map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if serveMarket(w, r) {
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"code":"0","data":[{"algoId":"7","sCode":"0"}]}`))
+	}))
+	defer server.Close()
+	oc, _ := NewOkxClient("pass", "secret", "key", "0")
+	oc.restClient.SetBaseURL(server.URL)
+	if _, err := oc.PlaceStop(context.Background(), "BTC-USDT", "majors", "0.0123456789", 48123.456, 0); err != nil {
+		t.Fatal(err)
+	}
+	if body["sz"] != "0.01234567" || body["slTriggerPx"] != "48123.5" || body["ordType"] != "conditional" {
+		t.Errorf("unexpected stop %v", body)
+	}
+}
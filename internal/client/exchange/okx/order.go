@@ -0,0 +1,133 @@
+package okx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"log"
+	"net/http"
+)
+
+// Order places a market order and waits for it to settle. sz is in the quote currency for a
+// buy and in the coin for a sell, it is rounded to the precision of the instrument first. An
+// order still live after the wait is returned as is, the caller can look it up again with
+// GetOrder.
+//
+// The order carries a client order id. When the answer is lost the order is looked up by it
+// and only placed again when OKX does not know it, so it cannot be placed twice.
+func (oc *Client) Order(ctx context.Context, instId, side, sz string) (*model.OrderResult, error) {
+	path := "/api/v5/trade/order"
+	sz, tgtCcy, err := oc.normalizeOrder(ctx, instId, side, sz)
+	if err != nil {
+		return nil, err
+	}
+	clOrdId := exchange.ClientOrderId()
+	body := map[string]string{
+		"instId":  instId,
+		"side":    side,
+		"sz":      sz,
+		"tgtCcy":  tgtCcy,
+		"ordType": "market",
+		"tdMode":  "cash",
+		"clOrdId": clOrdId,
+	}
+	resp := &PlaceOrderResponse{}
+	place := func() error {
+		req := oc.restClient.R().WithContext(ctx).SetResult(resp)
+		return oc.doRestyRequest(req, http.MethodPost, path, body)
+	}
+	var placed bool
+	found := func() (bool, error) {
+		_, err := oc.getOrder(ctx, instId, "clOrdId", clOrdId)
+		if errors.Is(err, ErrOrderNotFound) {
+			return false, nil
+		}
+		placed = err == nil
+		return placed, err
+	}
+	var ordId string
+	err = oc.retry.Place(ctx, place, found)
+	switch {
+	case errors.Is(err, ErrDuplicateOrder), err == nil && placed:
+		log.Println(fmt.Sprintf("[okx.Order] %s 已提交, 使用先前的订单", clOrdId))
+	case err != nil:
+		return nil, err
+	case len(resp.Data) == 0:
+		return nil, fmt.Errorf("[okx.Order] empty response")
+	default:
+		ordId = resp.Data[0].OrdId
+	}
+	return oc.waitOrder(ctx, instId, side, ordId, clOrdId), nil
+}
+
+// waitOrder polls the order until it is filled or canceled, then fetches its fills.
+func (oc *Client) waitOrder(ctx context.Context, instId, side, ordId, clOrdId string) *model.OrderResult {
+	res := &model.OrderResult{OrderId: ordId, InstId: instId, Side: side, State: model.OrderLive}
+	res = oc.orders.Settle(ctx, clOrdId, res, func() (*model.OrderResult, error) {
+		return oc.getOrder(ctx, instId, "clOrdId", clOrdId)
+	})
+	oc.orders.Fill(res, func() ([]model.OrderFill, error) {
+		return oc.GetFills(ctx, instId, res.OrderId)
+	})
+	return res
+}
+
+// GetOrder returns the state of ordId.
+func (oc *Client) GetOrder(ctx context.Context, instId, ordId string) (*model.OrderResult, error) {
+	return oc.getOrder(ctx, instId, "ordId", ordId)
+}
+
+// getOrder looks an order up by ordId or clOrdId.
+func (oc *Client) getOrder(ctx context.Context, instId, idParam, id string) (*model.OrderResult, error) {
+	path := "/api/v5/trade/order"
+	resp := &OrderResponse{}
+	req := oc.restClient.R().WithContext(ctx).SetResult(resp).SetQueryParams(map[string]string{
+		"instId": instId,
+		idParam:  id,
+	})
+	if err := oc.doRestyRequest(req, http.MethodGet, path); err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("[okx.GetOrder] order %s: %w", id, ErrOrderNotFound)
+	}
+	o := resp.Data[0]
+	return &model.OrderResult{
+		OrderId:    o.OrdId,
+		InstId:     o.InstId,
+		Side:       o.Side,
+		State:      o.State,
+		FilledSize: o.AccFillSz.Float64(),
+		AvgPrice:   o.AvgPx.Float64(),
+		Fee:        -o.Fee.Float64(),
+		FeeCcy:     o.FeeCcy,
+	}, nil
+}
+
+// GetFills returns the trades that filled ordId, newest first.
+func (oc *Client) GetFills(ctx context.Context, instId, ordId string) ([]model.OrderFill, error) {
+	path := "/api/v5/trade/fills"
+	resp := &FillsResponse{}
+	req := oc.restClient.R().WithContext(ctx).SetResult(resp).SetQueryParams(map[string]string{
+		"instType": "SPOT",
+		"instId":   instId,
+		"ordId":    ordId,
+	})
+	if err := oc.doRestyRequest(req, http.MethodGet, path); err != nil {
+		return nil, err
+	}
+	res := make([]model.OrderFill, len(resp.Data))
+	for i, f := range resp.Data {
+		res[i] = model.OrderFill{
+			TradeId: f.TradeId,
+			Ts:      f.Ts,
+			Price:   f.FillPx.Float64(),
+			Size:    f.FillSz.Float64(),
+			Fee:     -f.Fee.Float64(),
+			FeeCcy:  f.FeeCcy,
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,171 @@
+package okx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRest answers the order endpoints: placing the order answers with placed for the n-th
+// attempt, which also tells whether the attempt went through. The order is unknown until
+// then, live on the first lookup and filled from the second one on. The client order ids of
+// every attempt are collected in clOrdIds.
+func fakeRest(t *testing.T, clOrdIds *[]string, placed func(n int) (int, string, bool)) *Client {
+	var mu sync.Mutex
+	var lookups atomic.Int32
+	var accepted atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case serveMarket(w, r):
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v5/trade/order":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["sz"] != "100" || body["tgtCcy"] != "quote_ccy" {
+				t.Errorf("unexpected order %v", body)
+			}
+			mu.Lock()
+			*clOrdIds = append(*clOrdIds, body["clOrdId"])
+			status, answer, ok := placed(len(*clOrdIds))
+			mu.Unlock()
+			if ok {
+				accepted.Store(true)
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(answer))
+		case r.URL.Path == "/api/v5/trade/order":
+			mu.Lock()
+			want := (*clOrdIds)[0]
+			mu.Unlock()
+			if r.URL.Query().Get("clOrdId") != want {
+				t.Errorf("unexpected lookup %s", r.URL.RawQuery)
+			}
+			if !accepted.Load() {
+				w.Write([]byte(`{"code":"51603","msg":"Order does not exist","data":[]}`))
+				return
+			}
+			if lookups.Add(1) == 1 {
+				w.Write([]byte(`{"code":"0","data":[{"instId":"BTC-USDT","ordId":"42","side":"buy","state":"live","accFillSz":"","avgPx":""}]}`))
+				return
+			}
+			w.Write([]byte(`{"code":"0","data":[{"instId":"BTC-USDT","ordId":"42","side":"buy","state":"filled","accFillSz":"0.002","avgPx":"50000","fee":"-0.000002","feeCcy":"BTC"}]}`))
+		case r.URL.Path == "/api/v5/trade/fills":
+			if r.URL.Query().Get("ordId") != "42" {
+				t.Errorf("unexpected fills query %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"code":"0","data":[
+				{"instId":"BTC-USDT","tradeId":"2","ordId":"42","fillPx":"50010","fillSz":"0.001","fee":"-0.000001","feeCcy":"BTC","ts":"2"},
+				{"instId":"BTC-USDT","tradeId":"1","ordId":"42","fillPx":"49990","fillSz":"0.001","fee":"-0.000001","feeCcy":"BTC","ts":"1"}]}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	t.Cleanup(server.Close)
+	oc, _ := NewOkxClient("pass", "secret", "key", "0")
+	oc.restClient.SetBaseURL(server.URL)
+	oc.orders.Poll = time.Millisecond
+	oc.retry.MinBackoff = time.Millisecond
+	return oc
+}
+
+// serveMarket answers the instrument and ticker endpoints, BTC-USDT trades at 50000.
+func serveMarket(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/api/v5/public/instruments":
+		w.Write([]byte(`{"code":"0","data":[
+			{"instId":"BTC-USDT","baseCcy":"BTC","quoteCcy":"USDT","lotSz":"0.00000001","minSz":"0.00001","tickSz":"0.1","state":"live"},
+			{"instId":"LUNA-USDT","baseCcy":"LUNA","quoteCcy":"USDT","lotSz":"0.001","minSz":"1","tickSz":"0.0001","state":"suspend"}]}`))
+	case "/api/v5/market/ticker":
+		w.Write([]byte(`{"code":"0","data":[{"instId":"BTC-USDT","last":"50000"}]}`))
+	default:
+		return false
+	}
+	return true
+}
+
+func answer(body string, placed bool) func(int) (int, string, bool) {
+	return func(int) (int, string, bool) {
+		return http.StatusOK, body, placed
+	}
+}
+
+func TestOrder(t *testing.T) {
+	var clOrdIds []string
+	oc := fakeRest(t, &clOrdIds, answer(`{"code":"0","data":[{"ordId":"42","sCode":"0","sMsg":"Order placed"}]}`, true))
+	res, err := oc.Order(context.Background(), "BTC-USDT", "buy", "100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.State != model.OrderFilled || !res.Done() || res.FilledSize != 0.002 || res.AvgPrice != 50000 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if res.Fee != 0.000002 || res.FeeCcy != "BTC" {
+		t.Errorf("fee should be a positive cost, got %v %s", res.Fee, res.FeeCcy)
+	}
+	if len(res.Fills) != 2 || res.Fills[0].Price != 50010 || res.Fills[1].Size != 0.001 || res.Fills[1].Fee != 0.000001 {
+		t.Errorf("unexpected fills %+v", res.Fills)
+	}
+}
+
+func TestOrderRejected(t *testing.T) {
+	var clOrdIds []string
+	oc := fakeRest(t, &clOrdIds, answer(`{"code":"1","msg":"All operations failed","data":[{"ordId":"","sCode":"51008","sMsg":"Order failed. Insufficient balance"}]}`, false))
+	res, err := oc.Order(context.Background(), "BTC-USDT", "buy", "100")
+	if !errors.Is(err, ErrInsufficientBalance) || exchange.IsRetryable(err) {
+		t.Fatalf("expected a fatal insufficient balance error, got %+v, %v", res, err)
+	}
+	if len(clOrdIds) != 1 {
+		t.Errorf("a rejected order must not be retried, sent %d times", len(clOrdIds))
+	}
+}
+
+func TestOrderRetry(t *testing.T) {
+	var clOrdIds []string
+	oc := fakeRest(t, &clOrdIds, func(n int) (int, string, bool) {
+		if n == 1 {
+			return http.StatusTooManyRequests, `{"code":"50011","msg":"Too Many Requests"}`, false
+		}
+		// placed, but the answer is lost
+		return http.StatusBadGateway, `bad gateway`, true
+	})
+	res, err := oc.Order(context.Background(), "BTC-USDT", "buy", "100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.OrderId != "42" || res.State != model.OrderFilled || len(res.Fills) != 2 {
+		t.Fatalf("the placed order should be picked up, got %+v", res)
+	}
+	if len(clOrdIds) != 2 || clOrdIds[0] == "" || clOrdIds[1] != clOrdIds[0] {
+		t.Errorf("a placed order must not be sent again, got %v", clOrdIds)
+	}
+}
+
+func TestOrderResentWhenNotPlaced(t *testing.T) {
+	var clOrdIds []string
+	oc := fakeRest(t, &clOrdIds, func(n int) (int, string, bool) {
+		if n == 1 {
+			// lost before it reached the matching engine
+			return http.StatusBadGateway, `bad gateway`, false
+		}
+		return http.StatusOK, `{"code":"0","data":[{"ordId":"42","sCode":"0","sMsg":"Order placed"}]}`, true
+	})
+	res, err := oc.Order(context.Background(), "BTC-USDT", "buy", "100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.OrderId != "42" || res.State != model.OrderFilled {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if len(clOrdIds) != 2 || clOrdIds[1] != clOrdIds[0] {
+		t.Errorf("the missing order should be sent again with its client order id, got %v", clOrdIds)
+	}
+}
@@ -0,0 +1,27 @@
+package okx
+
+import (
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"net/http"
+	"time"
+)
+
+// defaultEndpoint applies to endpoints missing from endpoints.
+var defaultEndpoint = exchange.Endpoint{Requests: 10, Window: 2 * time.Second}
+
+// endpoints are the limits of the endpoints the client uses, see the "Rate Limit" of each
+// endpoint in https://www.okx.com/docs-v5/en/
+// Orders are not idempotent: OKX only rejects a duplicate clOrdId among open orders, and a
+// market order does not stay open. Order looks a lost order up instead, see exchange.Retry.Place.
+var endpoints = map[string]exchange.Endpoint{
+	http.MethodGet + " /api/v5/public/instruments":        {Requests: 20, Window: 2 * time.Second},
+	http.MethodGet + " /api/v5/market/ticker":             {Requests: 20, Window: 2 * time.Second},
+	http.MethodGet + " /api/v5/market/candles":            {Requests: 40, Window: 2 * time.Second},
+	http.MethodGet + " /api/v5/account/balance":           {Requests: 10, Window: 2 * time.Second},
+	http.MethodPost + " /api/v5/trade/order":              {Requests: 60, Window: 2 * time.Second},
+	http.MethodGet + " /api/v5/trade/order":               {Requests: 60, Window: 2 * time.Second},
+	http.MethodGet + " /api/v5/trade/fills":               {Requests: 60, Window: 2 * time.Second},
+	http.MethodPost + " /api/v5/trade/order-algo":         {Requests: 20, Window: 2 * time.Second},
+	http.MethodGet + " /api/v5/trade/orders-algo-pending": {Requests: 20, Window: 2 * time.Second},
+	http.MethodPost + " /api/v5/trade/cancel-algos":       {Requests: 20, Window: 2 * time.Second},
+}
@@ -0,0 +1,37 @@
+package okx
+
+import (
+	"context"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"net/http"
+	"testing"
+)
+
+func TestRetryable(t *testing.T) {
+	gateway := &APIError{Status: http.StatusBadGateway}
+	limited := &APIError{Status: http.StatusTooManyRequests, Code: "50011"}
+	balance := &APIError{Status: http.StatusOK, Code: "1", Items: []ItemError{{SCode: "51008"}}}
+	limiter := exchange.NewLimiter(endpoints, defaultEndpoint)
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		err    error
+		want   bool
+	}{
+		{"read after 5xx", http.MethodGet, "/api/v5/account/balance", gateway, true},
+		{"order after 5xx", http.MethodPost, "/api/v5/trade/order", gateway, false},
+		{"stop after 5xx", http.MethodPost, "/api/v5/trade/order-algo", gateway, false},
+		{"stop after rate limit", http.MethodPost, "/api/v5/trade/order-algo", limited, true},
+		{"insufficient balance", http.MethodPost, "/api/v5/trade/order", balance, false},
+		{"network", http.MethodPost, "/api/v5/trade/cancel-algos", context.DeadlineExceeded, false},
+		{"network read", http.MethodGet, "/api/v5/market/candles", context.DeadlineExceeded, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := exchange.Retryable(c.method, limiter.Endpoint(c.method, c.path).Idempotent, c.err); got != c.want {
+				t.Errorf("retryable = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
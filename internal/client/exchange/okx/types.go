@@ -1,6 +1,9 @@
 package okx
 
-import "github.com/twoonefour/sigmaflow/pkg"
+import (
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg"
+)
 
 type HoldingData struct {
 	AvgPx       string `json:"avgPx"`       // 开仓均价
@@ -34,3 +37,155 @@ type BalanceResponse struct {
 	Data []BalanceData `json:"data"`
 	Msg  string        `json:"msg"`
 }
+
+type AlgoOrderResponse struct {
+	Code string `json:"code"`
+	Data []struct {
+		AlgoId string `json:"algoId"`
+		SCode  string `json:"sCode"`
+		SMsg   string `json:"sMsg"`
+	} `json:"data"`
+	Msg string `json:"msg"`
+}
+
+type PendingAlgoData struct {
+	AlgoId      string          `json:"algoId"`
+	AlgoClOrdId string          `json:"algoClOrdId"`
+	InstId      string          `json:"instId"`
+	OrdType     string          `json:"ordType"`
+	Side        string          `json:"side"`
+	Sz          string          `json:"sz"`
+	SlTriggerPx pkg.TextFloat64 `json:"slTriggerPx"`
+	TpTriggerPx pkg.TextFloat64 `json:"tpTriggerPx"`
+}
+
+// AlgoHistoryData is an algo order that left the pending list, see GetTriggeredStops.
+type AlgoHistoryData struct {
+	AlgoId      string   `json:"algoId"`
+	AlgoClOrdId string   `json:"algoClOrdId"`
+	InstId      string   `json:"instId"`
+	State       string   `json:"state"` // effective once triggered, canceled, order_failed
+	OrdId       string   `json:"ordId"` // the order it triggered, older answers
+	OrdIdList   []string `json:"ordIdList"`
+}
+
+type AlgoHistoryResponse struct {
+	Code string            `json:"code"`
+	Data []AlgoHistoryData `json:"data"`
+	Msg  string            `json:"msg"`
+}
+
+type PendingAlgoResponse struct {
+	Code string            `json:"code"`
+	Data []PendingAlgoData `json:"data"`
+	Msg  string            `json:"msg"`
+}
+
+// Ticker is a push of the tickers channel.
+type Ticker struct {
+	InstId  string          `json:"instId"`
+	Last    pkg.TextFloat64 `json:"last"`
+	LastSz  pkg.TextFloat64 `json:"lastSz"`
+	AskPx   pkg.TextFloat64 `json:"askPx"`
+	AskSz   pkg.TextFloat64 `json:"askSz"`
+	BidPx   pkg.TextFloat64 `json:"bidPx"`
+	BidSz   pkg.TextFloat64 `json:"bidSz"`
+	Open24h pkg.TextFloat64 `json:"open24h"`
+	High24h pkg.TextFloat64 `json:"high24h"`
+	Low24h  pkg.TextFloat64 `json:"low24h"`
+	Vol24h  pkg.TextFloat64 `json:"vol24h"`
+	Ts      string          `json:"ts"`
+}
+
+// Trade is a push of the trades channel.
+type Trade struct {
+	InstId  string          `json:"instId"`
+	TradeId string          `json:"tradeId"`
+	Px      pkg.TextFloat64 `json:"px"`
+	Sz      pkg.TextFloat64 `json:"sz"`
+	Side    string          `json:"side"` // taker side
+	Ts      string          `json:"ts"`
+}
+
+// CandleEvent is a push of a candle channel.
+type CandleEvent struct {
+	InstId    string
+	Timeframe model.Timeframe
+	model.Candlestick
+}
+
+// OrderEvent is a push of the orders channel.
+type OrderEvent struct {
+	InstId    string          `json:"instId"`
+	OrdId     string          `json:"ordId"`
+	ClOrdId   string          `json:"clOrdId"`
+	Side      string          `json:"side"`
+	OrdType   string          `json:"ordType"`
+	State     string          `json:"state"` // live, partially_filled, filled, canceled
+	Sz        pkg.TextFloat64 `json:"sz"`
+	AccFillSz pkg.TextFloat64 `json:"accFillSz"`
+	AvgPx     pkg.TextFloat64 `json:"avgPx"`
+	FillPx    pkg.TextFloat64 `json:"fillPx"`
+	FillSz    pkg.TextFloat64 `json:"fillSz"`
+	Fee       pkg.TextFloat64 `json:"fee"`
+	FeeCcy    string          `json:"feeCcy"`
+	UTime     string          `json:"uTime"`
+}
+
+type PlaceOrderResponse struct {
+	Code string `json:"code"`
+	Data []struct {
+		OrdId   string `json:"ordId"`
+		ClOrdId string `json:"clOrdId"`
+		SCode   string `json:"sCode"`
+		SMsg    string `json:"sMsg"`
+	} `json:"data"`
+	Msg string `json:"msg"`
+}
+
+// OrderResponse is the answer of /api/v5/trade/order, its data has the fields of OrderEvent.
+type OrderResponse struct {
+	Code string       `json:"code"`
+	Data []OrderEvent `json:"data"`
+	Msg  string       `json:"msg"`
+}
+
+type FillData struct {
+	InstId  string          `json:"instId"`
+	TradeId string          `json:"tradeId"`
+	OrdId   string          `json:"ordId"`
+	FillPx  pkg.TextFloat64 `json:"fillPx"`
+	FillSz  pkg.TextFloat64 `json:"fillSz"`
+	Side    string          `json:"side"`
+	Fee     pkg.TextFloat64 `json:"fee"` // negative is charged
+	FeeCcy  string          `json:"feeCcy"`
+	Ts      string          `json:"ts"`
+}
+
+type FillsResponse struct {
+	Code string     `json:"code"`
+	Data []FillData `json:"data"`
+	Msg  string     `json:"msg"`
+}
+
+type InstrumentData struct {
+	InstId   string          `json:"instId"`
+	BaseCcy  string          `json:"baseCcy"`
+	QuoteCcy string          `json:"quoteCcy"`
+	LotSz    pkg.TextFloat64 `json:"lotSz"`
+	MinSz    pkg.TextFloat64 `json:"minSz"`
+	TickSz   pkg.TextFloat64 `json:"tickSz"`
+	State    string          `json:"state"` // live, suspend, preopen, test
+}
+
+type InstrumentsResponse struct {
+	Code string           `json:"code"`
+	Data []InstrumentData `json:"data"`
+	Msg  string           `json:"msg"`
+}
+
+type TickerResponse struct {
+	Code string   `json:"code"`
+	Data []Ticker `json:"data"`
+	Msg  string   `json:"msg"`
+}
@@ -0,0 +1,510 @@
+package okx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	wsBase         = "wss://ws.okx.com:8443"
+	wsSimulateBase = "wss://wspap.okx.com:8443"
+
+	wsPublic   = "/ws/v5/public"   // tickers, trades
+	wsBusiness = "/ws/v5/business" // candles
+	wsPrivate  = "/ws/v5/private"  // orders, account
+)
+
+// ErrStreamClosed is returned when subscribing on a closed Client.
+var ErrStreamClosed = errors.New("okx: websocket stream closed")
+
+type loginReq struct {
+	Op   string `json:"op"`
+	Args []args `json:"args"`
+}
+
+type args struct {
+	ApiKey     string `json:"apiKey"`
+	Passphrase string `json:"passphrase"`
+	Timestamp  string `json:"timestamp"`
+	Sign       string `json:"sign"`
+}
+
+type wsArg struct {
+	Channel  string `json:"channel"`
+	InstId   string `json:"instId,omitempty"`
+	InstType string `json:"instType,omitempty"`
+}
+
+func (a wsArg) key() string {
+	return a.Channel + ":" + a.InstType + ":" + a.InstId
+}
+
+type wsOp struct {
+	Op   string  `json:"op"`
+	Args []wsArg `json:"args"`
+}
+
+// wsMessage is any frame from the server, either an event reply or a channel push.
+type wsMessage struct {
+	Event string          `json:"event"`
+	Code  string          `json:"code"`
+	Msg   string          `json:"msg"`
+	Arg   wsArg           `json:"arg"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// subscription is one consumer of one or more channels.
+type subscription struct {
+	args    []wsArg
+	mu      sync.Mutex
+	closed  bool
+	deliver func(arg wsArg, data json.RawMessage) // decodes data and sends it to the consumer
+	close   func()
+}
+
+func (sub *subscription) push(arg wsArg, data json.RawMessage) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if !sub.closed {
+		sub.deliver(arg, data)
+	}
+}
+
+func (sub *subscription) stop() {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if !sub.closed {
+		sub.closed = true
+		sub.close()
+	}
+}
+
+// Stream is the websocket connection to one OKX endpoint. It logs in on private endpoints,
+// sends a ping while idle, and reconnects with backoff, subscribing every live channel again.
+type Stream struct {
+	url          string
+	login        func() ([]byte, error) // nil on public endpoints
+	dialer       *websocket.Dialer
+	pingInterval time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+
+	mu      sync.Mutex
+	conn    *websocket.Conn // nil while disconnected
+	subs    map[string]*channel
+	started bool
+	closed  bool
+	done    chan struct{}
+	writeMu sync.Mutex
+}
+
+func newStream(url string, login func() ([]byte, error)) *Stream {
+	return &Stream{
+		url:          url,
+		login:        login,
+		dialer:       websocket.DefaultDialer,
+		pingInterval: 25 * time.Second, // OKX drops connections idle for 30s
+		minBackoff:   time.Second,
+		maxBackoff:   30 * time.Second,
+		subs:         make(map[string]*channel),
+		done:         make(chan struct{}),
+	}
+}
+
+// Close disconnects and ends every subscription.
+func (s *Stream) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.done)
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	subs := s.all()
+	s.subs = make(map[string]*channel)
+	s.mu.Unlock()
+	for _, sub := range subs {
+		sub.stop()
+	}
+}
+
+// channel is one subscribed channel and its consumers.
+type channel struct {
+	arg  wsArg
+	subs []*subscription
+}
+
+// all returns every subscription once, the caller holds mu.
+func (s *Stream) all() []*subscription {
+	res := make([]*subscription, 0)
+	for _, c := range s.subs {
+		for _, sub := range c.subs {
+			if !slices.Contains(res, sub) {
+				res = append(res, sub)
+			}
+		}
+	}
+	return res
+}
+
+func (s *Stream) add(sub *subscription) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrStreamClosed
+	}
+	fresh := make([]wsArg, 0, len(sub.args))
+	for _, arg := range sub.args {
+		c, ok := s.subs[arg.key()]
+		if !ok {
+			c = &channel{arg: arg}
+			s.subs[arg.key()] = c
+			fresh = append(fresh, arg)
+		}
+		c.subs = append(c.subs, sub)
+	}
+	if !s.started {
+		s.started = true
+		go s.run()
+	}
+	// while disconnected the next session subscribes to everything in subs
+	conn := s.conn
+	s.mu.Unlock()
+	if conn != nil && len(fresh) > 0 {
+		return s.write(conn, wsOp{Op: "subscribe", Args: fresh})
+	}
+	return nil
+}
+
+func (s *Stream) remove(sub *subscription) {
+	s.mu.Lock()
+	unused := make([]wsArg, 0, len(sub.args))
+	for _, arg := range sub.args {
+		c, ok := s.subs[arg.key()]
+		if !ok {
+			continue
+		}
+		c.subs = slices.DeleteFunc(c.subs, func(v *subscription) bool { return v == sub })
+		if len(c.subs) == 0 {
+			delete(s.subs, arg.key())
+			unused = append(unused, arg)
+		}
+	}
+	conn := s.conn
+	s.mu.Unlock()
+	sub.stop()
+	if conn != nil && len(unused) > 0 {
+		if err := s.write(conn, wsOp{Op: "unsubscribe", Args: unused}); err != nil {
+			log.Println(fmt.Sprintf("[okx.Stream] %s 取消订阅失败: %s", s.url, err.Error()))
+		}
+	}
+}
+
+func (s *Stream) write(conn *websocket.Conn, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.writeRaw(conn, data)
+}
+
+func (s *Stream) writeRaw(conn *websocket.Conn, data []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (s *Stream) run() {
+	delay := s.minBackoff
+	for {
+		ready, err := s.session()
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		if ready {
+			delay = s.minBackoff
+		}
+		log.Println(fmt.Sprintf("[okx.Stream] %s 连接断开, %s 后重连: %v", s.url, delay, err))
+		select {
+		case <-time.After(delay):
+		case <-s.done:
+			return
+		}
+		delay = min(delay*2, s.maxBackoff)
+	}
+}
+
+// session runs one connection until it fails. ready reports whether it got as far as
+// subscribing, in which case the backoff starts over.
+func (s *Stream) session() (ready bool, err error) {
+	conn, _, err := s.dialer.Dial(s.url, nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if s.login != nil {
+		if err = s.authenticate(conn); err != nil {
+			return false, err
+		}
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return false, ErrStreamClosed
+	}
+	s.conn = conn
+	resubscribe := make([]wsArg, 0, len(s.subs))
+	for _, c := range s.subs {
+		resubscribe = append(resubscribe, c.arg)
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+	}()
+	if len(resubscribe) > 0 {
+		if err = s.write(conn, wsOp{Op: "subscribe", Args: resubscribe}); err != nil {
+			return false, err
+		}
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.keepalive(conn, stop)
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * s.pingInterval))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return true, err
+		}
+		s.handle(data)
+	}
+}
+
+func (s *Stream) authenticate(conn *websocket.Conn) error {
+	data, err := s.login()
+	if err != nil {
+		return err
+	}
+	if err = s.writeRaw(conn, data); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for {
+		_, data, err = conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var msg wsMessage
+		if json.Unmarshal(data, &msg) != nil {
+			continue
+		}
+		switch msg.Event {
+		case "login":
+			if msg.Code != "" && msg.Code != "0" {
+				return fmt.Errorf("[okx.Stream] login failed: %w", &APIError{Code: msg.Code, Msg: msg.Msg})
+			}
+			return nil
+		case "error":
+			return fmt.Errorf("[okx.Stream] login failed: %w", &APIError{Code: msg.Code, Msg: msg.Msg})
+		}
+	}
+}
+
+func (s *Stream) keepalive(conn *websocket.Conn, stop chan struct{}) {
+	ticker := time.NewTicker(s.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.writeRaw(conn, []byte("ping")); err != nil {
+				conn.Close()
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (s *Stream) handle(data []byte) {
+	if string(data) == "pong" {
+		return
+	}
+	var msg wsMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Println(fmt.Sprintf("[okx.Stream] %s 无法解析消息: %s", s.url, string(data)))
+		return
+	}
+	switch msg.Event {
+	case "":
+		var subs []*subscription
+		s.mu.Lock()
+		if c, ok := s.subs[msg.Arg.key()]; ok {
+			subs = slices.Clone(c.subs)
+		}
+		s.mu.Unlock()
+		for _, sub := range subs {
+			sub.push(msg.Arg, msg.Data)
+		}
+	case "error", "notice":
+		log.Println(fmt.Sprintf("[okx.Stream] %s %s: %s %s", s.url, msg.Event, msg.Code, msg.Msg))
+	}
+}
+
+// subscribe registers a consumer of args on s. Every push is decoded by decode and delivered on
+// the returned channel, which is closed once ctx is done or the Client is closed.
+func subscribe[T any](ctx context.Context, s *Stream, args []wsArg, decode func(wsArg, json.RawMessage) ([]T, error)) (<-chan T, error) {
+	ch := make(chan T, 64)
+	sub := &subscription{args: args}
+	sub.deliver = func(arg wsArg, data json.RawMessage) {
+		events, err := decode(arg, data)
+		if err != nil {
+			log.Println(fmt.Sprintf("[okx.Stream] %s 无法解析推送: %s", s.url, err.Error()))
+			return
+		}
+		for _, e := range events {
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+				return
+			case <-s.done:
+				return
+			}
+		}
+	}
+	sub.close = func() {
+		close(ch)
+	}
+	if err := s.add(sub); err != nil {
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.done:
+		}
+		s.remove(sub)
+	}()
+	return ch, nil
+}
+
+func decodeData[T any](_ wsArg, data json.RawMessage) ([]T, error) {
+	var res []T
+	err := json.Unmarshal(data, &res)
+	return res, err
+}
+
+// stream returns the shared connection to the endpoint at path.
+func (oc *Client) stream(path string) *Stream {
+	oc.wsMu.Lock()
+	defer oc.wsMu.Unlock()
+	if s, ok := oc.streams[path]; ok {
+		return s
+	}
+	var login func() ([]byte, error)
+	if path == wsPrivate {
+		login = oc.wsLogin
+	}
+	s := newStream(oc.wsBase+path, login)
+	oc.streams[path] = s
+	return s
+}
+
+func (oc *Client) wsLogin() ([]byte, error) {
+	uriPath := "/users/self/verify"
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	return json.Marshal(&loginReq{
+		Op: "login",
+		Args: []args{
+			{
+				ApiKey:     oc.apiKey,
+				Passphrase: oc.passPhrase,
+				Timestamp:  ts,
+				Sign:       AccessSign(ts, http.MethodGet, uriPath, "", oc.secretKey),
+			},
+		},
+	})
+}
+
+// Close disconnects every websocket stream and closes their subscription channels.
+func (oc *Client) Close() {
+	oc.wsMu.Lock()
+	streams := oc.streams
+	oc.streams = make(map[string]*Stream)
+	oc.wsMu.Unlock()
+	for _, s := range streams {
+		s.Close()
+	}
+}
+
+func instArgs(channel string, instIds []string) []wsArg {
+	res := make([]wsArg, len(instIds))
+	for i, id := range instIds {
+		res[i] = wsArg{Channel: channel, InstId: id}
+	}
+	return res
+}
+
+// SubscribeTickers streams the latest price, best bid/ask and 24h statistics of instIds.
+func (oc *Client) SubscribeTickers(ctx context.Context, instIds ...string) (<-chan Ticker, error) {
+	return subscribe(ctx, oc.stream(wsPublic), instArgs("tickers", instIds), decodeData[Ticker])
+}
+
+// SubscribeTrades streams the public trades of instIds.
+func (oc *Client) SubscribeTrades(ctx context.Context, instIds ...string) (<-chan Trade, error) {
+	return subscribe(ctx, oc.stream(wsPublic), instArgs("trades", instIds), decodeData[Trade])
+}
+
+// SubscribeCandles streams candle updates of timeframe for instIds. The open candle is pushed
+// on every change, Candlestick.Confirm is "1" once it closed.
+func (oc *Client) SubscribeCandles(ctx context.Context, timeframe model.Timeframe, instIds ...string) (<-chan CandleEvent, error) {
+	bar, ok := bars[timeframe]
+	if !ok {
+		return nil, fmt.Errorf("[okx.SubscribeCandles] unsupported timeframe %q", timeframe)
+	}
+	channel := "candle" + bar
+	return subscribe(ctx, oc.stream(wsBusiness), instArgs(channel, instIds), func(arg wsArg, data json.RawMessage) ([]CandleEvent, error) {
+		rows, err := decodeData[[]string](arg, data)
+		if err != nil {
+			return nil, err
+		}
+		res := make([]CandleEvent, len(rows))
+		for i, row := range rows {
+			if res[i].Candlestick, err = parseCandle(row); err != nil {
+				return nil, err
+			}
+			res[i].InstId = arg.InstId
+			res[i].Timeframe = timeframe
+		}
+		return res, nil
+	})
+}
+
+// SubscribeOrders streams updates of the account's orders of instType, e.g. "SPOT".
+func (oc *Client) SubscribeOrders(ctx context.Context, instType string) (<-chan OrderEvent, error) {
+	return subscribe(ctx, oc.stream(wsPrivate), []wsArg{{Channel: "orders", InstType: instType}}, decodeData[OrderEvent])
+}
+
+// SubscribeAccount streams balance changes of the account.
+func (oc *Client) SubscribeAccount(ctx context.Context) (<-chan BalanceData, error) {
+	return subscribe(ctx, oc.stream(wsPrivate), []wsArg{{Channel: "account"}}, decodeData[BalanceData])
+}
@@ -0,0 +1,219 @@
+package okx
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConn serializes the writes of the handler and the test.
+type fakeConn struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+}
+
+func (c *fakeConn) send(msg string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.WriteMessage(websocket.TextMessage, []byte(msg))
+}
+
+type wsFrame struct {
+	path string
+	data string
+}
+
+// fakeWs stands in for the OKX websocket endpoints: it answers login and ping, and hands
+// every connection and every other frame to the test.
+type fakeWs struct {
+	server *httptest.Server
+	conns  chan *fakeConn
+	frames chan wsFrame
+}
+
+func newFakeWs(t *testing.T) *fakeWs {
+	f := &fakeWs{
+		conns:  make(chan *fakeConn, 8),
+		frames: make(chan wsFrame, 64),
+	}
+	upgrader := websocket.Upgrader{}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn := &fakeConn{conn: ws}
+		f.conns <- conn
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(data) == "ping" {
+				conn.send("pong")
+			}
+			var login loginReq
+			if json.Unmarshal(data, &login) == nil && login.Op == "login" {
+				a := login.Args[0]
+				code := "0"
+				if a.Sign != AccessSign(a.Timestamp, http.MethodGet, "/users/self/verify", "", "secret") {
+					code = "60007"
+				}
+				conn.send(`{"event":"login","code":"` + code + `"}`)
+			}
+			f.frames <- wsFrame{path: r.URL.Path, data: string(data)}
+		}
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeWs) client(t *testing.T) *Client {
+	oc, _ := NewOkxClient("pass", "secret", "key", "0")
+	oc.wsBase = "ws" + strings.TrimPrefix(f.server.URL, "http")
+	for _, path := range []string{wsPublic, wsBusiness, wsPrivate} {
+		s := oc.stream(path)
+		s.minBackoff = 10 * time.Millisecond
+		s.pingInterval = 50 * time.Millisecond
+	}
+	t.Cleanup(oc.Close)
+	return oc
+}
+
+// expect waits for the next frame that is not a ping and checks that it contains want.
+func (f *fakeWs) expect(t *testing.T, path, want string) {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case frame := <-f.frames:
+			if frame.data == "ping" {
+				continue
+			}
+			if frame.path != path || !strings.Contains(frame.data, want) {
+				t.Fatalf("expected %s on %s, got %s on %s", want, path, frame.data, frame.path)
+			}
+			return
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+}
+
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v, ok := <-ch:
+		if !ok {
+			t.Fatal("channel closed")
+		}
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	var zero T
+	return zero
+}
+
+func nextConn(t *testing.T, f *fakeWs) *fakeConn {
+	t.Helper()
+	select {
+	case conn := <-f.conns:
+		return conn
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a connection")
+	}
+	return nil
+}
+
+func TestStreamReconnect(t *testing.T) {
+	f := newFakeWs(t)
+	oc := f.client(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tickers, err := oc.SubscribeTickers(ctx, "BTC-USDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	push := `{"arg":{"channel":"tickers","instId":"BTC-USDT"},"data":[{"instId":"BTC-USDT","last":"100.5","ts":"1"}]}`
+	conn := nextConn(t, f)
+	f.expect(t, wsPublic, `"op":"subscribe","args":[{"channel":"tickers","instId":"BTC-USDT"}]`)
+	conn.send(push)
+	if tick := receive(t, tickers); tick.InstId != "BTC-USDT" || tick.Last != 100.5 {
+		t.Fatalf("unexpected ticker %+v", tick)
+	}
+
+	// the server drops the connection, the client must come back and subscribe again
+	conn.conn.Close()
+	conn = nextConn(t, f)
+	f.expect(t, wsPublic, `"op":"subscribe","args":[{"channel":"tickers","instId":"BTC-USDT"}]`)
+	conn.send(push)
+	receive(t, tickers)
+
+	cancel()
+	f.expect(t, wsPublic, `"op":"unsubscribe"`)
+	if _, ok := <-tickers; ok {
+		t.Error("channel should be closed once the context is done")
+	}
+}
+
+func TestStreamCandles(t *testing.T) {
+	f := newFakeWs(t)
+	oc := f.client(t)
+	candles, err := oc.SubscribeCandles(context.Background(), model.Hour4, "ETH-USDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := nextConn(t, f)
+	f.expect(t, wsBusiness, `"channel":"candle4H","instId":"ETH-USDT"`)
+	conn.send(`{"arg":{"channel":"candle4H","instId":"ETH-USDT"},"data":[["1710000000000","10","12","9","11","100","0","0","1"]]}`)
+	c := receive(t, candles)
+	if c.InstId != "ETH-USDT" || c.Timeframe != model.Hour4 || c.C != 11 || c.Confirm != "1" {
+		t.Fatalf("unexpected candle %+v", c)
+	}
+
+	oc.Close()
+	if _, ok := <-candles; ok {
+		t.Error("channel should be closed with the client")
+	}
+	if _, err = oc.SubscribeTickers(context.Background(), "BTC-USDT"); err != nil {
+		t.Errorf("a closed client should open new streams on demand, got %v", err)
+	}
+}
+
+func TestStreamPrivate(t *testing.T) {
+	f := newFakeWs(t)
+	oc := f.client(t)
+	orders, err := oc.SubscribeOrders(context.Background(), "SPOT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := nextConn(t, f)
+	f.expect(t, wsPrivate, `"op":"login"`)
+	f.expect(t, wsPrivate, `"op":"subscribe","args":[{"channel":"orders","instType":"SPOT"}]`)
+	conn.send(`{"arg":{"channel":"orders","instType":"SPOT","uid":"1"},"data":[{"instId":"BTC-USDT","ordId":"42","state":"filled","accFillSz":"0.5","avgPx":"100"}]}`)
+	if o := receive(t, orders); o.OrdId != "42" || o.State != "filled" || o.AccFillSz != 0.5 {
+		t.Fatalf("unexpected order %+v", o)
+	}
+
+	// idle connections are kept alive with ping
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case frame := <-f.frames:
+			if frame.data == "ping" {
+				return
+			}
+		case <-timeout:
+			t.Fatal("no ping sent")
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"time"
+)
+
+// ClientOrderId returns a new client order id: letters and digits, at most 24 of them, which
+// every exchange accepts.
+func ClientOrderId() string {
+	return "sf" + strconv.FormatInt(time.Now().UnixMilli(), 36) + strconv.FormatUint(rand.Uint64(), 36)
+}
+
+// OrderWait is how a client waits for a new market order to settle.
+type OrderWait struct {
+	Name string        // log prefix, e.g. okx
+	Poll time.Duration // between two lookups of the order
+	Wait time.Duration // how long to wait for a final state
+}
+
+// DefaultOrderWait looks the order up every 200ms for up to 5 seconds.
+func DefaultOrderWait(name string) OrderWait {
+	return OrderWait{Name: name, Poll: 200 * time.Millisecond, Wait: 5 * time.Second}
+}
+
+// Settle looks the order id up through get until it is done or Wait has passed, starting
+// from res, what is known of it so far. The order is accepted at this point, so a failed
+// lookup returns the last known state instead of an error.
+func (w OrderWait) Settle(ctx context.Context, id string, res *model.OrderResult, get func() (*model.OrderResult, error)) *model.OrderResult {
+	deadline := time.Now().Add(w.Wait)
+	for first := true; !res.Done(); first = false {
+		if !first {
+			if time.Now().After(deadline) {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return res
+			case <-time.After(w.Poll):
+			}
+		}
+		o, err := get()
+		if err != nil {
+			log.Println(fmt.Sprintf("[%s.Order] %s 查询订单状态失败: %s", w.Name, id, err.Error()))
+			return res
+		}
+		res = o
+	}
+	return res
+}
+
+// Fill adds the fills of a settled order from fills, the fee currency becomes the one of its
+// fills. They are only details of what the order already reports, so a failed lookup is
+// logged and leaves res without them.
+func (w OrderWait) Fill(res *model.OrderResult, fills func() ([]model.OrderFill, error)) {
+	if res.FilledSize <= 0 {
+		return
+	}
+	f, err := fills()
+	if err != nil {
+		log.Println(fmt.Sprintf("[%s.Order] %s 获取成交明细失败: %s", w.Name, res.OrderId, err.Error()))
+		return
+	}
+	res.Fills = f
+	if len(f) > 0 {
+		res.FeeCcy = f[0].FeeCcy
+	}
+}
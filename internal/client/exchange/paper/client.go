@@ -0,0 +1,203 @@
+package paper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CandleSource provides market data for the paper exchange, e.g. okx.Client or a historical replay.
+type CandleSource interface {
+	GetCandle(pair currency.Pair, timeframe model.Timeframe, period int) ([]model.Candlestick, error)
+}
+
+type Options struct {
+	Quote     currency.Coin             // currency every instrument is priced in, defaults to USDT
+	Initial   map[currency.Coin]float64 // starting balances when no state file exists
+	Slippage  float64                   // price impact of a market order, e.g. 0.0005 = 5bp
+	TakerFee  float64                   // fee rate charged on every fill, e.g. 0.001
+	StatePath string                    // optional JSON file the ledger is loaded from and saved to
+}
+
+// Client is a local exchange implementing trade.Market. Market orders fill immediately at the
+// last close of source, adjusted by slippage, against an in-memory ledger.
+type Client struct {
+	source CandleSource
+	opts   Options
+	mu     sync.Mutex
+	ledger *Ledger
+}
+
+func NewPaperClient(source CandleSource, opts Options) (*Client, error) {
+	if opts.Quote == "" {
+		opts.Quote = currency.USDT
+	}
+	ledger := NewLedger(opts.Initial)
+	if opts.StatePath != "" {
+		loaded, err := LoadLedger(opts.StatePath)
+		switch {
+		case err == nil:
+			ledger = loaded
+		case !errors.Is(err, os.ErrNotExist):
+			return nil, err
+		}
+	}
+	return &Client{
+		source: source,
+		opts:   opts,
+		ledger: ledger,
+	}, nil
+}
+
+func (pc *Client) GetCandle(pair currency.Pair, timeframe model.Timeframe, period int) ([]model.Candlestick, error) {
+	return pc.source.GetCandle(pair, timeframe, period)
+}
+
+// Fills returns a copy of all executions so far.
+func (pc *Client) Fills() []Fill {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	res := make([]Fill, len(pc.ledger.Fills))
+	copy(res, pc.ledger.Fills)
+	return res
+}
+
+// GetBalance reports the requested coins (all ledger coins when none is given). Coins that
+// were never held are still returned with zero balances.
+func (pc *Client) GetBalance(_ context.Context, coin ...currency.Coin) (*model.TradeData, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	res := &model.TradeData{AccountAssets: make(map[currency.Coin]*model.Asset)}
+	for c, h := range pc.ledger.Balances {
+		asset, err := pc.asset(c, h)
+		if err != nil {
+			return nil, err
+		}
+		res.TotalEquity += asset.EquityUSD
+		res.AccountAssets[c] = asset
+	}
+	if len(coin) == 0 {
+		return res, nil
+	}
+
+	filtered := make(map[currency.Coin]*model.Asset, len(coin))
+	for _, c := range coin {
+		if asset, ok := res.AccountAssets[c]; ok {
+			filtered[c] = asset
+			continue
+		}
+		filtered[c] = &model.Asset{Currency: c}
+	}
+	res.AccountAssets = filtered
+	return res, nil
+}
+
+func (pc *Client) asset(c currency.Coin, h *Holding) (*model.Asset, error) {
+	asset := &model.Asset{
+		Equity:      h.Amount,
+		Currency:    c,
+		TotalProfit: h.RealizedPNL,
+		AVGPrice:    h.AvgPrice,
+	}
+	if c.IsStable() {
+		asset.EquityUSD = h.Amount
+		return asset, nil
+	}
+	if h.Amount == 0 {
+		return asset, nil
+	}
+	last, err := pc.lastCandle(c)
+	if err != nil {
+		return nil, err
+	}
+	asset.EquityUSD = h.Amount * last.C
+	if h.AvgPrice > 0 {
+		asset.UnrealizedPNL = (last.C - h.AvgPrice) * h.Amount
+		asset.UnrealizedPNLRatio = last.C/h.AvgPrice - 1
+	}
+	return asset, nil
+}
+
+func (pc *Client) lastCandle(c currency.Coin) (model.Candlestick, error) {
+	candles, err := pc.source.GetCandle(currency.NewPair(pc.opts.Quote, c), model.Minute1, 1)
+	if err != nil {
+		return model.Candlestick{}, err
+	}
+	if len(candles) == 0 {
+		return model.Candlestick{}, fmt.Errorf("[paper.lastCandle] no price for %s", c.String())
+	}
+	return candles[0], nil
+}
+
+// Order fills a market order. instId is "COIN-QUOTE"; for buys sz is the quote amount to
+// spend and for sells the coin amount, matching OKX spot market orders. An order the ledger
+// cannot fill, e.g. a buy without cash, is canceled like on the exchange.
+func (pc *Client) Order(_ context.Context, instId, side, sz string) (*model.OrderResult, error) {
+	coin, quote, err := splitInstId(instId)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := strconv.ParseFloat(sz, 64)
+	if err != nil {
+		return nil, fmt.Errorf("[paper.Order] invalid size %q: %w", sz, err)
+	}
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	last, err := pc.lastCandle(coin)
+	if err != nil {
+		return nil, err
+	}
+	var fill Fill
+	var ok bool
+	side = strings.ToLower(side)
+	switch side {
+	case "buy":
+		fill, ok = pc.ledger.Buy(last.Ts, coin, quote, last.C*(1+pc.opts.Slippage), amount, pc.opts.TakerFee)
+	case "sell":
+		fill, ok = pc.ledger.Sell(last.Ts, coin, quote, last.C*(1-pc.opts.Slippage), amount, pc.opts.TakerFee)
+	default:
+		return nil, fmt.Errorf("[paper.Order] unknown side %q", side)
+	}
+	res := &model.OrderResult{
+		OrderId: "paper-" + strconv.Itoa(len(pc.ledger.Fills)),
+		InstId:  instId,
+		Side:    side,
+		State:   model.OrderCanceled,
+	}
+	if !ok {
+		return res, nil
+	}
+	res.State = model.OrderFilled
+	res.FilledSize = fill.Size
+	res.AvgPrice = fill.Price
+	res.Fee = fill.Fee
+	res.FeeCcy = quote.String()
+	res.Fills = []model.OrderFill{{
+		TradeId: res.OrderId,
+		Ts:      fill.Ts,
+		Price:   fill.Price,
+		Size:    fill.Size,
+		Fee:     fill.Fee,
+		FeeCcy:  res.FeeCcy,
+	}}
+	if pc.opts.StatePath != "" {
+		return res, pc.ledger.Save(pc.opts.StatePath)
+	}
+	return res, nil
+}
+
+func splitInstId(instId string) (currency.Coin, currency.Coin, error) {
+	parts := strings.Split(instId, "-")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("[paper.Order] invalid instId %q", instId)
+	}
+	return currency.Coin(parts[0]), currency.Coin(parts[1]), nil
+}
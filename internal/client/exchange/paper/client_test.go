@@ -0,0 +1,80 @@
+package paper
+
+import (
+	"context"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+type fixedSource struct {
+	price float64
+}
+
+func (f *fixedSource) GetCandle(_ currency.Pair, _ model.Timeframe, period int) ([]model.Candlestick, error) {
+	res := make([]model.Candlestick, period)
+	for i := range res {
+		res[i] = model.Candlestick{Ts: "1700000000000", C: f.price}
+	}
+	return res, nil
+}
+
+func TestOrder(t *testing.T) {
+	source := &fixedSource{price: 100}
+	statePath := filepath.Join(t.TempDir(), "paper.json")
+	client, err := NewPaperClient(source, Options{
+		Initial:   map[currency.Coin]float64{currency.USDT: 1000},
+		Slippage:  0.01,
+		TakerFee:  0.001,
+		StatePath: statePath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Order(context.Background(), "BTC-USDT", "buy", "500")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.State != model.OrderFilled || res.AvgPrice != 101 || math.Abs(res.Fee-0.5) > 1e-9 || res.FeeCcy != "USDT" {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	source.price = 110
+	balance, err := client.GetBalance(context.Background(), currency.USDT, currency.BTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	btc := balance.AccountAssets[currency.BTC]
+	wantSize := 499.5 / 101
+	if math.Abs(btc.Equity-wantSize) > 1e-9 || math.Abs(btc.AVGPrice-500/wantSize) > 1e-9 {
+		t.Errorf("unexpected position: %+v", btc)
+	}
+	if math.Abs(btc.EquityUSD-wantSize*110) > 1e-9 || math.Abs(btc.UnrealizedPNL-(wantSize*110-500)) > 1e-9 {
+		t.Errorf("unexpected valuation: %+v", btc)
+	}
+	if balance.AccountAssets[currency.USDT].Equity != 500 || math.Abs(balance.TotalEquity-(500+wantSize*110)) > 1e-9 {
+		t.Errorf("unexpected balance: %+v", balance)
+	}
+
+	// state survives a restart
+	restored, err := NewPaperClient(source, Options{Slippage: 0.01, TakerFee: 0.001, StatePath: statePath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = restored.Order(context.Background(), "BTC-USDT", "sell", "100"); err != nil {
+		t.Fatal(err)
+	}
+	if res, err = restored.Order(context.Background(), "BTC-USDT", "sell", "1"); err != nil || res.State != model.OrderCanceled {
+		t.Errorf("selling an empty position should be canceled, got %+v, %v", res, err)
+	}
+	fills := restored.Fills()
+	if len(fills) != 2 || fills[1].Size != wantSize || fills[1].Price != 108.9 {
+		t.Fatalf("unexpected fills: %+v", fills)
+	}
+	wantPNL := wantSize*108.9*0.999 - 500
+	if math.Abs(fills[1].PNL-wantPNL) > 1e-9 {
+		t.Errorf("pnl = %f, want %f", fills[1].PNL, wantPNL)
+	}
+}
@@ -0,0 +1,138 @@
+package paper
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Holding is the ledger entry of one currency.
+type Holding struct {
+	Amount      float64 `json:"amount"`
+	AvgPrice    float64 `json:"avg_price"`    // average entry price in quote currency, 0 for the quote itself
+	RealizedPNL float64 `json:"realized_pnl"` // accumulated profit of closed sells, in quote currency
+}
+
+// Fill is a simulated order execution.
+type Fill struct {
+	Ts    string        `json:"ts"`
+	Coin  currency.Coin `json:"coin"`
+	Side  string        `json:"side"`
+	Price float64       `json:"price"` // fill price after slippage
+	Size  float64       `json:"size"`  // coin amount
+	Fee   float64       `json:"fee"`   // in quote currency
+	PNL   float64       `json:"pnl"`   // realized profit of a sell, 0 for buys
+}
+
+// Ledger keeps balances and fills of a paper account. It is not safe for concurrent use; Client guards it.
+type Ledger struct {
+	Balances map[currency.Coin]*Holding `json:"balances"`
+	Fills    []Fill                     `json:"fills"`
+}
+
+func NewLedger(initial map[currency.Coin]float64) *Ledger {
+	l := &Ledger{
+		Balances: make(map[currency.Coin]*Holding),
+		Fills:    make([]Fill, 0),
+	}
+	for c, amount := range initial {
+		l.Balances[c] = &Holding{Amount: amount}
+	}
+	return l
+}
+
+// LoadLedger reads a ledger previously written by Save.
+func LoadLedger(path string) (*Ledger, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	l := NewLedger(nil)
+	if err = json.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("[paper.LoadLedger] %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// Save writes the ledger to path, replacing the previous file atomically.
+func (l *Ledger) Save(path string) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".paper-*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (l *Ledger) holding(c currency.Coin) *Holding {
+	h, ok := l.Balances[c]
+	if !ok {
+		h = &Holding{}
+		l.Balances[c] = h
+	}
+	return h
+}
+
+// Amount returns the balance of c.
+func (l *Ledger) Amount(c currency.Coin) float64 {
+	if h, ok := l.Balances[c]; ok {
+		return h.Amount
+	}
+	return 0
+}
+
+// Buy spends up to quoteAmount of quote on coin at price, charging fee (a rate) on the spent amount.
+func (l *Ledger) Buy(ts string, coin, quote currency.Coin, price, quoteAmount, fee float64) (Fill, bool) {
+	cash := l.holding(quote)
+	quoteAmount = math.Min(quoteAmount, cash.Amount)
+	if quoteAmount <= 0 || price <= 0 {
+		return Fill{}, false
+	}
+	h := l.holding(coin)
+	feeAmount := quoteAmount * fee
+	size := (quoteAmount - feeAmount) / price
+	// fees are part of the cost basis
+	h.AvgPrice = (h.AvgPrice*h.Amount + quoteAmount) / (h.Amount + size)
+	h.Amount += size
+	cash.Amount -= quoteAmount
+	fill := Fill{Ts: ts, Side: "buy", Coin: coin, Price: price, Size: size, Fee: feeAmount}
+	l.Fills = append(l.Fills, fill)
+	return fill, true
+}
+
+// Sell sells up to size of coin for quote at price, charging fee (a rate) on the proceeds.
+func (l *Ledger) Sell(ts string, coin, quote currency.Coin, price, size, fee float64) (Fill, bool) {
+	h := l.holding(coin)
+	size = math.Min(size, h.Amount)
+	if size <= 0 || price <= 0 {
+		return Fill{}, false
+	}
+	gross := size * price
+	feeAmount := gross * fee
+	pnl := gross - feeAmount - size*h.AvgPrice
+	h.Amount -= size
+	h.RealizedPNL += pnl
+	if h.Amount <= 1e-12 {
+		h.Amount = 0
+		h.AvgPrice = 0
+	}
+	l.holding(quote).Amount += gross - feeAmount
+	fill := Fill{Ts: ts, Side: "sell", Coin: coin, Price: price, Size: size, Fee: feeAmount, PNL: pnl}
+	l.Fills = append(l.Fills, fill)
+	return fill, true
+}
@@ -0,0 +1,170 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Endpoint is the documented rate limit of a REST endpoint: requests per window.
+type Endpoint struct {
+	Requests int
+	Window   time.Duration
+	// idempotent POSTs may be sent again after a timeout or a 5xx, when it is unknown
+	// whether the first one went through
+	Idempotent bool
+}
+
+// Limiter keeps one token bucket per endpoint.
+type Limiter struct {
+	endpoints map[string]Endpoint // by "METHOD path"
+	fallback  Endpoint
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewLimiter limits the endpoints keyed "METHOD path", the ones missing from endpoints are
+// limited to fallback.
+func NewLimiter(endpoints map[string]Endpoint, fallback Endpoint) *Limiter {
+	return &Limiter{
+		endpoints: endpoints,
+		fallback:  fallback,
+		buckets:   make(map[string]*bucket),
+	}
+}
+
+// Endpoint returns the limit of method path.
+func (l *Limiter) Endpoint(method, path string) Endpoint {
+	if e, ok := l.endpoints[method+" "+path]; ok {
+		return e
+	}
+	return l.fallback
+}
+
+// Wait takes a token of method path, blocking until one is available or ctx is done.
+func (l *Limiter) Wait(ctx context.Context, method, path string) error {
+	key := method + " " + path
+	l.mu.Lock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newBucket(l.Endpoint(method, path))
+		l.buckets[key] = b
+	}
+	l.mu.Unlock()
+	return b.wait(ctx)
+}
+
+// bucket is a token bucket holding up to the requests of one window, refilled evenly.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(e Endpoint) *bucket {
+	return &bucket{
+		rate:   float64(e.Requests) / e.Window.Seconds(),
+		burst:  float64(e.Requests),
+		tokens: float64(e.Requests),
+		last:   time.Now(),
+	}
+}
+
+func (b *bucket) wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Backoff is the delay before retry attempt (from 1): exponential from minBackoff up to
+// maxBackoff, jittered down by up to half so that clients hitting the same limit spread out.
+func Backoff(attempt int, minBackoff, maxBackoff time.Duration) time.Duration {
+	d := minBackoff << (attempt - 1)
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// Retry is how a client sends its REST requests again.
+type Retry struct {
+	Name       string // log prefix, e.g. okx
+	MaxRetries int    // retries of a failed request, see Retryable
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetry retries up to 3 times within 5 seconds.
+func DefaultRetry(name string) Retry {
+	return Retry{Name: name, MaxRetries: 3, MinBackoff: 500 * time.Millisecond, MaxBackoff: 5 * time.Second}
+}
+
+// Do sends method path through send, waiting for the rate limit of the endpoint before every
+// attempt and retrying with backoff while Retryable allows it. send has to sign the request
+// anew, a retried request needs a fresh timestamp.
+func (r Retry) Do(ctx context.Context, l *Limiter, method, path string, send func() error) error {
+	idempotent := l.Endpoint(method, path).Idempotent
+	for attempt := 0; ; attempt++ {
+		if err := l.Wait(ctx, method, path); err != nil {
+			return err
+		}
+		err := send()
+		if err == nil || attempt >= r.MaxRetries || ctx.Err() != nil || !Retryable(method, idempotent, err) {
+			return err
+		}
+		delay := Backoff(attempt+1, r.MinBackoff, r.MaxBackoff)
+		log.Println(fmt.Sprintf("[%s] %s %s 失败, %s 后重试: %s", r.Name, method, path, delay, err.Error()))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Place sends a write that must not happen twice, e.g. an order: place sends it, through Do
+// on an endpoint that is not Idempotent. When its outcome is Ambiguous, found looks it up,
+// e.g. by its client order id, and it is only placed again when it is missing. A write found
+// counts as placed.
+func (r Retry) Place(ctx context.Context, place func() error, found func() (bool, error)) error {
+	err := place()
+	for attempt := 1; Ambiguous(err) && attempt <= r.MaxRetries; attempt++ {
+		delay := Backoff(attempt, r.MinBackoff, r.MaxBackoff)
+		log.Println(fmt.Sprintf("[%s] 下单结果未知, %s 后查询订单: %s", r.Name, delay, err.Error()))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		ok, lookupErr := found()
+		if lookupErr != nil {
+			return fmt.Errorf("[%s] order outcome unknown, lookup failed: %w, %v", r.Name, err, lookupErr)
+		}
+		if ok {
+			return nil
+		}
+		err = place()
+	}
+	return err
+}
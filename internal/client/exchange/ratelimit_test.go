@@ -0,0 +1,120 @@
+package exchange
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	b := newBucket(Endpoint{Requests: 5, Window: 100 * time.Millisecond})
+	start := time.Now()
+	for range 5 {
+		if err := b.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if time.Since(start) > 10*time.Millisecond {
+		t.Fatal("the burst should not wait")
+	}
+	if err := b.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < 15*time.Millisecond {
+		t.Errorf("the sixth request should wait for a token, waited %s", waited)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.tokens = 0
+	if err := b.wait(ctx); err == nil {
+		t.Error("wait should give up with the context")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 1; attempt <= 10; attempt++ {
+		d := Backoff(attempt, 100*time.Millisecond, time.Second)
+		ceiling := min(100*time.Millisecond<<(attempt-1), time.Second)
+		if d < ceiling/2 || d > ceiling {
+			t.Errorf("Backoff(%d) = %s, want within [%s, %s]", attempt, d, ceiling/2, ceiling)
+		}
+	}
+}
+
+type statusError struct {
+	status    int
+	retryable bool
+}
+
+func (e *statusError) Error() string   { return http.StatusText(e.status) }
+func (e *statusError) HTTPStatus() int { return e.status }
+func (e *statusError) Retryable() bool { return e.retryable }
+
+func TestRetryDo(t *testing.T) {
+	limiter := NewLimiter(map[string]Endpoint{
+		http.MethodPost + " /order": {Requests: 100, Window: time.Second, Idempotent: true},
+	}, Endpoint{Requests: 100, Window: time.Second})
+	retry := Retry{Name: "test", MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	gateway := &statusError{status: http.StatusBadGateway, retryable: true}
+	cases := []struct {
+		name  string
+		path  string
+		err   error
+		sends int
+	}{
+		{"idempotent after 5xx", "/order", gateway, 3},
+		{"other POST after 5xx", "/stop", gateway, 1},
+		{"rate limit", "/stop", &statusError{status: http.StatusTooManyRequests, retryable: true}, 3},
+		{"rejected", "/order", &statusError{status: http.StatusBadRequest}, 1},
+		{"network", "/stop", context.DeadlineExceeded, 1},
+		{"network idempotent", "/order", context.DeadlineExceeded, 3},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sends := 0
+			err := retry.Do(context.Background(), limiter, http.MethodPost, c.path, func() error {
+				sends++
+				return c.err
+			})
+			if err != c.err || sends != c.sends {
+				t.Errorf("sent %d times with %v, want %d", sends, err, c.sends)
+			}
+		})
+	}
+}
+
+func TestRetryPlace(t *testing.T) {
+	retry := Retry{Name: "test", MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	gateway := &statusError{status: http.StatusBadGateway}
+	cases := []struct {
+		name    string
+		errs    []error // of the sends
+		found   bool
+		sends   int
+		lookups int
+		ok      bool
+	}{
+		{"placed", []error{nil}, false, 1, 0, true},
+		{"rejected", []error{&statusError{status: http.StatusBadRequest}}, false, 1, 0, false},
+		{"lost and placed", []error{gateway}, true, 1, 1, true},
+		{"lost and missing", []error{context.DeadlineExceeded, nil}, false, 2, 1, true},
+		{"lost every time", []error{gateway, gateway, gateway}, false, 3, 2, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sends, lookups := 0, 0
+			err := retry.Place(context.Background(), func() error {
+				sends++
+				return c.errs[sends-1]
+			}, func() (bool, error) {
+				lookups++
+				return c.found, nil
+			})
+			if (err == nil) != c.ok || sends != c.sends || lookups != c.lookups {
+				t.Errorf("sent %d times and looked up %d times with %v", sends, lookups, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Client is what every exchange client implements, the same methods as trade.Market.
+type Client interface {
+	GetCandle(pair currency.Pair, timeframe model.Timeframe, period int) ([]model.Candlestick, error)
+	GetBalance(ctx context.Context, coin ...currency.Coin) (*model.TradeData, error)
+	Order(ctx context.Context, instId, side, sz string) (*model.OrderResult, error)
+}
+
+// Account is one trading account on an exchange.
+type Account struct {
+	Exchange   string // registered name, e.g. okx
+	APIKey     string
+	SecretKey  string
+	Passphrase string // only OKX has one
+	Demo       bool   // the demo trading or testnet environment of the exchange
+	BaseURL    string // overrides the REST base url, e.g. a proxy
+	WSURL      string // overrides the websocket base url
+}
+
+// Factory builds the client of an account.
+type Factory func(Account) (Client, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes an exchange available to New by name, the client packages register
+// themselves on import. It panics when name is registered twice.
+func Register(name string, f Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic("exchange: Register called twice for " + name)
+	}
+	registry[name] = f
+}
+
+// Names returns the registered exchanges, sorted.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	res := make([]string, 0, len(registry))
+	for name := range registry {
+		res = append(res, name)
+	}
+	slices.Sort(res)
+	return res
+}
+
+// New builds the client of account with the factory of its exchange.
+func New(account Account) (Client, error) {
+	registryMu.RLock()
+	f, ok := registry[account.Exchange]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("[exchange.New] unknown exchange %q, registered: %s", account.Exchange, strings.Join(Names(), ", "))
+	}
+	return f(account)
+}
@@ -0,0 +1,51 @@
+package exchange
+
+import (
+	"context"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"slices"
+	"testing"
+)
+
+type fakeClient struct {
+	account Account
+}
+
+func (f *fakeClient) GetCandle(currency.Pair, model.Timeframe, int) ([]model.Candlestick, error) {
+	return nil, nil
+}
+
+func (f *fakeClient) GetBalance(context.Context, ...currency.Coin) (*model.TradeData, error) {
+	return &model.TradeData{}, nil
+}
+
+func (f *fakeClient) Order(context.Context, string, string, string) (*model.OrderResult, error) {
+	return &model.OrderResult{}, nil
+}
+
+func TestRegistry(t *testing.T) {
+	Register("fake", func(a Account) (Client, error) {
+		return &fakeClient{account: a}, nil
+	})
+	if !slices.Contains(Names(), "fake") {
+		t.Fatalf("fake should be registered, got %v", Names())
+	}
+	c, err := New(Account{Exchange: "fake", APIKey: "key", Demo: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := c.(*fakeClient); f.account.APIKey != "key" || !f.account.Demo {
+		t.Errorf("the factory should get the account, got %+v", f.account)
+	}
+	if _, err = New(Account{Exchange: "nope"}); err == nil {
+		t.Error("an unknown exchange should fail")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice should panic")
+		}
+	}()
+	Register("fake", nil)
+}
@@ -0,0 +1,12 @@
+package exchange
+
+import (
+	"resty.dev/v3"
+	"time"
+)
+
+// NewRestClient returns the REST client of an exchange at base. The body of an answer can
+// be read more than once: into the result and again when checking it for an error.
+func NewRestClient(base string) *resty.Client {
+	return resty.New().SetTimeout(5 * time.Second).SetResponseBodyUnlimitedReads(true).SetBaseURL(base)
+}
@@ -0,0 +1,444 @@
+// Package config is the configuration of the bot: the exchange accounts, the LLM providers,
+// the strategies, notifications and storage. It is read from a YAML file, the environment
+// variables of the bot override the file, see Load.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Config is the content of the config file:
+//
+//	accounts:
+//	  okx-main:
+//	    exchange: okx
+//	    api_key: ${OKX_API_KEY}
+//	    secret_key: ${OKX_API_SECRET}
+//	    passphrase: ${OKX_API_PASSPHRASE}
+//	llm:
+//	  providers: [gemini, openai]
+//	  gemini:
+//	    api_key: ${GEMINI_API_KEY}
+//	  openai:
+//	    api_key: ${OPENAI_API_KEY}
+//	    model: gpt-4o
+//	strategies:
+//	  - name: majors
+//	    account: okx-main
+//	    pairs: [BTC, ETH]
+//	    timeframe: 4H
+//	    indicators: [RSI, MACD]
+//	    context:
+//	      - timeframe: 1W
+//	    risk:
+//	      max_exposure: 0.8
+//	notifications:
+//	  webhook: https://hooks.slack.com/services/...
+//	storage:
+//	  journal: journal.db
+//
+// ${VAR} in a string value is replaced with the environment variable VAR, so secrets can stay
+// out of the file. Any other $ is kept as it is.
+type Config struct {
+	Accounts      map[string]Account `yaml:"accounts"`
+	LLM           LLM                `yaml:"llm"`
+	Strategies    []Strategy         `yaml:"strategies"`
+	Notifications Notifications      `yaml:"notifications"`
+	Storage       Storage            `yaml:"storage"`
+}
+
+// Account is one account on an exchange, see exchange.Account.
+type Account struct {
+	Exchange   string `yaml:"exchange"`
+	APIKey     string `yaml:"api_key"`
+	SecretKey  string `yaml:"secret_key"`
+	Passphrase string `yaml:"passphrase"`
+	Demo       bool   `yaml:"demo"`
+	BaseURL    string `yaml:"base_url"`
+	WSURL      string `yaml:"ws_url"`
+}
+
+// Settings returns the account as exchange.New takes it.
+func (a Account) Settings() exchange.Account {
+	return exchange.Account{
+		Exchange:   a.Exchange,
+		APIKey:     a.APIKey,
+		SecretKey:  a.SecretKey,
+		Passphrase: a.Passphrase,
+		Demo:       a.Demo,
+		BaseURL:    a.BaseURL,
+		WSURL:      a.WSURL,
+	}
+}
+
+// LLM picks the models asked for a decision. Every provider is asked once per temperature;
+// more than one answer is a consensus vote needing Quorum votes, 0 means a strict majority.
+type LLM struct {
+	Providers    []string  `yaml:"providers"`    // gemini, openai, anthropic
+	Temperatures []float64 `yaml:"temperatures"` // none keeps the provider default
+	Quorum       int       `yaml:"quorum"`
+	Gemini       Provider  `yaml:"gemini"`
+	OpenAI       Provider  `yaml:"openai"`
+	Anthropic    Provider  `yaml:"anthropic"`
+}
+
+// Provider is the account and model of one LLM provider.
+type Provider struct {
+	APIKey         string `yaml:"api_key"`
+	BaseURL        string `yaml:"base_url"`
+	Model          string `yaml:"model"`
+	ThinkingBudget *int32 `yaml:"thinking_budget"` // gemini and anthropic, 0 turns thinking off
+	JSONMode       *bool  `yaml:"json_mode"`       // openai, on by default
+}
+
+// Thinking returns the thinking budget in tokens, 0 when it is not set.
+func (p *Provider) Thinking() int32 {
+	if p.ThinkingBudget == nil {
+		return 0
+	}
+	return *p.ThinkingBudget
+}
+
+// Provider returns the settings of the named provider, nil for an unknown one.
+func (l *LLM) Provider(name string) *Provider {
+	switch name {
+	case "gemini":
+		return &l.Gemini
+	case "openai":
+		return &l.OpenAI
+	case "anthropic":
+		return &l.Anthropic
+	}
+	return nil
+}
+
+// Strategy trades pairs on one account.
+type Strategy struct {
+	Name       string          `yaml:"name"`
+	Account    string          `yaml:"account"`
+	Pairs      []string        `yaml:"pairs"` // coins traded against USDT, e.g. BTC
+	Timeframe  model.Timeframe `yaml:"timeframe"`
+	Lookback   int             `yaml:"lookback"` // candles shown to the model
+	Warmup     int             `yaml:"warmup"`   // extra candles for the indicators
+	Indicators []string        `yaml:"indicators"`
+	Context    []Frame         `yaml:"context"`  // further timeframes shown to the model
+	Schedule   string          `yaml:"schedule"` // cron, one minute after every bar close by default
+	Timeout    time.Duration   `yaml:"timeout"`  // of a run, per pair
+	Risk       Risk            `yaml:"risk"`
+	Guard      *bool           `yaml:"guard"` // watch stops between runs, on by default
+	Paper      Paper           `yaml:"paper"`
+	// Advisory never places orders: they are logged and filled in a shadow portfolio with
+	// the cash, slippage and fee of Paper, see trade.WithAdvisory
+	Advisory bool `yaml:"advisory"`
+}
+
+// Frame is a timeframe shown to the model next to the strategy one.
+type Frame struct {
+	Timeframe  model.Timeframe `yaml:"timeframe"`
+	Lookback   int             `yaml:"lookback"`
+	Warmup     int             `yaml:"warmup"`
+	Indicators []string        `yaml:"indicators"`
+}
+
+// Risk limits the positions of a strategy.
+type Risk struct {
+	MaxExposure float64 `yaml:"max_exposure"` // share of equity held in coins, 0 is no cap
+}
+
+// Paper trades against a local ledger with the prices of the account. The settings also
+// apply to the shadow portfolio of an advisory strategy.
+type Paper struct {
+	Enabled  bool     `yaml:"enabled"`
+	Cash     float64  `yaml:"cash"`     // initial USDT
+	Slippage *float64 `yaml:"slippage"` // 0 is none
+	Fee      *float64 `yaml:"fee"`      // 0 is none
+}
+
+// SlippageRate returns the slippage, 0 when it is not set.
+func (p Paper) SlippageRate() float64 {
+	if p.Slippage == nil {
+		return 0
+	}
+	return *p.Slippage
+}
+
+// FeeRate returns the taker fee, 0 when it is not set.
+func (p Paper) FeeRate() float64 {
+	if p.Fee == nil {
+		return 0
+	}
+	return *p.Fee
+}
+
+// Notifications are posted to a chat webhook.
+type Notifications struct {
+	Webhook string   `yaml:"webhook"`
+	Events  []string `yaml:"events"` // trade, error; both by default
+}
+
+// Notification events.
+const (
+	EventTrade = "trade"
+	EventError = "error"
+)
+
+// Notify reports whether event is posted.
+func (n Notifications) Notify(event string) bool {
+	if n.Webhook == "" {
+		return false
+	}
+	if len(n.Events) == 0 {
+		return true
+	}
+	for _, e := range n.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Storage is where the bot keeps its state.
+type Storage struct {
+	Journal string `yaml:"journal"` // sqlite database of the runs
+	Paper   string `yaml:"paper"`   // paper ledger, one per strategy when there are several
+	Shadow  string `yaml:"shadow"`  // shadow portfolio of the advisory strategies, one per strategy
+}
+
+// CurrencyPairs returns the pairs of s.
+func (s Strategy) CurrencyPairs() []currency.Pair {
+	return currency.ParsePairs(strings.Join(s.Pairs, ","), currency.USDT)
+}
+
+// GuardEnabled reports whether stops are watched between runs.
+func (s Strategy) GuardEnabled() bool {
+	return s.Guard == nil || *s.Guard
+}
+
+// PaperState returns the path of the paper ledger of s.
+func (c *Config) PaperState(s Strategy) string {
+	return c.statePath(c.Storage.Paper, s)
+}
+
+// ShadowState returns the path of the shadow portfolio of the advisory strategy s.
+func (c *Config) ShadowState(s Strategy) string {
+	return c.statePath(c.Storage.Shadow, s)
+}
+
+// statePath suffixes path with the name of s when there are several strategies, so that
+// every strategy keeps its own ledger.
+func (c *Config) statePath(path string, s Strategy) string {
+	if len(c.Strategies) == 1 {
+		return path
+	}
+	return strings.TrimSuffix(path, ".json") + "-" + s.Name + ".json"
+}
+
+// Account returns the account of strategy s.
+func (c *Config) Account(s Strategy) exchange.Account {
+	return c.Accounts[s.Account].Settings()
+}
+
+// Load reads the config file at path, or starts from FromEnv without a path. The environment
+// variables override the file (see applyEnv), the defaults fill what is left, and the result
+// is validated. The error lists every problem found, one per line.
+func Load(path string) (*Config, error) {
+	return load(path, (*Config).Validate)
+}
+
+// LoadMarket is Load for the commands that do not ask the model, e.g. balance: the LLM
+// settings are not validated, see ValidateMarket.
+func LoadMarket(path string) (*Config, error) {
+	return load(path, (*Config).ValidateMarket)
+}
+
+func load(path string, validate func(*Config) []error) (*Config, error) {
+	var c *Config
+	var errs []error
+	if path == "" {
+		c = FromEnv()
+	} else {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("[config.Load] %w", err)
+		}
+		if c, errs = parse(data); c == nil {
+			return nil, errors.Join(errs...)
+		}
+	}
+	errs = append(errs, c.applyEnv()...)
+	c.applyDefaults()
+	errs = append(errs, validate(c)...)
+	return c, errors.Join(errs...)
+}
+
+// Parse decodes a config file, expanding ${VAR}, and validates it without the
+// environment overrides.
+func Parse(data []byte) (*Config, error) {
+	c, errs := parse(data)
+	if c == nil {
+		return nil, errors.Join(errs...)
+	}
+	c.applyDefaults()
+	errs = append(errs, c.Validate()...)
+	return c, errors.Join(errs...)
+}
+
+// parse decodes data and expands ${VAR} in the decoded strings. Unknown keys are reported so
+// that a typo does not silently fall back to a default, the rest of the file is still
+// decoded. c is nil when the file is no valid YAML.
+func parse(data []byte) (*Config, []error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	c := &Config{}
+	err := dec.Decode(c)
+	var typeErr *yaml.TypeError
+	if err == nil || errors.As(err, &typeErr) {
+		expandEnv(reflect.ValueOf(c).Elem())
+	}
+	switch {
+	case err == nil:
+		return c, nil
+	case errors.As(err, &typeErr):
+		errs := make([]error, len(typeErr.Errors))
+		for i, e := range typeErr.Errors {
+			errs[i] = errors.New(e)
+		}
+		return c, errs
+	default:
+		return nil, []error{fmt.Errorf("[config] %w", err)}
+	}
+}
+
+// envVar is the ${VAR} expanded by expandEnv.
+var envVar = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} with the environment variable VAR in every string v holds.
+func expandEnv(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(envVar.ReplaceAllStringFunc(v.String(), func(m string) string {
+			return os.Getenv(m[2 : len(m)-1])
+		}))
+	case reflect.Pointer:
+		if !v.IsNil() {
+			expandEnv(v.Elem())
+		}
+	case reflect.Struct:
+		for i := range v.NumField() {
+			if v.Field(i).CanSet() {
+				expandEnv(v.Field(i))
+			}
+		}
+	case reflect.Slice:
+		for i := range v.Len() {
+			expandEnv(v.Index(i))
+		}
+	case reflect.Map:
+		// map values cannot be set in place
+		for _, k := range v.MapKeys() {
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(v.MapIndex(k))
+			expandEnv(e)
+			v.SetMapIndex(k, e)
+		}
+	}
+}
+
+// Defaults of the settings left out.
+const (
+	DefaultGeminiModel    = "gemini-2.5-pro"
+	DefaultGeminiThinking = 32768
+	DefaultOpenAIModel    = "gpt-4o"
+	DefaultAnthropicModel = "claude-sonnet-4-5"
+	DefaultTimeout        = 30 * time.Second
+	DefaultPaperCash      = 10000
+	DefaultPaperSlippage  = 0.0005
+	DefaultPaperFee       = 0.001
+	DefaultJournalPath    = "journal.db"
+	DefaultPaperStatePath = "paper.json"
+	DefaultShadowPath     = "shadow.json"
+)
+
+func (c *Config) applyDefaults() {
+	if len(c.LLM.Providers) == 0 {
+		c.LLM.Providers = []string{"gemini"}
+	}
+	if c.LLM.Gemini.Model == "" {
+		c.LLM.Gemini.Model = DefaultGeminiModel
+	}
+	if c.LLM.Gemini.ThinkingBudget == nil {
+		// only when left out, an explicit 0 turns thinking off
+		budget := int32(DefaultGeminiThinking)
+		c.LLM.Gemini.ThinkingBudget = &budget
+	}
+	if c.LLM.OpenAI.Model == "" {
+		c.LLM.OpenAI.Model = DefaultOpenAIModel
+	}
+	if c.LLM.Anthropic.Model == "" {
+		c.LLM.Anthropic.Model = DefaultAnthropicModel
+	}
+	for i := range c.Strategies {
+		s := &c.Strategies[i]
+		if len(s.Pairs) == 0 {
+			s.Pairs = []string{currency.BTC.String()}
+		}
+		if s.Timeframe == "" {
+			s.Timeframe = model.Day1
+		} else if tf, err := model.ParseTimeframe(string(s.Timeframe)); err == nil {
+			s.Timeframe = tf
+		}
+		upper(s.Indicators)
+		for j := range s.Context {
+			if tf, err := model.ParseTimeframe(string(s.Context[j].Timeframe)); err == nil {
+				s.Context[j].Timeframe = tf
+			}
+			upper(s.Context[j].Indicators)
+		}
+		if s.Schedule == "" {
+			s.Schedule = s.Timeframe.Cron()
+		}
+		if s.Timeout == 0 {
+			s.Timeout = DefaultTimeout
+		}
+		if s.Paper.Cash == 0 {
+			s.Paper.Cash = DefaultPaperCash
+		}
+		// only when left out, an explicit 0 trades without slippage or fee
+		if s.Paper.Slippage == nil {
+			slippage := DefaultPaperSlippage
+			s.Paper.Slippage = &slippage
+		}
+		if s.Paper.Fee == nil {
+			fee := DefaultPaperFee
+			s.Paper.Fee = &fee
+		}
+	}
+	if c.Storage.Journal == "" {
+		c.Storage.Journal = DefaultJournalPath
+	}
+	if c.Storage.Paper == "" {
+		c.Storage.Paper = DefaultPaperStatePath
+	}
+	if c.Storage.Shadow == "" {
+		c.Storage.Shadow = DefaultShadowPath
+	}
+}
+
+// upper spells the indicator names like the indicator.Name* constants.
+func upper(names []string) {
+	for i, name := range names {
+		names[i] = strings.ToUpper(strings.TrimSpace(name))
+	}
+}
@@ -0,0 +1,313 @@
+package config
+
+import (
+	"errors"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	_ "github.com/twoonefour/sigmaflow/internal/client/exchange/binance"
+	_ "github.com/twoonefour/sigmaflow/internal/client/exchange/bybit"
+	_ "github.com/twoonefour/sigmaflow/internal/client/exchange/okx"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sample = `
+accounts:
+  okx-main:
+    exchange: okx
+    api_key: ${TEST_OKX_KEY}
+    secret_key: secret
+    passphrase: phrase
+  bybit-test:
+    exchange: bybit
+    demo: true
+    base_url: https://api-demo.bybit.com
+llm:
+  providers: [gemini, openai]
+  temperatures: [0.2, 0.8]
+  quorum: 3
+  gemini:
+    api_key: g
+  openai:
+    api_key: o
+    model: gpt-4.1
+strategies:
+  - name: majors
+    account: okx-main
+    pairs: [BTC, eth]
+    timeframe: 4H
+    indicators: [RSI, MACD]
+    context:
+      - timeframe: 1w
+        lookback: 20
+    timeout: 1m
+    risk:
+      max_exposure: 0.8
+  - name: alts
+    account: bybit-test
+    schedule: CRON_TZ=UTC 5 * * * *
+    paper:
+      enabled: true
+      cash: 500
+  - name: advice
+    account: okx-main
+    advisory: true
+notifications:
+  webhook: https://hooks.example.com/abc
+  events: [error]
+`
+
+func TestParse(t *testing.T) {
+	t.Setenv("TEST_OKX_KEY", "from-env")
+	c, err := Parse([]byte(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Strategies) != 3 {
+		t.Fatalf("expected 3 strategies, got %d", len(c.Strategies))
+	}
+	majors, alts := c.Strategies[0], c.Strategies[1]
+	want := exchange.Account{Exchange: "okx", APIKey: "from-env", SecretKey: "secret", Passphrase: "phrase"}
+	if got := c.Account(majors); got != want {
+		t.Errorf("account = %+v, want %+v", got, want)
+	}
+	if got := c.Account(alts); !got.Demo || got.BaseURL != "https://api-demo.bybit.com" || got.Exchange != "bybit" {
+		t.Errorf("unexpected account %+v", got)
+	}
+	pairs := majors.CurrencyPairs()
+	if len(pairs) != 2 || pairs[1].Quote != currency.Coin("ETH") || pairs[1].Base != currency.USDT {
+		t.Errorf("unexpected pairs %v", pairs)
+	}
+	if majors.Timeframe != model.Hour4 || majors.Context[0].Timeframe != model.Week1 {
+		t.Errorf("timeframes should be normalized, got %s and %s", majors.Timeframe, majors.Context[0].Timeframe)
+	}
+	if majors.Schedule != model.Hour4.Cron() || majors.Timeout != time.Minute {
+		t.Errorf("unexpected schedule %q, timeout %s", majors.Schedule, majors.Timeout)
+	}
+	if c.LLM.OpenAI.Model != "gpt-4.1" || c.LLM.Gemini.Model != DefaultGeminiModel {
+		t.Errorf("unexpected models %q and %q", c.LLM.OpenAI.Model, c.LLM.Gemini.Model)
+	}
+	if c.LLM.Gemini.Thinking() != DefaultGeminiThinking || c.LLM.Anthropic.Thinking() != 0 {
+		t.Errorf("unexpected thinking budgets %d and %d", c.LLM.Gemini.Thinking(), c.LLM.Anthropic.Thinking())
+	}
+
+	// defaults
+	if pairs = alts.CurrencyPairs(); len(pairs) != 1 || pairs[0].Quote != currency.BTC {
+		t.Errorf("BTC should be traded by default, got %v", pairs)
+	}
+	if alts.Timeframe != model.Day1 || alts.Timeout != DefaultTimeout || !alts.GuardEnabled() {
+		t.Errorf("unexpected defaults %+v", alts)
+	}
+	if alts.Paper.Cash != 500 || alts.Paper.FeeRate() != DefaultPaperFee || alts.Paper.SlippageRate() != DefaultPaperSlippage {
+		t.Errorf("unexpected paper settings %+v", alts.Paper)
+	}
+	if got := c.PaperState(alts); got != "paper-alts.json" {
+		t.Errorf("paper state = %q", got)
+	}
+	if advice := c.Strategies[2]; !advice.Advisory || c.ShadowState(advice) != "shadow-advice.json" || advice.Paper.Cash != DefaultPaperCash {
+		t.Errorf("unexpected advisory strategy %+v", advice)
+	}
+	if c.Notifications.Notify(EventTrade) || !c.Notifications.Notify(EventError) {
+		t.Errorf("only errors should be notified")
+	}
+
+	// an explicit 0 turns thinking, slippage and fee off
+	off, err := Parse([]byte("accounts:\n  a:\n    exchange: okx\nllm:\n  gemini:\n    api_key: g\n    thinking_budget: 0\nstrategies:\n  - name: s\n    account: a\n    advisory: true\n    paper:\n      slippage: 0\n      fee: 0\n"))
+	if err != nil || off.LLM.Gemini.ThinkingBudget == nil || off.LLM.Gemini.Thinking() != 0 {
+		t.Errorf("thinking should stay off, got %v (%v)", off.LLM.Gemini.ThinkingBudget, err)
+	}
+	if paper := off.Strategies[0].Paper; paper.SlippageRate() != 0 || paper.FeeRate() != 0 {
+		t.Errorf("slippage and fee should stay 0, got %v and %v", paper.SlippageRate(), paper.FeeRate())
+	}
+
+	// advice places no order, the account needs no credentials
+	advisory := "accounts:\n  a:\n    exchange: okx\nllm:\n  gemini:\n    api_key: g\nstrategies:\n  - name: s\n    account: a\n    advisory: true\n"
+	if _, err = Parse([]byte(advisory)); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestParseExpandsEnv(t *testing.T) {
+	t.Setenv("TEST_OKX_KEY", "from-env")
+	t.Setenv("TEST_OKX_PASSPHRASE", "a$b")
+	t.Setenv("HOME", "/home/bot")
+	data := `
+accounts:
+  okx-main:
+    exchange: okx
+    api_key: ${TEST_OKX_KEY} # ${TEST_OKX_KEY}: with a colon
+    secret_key: pa$$w0rd$1$HOME
+    passphrase: ${TEST_OKX_PASSPHRASE}
+llm:
+  gemini:
+    api_key: g
+strategies:
+  - name: majors
+    account: okx-main
+    advisory: true
+`
+	c, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := exchange.Account{Exchange: "okx", APIKey: "from-env", SecretKey: "pa$$w0rd$1$HOME", Passphrase: "a$b"}
+	if got := c.Account(c.Strategies[0]); got != want {
+		t.Errorf("account = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"unknown key", "accounts:\n  a:\n    exchange: okx\n    apikey: x\nstrategies:\n  - name: s\n    account: a\n    paper: {enabled: true}\n", "apikey"},
+		{"invalid yaml", "accounts: [", "yaml"},
+		{"unknown account", "accounts:\n  a:\n    exchange: okx\nstrategies:\n  - name: s\n    account: b\n", `unknown account "b"`},
+		{"no exchange", "accounts:\n  a:\n    demo: true\nstrategies:\n  - name: s\n    account: a\n", "accounts.a.exchange: missing"},
+		{"unknown exchange", "accounts:\n  a:\n    exchange: kraken\n", `unknown exchange "kraken"`},
+		{"live without key", "accounts:\n  a:\n    exchange: okx\nstrategies:\n  - name: s\n    account: a\n", "accounts.a.api_key: missing"},
+		{"duplicate strategy", "accounts:\n  a:\n    exchange: okx\nstrategies:\n  - name: s\n    account: a\n  - name: s\n    account: a\n", "defined twice"},
+		{"no strategies", "accounts:\n  a:\n    exchange: okx\n", "strategies: no strategy"},
+		{"bad schedule", "strategies:\n  - name: s\n    schedule: every hour\n", "strategies.s.schedule"},
+		{"advisory paper", "strategies:\n  - name: s\n    advisory: true\n    paper: {enabled: true}\n", "strategies.s.advisory"},
+		{"anthropic thinking temperature", "llm:\n  providers: [anthropic]\n  temperatures: [0.5]\n  anthropic:\n    api_key: a\n    thinking_budget: 2048\n", "llm.temperatures: not supported by anthropic"},
+		{"bad webhook", "notifications:\n  webhook: hooks.example.com\n", "notifications.webhook"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := Parse([]byte(c.yaml))
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Errorf("expected an error about %q, got %v", c.want, err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	yaml := `
+accounts:
+  a:
+    exchange: okx
+llm:
+  providers: [gemini, mistral]
+  temperatures: [3]
+strategies:
+  - name: s
+    account: a
+    pairs: [BTC-USDT]
+    timeframe: 7m
+    indicators: [RSI, FOO]
+    risk:
+      max_exposure: 2
+    paper:
+      enabled: true
+      fee: 1
+`
+	_, err := Parse([]byte(yaml))
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) {
+		t.Fatalf("expected joined errors, got %v", err)
+	}
+	// every problem is reported at once
+	want := []string{
+		"llm.providers: unknown provider \"mistral\"",
+		"llm.gemini.api_key: missing",
+		"llm.temperatures: 3 outside",
+		"strategies.s.pairs: \"BTC-USDT\" is not a coin",
+		"strategies.s.timeframe: unsupported timeframe \"7m\"",
+		"strategies.s.indicators: unknown indicator \"FOO\"",
+		"strategies.s.risk.max_exposure: 2 outside",
+		"strategies.s.paper.fee: 1 outside",
+	}
+	errs := joined.Unwrap()
+	if len(errs) != len(want) {
+		t.Errorf("expected %d errors, got %d:\n%v", len(want), len(errs), err)
+	}
+	for _, w := range want {
+		if !strings.Contains(err.Error(), w) {
+			t.Errorf("missing error %q in\n%v", w, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	t.Setenv("TEST_OKX_KEY", "from-env")
+	if err := os.WriteFile(path, []byte(sample), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OPENAI_MODEL", "o3")
+	t.Setenv("MAX_EXPOSURE", "0.5")
+	t.Setenv("JOURNAL_PATH", "/tmp/runs.db")
+	t.Setenv("GEMINI_THINKING_BUDGET", "0")
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.LLM.OpenAI.Model != "o3" || c.Storage.Journal != "/tmp/runs.db" {
+		t.Errorf("env should override the file, got %q and %q", c.LLM.OpenAI.Model, c.Storage.Journal)
+	}
+	if c.LLM.Gemini.ThinkingBudget == nil || c.LLM.Gemini.Thinking() != 0 {
+		t.Errorf("GEMINI_THINKING_BUDGET=0 should turn thinking off, got %v", c.LLM.Gemini.ThinkingBudget)
+	}
+	for _, s := range c.Strategies {
+		if s.Risk.MaxExposure != 0.5 {
+			t.Errorf("%s: max exposure = %g", s.Name, s.Risk.MaxExposure)
+		}
+	}
+
+	t.Setenv("LOOKBACK", "many")
+	t.Setenv("GEMINI_THINKING_BUDGET", "lots")
+	if _, err = Load(path); err == nil || !strings.Contains(err.Error(), "LOOKBACK") || !strings.Contains(err.Error(), "GEMINI_THINKING_BUDGET") {
+		t.Errorf("expected errors about LOOKBACK and GEMINI_THINKING_BUDGET, got %v", err)
+	}
+	t.Setenv("GEMINI_THINKING_BUDGET", "")
+
+	// the commands that do not ask the model load without its key
+	t.Setenv("LOOKBACK", "")
+	market := filepath.Join(t.TempDir(), "market.yaml")
+	if err = os.WriteFile(market, []byte("accounts:\n  a:\n    exchange: okx\nstrategies:\n  - name: s\n    account: a\n    paper:\n      enabled: true\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Load(market); err == nil || !strings.Contains(err.Error(), "api_key") {
+		t.Errorf("expected an error about the LLM api_key, got %v", err)
+	}
+	if _, err = LoadMarket(market); err != nil {
+		t.Errorf("LoadMarket should not need the LLM settings, got %v", err)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("EXCHANGE", "")
+	t.Setenv("OKX_SIMULATE", "1")
+	t.Setenv("OKX_SIMULATE_API_KEY", "demo-key")
+	c := FromEnv()
+	if a := c.Account(c.Strategies[0]); a.Exchange != "okx" || !a.Demo || a.APIKey != "demo-key" {
+		t.Errorf("unexpected account %+v", a)
+	}
+
+	t.Setenv("EXCHANGE", "bybit")
+	t.Setenv("BYBIT_API_KEY", "bybit-key")
+	t.Setenv("BYBIT_TESTNET", "1")
+	c = FromEnv()
+	if a := c.Account(c.Strategies[0]); a.Exchange != "bybit" || !a.Demo || a.APIKey != "bybit-key" {
+		t.Errorf("unexpected account %+v", a)
+	}
+
+	t.Setenv("PAIRS", "BTC,SOL")
+	t.Setenv("PAPER_TRADING", "1")
+	t.Setenv("GEMINI_API_KEY", "g")
+	c, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := c.Strategies[0]; len(s.CurrencyPairs()) != 2 || !s.Paper.Enabled {
+		t.Errorf("unexpected strategy %+v", s)
+	}
+}
@@ -0,0 +1,222 @@
+package config
+
+import (
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FromEnv is the config of a single strategy for setups without a config file: EXCHANGE
+// (okx, binance or bybit, okx by default) with its credentials and PAIRS. OKX_SIMULATE=1 uses
+// the OKX_SIMULATE_* credentials on OKX demo trading; BINANCE_TESTNET=1 and BYBIT_TESTNET=1
+// use the testnets. Everything else comes from the overrides of Load.
+func FromEnv() *Config {
+	account := Account{Exchange: os.Getenv("EXCHANGE")}
+	switch account.Exchange {
+	case "", "okx":
+		account.Exchange = "okx"
+		// live credentials used to be read as OKXAPI_KEY etc., those names still work
+		prefixes := []string{"OKX_", "OKX"}
+		if os.Getenv("OKX_SIMULATE") == "1" {
+			prefixes = []string{"OKX_SIMULATE_"}
+			account.Demo = true
+		}
+		account.APIKey = getenv(prefixes, "API_KEY")
+		account.SecretKey = getenv(prefixes, "API_SECRET")
+		account.Passphrase = getenv(prefixes, "API_PASSPHRASE")
+	default:
+		prefix := strings.ToUpper(account.Exchange) + "_"
+		account.APIKey = os.Getenv(prefix + "API_KEY")
+		account.SecretKey = os.Getenv(prefix + "API_SECRET")
+		account.Demo = os.Getenv(prefix+"TESTNET") == "1"
+	}
+	return &Config{
+		Accounts:   map[string]Account{account.Exchange: account},
+		Strategies: []Strategy{{Name: "default", Account: account.Exchange}},
+	}
+}
+
+// getenv returns the first non-empty variable of the prefixes followed by name.
+func getenv(prefixes []string, name string) string {
+	for _, p := range prefixes {
+		if v := os.Getenv(p + name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// applyEnv overrides c with the environment variables that are set:
+//
+//	LLM_PROVIDER, LLM_TEMPERATURES, LLM_QUORUM        llm (comma separated lists)
+//	GEMINI_API_KEY, GEMINI_MODEL,
+//	GEMINI_THINKING_BUDGET                             llm.gemini
+//	OPENAI_API_KEY, OPENAI_BASE_URL, OPENAI_MODEL,
+//	OPENAI_JSON_MODE                                   llm.openai
+//	ANTHROPIC_API_KEY, ANTHROPIC_BASE_URL,
+//	ANTHROPIC_MODEL, ANTHROPIC_THINKING_BUDGET         llm.anthropic
+//	PAIRS, TIMEFRAME, LOOKBACK, WARMUP, INDICATORS,
+//	SCHEDULE, RUN_TIMEOUT, MAX_EXPOSURE, GUARD         every strategy
+//	CONTEXT_TIMEFRAMES, INDICATORS_<TIMEFRAME>         context of every strategy, e.g. 1W,4H
+//	PAPER_TRADING, PAPER_CASH                          paper trading of every strategy
+//	ADVISORY                                           every strategy only advises
+//	NOTIFY_WEBHOOK, NOTIFY_EVENTS                      notifications
+//	JOURNAL_PATH, PAPER_STATE, SHADOW_STATE            storage
+//
+// A value that does not parse is returned as an error and leaves the setting alone.
+func (c *Config) applyEnv() []error {
+	e := envReader{}
+	l := &c.LLM
+	e.list("LLM_PROVIDER", &l.Providers)
+	if v := os.Getenv("LLM_TEMPERATURES"); v != "" {
+		l.Temperatures = nil
+		for _, t := range split(v) {
+			f, err := strconv.ParseFloat(t, 64)
+			if err != nil {
+				e.errs = append(e.errs, fmt.Errorf("LLM_TEMPERATURES: %w", err))
+				continue
+			}
+			l.Temperatures = append(l.Temperatures, f)
+		}
+	}
+	e.int("LLM_QUORUM", &l.Quorum)
+	e.str("GEMINI_API_KEY", &l.Gemini.APIKey)
+	e.str("GEMINI_MODEL", &l.Gemini.Model)
+	e.thinking("GEMINI_THINKING_BUDGET", &l.Gemini.ThinkingBudget)
+	e.str("OPENAI_API_KEY", &l.OpenAI.APIKey)
+	e.str("OPENAI_BASE_URL", &l.OpenAI.BaseURL)
+	e.str("OPENAI_MODEL", &l.OpenAI.Model)
+	if v := os.Getenv("OPENAI_JSON_MODE"); v != "" {
+		jsonMode := v != "0"
+		l.OpenAI.JSONMode = &jsonMode
+	}
+	e.str("ANTHROPIC_API_KEY", &l.Anthropic.APIKey)
+	e.str("ANTHROPIC_BASE_URL", &l.Anthropic.BaseURL)
+	e.str("ANTHROPIC_MODEL", &l.Anthropic.Model)
+	e.thinking("ANTHROPIC_THINKING_BUDGET", &l.Anthropic.ThinkingBudget)
+
+	for i := range c.Strategies {
+		se := &envReader{}
+		se.strategy(&c.Strategies[i])
+		// the variables are the same for every strategy, their errors are reported once
+		if i == 0 {
+			e.errs = append(e.errs, se.errs...)
+		}
+	}
+	e.str("NOTIFY_WEBHOOK", &c.Notifications.Webhook)
+	e.list("NOTIFY_EVENTS", &c.Notifications.Events)
+	e.str("JOURNAL_PATH", &c.Storage.Journal)
+	e.str("PAPER_STATE", &c.Storage.Paper)
+	e.str("SHADOW_STATE", &c.Storage.Shadow)
+	return e.errs
+}
+
+// envReader reads environment variables into settings, collecting the parse errors.
+type envReader struct {
+	errs []error
+}
+
+// strategy applies the strategy overrides to s.
+func (e *envReader) strategy(s *Strategy) {
+	e.list("PAIRS", &s.Pairs)
+	if v := os.Getenv("TIMEFRAME"); v != "" {
+		s.Timeframe = model.Timeframe(v)
+	}
+	e.int("LOOKBACK", &s.Lookback)
+	e.int("WARMUP", &s.Warmup)
+	e.list("INDICATORS", &s.Indicators)
+	e.str("SCHEDULE", &s.Schedule)
+	e.duration("RUN_TIMEOUT", &s.Timeout)
+	e.float("MAX_EXPOSURE", &s.Risk.MaxExposure)
+	if v := os.Getenv("GUARD"); v != "" {
+		guard := v != "0"
+		s.Guard = &guard
+	}
+	if v := os.Getenv("CONTEXT_TIMEFRAMES"); v != "" {
+		s.Context = nil
+		for _, tf := range split(v) {
+			frame := Frame{Timeframe: model.Timeframe(tf)}
+			e.list("INDICATORS_"+strings.ToUpper(tf), &frame.Indicators)
+			s.Context = append(s.Context, frame)
+		}
+	}
+	if v := os.Getenv("PAPER_TRADING"); v != "" {
+		s.Paper.Enabled = v == "1"
+	}
+	if v := os.Getenv("ADVISORY"); v != "" {
+		s.Advisory = v == "1"
+	}
+	e.float("PAPER_CASH", &s.Paper.Cash)
+}
+
+func (e *envReader) str(name string, dst *string) {
+	if v := os.Getenv(name); v != "" {
+		*dst = v
+	}
+}
+
+func (e *envReader) list(name string, dst *[]string) {
+	if v := os.Getenv(name); v != "" {
+		*dst = split(v)
+	}
+}
+
+func (e *envReader) int(name string, dst *int) {
+	if v := os.Getenv(name); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		*dst = n
+	}
+}
+
+// thinking reads a thinking budget in tokens, 0 turns thinking off.
+func (e *envReader) thinking(name string, dst **int32) {
+	if v := os.Getenv(name); v != "" {
+		budget, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		thinking := int32(budget)
+		*dst = &thinking
+	}
+}
+
+func (e *envReader) float(name string, dst *float64) {
+	if v := os.Getenv(name); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		*dst = f
+	}
+}
+
+func (e *envReader) duration(name string, dst *time.Duration) {
+	if v := os.Getenv(name); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		*dst = d
+	}
+}
+
+// split splits a comma separated list, dropping empty items.
+func split(s string) []string {
+	res := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
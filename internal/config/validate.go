@@ -0,0 +1,209 @@
+package config
+
+import (
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/indicator"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// providers are the LLM providers the bot has a client for.
+var providers = []string{"gemini", "openai", "anthropic"}
+
+// Validate returns every problem of c, each naming the setting it is about. Exchanges are
+// checked against exchange.Names, so the client packages have to be imported.
+func (c *Config) Validate() []error {
+	return c.validate(true)
+}
+
+// ValidateMarket is Validate without the LLM settings, for the commands that only use the
+// accounts and the journal.
+func (c *Config) ValidateMarket() []error {
+	return c.validate(false)
+}
+
+func (c *Config) validate(llm bool) []error {
+	v := &validator{}
+	c.validateAccounts(v)
+	if llm {
+		c.validateLLM(v)
+	}
+	if len(c.Strategies) == 0 {
+		v.add("strategies", "no strategy")
+	}
+	names := make(map[string]bool)
+	for i, s := range c.Strategies {
+		key := fmt.Sprintf("strategies[%d]", i)
+		if s.Name == "" {
+			v.add(key+".name", "missing")
+		} else {
+			key = "strategies." + s.Name
+			if names[s.Name] {
+				v.add(key, "defined twice")
+			}
+			names[s.Name] = true
+		}
+		c.validateStrategy(v, key, s)
+	}
+	n := c.Notifications
+	if n.Webhook != "" {
+		if u, err := url.Parse(n.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.add("notifications.webhook", "not an http(s) url")
+		}
+	}
+	for _, e := range n.Events {
+		if e != EventTrade && e != EventError {
+			v.add("notifications.events", "unknown event %q, use %s or %s", e, EventTrade, EventError)
+		}
+	}
+	if c.Storage.Journal == "" {
+		v.add("storage.journal", "missing")
+	}
+	return v.errs
+}
+
+func (c *Config) validateAccounts(v *validator) {
+	names := make([]string, 0, len(c.Accounts))
+	for name := range c.Accounts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		a := c.Accounts[name]
+		key := "accounts." + name
+		switch {
+		case a.Exchange == "":
+			v.add(key+".exchange", "missing")
+		case !slices.Contains(exchange.Names(), a.Exchange):
+			v.add(key+".exchange", "unknown exchange %q, use one of %s", a.Exchange, strings.Join(exchange.Names(), ", "))
+		}
+		// paper trading and advice only read public market data
+		if !c.tradesLive(name) {
+			continue
+		}
+		if a.APIKey == "" {
+			v.add(key+".api_key", "missing")
+		}
+		if a.SecretKey == "" {
+			v.add(key+".secret_key", "missing")
+		}
+		if a.Exchange == "okx" && a.Passphrase == "" {
+			v.add(key+".passphrase", "missing, OKX needs the passphrase of the api key")
+		}
+	}
+}
+
+// tradesLive reports whether a strategy places real orders on account.
+func (c *Config) tradesLive(account string) bool {
+	for _, s := range c.Strategies {
+		if s.Account == account && !s.Paper.Enabled && !s.Advisory {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) validateLLM(v *validator) {
+	l := &c.LLM
+	for _, name := range l.Providers {
+		p := l.Provider(name)
+		if p == nil {
+			v.add("llm.providers", "unknown provider %q, use one of %s", name, strings.Join(providers, ", "))
+			continue
+		}
+		if p.APIKey == "" {
+			v.add("llm."+name+".api_key", "missing")
+		}
+		if p.Thinking() < 0 {
+			v.add("llm."+name+".thinking_budget", "negative")
+		}
+	}
+	for _, t := range l.Temperatures {
+		if t < 0 || t > 2 {
+			v.add("llm.temperatures", "%g outside of [0, 2]", t)
+		}
+	}
+	if len(l.Temperatures) > 0 && slices.Contains(l.Providers, "anthropic") && l.Anthropic.Thinking() > 0 {
+		// the API rejects a temperature with extended thinking
+		v.add("llm.temperatures", "not supported by anthropic with thinking, set llm.anthropic.thinking_budget to 0")
+	}
+	advisors := len(l.Providers) * max(1, len(l.Temperatures))
+	if l.Quorum < 0 || l.Quorum > advisors {
+		v.add("llm.quorum", "%d outside of [0, %d], the number of advisors", l.Quorum, advisors)
+	}
+}
+
+func (c *Config) validateStrategy(v *validator, key string, s Strategy) {
+	if _, ok := c.Accounts[s.Account]; !ok {
+		v.add(key+".account", "unknown account %q", s.Account)
+	}
+	for _, p := range s.Pairs {
+		if p = strings.TrimSpace(p); p == "" || strings.ContainsAny(p, "-_/ ") {
+			v.add(key+".pairs", "%q is not a coin, list coins like BTC", p)
+		}
+	}
+	if _, err := model.ParseTimeframe(string(s.Timeframe)); err != nil {
+		v.add(key+".timeframe", "unsupported timeframe %q", s.Timeframe)
+	}
+	if s.Lookback < 0 {
+		v.add(key+".lookback", "negative")
+	}
+	if s.Warmup < 0 {
+		v.add(key+".warmup", "negative")
+	}
+	validateIndicators(v, key+".indicators", s.Indicators)
+	for i, f := range s.Context {
+		frameKey := fmt.Sprintf("%s.context[%d]", key, i)
+		if _, err := model.ParseTimeframe(string(f.Timeframe)); err != nil {
+			v.add(frameKey+".timeframe", "unsupported timeframe %q", f.Timeframe)
+		}
+		if f.Lookback < 0 || f.Warmup < 0 {
+			v.add(frameKey, "negative lookback or warmup")
+		}
+		validateIndicators(v, frameKey+".indicators", f.Indicators)
+	}
+	if _, err := cron.ParseStandard(s.Schedule); err != nil {
+		v.add(key+".schedule", "%s", err.Error())
+	}
+	if s.Timeout <= 0 {
+		v.add(key+".timeout", "not positive")
+	}
+	if r := s.Risk.MaxExposure; r < 0 || r > 1 {
+		v.add(key+".risk.max_exposure", "%g outside of [0, 1]", r)
+	}
+	if s.Paper.Enabled && s.Advisory {
+		v.add(key+".advisory", "paper trading already places no real order, use one of both")
+	}
+	if s.Paper.Enabled || s.Advisory {
+		if s.Paper.Cash <= 0 {
+			v.add(key+".paper.cash", "not positive")
+		}
+		if r := s.Paper.SlippageRate(); r < 0 || r >= 1 {
+			v.add(key+".paper.slippage", "%g outside of [0, 1)", r)
+		}
+		if r := s.Paper.FeeRate(); r < 0 || r >= 1 {
+			v.add(key+".paper.fee", "%g outside of [0, 1)", r)
+		}
+	}
+}
+
+func validateIndicators(v *validator, key string, names []string) {
+	for _, name := range names {
+		if !slices.Contains(indicator.Names, strings.ToUpper(name)) {
+			v.add(key, "unknown indicator %q, use %s", name, strings.Join(indicator.Names, ", "))
+		}
+	}
+}
+
+// validator collects the problems of a config.
+type validator struct {
+	errs []error
+}
+
+func (v *validator) add(key, format string, args ...any) {
+	v.errs = append(v.errs, fmt.Errorf("%s: "+format, append([]any{key}, args...)...))
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrBelowMinimum is returned for an order smaller than its instrument allows.
+var ErrBelowMinimum = errors.New("order below the instrument minimum")
+
+// Instrument is the trading rules of a spot instrument.
+type Instrument struct {
+	InstId      string
+	Base        string  // the coin, e.g. BTC
+	Quote       string  // the currency it is priced in, e.g. USDT
+	LotSize     float64 // size step, in the coin
+	MinSize     float64 // smallest order size, in the coin
+	TickSize    float64 // price step
+	MinNotional float64 // smallest order value in the quote currency, 0 when the exchange has none
+	QuoteStep   float64 // step of quote amounts, e.g. the spend of a market buy, 0 when the exchange has none
+}
+
+// FloorSize rounds a coin amount down to the lot size, so an order never exceeds what it
+// was derived from.
+func (i Instrument) FloorSize(sz float64) float64 {
+	return floorStep(sz, i.LotSize)
+}
+
+// RoundPrice rounds price to the nearest tick.
+func (i Instrument) RoundPrice(price float64) float64 {
+	if i.TickSize <= 0 {
+		return price
+	}
+	return math.Round(price/i.TickSize) * i.TickSize
+}
+
+// FloorQuote rounds a quote amount down to QuoteStep.
+func (i Instrument) FloorQuote(amount float64) float64 {
+	return floorStep(amount, i.QuoteStep)
+}
+
+// FormatSize, FormatPrice and FormatQuote write a value with the decimals of its step.
+func (i Instrument) FormatSize(sz float64) string {
+	return formatStep(sz, i.LotSize)
+}
+
+func (i Instrument) FormatPrice(price float64) string {
+	return formatStep(price, i.TickSize)
+}
+
+func (i Instrument) FormatQuote(amount float64) string {
+	return formatStep(amount, i.QuoteStep)
+}
+
+// CheckSize returns ErrBelowMinimum when sz coins at price are below MinSize or MinNotional.
+// price may be 0 when unknown, the notional is not checked then.
+func (i Instrument) CheckSize(sz, price float64) error {
+	if sz <= 0 || sz < i.MinSize {
+		return fmt.Errorf("%s size %s, minimum %s: %w", i.InstId, i.FormatSize(sz), i.FormatSize(i.MinSize), ErrBelowMinimum)
+	}
+	if price > 0 && i.MinNotional > 0 && sz*price < i.MinNotional {
+		return fmt.Errorf("%s value %.2f %s, minimum %.2f: %w", i.InstId, sz*price, i.Quote, i.MinNotional, ErrBelowMinimum)
+	}
+	return nil
+}
+
+// floorStep rounds v down to a multiple of step, tolerating float noise just below it.
+func floorStep(v, step float64) float64 {
+	if step <= 0 {
+		return v
+	}
+	return math.Floor(v/step+1e-9) * step
+}
+
+func formatStep(v, step float64) string {
+	if step <= 0 {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'f', decimals(step), 64)
+}
+
+// decimals is the number of digits after the point of step, e.g. 3 for 0.001.
+func decimals(step float64) int {
+	s := strconv.FormatFloat(step, 'f', -1, 64)
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		return len(s) - i - 1
+	}
+	return 0
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+var btcUsdt = Instrument{
+	InstId:    "BTC-USDT",
+	Base:      "BTC",
+	Quote:     "USDT",
+	LotSize:   0.00000001,
+	MinSize:   0.00001,
+	TickSize:  0.1,
+	QuoteStep: 0.01,
+}
+
+func TestInstrumentRounding(t *testing.T) {
+	cases := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"size floored", btcUsdt.FormatSize(btcUsdt.FloorSize(0.123456789)), "0.12345678"},
+		{"size on step", btcUsdt.FormatSize(btcUsdt.FloorSize(0.3)), "0.30000000"},
+		{"price", btcUsdt.FormatPrice(btcUsdt.RoundPrice(65432.16)), "65432.2"},
+		{"quote", btcUsdt.FormatQuote(btcUsdt.FloorQuote(123.4567)), "123.45"},
+		{"whole lots", Instrument{LotSize: 1}.FormatSize(Instrument{LotSize: 1}.FloorSize(12.9)), "12"},
+		{"no step", Instrument{}.FormatSize(Instrument{}.FloorSize(1.25)), "1.25"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.got != c.want {
+				t.Errorf("got %s, want %s", c.got, c.want)
+			}
+		})
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	inst := btcUsdt
+	inst.MinNotional = 5
+	if err := inst.CheckSize(0.001, 60000); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := inst.CheckSize(0.000001, 60000); !errors.Is(err, ErrBelowMinimum) {
+		t.Errorf("expected a minimum size error, got %v", err)
+	}
+	if err := inst.CheckSize(0.00005, 60000); !errors.Is(err, ErrBelowMinimum) {
+		t.Errorf("expected a minimum notional error, got %v", err)
+	}
+	if err := inst.CheckSize(0.00005, 0); err != nil {
+		t.Errorf("notional cannot be checked without a price, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timeframe is the bar size of a candle series, written the way OKX names its bars.
+type Timeframe string
+
+const (
+	Minute1  Timeframe = "1m"
+	Minute15 Timeframe = "15m"
+	Hour1    Timeframe = "1H"
+	Hour4    Timeframe = "4H"
+	Day1     Timeframe = "1D"
+	Week1    Timeframe = "1W"
+)
+
+type timeframeSpec struct {
+	duration time.Duration
+	label    string // adjective used in the prompt, e.g. "daily"
+	unit     string // singular unit for whole-unit bars, e.g. "day"
+	layout   string
+	cron     string // one minute after every close, in UTC
+}
+
+var timeframes = map[Timeframe]timeframeSpec{
+	Minute1:  {time.Minute, "1-minute", "minute", "2006-01-02 15:04", "CRON_TZ=UTC * * * * *"},
+	Minute15: {15 * time.Minute, "15-minute", "", "2006-01-02 15:04", "CRON_TZ=UTC 1/15 * * * *"},
+	Hour1:    {time.Hour, "hourly", "hour", "2006-01-02 15:04", "CRON_TZ=UTC 1 * * * *"},
+	Hour4:    {4 * time.Hour, "4-hour", "", "2006-01-02 15:04", "CRON_TZ=UTC 1 */4 * * *"},
+	Day1:     {24 * time.Hour, "daily", "day", "2006-01-02", "CRON_TZ=UTC 1 0 * * *"},
+	Week1:    {7 * 24 * time.Hour, "weekly", "week", "2006-01-02", "CRON_TZ=UTC 1 0 * * 1"},
+}
+
+// ParseTimeframe accepts the Timeframe constants case-insensitively for the day and week bars.
+func ParseTimeframe(s string) (Timeframe, error) {
+	tf := Timeframe(strings.TrimSpace(s))
+	switch strings.ToUpper(string(tf)) {
+	case "1D":
+		tf = Day1
+	case "1W":
+		tf = Week1
+	}
+	if _, ok := timeframes[tf]; !ok {
+		return "", fmt.Errorf("[model.ParseTimeframe] unsupported timeframe %q", s)
+	}
+	return tf, nil
+}
+
+func (t Timeframe) spec() timeframeSpec {
+	if s, ok := timeframes[t]; ok {
+		return s
+	}
+	return timeframes[Day1]
+}
+
+func (t Timeframe) Duration() time.Duration {
+	return t.spec().duration
+}
+
+// Label is the adjective form, e.g. "daily" or "4-hour".
+func (t Timeframe) Label() string {
+	return t.spec().label
+}
+
+// Span describes n bars, e.g. "30-day" or "30-candle 4-hour".
+func (t Timeframe) Span(n int) string {
+	if unit := t.spec().unit; unit != "" {
+		return fmt.Sprintf("%d-%s", n, unit)
+	}
+	return fmt.Sprintf("%d-candle %s", n, t.Label())
+}
+
+// Range describes the last n bars, e.g. "Last 30 Days" or "Last 30 4-hour Candles".
+func (t Timeframe) Range(n int) string {
+	if unit := t.spec().unit; unit != "" {
+		return fmt.Sprintf("Last %d %ss", n, strings.ToUpper(unit[:1])+unit[1:])
+	}
+	return fmt.Sprintf("Last %d %s Candles", n, t.Label())
+}
+
+// Cron is the schedule running one minute after every bar closes.
+func (t Timeframe) Cron() string {
+	return t.spec().cron
+}
+
+// FormatTs formats a millisecond candle timestamp in UTC, with the time of day for intraday bars.
+func (t Timeframe) FormatTs(ts string) string {
+	v, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ts
+	}
+	return time.UnixMilli(v).UTC().Format(t.spec().layout)
+}
+
+// CandleSeries is the candles of one timeframe, newest first.
+type CandleSeries struct {
+	Timeframe Timeframe
+	Candles   []CandleWithIndicator
+}
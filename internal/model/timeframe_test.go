@@ -0,0 +1,37 @@
+package model
+
+import (
+	"github.com/robfig/cron/v3"
+	"testing"
+)
+
+func TestTimeframe(t *testing.T) {
+	cases := []struct {
+		in    string
+		tf    Timeframe
+		span  string
+		rng   string
+		stamp string
+	}{
+		{"1d", Day1, "30-day", "Last 30 Days", "2024-03-09"},
+		{"1W", Week1, "30-week", "Last 30 Weeks", "2024-03-09"},
+		{"1H", Hour1, "30-hour", "Last 30 Hours", "2024-03-09 16:00"},
+		{"4H", Hour4, "30-candle 4-hour", "Last 30 4-hour Candles", "2024-03-09 16:00"},
+		{"15m", Minute15, "30-candle 15-minute", "Last 30 15-minute Candles", "2024-03-09 16:00"},
+	}
+	for _, c := range cases {
+		tf, err := ParseTimeframe(c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tf != c.tf || tf.Span(30) != c.span || tf.Range(30) != c.rng || tf.FormatTs("1710000000000") != c.stamp {
+			t.Errorf("%s: got %s %q %q %q", c.in, tf, tf.Span(30), tf.Range(30), tf.FormatTs("1710000000000"))
+		}
+		if _, err = cron.ParseStandard(tf.Cron()); err != nil {
+			t.Errorf("%s: invalid cron %q: %v", c.in, tf.Cron(), err)
+		}
+	}
+	if _, err := ParseTimeframe("2D"); err == nil {
+		t.Error("expected an error for an unsupported timeframe")
+	}
+}
@@ -1,6 +1,9 @@
 package model
 
-import "github.com/twoonefour/sigmaflow/pkg/currency"
+import (
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"time"
+)
 
 type Decision struct {
 	Action          string  `json:"action"`
@@ -9,6 +12,8 @@ type Decision struct {
 	StopLossPrice   float64 `json:"stop_loss_price"`
 	TakeProfitPrice float64 `json:"take_profit_price"`
 	Amount          string  `json:"amount"`
+	Prompt          string  `json:"-"` // messages sent to the LLM
+	Response        string  `json:"-"` // raw LLM answer(s) the decision was parsed from
 }
 
 type TrendIndicators struct {
@@ -18,6 +23,13 @@ type TrendIndicators struct {
 	BBUpper float64 `json:"bb_upper"`
 	BBMid   float64 `json:"bb_mid"`
 	BBLower float64 `json:"bb_lower"`
+	// Optional indicators selected per strategy, in display order
+	Extra []IndicatorValue `json:"extra,omitempty"`
+}
+
+type IndicatorValue struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
 }
 
 type Candlestick struct {
@@ -52,3 +64,65 @@ type Asset struct {
 
 type GraphicData struct {
 }
+
+// OrderRequest is what was sent to the market for a decision.
+type OrderRequest struct {
+	InstId     string  `json:"inst_id"`
+	Side       string  `json:"side"`
+	Size       string  `json:"size"`
+	StopLoss   float64 `json:"stop_loss_price,omitempty"` // levels of the decision, 0 when unset
+	TakeProfit float64 `json:"take_profit_price,omitempty"`
+}
+
+// Order states, as reported by OKX.
+const (
+	OrderLive            = "live"
+	OrderPartiallyFilled = "partially_filled"
+	OrderFilled          = "filled"
+	OrderCanceled        = "canceled"
+)
+
+// OrderResult is what the market reports for an order.
+type OrderResult struct {
+	OrderId    string      `json:"order_id"`
+	InstId     string      `json:"inst_id"`
+	Side       string      `json:"side"`
+	State      string      `json:"state"`
+	FilledSize float64     `json:"filled_size"` // coin amount
+	AvgPrice   float64     `json:"avg_price"`
+	Fee        float64     `json:"fee"` // positive is a cost, in FeeCcy
+	FeeCcy     string      `json:"fee_ccy"`
+	Fills      []OrderFill `json:"fills,omitempty"`
+}
+
+// Done reports whether the order reached a final state.
+func (r *OrderResult) Done() bool {
+	return r.State == OrderFilled || r.State == OrderCanceled
+}
+
+type OrderFill struct {
+	TradeId string  `json:"trade_id"`
+	Ts      string  `json:"ts"`
+	Price   float64 `json:"price"`
+	Size    float64 `json:"size"`
+	Fee     float64 `json:"fee"` // positive is a cost
+	FeeCcy  string  `json:"fee_ccy"`
+}
+
+// RunRecord is one journal entry: everything the bot saw and did for a pair in one run.
+type RunRecord struct {
+	ID            int64
+	Ts            time.Time
+	Pair          string // instId, e.g. BTC-USDT
+	Strategy      string // name of the strategy, empty for an entry journaled before it was kept
+	Prompt        string
+	Response      string
+	Decision      *Decision
+	Order         *OrderRequest // nil when nothing was sent
+	OrderResult   *OrderResult  // what the market made of Order, when known
+	OrderError    string
+	BalanceBefore *TradeData
+	BalanceAfter  *TradeData
+	Error         string // analysis failure, if any
+	Advisory      bool   // Order was only filled in the shadow portfolio of an advisory service
+}
@@ -0,0 +1,182 @@
+package backtest
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/internal/service/trade"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadCandles reads a candle series from a .csv or .json file and returns it sorted oldest first.
+//
+// CSV rows are "ts,open,high,low,close,volume" with an optional header line; ts is either a
+// millisecond timestamp or a date (2006-01-02 / RFC3339). JSON files hold an array of model.Candlestick.
+func LoadCandles(path string) ([]model.Candlestick, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var candles []model.Candlestick
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		candles, err = readCSV(f)
+	case ".json":
+		err = json.NewDecoder(f).Decode(&candles)
+	default:
+		return nil, fmt.Errorf("[backtest.LoadCandles] unsupported file type: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[backtest.LoadCandles] %s: %w", path, err)
+	}
+	sortCandles(candles)
+	return candles, nil
+}
+
+// SaveCandles writes candles to path as CSV or JSON by its extension, the formats LoadCandles
+// reads back.
+func SaveCandles(path string, candles []model.Candlestick) error {
+	var data []byte
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		data = writeCSV(candles)
+	case ".json":
+		var err error
+		if data, err = json.Marshal(candles); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("[backtest.SaveCandles] unsupported file type: %s", path)
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// FetchCandles returns the candles of timeframe for pair. An existing cachePath is used as it
+// is, even when it holds fewer than count candles, and is never written to. Otherwise count
+// candles are downloaded from market and cached at cachePath.
+func FetchCandles(market trade.Market, pair currency.Pair, timeframe model.Timeframe, count int, cachePath string) ([]model.Candlestick, error) {
+	if cachePath != "" {
+		candles, err := LoadCandles(cachePath)
+		if err == nil {
+			if len(candles) < count {
+				log.Println(fmt.Sprintf("[backtest.FetchCandles] %s 只有 %d 根K线, 少于 %d", cachePath, len(candles), count))
+			}
+			return candles, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	candles, err := market.GetCandle(pair, timeframe, count)
+	if err != nil {
+		return nil, err
+	}
+	sortCandles(candles)
+	if cachePath != "" {
+		if err = SaveCandles(cachePath, candles); err != nil {
+			return nil, err
+		}
+	}
+	return candles, nil
+}
+
+// writeCSV renders candles in the layout readCSV reads, with a header line.
+func writeCSV(candles []model.Candlestick) []byte {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	_ = w.Write([]string{"ts", "open", "high", "low", "close", "volume"})
+	for _, c := range candles {
+		_ = w.Write([]string{c.Ts, ftoa(c.O), ftoa(c.H), ftoa(c.L), ftoa(c.C), ftoa(c.Vol)})
+	}
+	w.Flush()
+	return []byte(b.String())
+}
+
+func ftoa(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func readCSV(r io.Reader) ([]model.Candlestick, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	candles := make([]model.Candlestick, 0)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 6 {
+			return nil, fmt.Errorf("line %d: expected 6 columns, got %d", line, len(record))
+		}
+		ts, err := parseTs(record[0])
+		if err != nil {
+			if line == 1 {
+				// header
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		values := make([]float64, 5)
+		for i := range values {
+			values[i], err = strconv.ParseFloat(record[i+1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		candles = append(candles, model.Candlestick{
+			Ts:      ts,
+			O:       values[0],
+			H:       values[1],
+			L:       values[2],
+			C:       values[3],
+			Vol:     values[4],
+			Confirm: "1",
+		})
+	}
+	return candles, nil
+}
+
+// parseTs normalizes a timestamp column into the millisecond string used by model.Candlestick.
+func parseTs(s string) (string, error) {
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return s, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return strconv.FormatInt(t.UnixMilli(), 10), nil
+		}
+	}
+	return "", fmt.Errorf("invalid timestamp %q", s)
+}
+
+func sortCandles(candles []model.Candlestick) {
+	sort.SliceStable(candles, func(i, j int) bool {
+		return tsMilli(candles[i].Ts) < tsMilli(candles[j].Ts)
+	})
+}
+
+func tsMilli(ts string) int64 {
+	v, _ := strconv.ParseInt(ts, 10, 64)
+	return v
+}
@@ -0,0 +1,59 @@
+package backtest
+
+import (
+	"context"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type candleMarket struct {
+	candles []model.Candlestick
+	calls   int
+}
+
+func (m *candleMarket) GetCandle(_ currency.Pair, _ model.Timeframe, period int) ([]model.Candlestick, error) {
+	m.calls++
+	return m.candles[:period], nil
+}
+
+func (m *candleMarket) GetBalance(_ context.Context, _ ...currency.Coin) (*model.TradeData, error) {
+	return &model.TradeData{}, nil
+}
+
+func (m *candleMarket) Order(_ context.Context, _, _, _ string) (*model.OrderResult, error) {
+	return &model.OrderResult{}, nil
+}
+
+func TestFetchCandles(t *testing.T) {
+	pair := currency.NewPair(currency.USDT, currency.BTC)
+	market := &candleMarket{candles: linearHistory(10)}
+
+	// a file of the user with fewer candles than asked for is used as it is
+	short := filepath.Join(t.TempDir(), "short.csv")
+	content := "ts,open,high,low,close,volume\n2024-01-01,1,2,0.5,1.5,10\n2024-01-02,1.5,2,1,1.8,12\n"
+	if err := os.WriteFile(short, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	candles, err := FetchCandles(market, pair, model.Day1, 5, short)
+	if err != nil || len(candles) != 2 || market.calls != 0 {
+		t.Fatalf("expected the 2 candles of the file, got %d (%v), downloaded %d times", len(candles), err, market.calls)
+	}
+	if data, _ := os.ReadFile(short); string(data) != content {
+		t.Errorf("the file of the user was rewritten:\n%s", data)
+	}
+
+	// a missing file is downloaded and cached in the format of its extension
+	for _, name := range []string{"cache.csv", "cache.json"} {
+		path := filepath.Join(t.TempDir(), name)
+		if candles, err = FetchCandles(market, pair, model.Day1, 5, path); err != nil || len(candles) != 5 {
+			t.Fatalf("%s: expected 5 downloaded candles, got %d (%v)", name, len(candles), err)
+		}
+		cached, err := LoadCandles(path)
+		if err != nil || len(cached) != 5 || cached[4].C != candles[4].C || cached[4].Ts != candles[4].Ts {
+			t.Errorf("%s: expected the cache to load back, got %+v (%v)", name, cached, err)
+		}
+	}
+}
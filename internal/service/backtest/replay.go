@@ -0,0 +1,30 @@
+package backtest
+
+import (
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+)
+
+// replaySource serves a historical series as if it were live: only candles up to cursor are visible.
+type replaySource struct {
+	history []model.Candlestick // oldest first
+	cursor  int
+}
+
+func (r *replaySource) current() model.Candlestick {
+	return r.history[r.cursor]
+}
+
+// GetCandle returns the period candles ending at cursor, newest first like okx.Client. The
+// history is replayed as is, whatever timeframe is asked for.
+func (r *replaySource) GetCandle(_ currency.Pair, _ model.Timeframe, period int) ([]model.Candlestick, error) {
+	if r.cursor+1 < period {
+		return nil, fmt.Errorf("[backtest.GetCandle] need %d candles, only %d available", period, r.cursor+1)
+	}
+	res := make([]model.Candlestick, period)
+	for i := 0; i < period; i++ {
+		res[i] = r.history[r.cursor-i]
+	}
+	return res, nil
+}
@@ -0,0 +1,162 @@
+package backtest
+
+import (
+	"context"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange/paper"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/internal/service/llm"
+	"github.com/twoonefour/sigmaflow/internal/service/trade"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"log"
+	"math"
+	"strings"
+)
+
+type Options struct {
+	InitialCash float64 // starting quote balance, e.g. 10000 USDT
+	Fee         float64 // taker fee rate applied to every fill, e.g. 0.001
+	Slippage    float64 // price impact of every fill, e.g. 0.0005
+	Warmup      int     // candles required before the first decision, defaults to Strategy.Candles()
+	// Strategy must match the timeframe of the replayed history, zero fields keep trade.DefaultStrategy
+	Strategy trade.Strategy
+}
+
+type Service struct {
+	llm  *llm.Service
+	opts Options
+}
+
+type EquityPoint struct {
+	Ts     string
+	Equity float64
+	Action string
+}
+
+type Report struct {
+	Pair          currency.Pair
+	Timeframe     model.Timeframe
+	InitialEquity float64
+	FinalEquity   float64
+	EquityCurve   []EquityPoint
+	Fills         []paper.Fill
+	TotalReturn   float64 // FinalEquity/InitialEquity - 1
+	MaxDrawdown   float64 // largest peak-to-trough loss of the equity curve, as a ratio
+	WinRate       float64 // profitable sells / all sells
+	Trades        int
+	Errors        int // steps where the pipeline failed and the day was skipped
+}
+
+func NewService(llmService *llm.Service, opts Options) *Service {
+	opts.Strategy = opts.Strategy.WithDefaults()
+	if opts.Warmup <= 0 {
+		opts.Warmup = opts.Strategy.Candles()
+	}
+	if opts.InitialCash <= 0 {
+		opts.InitialCash = 10000
+	}
+	return &Service{
+		llm:  llmService,
+		opts: opts,
+	}
+}
+
+// Run replays history (oldest first) one candle at a time through the same
+// GetCandle -> GetBalance -> AnalyzeMarket -> Order pipeline used live.
+func (s *Service) Run(ctx context.Context, pair currency.Pair, history []model.Candlestick) (*Report, error) {
+	if len(history) < s.opts.Warmup {
+		return nil, fmt.Errorf("[backtest.Run] need at least %d candles, got %d", s.opts.Warmup, len(history))
+	}
+	source := &replaySource{history: history}
+	market, err := paper.NewPaperClient(source, paper.Options{
+		Quote:    pair.Base,
+		Initial:  map[currency.Coin]float64{pair.Base: s.opts.InitialCash},
+		Slippage: s.opts.Slippage,
+		TakerFee: s.opts.Fee,
+	})
+	if err != nil {
+		return nil, err
+	}
+	tradeService := trade.NewTradeService(market, s.llm, trade.WithStrategy(s.opts.Strategy))
+	report := &Report{
+		Pair:          pair,
+		Timeframe:     s.opts.Strategy.Timeframe,
+		InitialEquity: s.opts.InitialCash,
+		EquityCurve:   make([]EquityPoint, 0, len(history)-s.opts.Warmup+1),
+	}
+
+	for source.cursor = s.opts.Warmup - 1; source.cursor < len(history); source.cursor++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		action := "HOLD"
+		decision, err := tradeService.Execute(ctx, pair)
+		if err != nil {
+			report.Errors++
+			log.Println(fmt.Sprintf("[backtest.Run] %s 跳过: %s", s.opts.Strategy.Timeframe.FormatTs(source.current().Ts), err.Error()))
+		}
+		if decision != nil {
+			action = decision.Action
+		}
+		balance, err := market.GetBalance(ctx)
+		if err != nil {
+			return nil, err
+		}
+		report.EquityCurve = append(report.EquityCurve, EquityPoint{
+			Ts:     source.current().Ts,
+			Equity: balance.TotalEquity,
+			Action: action,
+		})
+	}
+	report.Fills = market.Fills()
+	report.FinalEquity = report.EquityCurve[len(report.EquityCurve)-1].Equity
+	report.calculate()
+	return report, nil
+}
+
+func (r *Report) calculate() {
+	r.Trades = len(r.Fills)
+	if r.InitialEquity > 0 {
+		r.TotalReturn = r.FinalEquity/r.InitialEquity - 1
+	}
+
+	peak := r.InitialEquity
+	for _, p := range r.EquityCurve {
+		peak = math.Max(peak, p.Equity)
+		if peak > 0 {
+			r.MaxDrawdown = math.Max(r.MaxDrawdown, (peak-p.Equity)/peak)
+		}
+	}
+
+	var sells, wins int
+	for _, f := range r.Fills {
+		if f.Side != "sell" {
+			continue
+		}
+		sells++
+		if f.PNL > 0 {
+			wins++
+		}
+	}
+	if sells > 0 {
+		r.WinRate = float64(wins) / float64(sells)
+	}
+}
+
+func (r *Report) String() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Pair: %s-%s\n", r.Pair.Quote.String(), r.Pair.Base.String()))
+	if len(r.EquityCurve) > 0 {
+		sb.WriteString(fmt.Sprintf("Period: %s ~ %s (%d steps)\n",
+			r.Timeframe.FormatTs(r.EquityCurve[0].Ts), r.Timeframe.FormatTs(r.EquityCurve[len(r.EquityCurve)-1].Ts), len(r.EquityCurve)))
+	}
+	sb.WriteString(fmt.Sprintf("Equity: %.2f -> %.2f\n", r.InitialEquity, r.FinalEquity))
+	sb.WriteString(fmt.Sprintf("Total Return: %.2f%%\n", r.TotalReturn*100))
+	sb.WriteString(fmt.Sprintf("Max Drawdown: %.2f%%\n", r.MaxDrawdown*100))
+	sb.WriteString(fmt.Sprintf("Win Rate: %.2f%%\n", r.WinRate*100))
+	sb.WriteString(fmt.Sprintf("Trades: %d\n", r.Trades))
+	if r.Errors > 0 {
+		sb.WriteString(fmt.Sprintf("Skipped Steps: %d\n", r.Errors))
+	}
+	return sb.String()
+}
@@ -0,0 +1,79 @@
+package backtest
+
+import (
+	"context"
+	"github.com/twoonefour/sigmaflow/internal/client/exchange/paper"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/internal/service/llm"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	pkgllm "github.com/twoonefour/sigmaflow/pkg/llm"
+	"math"
+	"strconv"
+	"testing"
+)
+
+type scriptedAdvisor struct {
+	replies []string
+	calls   int
+}
+
+func (a *scriptedAdvisor) Chat(_ context.Context, _ []pkgllm.Messages) (string, error) {
+	reply := `{"action":"HOLD","position_pct":0,"reason":"wait"}`
+	if a.calls < len(a.replies) && a.replies[a.calls] != "" {
+		reply = a.replies[a.calls]
+	}
+	a.calls++
+	return reply, nil
+}
+
+func linearHistory(n int) []model.Candlestick {
+	res := make([]model.Candlestick, n)
+	for i := range res {
+		price := 100 + float64(i)
+		res[i] = model.Candlestick{
+			Ts: strconv.FormatInt(int64(i)*86400000, 10),
+			O:  price, H: price, L: price, C: price, Vol: 1,
+		}
+	}
+	return res
+}
+
+func TestRun(t *testing.T) {
+	advisor := &scriptedAdvisor{replies: []string{
+		0: `{"action":"BUY","position_pct":1.0,"stop_loss_price":300,"reason":"breakout"}`,
+		5: `{"action":"SELL","position_pct":1.0,"reason":"exit"}`,
+	}}
+	llmService, _ := llm.NewClient(advisor)
+	report, err := NewService(llmService, Options{InitialCash: 10000}).
+		Run(context.Background(), currency.NewPair(currency.USDT, currency.BTC), linearHistory(240))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if advisor.calls != 10 || len(report.EquityCurve) != 10 {
+		t.Fatalf("expected 10 steps, got %d calls and %d points", advisor.calls, len(report.EquityCurve))
+	}
+	// bought at 330, sold at 335
+	want := 10000 * 335.0 / 330.0
+	if math.Abs(report.FinalEquity-want) > 1e-6 {
+		t.Errorf("final equity = %f, want %f", report.FinalEquity, want)
+	}
+	if report.Trades != 2 || report.WinRate != 1 || report.MaxDrawdown != 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
+
+func TestReportDrawdown(t *testing.T) {
+	r := &Report{
+		InitialEquity: 100,
+		FinalEquity:   90,
+		EquityCurve:   []EquityPoint{{Equity: 120}, {Equity: 90}, {Equity: 110}, {Equity: 90}},
+		Fills:         []paper.Fill{{Side: "buy"}, {Side: "sell", PNL: 5}, {Side: "sell", PNL: -3}},
+	}
+	r.calculate()
+	if math.Abs(r.MaxDrawdown-0.25) > 1e-9 {
+		t.Errorf("max drawdown = %f, want 0.25", r.MaxDrawdown)
+	}
+	if r.WinRate != 0.5 || r.Trades != 3 || math.Abs(r.TotalReturn+0.1) > 1e-9 {
+		t.Errorf("unexpected report: %+v", r)
+	}
+}
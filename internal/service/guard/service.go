@@ -0,0 +1,434 @@
+package guard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/internal/service/trade"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPollInterval = 15 * time.Second
+	// a failed exit is retried after retryBackoff, doubling up to maxRetryBackoff
+	retryBackoff    = 5 * time.Second
+	maxRetryBackoff = 5 * time.Minute
+)
+
+// Tick is a last traded price.
+type Tick struct {
+	InstId string
+	Price  float64
+}
+
+// Feed streams prices of instIds until ctx is done, e.g. the OKX tickers channel.
+type Feed interface {
+	Ticks(ctx context.Context, instIds ...string) (<-chan Tick, error)
+}
+
+// FeedFunc adapts a function to Feed.
+type FeedFunc func(ctx context.Context, instIds ...string) (<-chan Tick, error)
+
+func (f FeedFunc) Ticks(ctx context.Context, instIds ...string) (<-chan Tick, error) {
+	return f(ctx, instIds...)
+}
+
+// Levels are the exit prices of a position, 0 means not set.
+type Levels = trade.Levels
+
+type Options struct {
+	Feed         Feed          // nil polls only
+	PollInterval time.Duration // price polling while the feed is unavailable, default 15s
+	// Strategy is the name of the guarded strategy: only its exchange-side stops are canceled
+	// on exit, see trade.StopMarket, and only its journal entries are restored.
+	Strategy string
+	// Advisory guards the shadow portfolio of an advisory trade.Service: only advisory
+	// journal entries are restored, and exits are recorded as advisory.
+	Advisory bool
+}
+
+// Service watches the prices of open positions between runs and sells a position with a
+// market order as soon as it crosses the stop-loss or take-profit of its last decision.
+type Service struct {
+	market  trade.Market
+	journal trade.Journal
+	opts    Options
+
+	mu      sync.Mutex
+	watches map[string]watch // by instId, see watch.active
+	changed chan struct{}
+}
+
+type watch struct {
+	pair   currency.Pair
+	levels Levels
+	size   float64 // coins bought by the strategy, when known
+	// known is false for a position restored from entries journaled before fills were kept,
+	// its exit sells the whole balance
+	known bool
+	// exit is the reason of a triggered exit that did not sell yet, it is retried from
+	// retryAt on
+	exit    string
+	retries int
+	retryAt time.Time
+}
+
+// NewService creates a guard trading on market. journal may be nil.
+func NewService(market trade.Market, journal trade.Journal, opts Options) *Service {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+	return &Service{
+		market:  market,
+		journal: journal,
+		opts:    opts,
+		watches: make(map[string]watch),
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// active reports whether the prices of w are watched: it has levels and, as far as the
+// strategy knows, a position to sell.
+func (w watch) active() bool {
+	return w.levels != (Levels{}) && (!w.known || w.size > 0)
+}
+
+// Track implements trade.Watcher. A pair that was not restored holds what the tracked fills
+// add up to.
+func (s *Service) Track(pair currency.Pair, decision model.Decision, result *model.OrderResult) {
+	instId := trade.InstId(pair)
+	s.mu.Lock()
+	w, ok := s.watches[instId]
+	s.mu.Unlock()
+	if !ok {
+		w = watch{known: true}
+	}
+	levels, changed := trade.LevelsOf(w.levels, decision)
+	if !changed && result == nil {
+		return
+	}
+	s.set(pair, watch{pair: pair, levels: levels, size: max(w.size+trade.Filled(pair, result), 0), known: w.known})
+}
+
+// set replaces the watch of pair, and any pending exit.
+func (s *Service) set(pair currency.Pair, w watch) {
+	instId := trade.InstId(pair)
+	s.mu.Lock()
+	prev := s.watches[instId]
+	s.watches[instId] = w
+	s.mu.Unlock()
+	if prev.active() != w.active() {
+		// the set of watched instruments changed, resubscribe
+		select {
+		case s.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Levels returns the watched levels of pair.
+func (s *Service) Levels(pair currency.Pair) (Levels, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := s.watches[trade.InstId(pair)]
+	return w.levels, w.active()
+}
+
+// Restore picks up the levels of the executed decisions of each pair from history, so that
+// positions stay watched across restarts. The size of a position is summed up from every
+// order the strategy journaled, see trade.Position, once the exchange-side stops that
+// triggered meanwhile are journaled too, see trade.Reconcile.
+func (s *Service) Restore(ctx context.Context, history trade.History, pairs []currency.Pair) error {
+	for _, pair := range pairs {
+		if !s.opts.Advisory && s.journal != nil {
+			if _, err := trade.Reconcile(ctx, s.market, s.journal, pair, s.opts.Strategy); err != nil {
+				log.Println(fmt.Sprintf("[guard.Restore] %s: %s", trade.InstId(pair), err.Error()))
+			}
+		}
+		records, err := history.Executed(ctx, trade.InstId(pair), s.opts.Strategy)
+		if err != nil {
+			return err
+		}
+		levels := trade.LastLevels(records, s.opts.Advisory)
+		size, known := trade.Position(pair, records, s.opts.Advisory)
+		s.set(pair, watch{pair: pair, levels: levels, size: size, known: known})
+	}
+	return nil
+}
+
+func (s *Service) snapshot() []watch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]watch, 0, len(s.watches))
+	for _, w := range s.watches {
+		if w.active() {
+			res = append(res, w)
+		}
+	}
+	return res
+}
+
+// Run watches prices until ctx is done. It follows the feed and polls the last 1m candle
+// while the feed is missing or broken.
+func (s *Service) Run(ctx context.Context) error {
+	for {
+		watches := s.snapshot()
+		streamCtx, cancel := context.WithCancel(ctx)
+		ticks := s.subscribe(streamCtx, watches)
+		poll := time.NewTicker(s.opts.PollInterval)
+		err := s.watch(ctx, ticks, poll.C, watches)
+		poll.Stop()
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Service) subscribe(ctx context.Context, watches []watch) <-chan Tick {
+	if s.opts.Feed == nil || len(watches) == 0 {
+		return nil
+	}
+	instIds := make([]string, len(watches))
+	for i, w := range watches {
+		instIds[i] = trade.InstId(w.pair)
+	}
+	ticks, err := s.opts.Feed.Ticks(ctx, instIds...)
+	if err != nil {
+		log.Println(fmt.Sprintf("[guard.Run] 行情订阅失败, 改为轮询: %s", err.Error()))
+		return nil
+	}
+	return ticks
+}
+
+// watch handles prices until the watched set changes (nil) or ctx is done.
+func (s *Service) watch(ctx context.Context, ticks <-chan Tick, poll <-chan time.Time, watches []watch) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.changed:
+			return nil
+		case t, ok := <-ticks:
+			if !ok {
+				log.Println("[guard.Run] 行情推送中断, 改为轮询")
+				ticks = nil
+				continue
+			}
+			s.check(ctx, t.InstId, t.Price)
+		case <-poll:
+			s.retryExits(ctx)
+			if ticks != nil {
+				continue
+			}
+			for _, w := range watches {
+				candles, err := s.market.GetCandle(w.pair, model.Minute1, 1)
+				if err != nil || len(candles) == 0 {
+					log.Println(fmt.Sprintf("[guard.Run] %s 获取价格失败: %v", trade.InstId(w.pair), err))
+					continue
+				}
+				s.check(ctx, trade.InstId(w.pair), candles[0].C)
+			}
+			if s.opts.Feed != nil {
+				// try the stream again on the next round
+				return nil
+			}
+		}
+	}
+}
+
+// check exits the position of instId when price crossed one of its levels.
+func (s *Service) check(ctx context.Context, instId string, price float64) {
+	s.mu.Lock()
+	w, ok := s.watches[instId]
+	switch {
+	case !ok || !w.active() || price <= 0 || w.exit != "":
+		// a triggered exit is retried by retryExits, whatever the price does
+		s.mu.Unlock()
+		return
+	case w.levels.StopLoss > 0 && price <= w.levels.StopLoss:
+		w.exit = fmt.Sprintf("stop loss %.2f crossed at %.2f", w.levels.StopLoss, price)
+	case w.levels.TakeProfit > 0 && price >= w.levels.TakeProfit:
+		w.exit = fmt.Sprintf("take profit %.2f crossed at %.2f", w.levels.TakeProfit, price)
+	default:
+		s.mu.Unlock()
+		return
+	}
+	s.watches[instId] = w
+	s.mu.Unlock()
+	s.exit(ctx, instId)
+}
+
+// retryExits retries the failed exits whose backoff has passed.
+func (s *Service) retryExits(ctx context.Context) {
+	now := time.Now()
+	s.mu.Lock()
+	due := make([]string, 0)
+	for instId, w := range s.watches {
+		if w.exit != "" && !now.Before(w.retryAt) {
+			due = append(due, instId)
+		}
+	}
+	s.mu.Unlock()
+	for _, instId := range due {
+		s.exit(ctx, instId)
+	}
+}
+
+// exit sells the position of instId. The watch is kept until the position is sold: a failed
+// exit is retried with a growing backoff, unless a new decision replaces the watch first.
+func (s *Service) exit(ctx context.Context, instId string) {
+	s.mu.Lock()
+	w, ok := s.watches[instId]
+	s.mu.Unlock()
+	if !ok || w.exit == "" {
+		return
+	}
+	sold, err := s.sell(ctx, w)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, ok := s.watches[instId]; !ok || cur.exit == "" {
+		// replaced by a decision meanwhile
+		return
+	}
+	if err == nil {
+		// exit once, the next decision sets new levels
+		delete(s.watches, instId)
+		return
+	}
+	w.size = max(w.size-sold, 0)
+	w.retries++
+	backoff := min(retryBackoff<<min(w.retries-1, 10), maxRetryBackoff)
+	w.retryAt = time.Now().Add(backoff)
+	s.watches[instId] = w
+	log.Println(fmt.Sprintf("[guard.exit] %s 第%d次卖出失败, %s 后重试: %s", instId, w.retries, backoff, err.Error()))
+}
+
+// sell sells the tracked size of w at market, at most the balance; all of the balance when
+// the size is unknown, for a position restored from before fills were journaled. It records
+// the exit in the journal and returns how much was sold. An order that did not sell all of
+// it fails, so the rest is sold on the next try. What an exchange-side stop sold meanwhile is
+// journaled first, see trade.Reconcile, and counts as sold.
+func (s *Service) sell(ctx context.Context, w watch) (float64, error) {
+	stopped := s.reconcile(ctx, w.pair)
+	w.size = max(w.size-stopped, 0)
+	sold, err := s.sellAtMarket(ctx, w)
+	return stopped + sold, err
+}
+
+// reconcile journals the sells of the exchange-side stops of pair that triggered, and
+// returns how much they sold.
+func (s *Service) reconcile(ctx context.Context, pair currency.Pair) float64 {
+	if s.opts.Advisory || s.journal == nil {
+		return 0
+	}
+	added, err := trade.Reconcile(ctx, s.market, s.journal, pair, s.opts.Strategy)
+	if err != nil {
+		log.Println(fmt.Sprintf("[guard.exit] %s 同步交易所止损成交失败: %s", trade.InstId(pair), err.Error()))
+	}
+	var sold float64
+	for _, r := range added {
+		sold -= trade.Filled(pair, r.OrderResult)
+	}
+	return sold
+}
+
+// sellAtMarket does the market order of sell.
+func (s *Service) sellAtMarket(ctx context.Context, w watch) (float64, error) {
+	instId := trade.InstId(w.pair)
+	log.Println(fmt.Sprintf("[guard.exit] %s %s, 市价卖出", instId, w.exit))
+	decision := &model.Decision{Action: "SELL", PositionPct: 1, Reason: w.exit}
+	r := &model.RunRecord{Ts: time.Now(), Pair: instId, Strategy: s.opts.Strategy, Decision: decision, Advisory: s.opts.Advisory}
+	defer s.record(ctx, r)
+
+	before, err := s.market.GetBalance(ctx, w.pair.Base, w.pair.Quote)
+	if err != nil {
+		r.Error = err.Error()
+		return 0, err
+	}
+	r.BalanceBefore = before
+	var size float64
+	if asset, ok := before.AccountAssets[w.pair.Quote]; ok {
+		size = asset.Equity
+	}
+	if w.known {
+		size = min(size, w.size)
+	}
+	if size <= 0 {
+		// e.g. the exchange-side stop already closed it. The balance journaled after the
+		// exit tells trade.Position the strategy holds nothing, so it is not watched again
+		// after a restart.
+		r.Order = &model.OrderRequest{InstId: instId, Side: "sell", Size: "0"}
+		r.OrderError = "no position"
+		r.BalanceAfter = before
+		return 0, nil
+	}
+	// the stop locks the coins to sell, it is placed again when the sell fails, like
+	// trade.Order does
+	stops, canceled := s.market.(trade.StopMarket)
+	if canceled {
+		if err = stops.CancelStops(ctx, instId, s.opts.Strategy); err != nil {
+			log.Println(fmt.Sprintf("[guard.exit] %s 取消止损单失败: %s", instId, err.Error()))
+			canceled = false
+		}
+	}
+	decision.Amount = strconv.FormatFloat(size, 'f', -1, 64)
+	r.Order = &model.OrderRequest{InstId: instId, Side: "sell", Size: decision.Amount}
+	r.OrderResult, err = s.market.Order(ctx, instId, "sell", decision.Amount)
+	if err == nil && r.OrderResult.State == model.OrderCanceled && r.OrderResult.FilledSize <= 0 {
+		err = fmt.Errorf("[guard.exit] %s order %s canceled without fill", instId, r.OrderResult.OrderId)
+	}
+	switch {
+	case errors.Is(err, model.ErrBelowMinimum):
+		// dust the exchange does not take, nothing left to protect
+		r.OrderError = err.Error()
+		return 0, nil
+	case err != nil:
+		r.OrderError = err.Error()
+		if canceled {
+			s.restoreStop(ctx, stops, w, size)
+		}
+		return 0, err
+	}
+	res := r.OrderResult
+	log.Println(fmt.Sprintf("[guard.exit] %s 订单: %s, 状态: %s, 成交: %f, 均价: %.2f",
+		instId, res.OrderId, res.State, res.FilledSize, res.AvgPrice))
+	if r.BalanceAfter, err = s.market.GetBalance(ctx, w.pair.Base, w.pair.Quote); err != nil {
+		log.Println(fmt.Sprintf("[guard.exit] %s", err.Error()))
+	}
+	if res.State != model.OrderFilled && res.FilledSize < size {
+		// e.g. still live when the client stopped waiting: the rest stays watched, also
+		// after a restart, and protected
+		decision.StopLossPrice, decision.TakeProfitPrice = w.levels.StopLoss, w.levels.TakeProfit
+		if canceled {
+			s.restoreStop(ctx, stops, w, size-res.FilledSize)
+		}
+		return res.FilledSize, fmt.Errorf("[guard.exit] %s order %s %s, filled %f of %s", instId, res.OrderId, res.State, res.FilledSize, decision.Amount)
+	}
+	return res.FilledSize, nil
+}
+
+// restoreStop places the exchange-side stop at the levels of w again over size coins, after a
+// sell that did not sell them.
+func (s *Service) restoreStop(ctx context.Context, stops trade.StopMarket, w watch, size float64) {
+	instId := trade.InstId(w.pair)
+	sz := strconv.FormatFloat(size, 'f', -1, 64)
+	if _, err := stops.PlaceStop(ctx, instId, s.opts.Strategy, sz, w.levels.StopLoss, w.levels.TakeProfit); err != nil {
+		log.Println(fmt.Sprintf("[guard.exit] %s 卖出失败后恢复止损失败: %s", instId, err.Error()))
+	}
+}
+
+func (s *Service) record(ctx context.Context, r *model.RunRecord) {
+	if s.journal == nil {
+		return
+	}
+	if err := s.journal.Record(ctx, r); err != nil {
+		log.Println(fmt.Sprintf("[guard.record] 写入日志失败: %s", err.Error()))
+	}
+}
@@ -0,0 +1,462 @@
+package guard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeMarket struct {
+	mu       sync.Mutex
+	price    float64
+	position float64
+	fail     int     // orders to fail
+	partial  float64 // fill of the next order, it stays partially filled
+	orders   []string
+}
+
+func (f *fakeMarket) GetCandle(_ currency.Pair, _ model.Timeframe, _ int) ([]model.Candlestick, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return []model.Candlestick{{C: f.price}}, nil
+}
+
+func (f *fakeMarket) GetBalance(_ context.Context, coin ...currency.Coin) (*model.TradeData, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &model.TradeData{AccountAssets: map[currency.Coin]*model.Asset{
+		currency.BTC: {Currency: currency.BTC, Equity: f.position},
+	}}, nil
+}
+
+func (f *fakeMarket) Order(_ context.Context, instId, side, sz string) (*model.OrderResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.orders = append(f.orders, instId+" "+side+" "+sz)
+	if f.fail > 0 {
+		f.fail--
+		return nil, errors.New("exchange unavailable")
+	}
+	size, _ := strconv.ParseFloat(sz, 64)
+	size = min(size, f.position)
+	state := model.OrderFilled
+	if f.partial > 0 {
+		size, state = min(size, f.partial), model.OrderPartiallyFilled
+		f.partial = 0
+	}
+	f.position -= size
+	return &model.OrderResult{InstId: instId, Side: side, State: state, FilledSize: size, AvgPrice: f.price}, nil
+}
+
+// stopMarket is a fakeMarket holding exchange-side stops, which lock the coins they cover.
+type stopMarket struct {
+	*fakeMarket
+	locked float64
+	stops  []string
+}
+
+func (f *stopMarket) Order(ctx context.Context, instId, side, sz string) (*model.OrderResult, error) {
+	f.mu.Lock()
+	size, _ := strconv.ParseFloat(sz, 64)
+	locked := size > f.position-f.locked
+	f.mu.Unlock()
+	if locked {
+		return nil, errors.New("insufficient balance")
+	}
+	return f.fakeMarket.Order(ctx, instId, side, sz)
+}
+
+func (f *stopMarket) PlaceStop(_ context.Context, instId, _, sz string, stopLoss, takeProfit float64) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.locked, _ = strconv.ParseFloat(sz, 64)
+	f.stops = append(f.stops, fmt.Sprintf("%s %s %g/%g", instId, sz, stopLoss, takeProfit))
+	return "1", nil
+}
+
+func (f *stopMarket) CancelStops(_ context.Context, _, _ string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.locked = 0
+	f.stops = nil
+	return nil
+}
+
+func (f *fakeMarket) sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.orders...)
+}
+
+type fakeJournal struct {
+	mu      sync.Mutex
+	records []*model.RunRecord
+}
+
+func (j *fakeJournal) Record(_ context.Context, r *model.RunRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.records = append(j.records, r)
+	return nil
+}
+
+func (j *fakeJournal) List(_ context.Context, pair, strategy string, _ int) ([]*model.RunRecord, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	res := make([]*model.RunRecord, 0)
+	for i := len(j.records) - 1; i >= 0; i-- {
+		if j.records[i].Pair == pair && (strategy == "" || j.records[i].Strategy == strategy) {
+			res = append(res, j.records[i])
+		}
+	}
+	return res, nil
+}
+
+func (j *fakeJournal) Executed(ctx context.Context, pair, strategy string) ([]*model.RunRecord, error) {
+	return j.List(ctx, pair, strategy, 0)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+var pair = currency.NewPair(currency.USDT, currency.BTC)
+
+func TestStopLossFromFeed(t *testing.T) {
+	market := &fakeMarket{position: 0.5}
+	journal := &fakeJournal{}
+	ticks := make(chan Tick)
+	subscribed := make(chan []string, 4)
+	feed := FeedFunc(func(_ context.Context, instIds ...string) (<-chan Tick, error) {
+		subscribed <- instIds
+		return ticks, nil
+	})
+	s := NewService(market, journal, Options{Feed: feed, PollInterval: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	s.Track(pair, model.Decision{Action: "BUY", StopLossPrice: 90, TakeProfitPrice: 120}, &model.OrderResult{Side: "buy", FilledSize: 0.5})
+	waitFor(t, func() bool {
+		select {
+		case ids := <-subscribed:
+			return len(ids) == 1 && ids[0] == "BTC-USDT"
+		default:
+			return false
+		}
+	})
+	ticks <- Tick{InstId: "BTC-USDT", Price: 95}
+	ticks <- Tick{InstId: "BTC-USDT", Price: 89.5}
+	waitFor(t, func() bool { return len(market.sent()) == 1 })
+	if market.sent()[0] != "BTC-USDT sell 0.5" {
+		t.Fatalf("unexpected exit %v", market.sent())
+	}
+	if _, ok := s.Levels(pair); ok {
+		t.Error("levels should be cleared after the exit")
+	}
+	var records []*model.RunRecord
+	waitFor(t, func() bool {
+		records, _ = journal.List(context.Background(), "BTC-USDT", "", 10)
+		return len(records) == 1
+	})
+	r := records[0]
+	if r.Order == nil || r.OrderResult == nil || r.OrderResult.FilledSize != 0.5 || r.Decision.Action != "SELL" || r.BalanceBefore == nil || r.BalanceAfter == nil {
+		t.Errorf("unexpected journal record %+v", r)
+	}
+}
+
+func TestTakeProfitPolling(t *testing.T) {
+	market := &fakeMarket{position: 2, price: 100}
+	s := NewService(market, nil, Options{PollInterval: 5 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// bought before fills were journaled
+	history := &fakeJournal{}
+	history.Record(ctx, &model.RunRecord{Pair: "BTC-USDT", Decision: &model.Decision{Action: "BUY", StopLossPrice: 90, TakeProfitPrice: 120}, Order: &model.OrderRequest{InstId: "BTC-USDT", Side: "buy", Size: "200"}})
+	if err := s.Restore(ctx, history, []currency.Pair{pair}); err != nil {
+		t.Fatal(err)
+	}
+	go s.Run(ctx)
+
+	time.Sleep(20 * time.Millisecond)
+	if len(market.sent()) != 0 {
+		t.Fatalf("no level crossed yet, got %v", market.sent())
+	}
+	market.mu.Lock()
+	market.price = 121
+	market.mu.Unlock()
+	waitFor(t, func() bool { return len(market.sent()) == 1 })
+	if market.sent()[0] != "BTC-USDT sell 2" {
+		t.Errorf("a position of unknown size should be sold whole, got %v", market.sent())
+	}
+}
+
+func TestFlatPositionIsNotWatched(t *testing.T) {
+	// coins of another strategy or held by hand
+	market := &fakeMarket{position: 2}
+	s := NewService(market, nil, Options{})
+	s.Track(pair, model.Decision{Action: "HOLD", StopLossPrice: 90}, nil)
+	if _, ok := s.Levels(pair); ok {
+		t.Error("a strategy holding nothing should not be watched")
+	}
+	s.check(context.Background(), "BTC-USDT", 85)
+	if len(market.sent()) != 0 {
+		t.Errorf("sold coins that are not the strategy's: %v", market.sent())
+	}
+}
+
+func TestExitSellsTrackedSize(t *testing.T) {
+	// 0.3 BTC held besides the strategy
+	market := &fakeMarket{position: 0.8}
+	s := NewService(market, nil, Options{})
+	s.Track(pair, model.Decision{Action: "BUY", StopLossPrice: 90}, &model.OrderResult{Side: "buy", FilledSize: 0.4})
+	s.Track(pair, model.Decision{Action: "BUY", StopLossPrice: 90}, &model.OrderResult{Side: "buy", FilledSize: 0.2, Fee: 0.0002, FeeCcy: "BTC"})
+	s.Track(pair, model.Decision{Action: "SELL", PositionPct: 0.5, StopLossPrice: 90}, &model.OrderResult{Side: "sell", FilledSize: 0.1})
+	s.check(context.Background(), "BTC-USDT", 89)
+	if sent := market.sent(); len(sent) != 1 || sent[0] != "BTC-USDT sell 0.4998" {
+		t.Fatalf("expected the tracked position to be sold, got %v", sent)
+	}
+	if _, ok := s.Levels(pair); ok {
+		t.Error("levels should be cleared after the exit")
+	}
+}
+
+func TestExitUnlocksStop(t *testing.T) {
+	market := &stopMarket{fakeMarket: &fakeMarket{position: 1, fail: 1}, locked: 1, stops: []string{"BTC-USDT 1 90/120"}}
+	s := NewService(market, nil, Options{})
+	s.Track(pair, model.Decision{Action: "BUY", StopLossPrice: 90, TakeProfitPrice: 120}, &model.OrderResult{Side: "buy", FilledSize: 1})
+
+	// the failed sell places the stop again
+	s.check(context.Background(), "BTC-USDT", 85)
+	if len(market.stops) != 1 || market.stops[0] != "BTC-USDT 1 90/120" || market.locked != 1 {
+		t.Fatalf("expected the stop to be placed again, got %v", market.stops)
+	}
+
+	s.mu.Lock()
+	w := s.watches["BTC-USDT"]
+	w.retryAt = time.Now()
+	s.watches["BTC-USDT"] = w
+	s.mu.Unlock()
+	s.retryExits(context.Background())
+	if sent := market.sent(); len(sent) != 2 || market.position != 0 || len(market.stops) != 0 {
+		t.Errorf("expected the stop to be canceled and the position sold, got %v, stops %v", sent, market.stops)
+	}
+}
+
+func TestPartialExitKeepsRest(t *testing.T) {
+	market := &stopMarket{fakeMarket: &fakeMarket{position: 1, partial: 0.25}, locked: 1}
+	journal := &fakeJournal{}
+	s := NewService(market, journal, Options{})
+	s.Track(pair, model.Decision{Action: "BUY", StopLossPrice: 90, TakeProfitPrice: 120}, &model.OrderResult{Side: "buy", FilledSize: 1})
+	s.check(context.Background(), "BTC-USDT", 85)
+
+	if l, ok := s.Levels(pair); !ok || l.StopLoss != 90 {
+		t.Fatal("the unsold rest should stay watched")
+	}
+	if len(market.stops) != 1 || market.stops[0] != "BTC-USDT 0.75 90/120" {
+		t.Fatalf("expected the stop over the rest, got %v", market.stops)
+	}
+	s.mu.Lock()
+	w := s.watches["BTC-USDT"]
+	s.mu.Unlock()
+	if w.size != 0.75 || w.retries != 1 {
+		t.Errorf("expected the rest to be retried, got %+v", w)
+	}
+
+	// after a restart the rest is watched too
+	restored := NewService(market, nil, Options{})
+	journal.Record(context.Background(), &model.RunRecord{Pair: "BTC-USDT", Order: &model.OrderRequest{},
+		Decision: &model.Decision{Action: "BUY", StopLossPrice: 90, TakeProfitPrice: 120}, OrderResult: &model.OrderResult{Side: "buy", FilledSize: 1}})
+	journal.records[0], journal.records[1] = journal.records[1], journal.records[0]
+	if err := restored.Restore(context.Background(), journal, []currency.Pair{pair}); err != nil {
+		t.Fatal(err)
+	}
+	restored.mu.Lock()
+	w = restored.watches["BTC-USDT"]
+	restored.mu.Unlock()
+	if !w.active() || w.size != 0.75 || w.levels.StopLoss != 90 {
+		t.Errorf("expected the rest to be restored, got %+v", w)
+	}
+}
+
+func TestFailedExitIsRetried(t *testing.T) {
+	market := &fakeMarket{position: 1, fail: 1}
+	journal := &fakeJournal{}
+	s := NewService(market, journal, Options{})
+	s.Track(pair, model.Decision{Action: "BUY", StopLossPrice: 90}, &model.OrderResult{Side: "buy", FilledSize: 1})
+	s.check(context.Background(), "BTC-USDT", 85)
+	if _, ok := s.Levels(pair); !ok {
+		t.Fatal("a failed exit should stay watched")
+	}
+	// not due before the backoff, whatever the price
+	s.check(context.Background(), "BTC-USDT", 100)
+	s.retryExits(context.Background())
+	if len(market.sent()) != 1 {
+		t.Fatalf("retried before the backoff: %v", market.sent())
+	}
+
+	s.mu.Lock()
+	w := s.watches["BTC-USDT"]
+	if w.retries != 1 || w.retryAt.Sub(time.Now()) > retryBackoff {
+		t.Errorf("unexpected backoff %+v", w)
+	}
+	w.retryAt = time.Now()
+	s.watches["BTC-USDT"] = w
+	s.mu.Unlock()
+	s.retryExits(context.Background())
+	if sent := market.sent(); len(sent) != 2 || sent[1] != "BTC-USDT sell 1" {
+		t.Fatalf("expected the exit to be retried, got %v", sent)
+	}
+	if _, ok := s.Levels(pair); ok {
+		t.Error("levels should be cleared after the retried exit")
+	}
+	records, _ := journal.List(context.Background(), "BTC-USDT", "", 10)
+	if len(records) != 2 || records[1].OrderError == "" || records[0].OrderError != "" {
+		t.Errorf("expected the failed and the retried exit in the journal, got %+v", records)
+	}
+
+	// a new decision replaces a pending exit
+	market.fail = 1
+	market.position = 1
+	s.Track(pair, model.Decision{Action: "BUY", StopLossPrice: 90}, &model.OrderResult{Side: "buy", FilledSize: 1})
+	s.check(context.Background(), "BTC-USDT", 85)
+	s.Track(pair, model.Decision{Action: "HOLD", StopLossPrice: 80}, nil)
+	s.retryExits(context.Background())
+	if l, _ := s.Levels(pair); len(market.sent()) != 3 || l.StopLoss != 80 {
+		t.Errorf("expected the new levels to be watched, got %+v after %v", l, market.sent())
+	}
+}
+
+func TestClosedPositionNotRestored(t *testing.T) {
+	// the exchange-side stop sold the position before the guard saw the price
+	market := &fakeMarket{}
+	journal := &fakeJournal{}
+	journal.Record(context.Background(), &model.RunRecord{Pair: "BTC-USDT", Decision: &model.Decision{Action: "BUY", StopLossPrice: 90},
+		Order: &model.OrderRequest{}, OrderResult: &model.OrderResult{Side: "buy", FilledSize: 1}})
+	s := NewService(market, journal, Options{})
+	if err := s.Restore(context.Background(), journal, []currency.Pair{pair}); err != nil {
+		t.Fatal(err)
+	}
+	s.check(context.Background(), "BTC-USDT", 85)
+	if len(market.sent()) != 0 {
+		t.Fatalf("nothing to sell, got %v", market.sent())
+	}
+
+	// coins bought by hand later are not the strategy's, also after a restart
+	market.position = 2
+	restarted := NewService(market, journal, Options{})
+	if err := restarted.Restore(context.Background(), journal, []currency.Pair{pair}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := restarted.Levels(pair); ok {
+		t.Error("the closed position should not be watched after a restart")
+	}
+	restarted.check(context.Background(), "BTC-USDT", 85)
+	if len(market.sent()) != 0 {
+		t.Errorf("sold coins that are not the strategy's: %v", market.sent())
+	}
+}
+
+// triggeredMarket is a fakeMarket whose exchange-side stop sold by order 7.
+type triggeredMarket struct {
+	*fakeMarket
+	sold float64
+}
+
+func (f *triggeredMarket) GetTriggeredStops(_ context.Context, _, _ string) ([]string, error) {
+	return []string{"7"}, nil
+}
+
+func (f *triggeredMarket) GetOrder(_ context.Context, instId, ordId string) (*model.OrderResult, error) {
+	return &model.OrderResult{OrderId: ordId, InstId: instId, Side: "sell", State: model.OrderFilled, FilledSize: f.sold}, nil
+}
+
+func (f *triggeredMarket) GetFills(_ context.Context, _, _ string) ([]model.OrderFill, error) {
+	return nil, nil
+}
+
+func TestTriggeredStopNotRestored(t *testing.T) {
+	// the exchange-side stop sold the position while the bot was down, coins bought by hand
+	// later are not the strategy's
+	market := &triggeredMarket{&fakeMarket{position: 2}, 1}
+	journal := &fakeJournal{}
+	journal.Record(context.Background(), &model.RunRecord{Pair: "BTC-USDT", Decision: &model.Decision{Action: "BUY", StopLossPrice: 90},
+		Order: &model.OrderRequest{}, OrderResult: &model.OrderResult{OrderId: "1", Side: "buy", FilledSize: 1}})
+	s := NewService(market, journal, Options{})
+	if err := s.Restore(context.Background(), journal, []currency.Pair{pair}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Levels(pair); ok {
+		t.Error("the position the stop sold should not be watched")
+	}
+	if len(journal.records) != 2 || journal.records[1].OrderResult.OrderId != "7" {
+		t.Errorf("expected the sell of the stop to be journaled, got %d entries", len(journal.records))
+	}
+	s.check(context.Background(), "BTC-USDT", 85)
+	if len(market.sent()) != 0 {
+		t.Errorf("sold coins that are not the strategy's: %v", market.sent())
+	}
+}
+
+func TestTrackAndRestore(t *testing.T) {
+	s := NewService(&fakeMarket{}, nil, Options{Strategy: "majors"})
+	s.Track(pair, model.Decision{Action: "BUY", StopLossPrice: 90}, &model.OrderResult{Side: "buy", FilledSize: 1})
+	s.Track(pair, model.Decision{Action: "HOLD"}, nil)
+	if l, ok := s.Levels(pair); !ok || l.StopLoss != 90 {
+		t.Fatalf("a HOLD without stop should keep the levels, got %+v", l)
+	}
+	s.Track(pair, model.Decision{Action: "HOLD", TakeProfitPrice: 130}, nil)
+	if l, _ := s.Levels(pair); l.StopLoss != 90 || l.TakeProfit != 130 {
+		t.Fatalf("a HOLD with only a take profit should keep the stop, got %+v", l)
+	}
+	s.Track(pair, model.Decision{Action: "SELL", PositionPct: 1}, &model.OrderResult{Side: "sell", FilledSize: 1})
+	if _, ok := s.Levels(pair); ok {
+		t.Fatal("a full SELL should clear the levels")
+	}
+
+	history := &fakeJournal{}
+	for _, r := range []*model.RunRecord{
+		{Pair: "BTC-USDT", Strategy: "majors", Decision: &model.Decision{Action: "BUY"}, OrderResult: &model.OrderResult{Side: "buy", FilledSize: 0.5}},
+		{Pair: "BTC-USDT", Strategy: "majors", Decision: &model.Decision{Action: "SELL", PositionPct: 1}, OrderResult: &model.OrderResult{Side: "sell", FilledSize: 0.5}},
+		{Pair: "BTC-USDT", Strategy: "majors", Decision: &model.Decision{Action: "BUY", StopLossPrice: 80, TakeProfitPrice: 150}, OrderResult: &model.OrderResult{Side: "buy", FilledSize: 0.3}},
+		{Pair: "BTC-USDT", Strategy: "majors", Decision: &model.Decision{Action: "HOLD", StopLossPrice: 95}, OrderError: "rejected"},
+		{Pair: "BTC-USDT", Strategy: "majors", Decision: &model.Decision{Action: "HOLD"}},
+		{Pair: "BTC-USDT", Strategy: "majors", Error: "llm timeout"},
+		{Pair: "BTC-USDT", Strategy: "majors", Decision: &model.Decision{Action: "BUY", StopLossPrice: 70}, OrderResult: &model.OrderResult{Side: "buy", FilledSize: 0.1}, Advisory: true},
+		// another strategy on the account
+		{Pair: "BTC-USDT", Strategy: "alts", Decision: &model.Decision{Action: "BUY", StopLossPrice: 60}, OrderResult: &model.OrderResult{Side: "buy", FilledSize: 2}},
+	} {
+		history.Record(context.Background(), r)
+	}
+	if err := s.Restore(context.Background(), history, []currency.Pair{pair}); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := s.Levels(pair); l.StopLoss != 80 || l.TakeProfit != 150 {
+		t.Errorf("expected the levels of the last executed decision, got %+v", l)
+	}
+	if w := s.watches["BTC-USDT"]; w.size != 0.3 {
+		t.Errorf("expected the size of the open position, got %v", w.size)
+	}
+
+	// an advisory guard only follows the advice
+	advisory := NewService(&fakeMarket{}, nil, Options{Strategy: "majors", Advisory: true})
+	if err := advisory.Restore(context.Background(), history, []currency.Pair{pair}); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := advisory.Levels(pair); l.StopLoss != 70 {
+		t.Errorf("expected the levels of the advice, got %+v", l)
+	}
+}
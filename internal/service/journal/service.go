@@ -0,0 +1,261 @@
+package journal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	_ "modernc.org/sqlite"
+	"strings"
+	"time"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	ts             INTEGER NOT NULL,
+	pair           TEXT    NOT NULL,
+	strategy       TEXT    NOT NULL DEFAULT '',
+	prompt         TEXT    NOT NULL DEFAULT '',
+	response       TEXT    NOT NULL DEFAULT '',
+	decision       TEXT,
+	order_request  TEXT,
+	order_result   TEXT,
+	order_error    TEXT    NOT NULL DEFAULT '',
+	balance_before TEXT,
+	balance_after  TEXT,
+	error          TEXT    NOT NULL DEFAULT '',
+	advisory       INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS runs_pair_ts ON runs (pair, ts);
+`
+
+// migrations add the columns newer than a journal created by an older version.
+var migrations = []struct {
+	column string
+	ddl    string
+}{
+	{"order_result", `ALTER TABLE runs ADD COLUMN order_result TEXT`},
+	{"advisory", `ALTER TABLE runs ADD COLUMN advisory INTEGER NOT NULL DEFAULT 0`},
+	{"strategy", `ALTER TABLE runs ADD COLUMN strategy TEXT NOT NULL DEFAULT ''`},
+}
+
+// Store is a SQLite backed journal of every run.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens (and creates if needed) the journal database at path.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("[journal.Open] %s: %w", path, err)
+	}
+	if err = migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("[journal.Open] %s: %w", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+func migrate(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('runs')`)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if existing[m.column] {
+			continue
+		}
+		if _, err = db.Exec(m.ddl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Record appends r and sets its ID.
+func (s *Store) Record(ctx context.Context, r *model.RunRecord) error {
+	if r.Ts.IsZero() {
+		r.Ts = time.Now()
+	}
+	decision, err := encode(r.Decision)
+	if err != nil {
+		return err
+	}
+	order, err := encode(r.Order)
+	if err != nil {
+		return err
+	}
+	result, err := encode(r.OrderResult)
+	if err != nil {
+		return err
+	}
+	before, err := encode(r.BalanceBefore)
+	if err != nil {
+		return err
+	}
+	after, err := encode(r.BalanceAfter)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `
+INSERT INTO runs (ts, pair, strategy, prompt, response, decision, order_request, order_result, order_error, balance_before, balance_after, error, advisory)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.Ts.UnixMilli(), r.Pair, r.Strategy, r.Prompt, r.Response, decision, order, result, r.OrderError, before, after, r.Error, r.Advisory)
+	if err != nil {
+		return fmt.Errorf("[journal.Record] %w", err)
+	}
+	r.ID, err = res.LastInsertId()
+	return err
+}
+
+// List returns the newest limit records, optionally only those of pair and of strategy.
+func (s *Store) List(ctx context.Context, pair, strategy string, limit int) ([]*model.RunRecord, error) {
+	query := `SELECT ` + columns + ` FROM runs`
+	where := make([]string, 0, 2)
+	args := make([]interface{}, 0, 3)
+	if pair != "" {
+		where = append(where, `pair = ?`)
+		args = append(args, pair)
+	}
+	if strategy != "" {
+		where = append(where, `strategy = ?`)
+		args = append(args, strategy)
+	}
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	query += ` ORDER BY ts DESC, id DESC LIMIT ?`
+	args = append(args, limit)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]*model.RunRecord, 0)
+	for rows.Next() {
+		r, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+// Executed returns every record of pair, and of strategy when it is set, that sent an order or
+// set a stop-loss or take-profit, newest first, over the whole journal: what a position and
+// its levels are restored from. The prompt, answers and the balance before are left out to
+// keep it light.
+func (s *Store) Executed(ctx context.Context, pair, strategy string) ([]*model.RunRecord, error) {
+	query := `SELECT ` + executedColumns + ` FROM runs WHERE pair = ?`
+	args := []interface{}{pair}
+	if strategy != "" {
+		query += ` AND strategy = ?`
+		args = append(args, strategy)
+	}
+	query += ` AND (order_request IS NOT NULL
+	OR json_extract(decision, '$.stop_loss_price') > 0 OR json_extract(decision, '$.take_profit_price') > 0)
+ORDER BY ts DESC, id DESC`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]*model.RunRecord, 0)
+	for rows.Next() {
+		r, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+// Get returns the record with id, or sql.ErrNoRows.
+func (s *Store) Get(ctx context.Context, id int64) (*model.RunRecord, error) {
+	return scan(s.db.QueryRowContext(ctx, `SELECT `+columns+` FROM runs WHERE id = ?`, id))
+}
+
+const columns = `id, ts, pair, strategy, prompt, response, decision, order_request, order_result, order_error, balance_before, balance_after, error, advisory`
+
+// executedColumns are columns without the prompt, answers and the balance before.
+const executedColumns = `id, ts, pair, strategy, '', '', decision, order_request, order_result, order_error, NULL, balance_after, error, advisory`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(row scanner) (*model.RunRecord, error) {
+	r := &model.RunRecord{}
+	var ts int64
+	var decision, order, result, before, after sql.NullString
+	err := row.Scan(&r.ID, &ts, &r.Pair, &r.Strategy, &r.Prompt, &r.Response, &decision, &order, &result, &r.OrderError, &before, &after, &r.Error, &r.Advisory)
+	if err != nil {
+		return nil, err
+	}
+	r.Ts = time.UnixMilli(ts)
+	if err = decode(decision, &r.Decision); err != nil {
+		return nil, err
+	}
+	if err = decode(order, &r.Order); err != nil {
+		return nil, err
+	}
+	if err = decode(result, &r.OrderResult); err != nil {
+		return nil, err
+	}
+	if err = decode(before, &r.BalanceBefore); err != nil {
+		return nil, err
+	}
+	if err = decode(after, &r.BalanceAfter); err != nil {
+		return nil, err
+	}
+	if r.Decision != nil {
+		r.Decision.Prompt = r.Prompt
+		r.Decision.Response = r.Response
+	}
+	return r, nil
+}
+
+// encode stores v as JSON, a nil pointer becomes NULL.
+func encode[T any](v *T) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func decode(s sql.NullString, v interface{}) error {
+	if !s.Valid {
+		return nil
+	}
+	return json.Unmarshal([]byte(s.String), v)
+}
@@ -0,0 +1,172 @@
+package journal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := Open(filepath.Join(t.TempDir(), "journal.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	base := time.UnixMilli(1700000000000)
+	buy := &model.RunRecord{
+		Ts:       base,
+		Pair:     "BTC-USDT",
+		Strategy: "majors",
+		Prompt:   "[system]\nprompt",
+		Response: `{"action":"BUY"}`,
+		Decision: &model.Decision{Action: "BUY", PositionPct: 0.5, StopLossPrice: 90, Amount: "500"},
+		Order:    &model.OrderRequest{InstId: "BTC-USDT", Side: "buy", Size: "500"},
+		OrderResult: &model.OrderResult{OrderId: "42", State: model.OrderFilled, FilledSize: 5, AvgPrice: 99.9,
+			Fills: []model.OrderFill{{TradeId: "1", Price: 99.9, Size: 5}}},
+		BalanceBefore: &model.TradeData{TotalEquity: 1000, AccountAssets: map[currency.Coin]*model.Asset{
+			currency.USDT: {Currency: currency.USDT, Equity: 1000, EquityUSD: 1000},
+		}},
+	}
+	failed := &model.RunRecord{Ts: base.Add(time.Hour), Pair: "ETH-USDT", Error: "llm timeout", Advisory: true}
+	for _, r := range []*model.RunRecord{buy, failed} {
+		if err = store.Record(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buy.ID == 0 || failed.ID == buy.ID {
+		t.Fatalf("unexpected ids %d, %d", buy.ID, failed.ID)
+	}
+
+	got, err := store.Get(ctx, buy.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Decision.Action != "BUY" || got.Decision.Prompt != buy.Prompt || got.Order.Size != "500" || got.BalanceAfter != nil {
+		t.Errorf("unexpected record %+v", got)
+	}
+	if got.OrderResult == nil || got.OrderResult.AvgPrice != 99.9 || len(got.OrderResult.Fills) != 1 {
+		t.Errorf("unexpected order result %+v", got.OrderResult)
+	}
+	if got.BalanceBefore.AccountAssets[currency.USDT].Equity != 1000 || !got.Ts.Equal(base) {
+		t.Errorf("unexpected balance or ts %+v", got)
+	}
+
+	all, err := store.List(ctx, "", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].ID != failed.ID || all[0].Decision != nil || all[0].Error != "llm timeout" || !all[0].Advisory || all[1].Advisory || all[1].Strategy != "majors" {
+		t.Errorf("unexpected list %+v", all)
+	}
+	btc, err := store.List(ctx, "BTC-USDT", "", 10)
+	if err != nil || len(btc) != 1 {
+		t.Errorf("expected one BTC record, got %d (%v)", len(btc), err)
+	}
+	majors, err := store.List(ctx, "", "majors", 10)
+	if err != nil || len(majors) != 1 || majors[0].ID != buy.ID {
+		t.Errorf("expected the record of the strategy, got %v (%v)", majors, err)
+	}
+	if none, err := store.List(ctx, "ETH-USDT", "majors", 10); err != nil || len(none) != 0 {
+		t.Errorf("expected no record, got %v (%v)", none, err)
+	}
+	if _, err = store.Get(ctx, 999); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestExecuted(t *testing.T) {
+	ctx := context.Background()
+	store, err := Open(filepath.Join(t.TempDir(), "journal.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	base := time.UnixMilli(1700000000000)
+	buy := &model.RunRecord{Ts: base, Pair: "BTC-USDT", Strategy: "majors", Prompt: "prompt",
+		Decision:    &model.Decision{Action: "BUY", PositionPct: 0.5},
+		Order:       &model.OrderRequest{InstId: "BTC-USDT", Side: "buy", Size: "500"},
+		OrderResult: &model.OrderResult{State: model.OrderFilled, FilledSize: 5}}
+	records := []*model.RunRecord{buy}
+	// more plain holds than any page of List, the buy must not drop out
+	for i := 1; i <= 600; i++ {
+		records = append(records, &model.RunRecord{Ts: base.Add(time.Duration(i) * time.Minute), Pair: "BTC-USDT", Strategy: "majors",
+			Decision: &model.Decision{Action: "HOLD"}})
+	}
+	stop := &model.RunRecord{Ts: base.Add(time.Hour * 24), Pair: "BTC-USDT", Strategy: "majors",
+		Decision: &model.Decision{Action: "HOLD", TakeProfitPrice: 120}}
+	other := &model.RunRecord{Ts: base, Pair: "BTC-USDT", Strategy: "alts",
+		Decision: &model.Decision{Action: "BUY"}, Order: &model.OrderRequest{InstId: "BTC-USDT", Side: "buy", Size: "100"}}
+	records = append(records, stop, other)
+	for _, r := range records {
+		if err = store.Record(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := store.Executed(ctx, "BTC-USDT", "majors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != stop.ID || got[1].ID != buy.ID {
+		t.Fatalf("expected the take-profit hold and the buy, got %+v", got)
+	}
+	if got[1].OrderResult.FilledSize != 5 || got[1].Order.Size != "500" || got[1].Prompt != "" {
+		t.Errorf("unexpected record %+v", got[1])
+	}
+	if all, err := store.Executed(ctx, "BTC-USDT", ""); err != nil || len(all) != 3 {
+		t.Errorf("expected the entries of every strategy, got %d (%v)", len(all), err)
+	}
+}
+
+func TestOpenMigrates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the runs table before order results were recorded
+	_, err = db.Exec(`
+CREATE TABLE runs (
+	id             INTEGER PRIMARY KEY AUTOINCREMENT,
+	ts             INTEGER NOT NULL,
+	pair           TEXT    NOT NULL,
+	prompt         TEXT    NOT NULL DEFAULT '',
+	response       TEXT    NOT NULL DEFAULT '',
+	decision       TEXT,
+	order_request  TEXT,
+	order_error    TEXT    NOT NULL DEFAULT '',
+	balance_before TEXT,
+	balance_after  TEXT,
+	error          TEXT    NOT NULL DEFAULT ''
+);
+INSERT INTO runs (ts, pair) VALUES (1700000000000, 'BTC-USDT');`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	old, err := store.List(context.Background(), "BTC-USDT", "", 10)
+	if err != nil || len(old) != 1 || old[0].OrderResult != nil || old[0].Strategy != "" {
+		t.Fatalf("old records should still be readable, got %v (%v)", old, err)
+	}
+	r := &model.RunRecord{Pair: "BTC-USDT", Strategy: "majors", OrderResult: &model.OrderResult{State: model.OrderCanceled}}
+	if err = store.Record(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.Get(context.Background(), r.ID); err != nil || got.OrderResult.State != model.OrderCanceled || got.Strategy != "majors" {
+		t.Errorf("unexpected record %+v (%v)", got, err)
+	}
+}
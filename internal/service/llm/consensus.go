@@ -0,0 +1,173 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/llm"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Aggregate names how the numeric fields of the agreeing decisions are combined.
+type Aggregate string
+
+const (
+	AggregateMedian Aggregate = "median"
+	AggregateMean   Aggregate = "mean"
+	AggregateMin    Aggregate = "min"
+	AggregateMax    Aggregate = "max"
+)
+
+// ConsensusRule configures how the answers of several advisors become one decision.
+// Only the advisors voting for the winning action contribute to the numeric fields.
+type ConsensusRule struct {
+	Quorum      int       // votes the winning action needs, 0 means a strict majority of all advisors
+	PositionPct Aggregate // defaults to median
+	StopLoss    Aggregate // defaults to max, the tightest (most conservative) stop for a long position
+	TakeProfit  Aggregate // defaults to median
+}
+
+type vote struct {
+	advisor  int
+	decision *model.Decision
+	err      error
+}
+
+// consensus asks every advisor concurrently and combines the parsed decisions. Advisors that
+// fail count as abstentions; without quorum the result is a HOLD that leaves stops untouched.
+func (gs *Service) consensus(ctx context.Context, msg []llm.Messages, price float64) (*model.Decision, error) {
+	votes := make([]vote, len(gs.advisors))
+	var wg sync.WaitGroup
+	for i, advisor := range gs.advisors {
+		wg.Add(1)
+		go func(i int, advisor Advisor) {
+			defer wg.Done()
+			d, err := gs.ask(ctx, advisor, msg, price)
+			votes[i] = vote{advisor: i, decision: d, err: err}
+		}(i, advisor)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	valid := make([]*model.Decision, 0, len(votes))
+	for _, v := range votes {
+		if v.err != nil {
+			log.Println(fmt.Sprintf("[llm.consensus] advisor #%d 弃权: %s", v.advisor, v.err.Error()))
+			continue
+		}
+		valid = append(valid, v.decision)
+	}
+	decision := gs.rule.combine(len(gs.advisors), valid)
+	responses := make([]string, 0, len(votes))
+	for _, v := range votes {
+		if v.err == nil {
+			responses = append(responses, fmt.Sprintf("advisor #%d:\n%s", v.advisor, v.decision.Response))
+		}
+	}
+	decision.Prompt = renderMessages(msg)
+	decision.Response = strings.Join(responses, responseSeparator)
+	for _, v := range votes {
+		if v.err == nil && v.decision.Action != decision.Action {
+			log.Println(fmt.Sprintf("[llm.consensus] advisor #%d 反对: %s (%.2f), 理由: %s",
+				v.advisor, v.decision.Action, v.decision.PositionPct, v.decision.Reason))
+		}
+	}
+	return decision, nil
+}
+
+// combine applies the rule to the valid decisions out of total advisors.
+func (r ConsensusRule) combine(total int, decisions []*model.Decision) *model.Decision {
+	quorum := r.Quorum
+	if quorum <= 0 {
+		quorum = total/2 + 1
+	}
+	counts := make(map[string]int)
+	for _, d := range decisions {
+		counts[d.Action]++
+	}
+	action, best, tie := "", 0, false
+	for a, n := range counts {
+		switch {
+		case n > best:
+			action, best, tie = a, n, false
+		case n == best:
+			tie = true
+		}
+	}
+	if best < quorum || tie {
+		summary := make([]string, 0, len(counts))
+		for a, n := range counts {
+			summary = append(summary, fmt.Sprintf("%s=%d", a, n))
+		}
+		sort.Strings(summary)
+		return &model.Decision{
+			Action: "HOLD",
+			Reason: fmt.Sprintf("No consensus (quorum %d of %d): %s", quorum, total, strings.Join(summary, ", ")),
+		}
+	}
+
+	agreeing := make([]*model.Decision, 0, best)
+	reasons := make([]string, 0, best)
+	for _, d := range decisions {
+		if d.Action == action {
+			agreeing = append(agreeing, d)
+			reasons = append(reasons, d.Reason)
+		}
+	}
+	field := func(get func(d *model.Decision) float64) []float64 {
+		res := make([]float64, 0, len(agreeing))
+		for _, d := range agreeing {
+			if v := get(d); v > 0 {
+				res = append(res, v)
+			}
+		}
+		return res
+	}
+	return &model.Decision{
+		Action:          action,
+		PositionPct:     aggregate(orDefault(r.PositionPct, AggregateMedian), field(func(d *model.Decision) float64 { return d.PositionPct })),
+		StopLossPrice:   aggregate(orDefault(r.StopLoss, AggregateMax), field(func(d *model.Decision) float64 { return d.StopLossPrice })),
+		TakeProfitPrice: aggregate(orDefault(r.TakeProfit, AggregateMedian), field(func(d *model.Decision) float64 { return d.TakeProfitPrice })),
+		Reason:          fmt.Sprintf("%d/%d advisors voted %s: %s", best, total, action, strings.Join(reasons, " | ")),
+	}
+}
+
+func orDefault(a, def Aggregate) Aggregate {
+	if a == "" {
+		return def
+	}
+	return a
+}
+
+// aggregate combines values, returning 0 for an empty slice.
+func aggregate(a Aggregate, values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	switch a {
+	case AggregateMin:
+		return sorted[0]
+	case AggregateMax:
+		return sorted[len(sorted)-1]
+	case AggregateMean:
+		var sum float64
+		for _, v := range sorted {
+			sum += v
+		}
+		return sum / float64(len(sorted))
+	default:
+		mid := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return (sorted[mid-1] + sorted[mid]) / 2
+		}
+		return sorted[mid]
+	}
+}
@@ -0,0 +1,85 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"github.com/twoonefour/sigmaflow/pkg/llm"
+	"testing"
+)
+
+type staticAdvisor struct {
+	reply string
+	err   error
+}
+
+func (a staticAdvisor) Chat(_ context.Context, _ []llm.Messages) (string, error) {
+	return a.reply, a.err
+}
+
+func holding() *model.TradeData {
+	return &model.TradeData{
+		TotalEquity: 1000,
+		AccountAssets: map[currency.Coin]*model.Asset{
+			currency.USDT: {Currency: currency.USDT, Equity: 1000, EquityUSD: 1000},
+			currency.BTC:  {Currency: currency.BTC},
+		},
+	}
+}
+
+func TestConsensusMajority(t *testing.T) {
+	service, err := NewConsensusClient(ConsensusRule{},
+		staticAdvisor{reply: `{"action":"BUY","position_pct":0.8,"stop_loss_price":90,"take_profit_price":130}`},
+		staticAdvisor{reply: `{"action":"BUY","position_pct":0.4,"stop_loss_price":95,"take_profit_price":120}`},
+		staticAdvisor{reply: `{"action":"BUY","position_pct":0.6,"stop_loss_price":85,"take_profit_price":140}`},
+		staticAdvisor{reply: `{"action":"SELL","position_pct":1.0}`},
+		staticAdvisor{err: errors.New("timeout")},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := service.Completion(context.Background(), currency.NewPair(currency.USDT, currency.BTC), holding(), model.CandleSeries{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Action != "BUY" || d.PositionPct != 0.6 || d.StopLossPrice != 95 || d.TakeProfitPrice != 130 {
+		t.Errorf("unexpected decision: %+v", d)
+	}
+	if d.Amount != "600" {
+		t.Errorf("amount = %s, want 600", d.Amount)
+	}
+}
+
+func TestConsensusNoQuorum(t *testing.T) {
+	cases := []struct {
+		name     string
+		rule     ConsensusRule
+		advisors []Advisor
+	}{
+		{"split vote", ConsensusRule{}, []Advisor{
+			staticAdvisor{reply: `{"action":"BUY","position_pct":1,"stop_loss_price":90}`},
+			staticAdvisor{reply: `{"action":"SELL","position_pct":1}`},
+		}},
+		{"abstentions", ConsensusRule{Quorum: 2}, []Advisor{
+			staticAdvisor{reply: `{"action":"BUY","position_pct":1,"stop_loss_price":90}`},
+			staticAdvisor{err: errors.New("boom")},
+			staticAdvisor{reply: "not json"},
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			service, err := NewConsensusClient(c.rule, c.advisors...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := service.Completion(context.Background(), currency.NewPair(currency.USDT, currency.BTC), holding(), model.CandleSeries{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Action != "HOLD" || d.StopLossPrice != 0 {
+				t.Errorf("expected HOLD without stop, got %+v", d)
+			}
+		})
+	}
+}
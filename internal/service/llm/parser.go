@@ -0,0 +1,161 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"strings"
+)
+
+// ErrNoJSON is returned when a response contains no JSON object at all.
+var ErrNoJSON = errors.New("no JSON object found in response")
+
+// ValidationError reports a decision that parsed but breaks the output contract.
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+// DecisionError is returned by Completion when the model still produced no valid decision
+// after all retries. Err is the last parse or validation error; Prompt and Response are kept
+// like on a decision, so the failed run can be journaled.
+type DecisionError struct {
+	Attempts int
+	Prompt   string
+	Response string // every raw response, see Decision.Response
+	Err      error
+}
+
+func (e *DecisionError) Error() string {
+	return fmt.Sprintf("no valid decision after %d attempts: %s", e.Attempts, e.Err.Error())
+}
+
+func (e *DecisionError) Unwrap() error {
+	return e.Err
+}
+
+// rawDecision accepts the field names the model is known to use interchangeably.
+type rawDecision struct {
+	Action           string   `json:"action"`
+	PositionPct      *float64 `json:"position_pct"`
+	Reason           string   `json:"reason"`
+	StopLossPrice    float64  `json:"stop_loss_price"`
+	TakeProfitPrice  float64  `json:"take_profit_price"`
+	TakeProfitTarget float64  `json:"take_profit_target"`
+}
+
+// ExtractJSON returns the first balanced JSON object in text, ignoring any preamble,
+// markdown fences or trailing commentary around it.
+func ExtractJSON(text string) (string, error) {
+	for start := strings.IndexByte(text, '{'); start >= 0; {
+		if end := closingBrace(text, start); end > 0 {
+			if candidate := text[start : end+1]; json.Valid([]byte(candidate)) {
+				return candidate, nil
+			}
+		}
+		next := strings.IndexByte(text[start+1:], '{')
+		if next < 0 {
+			break
+		}
+		start += next + 1
+	}
+	return "", ErrNoJSON
+}
+
+// closingBrace returns the index of the brace closing the one at start, or -1.
+func closingBrace(text string, start int) int {
+	depth, inString, escaped := 0, false, false
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// ParseDecision extracts and validates a decision from a model response. price is the
+// current price used to sanity check the stop loss; 0 skips the price checks.
+func ParseDecision(text string, price float64) (*model.Decision, error) {
+	obj, err := ExtractJSON(text)
+	if err != nil {
+		return nil, err
+	}
+	var raw rawDecision
+	if err = json.Unmarshal([]byte(obj), &raw); err != nil {
+		return nil, err
+	}
+
+	decision := &model.Decision{
+		Action:          strings.ToUpper(strings.TrimSpace(raw.Action)),
+		Reason:          raw.Reason,
+		StopLossPrice:   raw.StopLossPrice,
+		TakeProfitPrice: raw.TakeProfitPrice,
+	}
+	if decision.TakeProfitPrice == 0 {
+		decision.TakeProfitPrice = raw.TakeProfitTarget
+	}
+	if raw.PositionPct != nil {
+		decision.PositionPct = *raw.PositionPct
+	}
+	if err = validate(decision, raw.PositionPct != nil, price); err != nil {
+		return nil, err
+	}
+	return decision, nil
+}
+
+func validate(d *model.Decision, hasPct bool, price float64) error {
+	switch d.Action {
+	case "BUY", "SELL", "HOLD":
+	default:
+		return &ValidationError{Field: "action", Reason: fmt.Sprintf("%q is not one of BUY, SELL, HOLD", d.Action)}
+	}
+	if d.Action != "HOLD" && !hasPct {
+		return &ValidationError{Field: "position_pct", Reason: "missing"}
+	}
+	if d.PositionPct < 0 || d.PositionPct > 1 {
+		return &ValidationError{Field: "position_pct", Reason: fmt.Sprintf("%v is outside [0, 1]", d.PositionPct)}
+	}
+	if d.StopLossPrice < 0 {
+		return &ValidationError{Field: "stop_loss_price", Reason: "must not be negative"}
+	}
+	if d.TakeProfitPrice < 0 {
+		return &ValidationError{Field: "take_profit_price", Reason: "must not be negative"}
+	}
+	if d.Action == "BUY" {
+		if d.PositionPct == 0 {
+			return &ValidationError{Field: "position_pct", Reason: "BUY with position_pct 0"}
+		}
+		if d.StopLossPrice == 0 {
+			return &ValidationError{Field: "stop_loss_price", Reason: "mandatory for BUY"}
+		}
+	}
+	if price <= 0 || d.Action == "SELL" {
+		return nil
+	}
+	if d.StopLossPrice >= price {
+		return &ValidationError{Field: "stop_loss_price", Reason: fmt.Sprintf("%.2f must be below the current price %.2f", d.StopLossPrice, price)}
+	}
+	if d.TakeProfitPrice > 0 && d.TakeProfitPrice <= price {
+		return &ValidationError{Field: "take_profit_price", Reason: fmt.Sprintf("%.2f must be above the current price %.2f", d.TakeProfitPrice, price)}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"github.com/twoonefour/sigmaflow/pkg/llm"
+	"strings"
+	"testing"
+)
+
+func TestExtractJSON(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain", `{"action":"HOLD"}`, `{"action":"HOLD"}`},
+		{"fenced", "```json\n{\"action\":\"HOLD\"}\n```", `{"action":"HOLD"}`},
+		{"preamble and trailer", "Here is my analysis:\n{\"action\":\"SELL\"}\nLet me know.", `{"action":"SELL"}`},
+		{"braces in strings", `{"reason":"range {low} \"}\" break","action":"BUY"}`, `{"reason":"range {low} \"}\" break","action":"BUY"}`},
+		{"skips invalid object", `{not json} {"action":"HOLD"}`, `{"action":"HOLD"}`},
+		{"nested", `{"a":{"b":1},"action":"HOLD"} {"x":2}`, `{"a":{"b":1},"action":"HOLD"}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := ExtractJSON(c.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("ExtractJSON() = %s, want %s", got, c.want)
+			}
+		})
+	}
+	if _, err := ExtractJSON("no json here {"); !errors.Is(err, ErrNoJSON) {
+		t.Errorf("expected ErrNoJSON, got %v", err)
+	}
+}
+
+func TestParseDecision(t *testing.T) {
+	cases := []struct {
+		name    string
+		text    string
+		field   string // expected ValidationError field, empty for success
+		wantTP  float64
+		wantAct string
+	}{
+		{"buy", `{"action":"BUY","position_pct":0.5,"stop_loss_price":90,"take_profit_price":120}`, "", 120, "BUY"},
+		{"take_profit_target alias", `{"action":"buy","position_pct":0.5,"stop_loss_price":90,"take_profit_target":125}`, "", 125, "BUY"},
+		{"hold without pct", `{"action":"HOLD","stop_loss_price":95}`, "", 0, "HOLD"},
+		{"sell ignores stop", `{"action":"SELL","position_pct":1,"stop_loss_price":150}`, "", 0, "SELL"},
+		{"unknown action", `{"action":"WAIT","position_pct":0}`, "action", 0, ""},
+		{"pct out of range", `{"action":"BUY","position_pct":50,"stop_loss_price":90}`, "position_pct", 0, ""},
+		{"missing pct", `{"action":"SELL"}`, "position_pct", 0, ""},
+		{"buy without stop", `{"action":"BUY","position_pct":0.5}`, "stop_loss_price", 0, ""},
+		{"stop above price", `{"action":"BUY","position_pct":0.5,"stop_loss_price":110}`, "stop_loss_price", 0, ""},
+		{"take profit below price", `{"action":"BUY","position_pct":0.5,"stop_loss_price":90,"take_profit_price":99}`, "take_profit_price", 0, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d, err := ParseDecision(c.text, 100)
+			if c.field != "" {
+				var vErr *ValidationError
+				if !errors.As(err, &vErr) || vErr.Field != c.field {
+					t.Fatalf("expected validation error on %s, got %v", c.field, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Action != c.wantAct || d.TakeProfitPrice != c.wantTP {
+				t.Errorf("unexpected decision %+v", d)
+			}
+		})
+	}
+}
+
+type sequenceAdvisor struct {
+	replies []string
+	seen    [][]llm.Messages
+}
+
+func (a *sequenceAdvisor) Chat(_ context.Context, messages []llm.Messages) (string, error) {
+	a.seen = append(a.seen, messages)
+	reply := a.replies[0]
+	if len(a.replies) > 1 {
+		a.replies = a.replies[1:]
+	}
+	return reply, nil
+}
+
+func TestCompletionRetry(t *testing.T) {
+	pair := currency.NewPair(currency.USDT, currency.BTC)
+	advisor := &sequenceAdvisor{replies: []string{
+		`I think we should buy.`,
+		`{"action":"BUY","position_pct":0.5,"stop_loss_price":90}`,
+	}}
+	service, _ := NewClient(advisor)
+	d, err := service.Completion(context.Background(), pair, holding(), model.CandleSeries{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Action != "BUY" || len(advisor.seen) != 2 {
+		t.Fatalf("unexpected decision %+v after %d calls", d, len(advisor.seen))
+	}
+	retry := advisor.seen[1]
+	if len(retry) != 4 || retry[2].Role != llm.RoleAssistant || retry[3].Role != llm.RoleUser {
+		t.Errorf("retry should carry the bad answer and the correction, got %+v", retry)
+	}
+
+	advisor = &sequenceAdvisor{replies: []string{`{"action":"MAYBE"}`}}
+	service, _ = NewClient(advisor)
+	_, err = service.Completion(context.Background(), pair, holding(), model.CandleSeries{})
+	var dErr *DecisionError
+	if !errors.As(err, &dErr) || dErr.Attempts != defaultMaxRetries+1 || len(advisor.seen) != defaultMaxRetries+1 {
+		t.Fatalf("expected DecisionError after %d attempts, got %v", defaultMaxRetries+1, err)
+	}
+	if dErr.Prompt == "" || strings.Count(dErr.Response, "MAYBE") != defaultMaxRetries+1 {
+		t.Errorf("the prompt and every answer should be kept, got %q", dErr.Response)
+	}
+
+	advisor = &sequenceAdvisor{replies: []string{`{"action":"MAYBE"}`}}
+	service, _ = NewClient(advisor)
+	service.SetMaxRetries(-1)
+	_, err = service.Completion(context.Background(), pair, holding(), model.CandleSeries{})
+	if !errors.As(err, &dErr) || dErr.Attempts != 1 || dErr.Err == nil || len(advisor.seen) != 1 {
+		t.Fatalf("a negative retry count should still ask once, got %v", err)
+	}
+}
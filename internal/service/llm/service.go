@@ -1,30 +1,31 @@
 package llm
 
 import (
+	"cmp"
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/twoonefour/sigmaflow/internal/model"
 	"github.com/twoonefour/sigmaflow/pkg/currency"
 	"github.com/twoonefour/sigmaflow/pkg/llm"
+	"log"
+	"slices"
 	"strconv"
 	"strings"
-	"time"
 )
 
-const systemPrompt = `
+var systemPromptTemplate = `
 ### Role
-You are a seasoned **Technical Analyst & Swing Trader**. You trade the daily timeframe with a **Right-Side (Trend Following)** philosophy. You are not a bot that follows rigid rules; you are a risk manager who identifies high-probability setups.
+You are a seasoned **Technical Analyst & Swing Trader**. You trade the %[1]s timeframe with a **Right-Side (Trend Following)** philosophy. You are not a bot that follows rigid rules; you are a risk manager who identifies high-probability setups.
 
 ### Your Trading Philosophy
 1. **Flow with the Market:** We buy strength and sell weakness. We do not guess bottoms in a downtrend.
 2. **Price Action First:** Candlestick patterns (Engulfing, Pinbar, Marubozu) and Market Structure (Higher Highs/Lows) are more important than lagging indicators.
 3. **Volume is Truth:** A breakout without volume is suspicious. A drop with heavy volume is dangerous.
 4. **Context Matters:** A signal near a key support/resistance level (MA50, MA200, Bollinger Mid) carries more weight.
-5. **Timezone:** You will make decisions at 0:01 UTC+0 (sync with the last daily close).
+5. **Timezone:** You will make decisions one minute after each %[1]s close in UTC+0.
 
 ### Task
-Analyze the provided 30-day market data (Row 0 is the NEWEST candle, and always indicate the current price).
+Analyze the provided %[2]s market data (Row 0 is the NEWEST candle, and always indicate the current price).
 - **Assess the Trend:** Is the asset in an Accumulation, Uptrend, Distribution, or Downtrend phase?
 - **Evaluate Momentum:** Is the trend accelerating or exhausting?
 - **Identify Key Events:** Breakouts, Support Bounces, Moving Average crossovers, Bollinger Band squeezes/expansions.
@@ -54,27 +55,38 @@ Strictly output a JSON object:
   "action": "BUY" | "SELL" | "HOLD",
   "position_pct": <float 0.0 to 1.0>, // DYNAMIC VALUE based on Conviction Level defined above. Do NOT simply output 0.5.
   "stop_loss_price": <float>, // MANDATORY for BUY/HOLD.
-  "take_profit_target": <float>, // The immediate technical resistance level.
+  "take_profit_price": <float>, // The immediate technical resistance level.
   "reason": "<Concise analysis explaining the Trend, Volume, and why you chose this specific position_pct>"
 }
 
 ### Note
-- If action is "SELL", position_pct represents the % of current holdings to sell (usually 1.0 to exit all, or 0.5 to take partial profits).
+- If action is "SELL", position_pct represents the %% of current holdings to sell (usually 1.0 to exit all, or 0.5 to take partial profits).
 - If Current Position is None, means no currency position is holding.
 `
 
 var userContentTemplate = `
 Context:
 - Timezone: UTC+0 Close
-- Date Range: Last 30 Days (Row 0 is the most recent closed candle)
+- Date Range: %s (Row 0 is the most recent closed candle)
 
 Account Status:
 %s
 
 Dataset:
-Format: Date, Open, High, Low, Close, Status, Volume, MA5, MA50, MA200, bb upper bound, bb Middle Band, bb Lower Band
-%s
-`
+%s%s`
+
+var datasetTemplate = `Format: Date, Open, High, Low, Close, Status, Volume, MA5, MA50, MA200, bb upper bound, bb Middle Band, bb Lower Band%s
+%s`
+
+// contextTemplate introduces the extra timeframes, highest first.
+var contextTemplate = `
+Multi-Timeframe Context:
+The Dataset above is the %s timeframe you trade on. Use the higher timeframes below to judge whether the trend is aligned and the lower ones to time the entry.
+%s`
+
+var frameTemplate = `
+### %s (%s)
+%s`
 
 var accountTemplate = `
 Total Equity(USD): %.2f
@@ -85,14 +97,35 @@ Current Position:
 
 var positionTemplate = `
 - Asset: %s
-- Average Entry Price: %.2f
+%s- Equity(usd): %.2f
+`
+
+// entryTemplate is the part of positionTemplate about the entry, left out when the exchange
+// reports no entry price, e.g. Binance.
+var entryTemplate = `- Average Entry Price: %.2f
 - Unrealized PnL: %.2f
 - PnL Ratio: %.2f
-- Equity(usd): %.2f
 `
 
+// pnlTemplate is the unrealized PnL of an exchange that reports it without the entry price.
+var pnlTemplate = `- Unrealized PnL: %.2f
+`
+
+var correctionTemplate = `
+Your previous answer could not be used: %s
+Reply again with ONLY the JSON object described in the Output Format, without any other text.
+`
+
+// responseSeparator joins several raw answers (retries, consensus votes) in Decision.Response.
+const responseSeparator = "\n\n---\n\n"
+
+// defaultMaxRetries is how many times a model is re-prompted after an invalid answer.
+const defaultMaxRetries = 2
+
 type Service struct {
-	advisor Advisor
+	advisors   []Advisor
+	rule       ConsensusRule
+	maxRetries int
 }
 
 type Advisor interface {
@@ -101,57 +134,85 @@ type Advisor interface {
 
 func NewClient(advisor Advisor) (*Service, error) {
 	_geminiClient := &Service{
-		advisor: advisor,
+		advisors:   []Advisor{advisor},
+		maxRetries: defaultMaxRetries,
 	}
 	return _geminiClient, nil
 }
 
-func (gs *Service) Completion(ctx context.Context, pair currency.Pair, holding *model.TradeData, candle []model.CandleWithIndicator) (*model.Decision, error) {
+// NewConsensusClient creates a Service that asks every advisor and combines their answers with rule.
+func NewConsensusClient(rule ConsensusRule, advisors ...Advisor) (*Service, error) {
+	if len(advisors) == 0 {
+		return nil, fmt.Errorf("[llm.NewConsensusClient] no advisor")
+	}
+	if rule.Quorum > len(advisors) {
+		return nil, fmt.Errorf("[llm.NewConsensusClient] quorum %d exceeds %d advisors", rule.Quorum, len(advisors))
+	}
+	return &Service{
+		advisors:   advisors,
+		rule:       rule,
+		maxRetries: defaultMaxRetries,
+	}, nil
+}
+
+// SetMaxRetries sets how many times an advisor is re-prompted after an invalid answer. A
+// negative n counts as 0: the advisor is always asked once.
+func (gs *Service) SetMaxRetries(n int) {
+	gs.maxRetries = max(n, 0)
+}
+
+// Completion asks for a decision on the series timeframe. frames are further timeframes shown
+// as separate tables for context, see trade.WithContext.
+func (gs *Service) Completion(ctx context.Context, pair currency.Pair, holding *model.TradeData, series model.CandleSeries, frames ...model.CandleSeries) (*model.Decision, error) {
+	candle := series.Candles
 	remainQuote := holding.AccountAssets[pair.Quote].Equity
 	remainBase := holding.AccountAssets[pair.Base].EquityUSD
 	var position string
 	if remainQuote < 0.01 {
 		position = "None"
 	} else {
-		position = fmt.Sprintf(positionTemplate,
-			pair.Quote.String(),
-			holding.AccountAssets[pair.Quote].AVGPrice,
-			holding.AccountAssets[pair.Quote].UnrealizedPNL,
-			holding.AccountAssets[pair.Quote].UnrealizedPNLRatio,
-			remainQuote)
+		asset := holding.AccountAssets[pair.Quote]
+		var entry string
+		switch {
+		case asset.AVGPrice > 0:
+			entry = fmt.Sprintf(entryTemplate, asset.AVGPrice, asset.UnrealizedPNL, asset.UnrealizedPNLRatio)
+		case asset.UnrealizedPNL != 0:
+			entry = fmt.Sprintf(pnlTemplate, asset.UnrealizedPNL)
+		}
+		position = fmt.Sprintf(positionTemplate, pair.Quote.String(), entry, remainQuote)
 	}
 	accountStr := fmt.Sprintf(accountTemplate, holding.TotalEquity, remainBase, position)
-	var candleStr strings.Builder
-	for i, c := range candle {
-		tsInt, _ := strconv.ParseInt(c.Ts, 10, 64)
-		dateStr := time.UnixMilli(tsInt).Format("2006-01-02")
-		status := "Closed"
-		if i == 0 {
-			status = "Unconfirmed"
+	var contextStr string
+	if len(frames) > 0 {
+		frames = slices.Clone(frames)
+		slices.SortStableFunc(frames, func(a, b model.CandleSeries) int {
+			return cmp.Compare(b.Timeframe.Duration(), a.Timeframe.Duration())
+		})
+		var frameStr strings.Builder
+		for _, f := range frames {
+			label := f.Timeframe.Label()
+			frameStr.WriteString(fmt.Sprintf(frameTemplate, strings.ToUpper(label[:1])+label[1:], f.Timeframe.Range(len(f.Candles)), renderDataset(f)))
 		}
-		line := fmt.Sprintf("%s, %.2f, %.2f, %.2f, %.2f, %s, %.2f, %.2f, %.2f, %.2f, %.2f, %.2f, %.2f\n",
-			dateStr, c.O, c.H, c.L, c.C, status, c.Vol, c.MA5, c.MA50, c.MA200, c.BBUpper, c.BBMid, c.BBLower)
-		candleStr.WriteString(line)
+		contextStr = fmt.Sprintf(contextTemplate, series.Timeframe.Label(), frameStr.String())
 	}
 
 	msg := []llm.Messages{
-		{Content: systemPrompt, Role: llm.RoleSystem},
-		{Content: fmt.Sprintf(userContentTemplate, accountStr, candleStr.String()), Role: llm.RoleUser},
+		{Content: fmt.Sprintf(systemPromptTemplate, series.Timeframe.Label(), series.Timeframe.Span(len(candle))), Role: llm.RoleSystem},
+		{Content: fmt.Sprintf(userContentTemplate, series.Timeframe.Range(len(candle)), accountStr, renderDataset(series), contextStr), Role: llm.RoleUser},
 	}
 
-	res, err := gs.advisor.Chat(ctx, msg)
-	if err != nil {
-		return nil, err
+	var price float64
+	if len(candle) > 0 {
+		price = candle[0].C
 	}
 	var decision *model.Decision
-
-	if strings.Contains(res, "`") {
-		res = strings.Trim(res, "`")
-		res = strings.Trim(res, "json")
+	var err error
+	if len(gs.advisors) == 1 {
+		decision, err = gs.ask(ctx, gs.advisors[0], msg, price)
+	} else {
+		decision, err = gs.consensus(ctx, msg, price)
 	}
-	resp := []byte(res)
-
-	if err = json.Unmarshal(resp, &decision); err != nil {
+	if err != nil {
 		return nil, err
 	}
 	switch decision.Action {
@@ -164,3 +225,71 @@ func (gs *Service) Completion(ctx context.Context, pair currency.Pair, holding *
 
 	return decision, nil
 }
+
+// ask queries advisor and parses its answer, re-prompting it with the parse or validation
+// error up to maxRetries times. Transport errors from the advisor are returned as is.
+func (gs *Service) ask(ctx context.Context, advisor Advisor, msg []llm.Messages, price float64) (*model.Decision, error) {
+	conversation := append([]llm.Messages{}, msg...)
+	responses := make([]string, 0, 1)
+	var res string
+	var err error
+	for attempt := 0; attempt <= gs.maxRetries; attempt++ {
+		res, err = advisor.Chat(ctx, conversation)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, res)
+		decision, parseErr := ParseDecision(res, price)
+		if parseErr == nil {
+			decision.Prompt = renderMessages(msg)
+			decision.Response = strings.Join(responses, responseSeparator)
+			return decision, nil
+		}
+		err = parseErr
+		log.Println(fmt.Sprintf("[llm.ask] 第%d次回答无效: %s", attempt+1, err.Error()))
+		conversation = append(conversation,
+			llm.Messages{Role: llm.RoleAssistant, Content: res},
+			llm.Messages{Role: llm.RoleUser, Content: fmt.Sprintf(correctionTemplate, err.Error())},
+		)
+	}
+	return nil, &DecisionError{
+		Attempts: gs.maxRetries + 1,
+		Prompt:   renderMessages(msg),
+		Response: strings.Join(responses, responseSeparator),
+		Err:      err,
+	}
+}
+
+// renderDataset formats series as the Format header followed by one row per candle.
+func renderDataset(series model.CandleSeries) string {
+	var candleStr strings.Builder
+	for i, c := range series.Candles {
+		dateStr := series.Timeframe.FormatTs(c.Ts)
+		status := "Closed"
+		if i == 0 {
+			status = "Unconfirmed"
+		}
+		line := fmt.Sprintf("%s, %.2f, %.2f, %.2f, %.2f, %s, %.2f, %.2f, %.2f, %.2f, %.2f, %.2f, %.2f",
+			dateStr, c.O, c.H, c.L, c.C, status, c.Vol, c.MA5, c.MA50, c.MA200, c.BBUpper, c.BBMid, c.BBLower)
+		candleStr.WriteString(line)
+		for _, e := range c.Extra {
+			candleStr.WriteString(fmt.Sprintf(", %.2f", e.Value))
+		}
+		candleStr.WriteString("\n")
+	}
+	var extraHeader strings.Builder
+	if len(series.Candles) > 0 {
+		for _, e := range series.Candles[0].Extra {
+			extraHeader.WriteString(", " + e.Name)
+		}
+	}
+	return fmt.Sprintf(datasetTemplate, extraHeader.String(), candleStr.String())
+}
+
+func renderMessages(msg []llm.Messages) string {
+	var sb strings.Builder
+	for _, m := range msg {
+		sb.WriteString(fmt.Sprintf("[%s]\n%s\n", m.Role.ToString(), strings.TrimSpace(m.Content)))
+	}
+	return sb.String()
+}
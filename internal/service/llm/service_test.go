@@ -0,0 +1,91 @@
+package llm
+
+import (
+	"context"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"strings"
+	"testing"
+)
+
+func TestCompletionTimeframe(t *testing.T) {
+	advisor := &sequenceAdvisor{replies: []string{`{"action":"HOLD","stop_loss_price":90}`}}
+	service, _ := NewClient(advisor)
+	candle := model.CandleWithIndicator{Candlestick: model.Candlestick{Ts: "1710000000000", C: 100}}
+	series := model.CandleSeries{Timeframe: model.Hour4, Candles: []model.CandleWithIndicator{candle, candle}}
+	if _, err := service.Completion(context.Background(), currency.NewPair(currency.USDT, currency.BTC), holding(), series); err != nil {
+		t.Fatal(err)
+	}
+	system, user := advisor.seen[0][0].Content, advisor.seen[0][1].Content
+	for _, want := range []string{"trade the 4-hour timeframe", "provided 2-candle 4-hour market data", "the % of current holdings"} {
+		if !strings.Contains(system, want) {
+			t.Errorf("system prompt is missing %q", want)
+		}
+	}
+	if !strings.Contains(user, "Last 2 4-hour Candles") || !strings.Contains(user, "2024-03-09 16:00, 0.00") {
+		t.Errorf("user prompt does not follow the timeframe:\n%s", user)
+	}
+}
+
+func TestCompletionFrames(t *testing.T) {
+	advisor := &sequenceAdvisor{replies: []string{`{"action":"HOLD","stop_loss_price":90}`}}
+	service, _ := NewClient(advisor)
+	candle := model.CandleWithIndicator{Candlestick: model.Candlestick{Ts: "1710000000000", C: 100}}
+	rsi := candle
+	rsi.Extra = []model.IndicatorValue{{Name: "RSI14", Value: 55}}
+	daily := model.CandleSeries{Timeframe: model.Day1, Candles: []model.CandleWithIndicator{candle}}
+	h4 := model.CandleSeries{Timeframe: model.Hour4, Candles: []model.CandleWithIndicator{candle}}
+	weekly := model.CandleSeries{Timeframe: model.Week1, Candles: []model.CandleWithIndicator{rsi}}
+	if _, err := service.Completion(context.Background(), currency.NewPair(currency.USDT, currency.BTC), holding(), daily, h4, weekly); err != nil {
+		t.Fatal(err)
+	}
+	user := advisor.seen[0][1].Content
+	dataset := strings.Index(user, "Dataset:")
+	context := strings.Index(user, "The Dataset above is the daily timeframe")
+	week := strings.Index(user, "### Weekly (Last 1 Weeks)")
+	hour := strings.Index(user, "### 4-hour (Last 1 4-hour Candles)")
+	if dataset < 0 || context < dataset || week < context || hour < week {
+		t.Fatalf("expected the daily dataset followed by the weekly and 4-hour tables:\n%s", user)
+	}
+	if !strings.Contains(user[week:hour], "bb Lower Band, RSI14\n2024-03-09, ") {
+		t.Errorf("weekly table should carry its own indicators:\n%s", user[week:hour])
+	}
+}
+
+func TestCompletionPosition(t *testing.T) {
+	pair := currency.NewPair(currency.USDT, currency.BTC)
+	candle := model.CandleWithIndicator{Candlestick: model.Candlestick{Ts: "1710000000000", C: 100}}
+	series := model.CandleSeries{Timeframe: model.Hour4, Candles: []model.CandleWithIndicator{candle}}
+	cases := []struct {
+		name    string
+		asset   model.Asset
+		want    []string
+		missing []string
+	}{
+		{"entry price", model.Asset{Equity: 0.5, AVGPrice: 90, UnrealizedPNL: 5, UnrealizedPNLRatio: 0.11}, []string{"Average Entry Price: 90.00", "Unrealized PnL: 5.00", "PnL Ratio: 0.11"}, nil},
+		{"no entry price", model.Asset{Equity: 0.5}, nil, []string{"Average Entry Price", "PnL"}},
+		{"pnl only", model.Asset{Equity: 0.5, UnrealizedPNL: 5}, []string{"Unrealized PnL: 5.00"}, []string{"Average Entry Price", "PnL Ratio"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			advisor := &sequenceAdvisor{replies: []string{`{"action":"HOLD","stop_loss_price":90}`}}
+			service, _ := NewClient(advisor)
+			h := holding()
+			h.AccountAssets[currency.BTC] = &c.asset
+			if _, err := service.Completion(context.Background(), pair, h, series); err != nil {
+				t.Fatal(err)
+			}
+			user := advisor.seen[0][1].Content
+			for _, w := range c.want {
+				if !strings.Contains(user, w) {
+					t.Errorf("prompt is missing %q:\n%s", w, user)
+				}
+			}
+			for _, m := range c.missing {
+				if strings.Contains(user, m) {
+					t.Errorf("prompt should leave out %q:\n%s", m, user)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"resty.dev/v3"
+	"time"
+)
+
+// Webhook posts messages to a chat webhook as {"text": "..."}, the payload of Slack incoming
+// webhooks and of the Slack compatible endpoints of Mattermost, Discord (/slack) and others.
+type Webhook struct {
+	url    string
+	client *resty.Client
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		url:    url,
+		client: resty.New().SetTimeout(10 * time.Second),
+	}
+}
+
+// Send posts text.
+func (w *Webhook) Send(ctx context.Context, text string) error {
+	resp, err := w.client.R().WithContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(map[string]string{"text": text}).
+		Post(w.url)
+	if err != nil {
+		return fmt.Errorf("[notify.Send] %w", err)
+	}
+	if resp.IsError() {
+		return fmt.Errorf("[notify.Send] http %d: %s", resp.StatusCode(), resp.String())
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSend(t *testing.T) {
+	var got map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		if got["text"] == "fail" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	w := NewWebhook(server.URL)
+	if err := w.Send(context.Background(), "BTC-USDT buy"); err != nil {
+		t.Fatal(err)
+	}
+	if got["text"] != "BTC-USDT buy" {
+		t.Errorf("unexpected payload %v", got)
+	}
+	if err := w.Send(context.Background(), "fail"); err == nil {
+		t.Error("a rejected message should fail")
+	}
+}
@@ -0,0 +1,86 @@
+package trade
+
+import (
+	"context"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"log"
+	"strings"
+)
+
+// Normalizer is implemented by markets that round order sizes to the rules of the
+// instrument, e.g. the okx, binance and bybit clients.
+type Normalizer interface {
+	// NormalizeOrder returns sz as Order would send it, or the error Order would fail
+	// with, e.g. model.ErrBelowMinimum, without placing the order.
+	NormalizeOrder(ctx context.Context, instId, side, sz string) (string, error)
+}
+
+// Shadow is the portfolio an advisory service trades instead of the market, e.g. a
+// paper.Client on the prices of the market.
+type Shadow interface {
+	GetBalance(ctx context.Context, coin ...currency.Coin) (*model.TradeData, error)
+	Order(ctx context.Context, instId, side, sz string) (*model.OrderResult, error)
+}
+
+// WithAdvisory makes the service advisory-only: it never places an order on the market.
+// Decisions are sized against shadow, and Order logs the order it would have sent, with the
+// size normalized to the rules of the market (see Normalizer) and the stop-loss and
+// take-profit, then fills it in shadow. Journal entries are marked as advisory, so the
+// advice can be compared with the live account over time.
+func WithAdvisory(shadow Shadow) Option {
+	return func(s *Service) {
+		s.shadow = shadow
+	}
+}
+
+// Advisory reports whether the service only advises, see WithAdvisory.
+func (o *Service) Advisory() bool {
+	return o.shadow != nil
+}
+
+// account is the portfolio balances are read from and orders are sent to: the market, or
+// the shadow portfolio of an advisory service.
+func (o *Service) account() Shadow {
+	if o.shadow != nil {
+		return o.shadow
+	}
+	return o.market
+}
+
+// advise is Order of an advisory service. Exchange-side stops are never touched, the
+// watcher follows the levels in the shadow portfolio instead.
+func (o *Service) advise(ctx context.Context, pair currency.Pair, decision model.Decision) (*model.OrderResult, error) {
+	instId := InstId(pair)
+	switch decision.Action {
+	case "HOLD":
+		o.track(pair, decision, nil)
+		return nil, nil
+	case "BUY", "SELL":
+	default:
+		return nil, fmt.Errorf("[trade.Order] unknown action %q", decision.Action)
+	}
+	side := strings.ToLower(decision.Action)
+	sz, err := o.normalize(ctx, instId, side, decision.Amount)
+	if err != nil {
+		return nil, err
+	}
+	log.Println(fmt.Sprintf("[trade.advise] 建议 %s %s %s, 止损: %.2f, 止盈: %.2f",
+		instId, side, sz, decision.StopLossPrice, decision.TakeProfitPrice))
+	res, err := o.marketOrder(ctx, instId, side, sz)
+	if err != nil {
+		return res, err
+	}
+	o.track(pair, decision, res)
+	return res, nil
+}
+
+// normalize returns sz as the market would take it, sz itself when the market does not say.
+func (o *Service) normalize(ctx context.Context, instId, side, sz string) (string, error) {
+	n, ok := o.market.(Normalizer)
+	if !ok {
+		return sz, nil
+	}
+	return n.NormalizeOrder(ctx, instId, side, sz)
+}
@@ -0,0 +1,65 @@
+package trade
+
+import (
+	"fmt"
+	"github.com/twoonefour/sigmaflow/pkg/indicator"
+	"strings"
+)
+
+// WithIndicators adds the named indicators (indicator.Name* constants) to every candle
+// returned by GetCandle, in the given order. Context frames use their own Strategy.Indicators.
+func WithIndicators(names ...string) Option {
+	return func(s *Service) {
+		s.strategy.Indicators = names
+	}
+}
+
+// column is one extra indicator series, aligned with the oldest-first input series.
+type column struct {
+	name   string
+	values []float64
+}
+
+func extraColumns(names []string, high, low, closes, volume []float64) ([]column, error) {
+	res := make([]column, 0, len(names))
+	for _, name := range names {
+		switch strings.ToUpper(name) {
+		case indicator.NameEMA:
+			res = append(res,
+				column{"EMA12", indicator.CalculateEMA(closes, 12)},
+				column{"EMA26", indicator.CalculateEMA(closes, 26)})
+		case indicator.NameRSI:
+			res = append(res, column{"RSI14", indicator.CalculateRSI(closes, 14)})
+		case indicator.NameMACD:
+			macd := indicator.CalculateMACD(closes, 12, 26, 9)
+			line, signal, hist := make([]float64, len(macd)), make([]float64, len(macd)), make([]float64, len(macd))
+			for i, m := range macd {
+				line[i], signal[i], hist[i] = m.Line, m.Signal, m.Histogram
+			}
+			res = append(res,
+				column{"MACD", line},
+				column{"MACD Signal", signal},
+				column{"MACD Hist", hist})
+		case indicator.NameATR:
+			res = append(res, column{"ATR14", indicator.CalculateATR(high, low, closes, 14)})
+		case indicator.NameStoch:
+			stoch := indicator.CalculateStochastic(high, low, closes, 14, 3)
+			k, d := make([]float64, len(stoch)), make([]float64, len(stoch))
+			for i, s := range stoch {
+				k[i], d[i] = s.K, s.D
+			}
+			res = append(res,
+				column{"Stoch %K", k},
+				column{"Stoch %D", d})
+		case indicator.NameADX:
+			res = append(res, column{"ADX14", indicator.CalculateADX(high, low, closes, 14)})
+		case indicator.NameOBV:
+			res = append(res, column{"OBV", indicator.CalculateOBV(closes, volume)})
+		case indicator.NameVWAP:
+			res = append(res, column{"VWAP20", indicator.CalculateVWAP(high, low, closes, volume, 20)})
+		default:
+			return nil, fmt.Errorf("[trade.extraColumns] unknown indicator %q", name)
+		}
+	}
+	return res, nil
+}
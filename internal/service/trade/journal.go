@@ -0,0 +1,184 @@
+package trade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/internal/service/llm"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"log"
+	"strings"
+	"time"
+)
+
+// Journal persists what happened to each analyzed pair, e.g. journal.Store.
+type Journal interface {
+	Record(ctx context.Context, r *model.RunRecord) error
+}
+
+// History lists every journal entry of pair, and of strategy when it is set, that sent an
+// order or set a stop-loss or take-profit, newest first, e.g. journal.Store. It covers the
+// whole journal: a position opened long ago is still summed up from its first order.
+type History interface {
+	Executed(ctx context.Context, pair, strategy string) ([]*model.RunRecord, error)
+}
+
+// WithJournal records every Execute/ExecutePortfolio outcome to j. When j is a History
+// too, stops are sized to the position of the strategy, see Position.
+func WithJournal(j Journal) Option {
+	return func(s *Service) {
+		s.journal = j
+	}
+}
+
+// Watcher follows the stop-loss and take-profit of every decision that reached the market,
+// e.g. guard.Service watching prices between runs. result is the order sent for decision,
+// nil for a HOLD, so the watcher can follow the size of the position.
+type Watcher interface {
+	Track(pair currency.Pair, decision model.Decision, result *model.OrderResult)
+}
+
+// WithWatcher reports every executed decision to w.
+func WithWatcher(w Watcher) Option {
+	return func(s *Service) {
+		s.watcher = w
+	}
+}
+
+func (o *Service) track(pair currency.Pair, decision model.Decision, result *model.OrderResult) {
+	if o.watcher != nil {
+		o.watcher.Track(pair, decision, result)
+	}
+}
+
+// Filled returns the coins result added to the position of pair, negative for a sell. A
+// fee charged in the coin is not part of the position.
+func Filled(pair currency.Pair, result *model.OrderResult) float64 {
+	if result == nil {
+		return 0
+	}
+	if result.Side == "sell" {
+		return -result.FilledSize
+	}
+	if result.FeeCcy == pair.Quote.String() {
+		return result.FilledSize - result.Fee
+	}
+	return result.FilledSize
+}
+
+// Position sums up the coins of pair the orders in records, newest first, left the strategy
+// with. Only advisory or only live entries count, as advisory says. known is false when an
+// order went out without its fill being journaled, e.g. one placed before fills were kept:
+// the position is then not the strategy's alone to tell. An entry whose balance after it
+// holds none of the coin, e.g. the exit of the guard after the exchange-side stop sold the
+// position, leaves the strategy with none, whatever came before.
+func Position(pair currency.Pair, records []*model.RunRecord, advisory bool) (size float64, known bool) {
+	known = true
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if r.Advisory != advisory {
+			continue
+		}
+		if r.Order != nil && r.OrderResult == nil && r.OrderError == "" {
+			known = false
+		}
+		size = max(size+Filled(pair, r.OrderResult), 0)
+		if flat(pair, r.BalanceAfter) {
+			size, known = 0, true
+		}
+	}
+	return size, known
+}
+
+// flat reports whether balance holds none of the coin of pair, false without a balance.
+func flat(pair currency.Pair, balance *model.TradeData) bool {
+	if balance == nil {
+		return false
+	}
+	asset, ok := balance.AccountAssets[pair.Quote]
+	return !ok || asset.Equity <= 0
+}
+
+// position returns the coins of pair the strategy holds, from the journal. known is false
+// when the journal cannot tell, see Position.
+func (o *Service) position(ctx context.Context, pair currency.Pair) (size float64, known bool) {
+	records, ok := o.executed(ctx, pair)
+	if !ok {
+		return 0, false
+	}
+	return Position(pair, records, o.shadow != nil)
+}
+
+// record writes one journal entry. ordered tells whether decision was sent to the market;
+// err is the analysis or order failure. The prompt and answers of a model that gave no valid
+// decision are kept from its llm.DecisionError. Journal failures are logged, never returned,
+// so they cannot abort trading.
+func (o *Service) record(ctx context.Context, pair currency.Pair, before, after *model.TradeData, decision *model.Decision, ordered bool, result *model.OrderResult, err error) {
+	if o.journal == nil {
+		return
+	}
+	r := &model.RunRecord{
+		Ts:            time.Now(),
+		Pair:          InstId(pair),
+		Strategy:      o.strategy.Name,
+		Decision:      decision,
+		BalanceBefore: before,
+		BalanceAfter:  after,
+		Advisory:      o.shadow != nil,
+	}
+	if decision == nil {
+		if err != nil {
+			r.Error = err.Error()
+		}
+		var dErr *llm.DecisionError
+		if errors.As(err, &dErr) {
+			r.Prompt = dErr.Prompt
+			r.Response = dErr.Response
+		}
+	} else {
+		r.Prompt = decision.Prompt
+		r.Response = decision.Response
+		if ordered {
+			r.Order = o.orderRequest(ctx, pair, decision)
+			r.OrderResult = result
+		}
+		if err != nil {
+			r.OrderError = err.Error()
+		}
+	}
+	if err := o.journal.Record(ctx, r); err != nil {
+		log.Println(fmt.Sprintf("[trade.record] 写入日志失败: %s", err.Error()))
+	}
+}
+
+// orderRequest is the order Order sent for decision. The size of an advisory order is the
+// normalized one advise filled in the shadow portfolio.
+func (o *Service) orderRequest(ctx context.Context, pair currency.Pair, decision *model.Decision) *model.OrderRequest {
+	r := &model.OrderRequest{
+		InstId:     InstId(pair),
+		Side:       strings.ToLower(decision.Action),
+		Size:       decision.Amount,
+		StopLoss:   decision.StopLossPrice,
+		TakeProfit: decision.TakeProfitPrice,
+	}
+	if o.shadow != nil {
+		if sz, err := o.normalize(ctx, r.InstId, r.Side, r.Size); err == nil {
+			r.Size = sz
+		}
+	}
+	return r
+}
+
+// balanceAfter re-reads the account for the journal, nil when there is no journal.
+func (o *Service) balanceAfter(ctx context.Context, coin ...currency.Coin) *model.TradeData {
+	if o.journal == nil {
+		return nil
+	}
+	after, err := o.GetBalance(ctx, coin...)
+	if err != nil {
+		log.Println(fmt.Sprintf("[trade.balanceAfter] %s", err.Error()))
+		return nil
+	}
+	return after
+}
@@ -0,0 +1,190 @@
+package trade
+
+import (
+	"context"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"log"
+	"math"
+	"strconv"
+)
+
+// PairDecision is the outcome of one pair in a portfolio run.
+type PairDecision struct {
+	Pair     currency.Pair
+	Decision *model.Decision
+	Result   *model.OrderResult // the market order sent for Decision, nil for a HOLD
+	Err      error
+	ordered  bool
+}
+
+// ExecutePortfolio analyzes every pair against the same account snapshot, then executes
+// SELL/HOLD decisions first to free quote capital and finally the BUYs, scaled down
+// together so that they stay within the portfolio budget (see WithMaxExposure).
+// All pairs must share the same quote currency (pair.Base, e.g. USDT).
+func (o *Service) ExecutePortfolio(ctx context.Context, pairs []currency.Pair) ([]PairDecision, error) {
+	res, balance, err := o.AnalyzePortfolio(ctx, pairs)
+	if err != nil {
+		// no pair was analyzed, each of them failed the same way
+		for _, pair := range pairs {
+			o.record(ctx, pair, nil, nil, nil, false, nil, err)
+		}
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+	quote, coins := pairs[0].Base, portfolioCoins(pairs)
+
+	buys := make([]int, 0)
+	for i := range res {
+		if res[i].Err != nil {
+			continue
+		}
+		if res[i].Decision.Action == "BUY" {
+			buys = append(buys, i)
+			continue
+		}
+		res[i].Result, res[i].Err = o.Order(ctx, res[i].Pair, *res[i].Decision)
+		res[i].ordered = res[i].Decision.Action != "HOLD"
+	}
+	defer o.recordPortfolio(ctx, res, balance, coins)
+	if len(buys) == 0 {
+		return res, nil
+	}
+
+	// re-read the account, sells above may have freed quote capital
+	balance, err = o.GetBalance(ctx, coins...)
+	if err != nil {
+		return res, err
+	}
+	amounts := o.allocateBuys(res, buys, balance, quote, coins[1:])
+	for i, idx := range buys {
+		if amounts[i] <= 0 {
+			continue
+		}
+		res[idx].Result, res[idx].Err = o.Order(ctx, res[idx].Pair, *res[idx].Decision)
+		res[idx].ordered = true
+	}
+	return res, nil
+}
+
+// PlanPortfolio is a dry run of ExecutePortfolio: it analyzes the pairs and scales the BUY
+// amounts down to the portfolio budget the way ExecutePortfolio does, without placing any
+// order or writing the journal. The budget is that of the current balance, the SELLs
+// ExecutePortfolio places first may free more.
+func (o *Service) PlanPortfolio(ctx context.Context, pairs []currency.Pair) ([]PairDecision, error) {
+	res, balance, err := o.AnalyzePortfolio(ctx, pairs)
+	if err != nil || len(res) == 0 {
+		return res, err
+	}
+	buys := make([]int, 0)
+	for i := range res {
+		if res[i].Err == nil && res[i].Decision.Action == "BUY" {
+			buys = append(buys, i)
+		}
+	}
+	coins := portfolioCoins(pairs)
+	o.allocateBuys(res, buys, balance, coins[0], coins[1:])
+	return res, nil
+}
+
+// allocateBuys sets the amounts of the BUY decisions res[buys] to their share of the budget
+// of balance and returns them.
+func (o *Service) allocateBuys(res []PairDecision, buys []int, balance *model.TradeData, quote currency.Coin, coins []currency.Coin) []float64 {
+	requests := make([]float64, len(buys))
+	for i, idx := range buys {
+		requests[i], _ = strconv.ParseFloat(res[idx].Decision.Amount, 64)
+	}
+	amounts := allocate(requests, o.budget(balance, quote, coins))
+	for i, idx := range buys {
+		if amounts[i] < requests[i] {
+			log.Println(fmt.Sprintf("[trade.allocateBuys] %s 买入金额受组合上限限制: %.2f -> %.2f",
+				InstId(res[idx].Pair), requests[i], amounts[i]))
+		}
+		res[idx].Decision.Amount = strconv.FormatFloat(amounts[i], 'f', -1, 64)
+	}
+	return amounts
+}
+
+// AnalyzePortfolio is the first half of ExecutePortfolio: it asks the model about every pair
+// against one account snapshot and returns the decisions with that snapshot, without placing
+// any order or writing the journal. A pair that could not be analyzed has Err set.
+func (o *Service) AnalyzePortfolio(ctx context.Context, pairs []currency.Pair) ([]PairDecision, *model.TradeData, error) {
+	if len(pairs) == 0 {
+		return nil, nil, nil
+	}
+	quote := pairs[0].Base
+	for _, p := range pairs {
+		if p.Base != quote {
+			return nil, nil, fmt.Errorf("[trade.AnalyzePortfolio] mixed quote currencies %s and %s", quote.String(), p.Base.String())
+		}
+	}
+	balance, err := o.GetBalance(ctx, portfolioCoins(pairs)...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res := make([]PairDecision, len(pairs))
+	for i, pair := range pairs {
+		res[i].Pair = pair
+		series, err := o.GetContext(pair)
+		if err != nil {
+			res[i].Err = err
+			continue
+		}
+		res[i].Decision, res[i].Err = o.AnalyzeMarket(ctx, pair, balance, series[0], series[1:]...)
+	}
+	return res, balance, nil
+}
+
+// portfolioCoins returns the quote currency followed by the coins of pairs.
+func portfolioCoins(pairs []currency.Pair) []currency.Coin {
+	coins := []currency.Coin{pairs[0].Base}
+	for _, p := range pairs {
+		coins = append(coins, p.Quote)
+	}
+	return coins
+}
+
+func (o *Service) recordPortfolio(ctx context.Context, res []PairDecision, before *model.TradeData, coins []currency.Coin) {
+	if o.journal == nil {
+		return
+	}
+	after := o.balanceAfter(ctx, coins...)
+	for _, r := range res {
+		o.record(ctx, r.Pair, before, after, r.Decision, r.ordered, r.Result, r.Err)
+	}
+}
+
+// budget is how much quote may be spent on new positions: the free quote balance, further
+// capped so that total exposure to the portfolio coins stays below maxExposure of equity.
+func (o *Service) budget(balance *model.TradeData, quote currency.Coin, coins []currency.Coin) float64 {
+	budget := balance.AccountAssets[quote].Equity
+	if o.maxExposure <= 0 || o.maxExposure >= 1 {
+		return budget
+	}
+	var exposure float64
+	for _, c := range coins {
+		exposure += balance.AccountAssets[c].EquityUSD
+	}
+	return math.Max(0, math.Min(budget, balance.TotalEquity*o.maxExposure-exposure))
+}
+
+// allocate scales requests down proportionally so that their sum does not exceed budget.
+func allocate(requests []float64, budget float64) []float64 {
+	res := make([]float64, len(requests))
+	var total float64
+	for _, r := range requests {
+		total += math.Max(r, 0)
+	}
+	scale := 1.0
+	if total > budget {
+		scale = math.Max(budget, 0) / total
+	}
+	for i, r := range requests {
+		res[i] = math.Max(r, 0) * scale
+	}
+	return res
+}
@@ -2,23 +2,58 @@ package trade
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/twoonefour/sigmaflow/internal/model"
 	"github.com/twoonefour/sigmaflow/internal/service/llm"
 	"github.com/twoonefour/sigmaflow/pkg/currency"
 	"github.com/twoonefour/sigmaflow/pkg/indicator"
+	"log"
 	"math"
-	"strings"
+	"strconv"
+	"sync"
 )
 
 type Service struct {
-	market Market
-	llm    *llm.Service
+	market      Market
+	llm         *llm.Service
+	maxExposure float64
+	journal     Journal
+	watcher     Watcher
+	strategy    Strategy
+	frames      []Strategy
+	shadow      Shadow
+
+	mu      sync.Mutex
+	streams map[string]*stream // by streamKey
+	stops   map[string]Levels  // of the exchange-side stop by instId, see levels
+}
+
+type Option func(*Service)
+
+// WithMaxExposure caps the share of total equity that ExecutePortfolio may hold in
+// non-quote coins, e.g. 0.8 keeps at least 20% in USDT. 0 or 1 means no cap.
+func WithMaxExposure(ratio float64) Option {
+	return func(s *Service) {
+		s.maxExposure = ratio
+	}
 }
 
 type Market interface {
-	GetCandle(pair currency.Pair, period int) ([]model.Candlestick, error)
+	// GetCandle returns the last period candles of timeframe, newest first.
+	GetCandle(pair currency.Pair, timeframe model.Timeframe, period int) ([]model.Candlestick, error)
 	GetBalance(ctx context.Context, coin ...currency.Coin) (*model.TradeData, error)
-	Order(instId, side, sz string) error
+	// Order places a market order, sz is in the quote currency for a buy and in the coin for
+	// a sell. The result tells how much was filled at which price.
+	Order(ctx context.Context, instId, side, sz string) (*model.OrderResult, error)
+}
+
+// StopMarket is implemented by markets that can hold exchange-side protective orders, so a
+// position stays protected between runs. tag is the name of the strategy: CancelStops only
+// cancels the stops placed with the same tag.
+type StopMarket interface {
+	PlaceStop(ctx context.Context, instId, tag, sz string, stopLoss, takeProfit float64) (string, error)
+	CancelStops(ctx context.Context, instId, tag string) error
 }
 
 type Trade interface {
@@ -28,79 +63,389 @@ type Trade interface {
 	Query()
 }
 
-func NewTradeService(c Market, llmService *llm.Service) *Service {
-	return &Service{
-		market: c,
-		llm:    llmService,
+func NewTradeService(c Market, llmService *llm.Service, opts ...Option) *Service {
+	s := &Service{
+		market:   c,
+		llm:      llmService,
+		strategy: DefaultStrategy,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Strategy is the candle window a decision is based on.
+type Strategy struct {
+	Name      string // tags the exchange-side stops and the journal entries of the strategy, see StopMarket
+	Timeframe model.Timeframe
+	Lookback  int // candles shown to the model
+	Warmup    int // extra older candles so that MA200 is defined for every shown candle
+	// Indicators are added to every candle, see WithIndicators
+	Indicators []string
+}
+
+// DefaultStrategy shows the model 30 daily candles.
+var DefaultStrategy = Strategy{
+	Timeframe: model.Day1,
+	Lookback:  30,
+	Warmup:    201,
+}
+
+// Candles is the number of candles GetCandle requests.
+func (s Strategy) Candles() int {
+	return s.Lookback + s.Warmup
+}
+
+// WithDefaults fills the zero fields of s from DefaultStrategy.
+func (s Strategy) WithDefaults() Strategy {
+	if s.Timeframe == "" {
+		s.Timeframe = DefaultStrategy.Timeframe
+	}
+	if s.Lookback <= 0 {
+		s.Lookback = DefaultStrategy.Lookback
+	}
+	if s.Warmup <= 0 {
+		s.Warmup = DefaultStrategy.Warmup
+	}
+	return s
+}
+
+// WithStrategy sets the timeframe and candle window, zero fields keep DefaultStrategy.
+func WithStrategy(strategy Strategy) Option {
+	return func(s *Service) {
+		if len(strategy.Indicators) == 0 {
+			strategy.Indicators = s.strategy.Indicators
+		}
+		s.strategy = strategy.WithDefaults()
+	}
+}
+
+// WithContext shows the model further timeframes next to the strategy one, e.g. 1W for the
+// trend and 4H for the entry. Each frame has its own window and indicators.
+func WithContext(frames ...Strategy) Option {
+	return func(s *Service) {
+		for _, f := range frames {
+			s.frames = append(s.frames, f.WithDefaults())
+		}
 	}
 }
 
-func (o *Service) GetCandle(pair currency.Pair) ([]model.CandleWithIndicator, error) {
-	const needCount = 231
-	const outputCount = 30
-	c, err := o.market.GetCandle(pair, needCount)
+// Strategy returns the candle window in use.
+func (o *Service) Strategy() Strategy {
+	return o.strategy
+}
+
+func (o *Service) GetCandle(pair currency.Pair) (model.CandleSeries, error) {
+	return o.series(pair, o.strategy)
+}
+
+// GetContext returns the strategy series followed by the WithContext ones. A context frame
+// that cannot be loaded is logged and left out rather than failing the run.
+func (o *Service) GetContext(pair currency.Pair) ([]model.CandleSeries, error) {
+	primary, err := o.GetCandle(pair)
 	if err != nil {
 		return nil, err
 	}
+	res := []model.CandleSeries{primary}
+	for _, frame := range o.frames {
+		series, err := o.series(pair, frame)
+		if err != nil {
+			log.Println(fmt.Sprintf("[trade.GetContext] %s %s 周期数据获取失败: %s", InstId(pair), frame.Timeframe, err.Error()))
+			continue
+		}
+		res = append(res, series)
+	}
+	return res, nil
+}
+
+// series returns the candles of strategy with their indicators. When every indicator of
+// strategy can be streamed they are kept between runs, see stream; otherwise the whole
+// window is fetched and computed again.
+func (o *Service) series(pair currency.Pair, strategy Strategy) (model.CandleSeries, error) {
+	if streamable(strategy.Indicators) {
+		return o.streamSeries(pair, strategy)
+	}
+	return o.batchSeries(pair, strategy)
+}
+
+func (o *Service) batchSeries(pair currency.Pair, strategy Strategy) (model.CandleSeries, error) {
+	series := model.CandleSeries{Timeframe: strategy.Timeframe}
+	need := strategy.Candles()
+	c, err := o.market.GetCandle(pair, strategy.Timeframe, need)
+	if err != nil {
+		return series, err
+	}
+	if len(c) < need {
+		return series, fmt.Errorf("[trade.GetCandle] need %d candles, got %d", need, len(c))
+	}
 
-	m := make([]float64, len(c))
+	// oldest first
+	n := len(c)
+	m := make([]float64, n)
+	high := make([]float64, n)
+	low := make([]float64, n)
+	vol := make([]float64, n)
 	for i, v := range c {
-		m[len(c)-1-i] = v.C
+		m[n-1-i] = v.C
+		high[n-1-i] = v.H
+		low[n-1-i] = v.L
+		vol[n-1-i] = v.Vol
 	}
 	bbResults := indicator.CalculateBollingerBands(m, 20, 2.0)
 	ma5 := indicator.CalculateMA(m, 5)
 	ma50 := indicator.CalculateMA(m, 50)
 	ma200 := indicator.CalculateMA(m, 200)
+	extras, err := extraColumns(strategy.Indicators, high, low, m, vol)
+	if err != nil {
+		return series, err
+	}
 
-	candles := make([]model.CandleWithIndicator, outputCount)
-
-	for i := 0; i < outputCount; i++ {
-		originalCandle := c[i]
-		valMA5 := ma5[len(ma5)-1-i]
-		valMA50 := ma50[len(ma50)-1-i]
-		valMA200 := ma200[len(ma200)-1-i]
-		BBUpper := bbResults[len(bbResults)-1-i].Upper
-		BBMid := bbResults[len(bbResults)-1-i].Middle
-		BBLower := bbResults[len(bbResults)-1-i].Lower
-
-		valMA5 = math.Floor(valMA5*10) / 10
-		valMA50 = math.Floor(valMA50*10) / 10
-		valMA200 = math.Floor(valMA50*10) / 10
-		BBUpper = math.Floor(BBUpper*10) / 10
-		BBMid = math.Floor(BBUpper*10) / 10
-		BBLower = math.Floor(BBUpper*10) / 10
-		candles[i] = model.CandleWithIndicator{
-			TrendIndicators: model.TrendIndicators{
-				MA5:     valMA5,
-				MA50:    valMA50,
-				MA200:   valMA200,
-				BBUpper: BBUpper,
-				BBMid:   BBMid,
-				BBLower: BBLower,
-			},
-			Candlestick: originalCandle,
+	candles := make([]model.CandleWithIndicator, strategy.Lookback)
+
+	for i := range candles {
+		var extra []model.IndicatorValue
+		if len(extras) > 0 {
+			extra = make([]model.IndicatorValue, len(extras))
+			for j, col := range extras {
+				extra[j] = model.IndicatorValue{Name: col.name, Value: col.values[n-1-i]}
+			}
 		}
+		candles[i] = indicatorRow(c[i], fromEnd(ma5, i), fromEnd(ma50, i), fromEnd(ma200, i), bbResults[len(bbResults)-1-i], extra)
 	}
 
-	return candles, nil
+	series.Candles = candles
+	return series, nil
+}
+
+// indicatorRow is candle with its indicators, the moving averages and bands floored to one
+// decimal.
+func indicatorRow(candle model.Candlestick, ma5, ma50, ma200 float64, bb indicator.Bollinger, extra []model.IndicatorValue) model.CandleWithIndicator {
+	return model.CandleWithIndicator{
+		TrendIndicators: model.TrendIndicators{
+			MA5:     math.Floor(ma5*10) / 10,
+			MA50:    math.Floor(ma50*10) / 10,
+			MA200:   math.Floor(ma200*10) / 10,
+			BBUpper: math.Floor(bb.Upper*10) / 10,
+			BBMid:   math.Floor(bb.Middle*10) / 10,
+			BBLower: math.Floor(bb.Lower*10) / 10,
+			Extra:   extra,
+		},
+		Candlestick: candle,
+	}
+}
+
+// fromEnd returns values[len-1-i], or 0 when the window was too short to compute it.
+func fromEnd(values []float64, i int) float64 {
+	if i >= len(values) {
+		return 0
+	}
+	return values[len(values)-1-i]
+}
+
+// LLM returns the analysis service so other runners (e.g. backtest) can share it.
+func (o *Service) LLM() *llm.Service {
+	return o.llm
 }
 
+// GetBalance returns the account balance. Requested coins that are not held are filled in
+// with zero assets so callers can index AccountAssets directly.
 func (o *Service) GetBalance(ctx context.Context, coin ...currency.Coin) (*model.TradeData, error) {
 	if len(coin) == 0 {
-		return o.market.GetBalance(ctx)
+		return o.account().GetBalance(ctx)
 	}
-	return o.market.GetBalance(ctx, coin...)
+	balance, err := o.account().GetBalance(ctx, coin...)
+	if err != nil {
+		return nil, err
+	}
+	if balance.AccountAssets == nil {
+		balance.AccountAssets = make(map[currency.Coin]*model.Asset)
+	}
+	for _, c := range coin {
+		if _, ok := balance.AccountAssets[c]; !ok {
+			balance.AccountAssets[c] = &model.Asset{Currency: c}
+		}
+	}
+	return balance, nil
+}
+
+// Order executes decision for pair and returns the market order it sent, nil for a HOLD.
+// When the market supports exchange-side stops, a BUY attaches a stop-loss/take-profit
+// order to the whole position and a HOLD moves the levels it carries, see LevelsOf. A SELL
+// cancels it first, as it locks the coins to sell, and places it again when the sell fails;
+// a partial SELL with levels protects what is left. An advisory service fills the order in
+// its shadow portfolio instead, see WithAdvisory.
+func (o *Service) Order(ctx context.Context, pair currency.Pair, decision model.Decision) (*model.OrderResult, error) {
+	if o.shadow != nil {
+		return o.advise(ctx, pair, decision)
+	}
+	instId := InstId(pair)
+	stops, protect := o.market.(StopMarket)
+	switch decision.Action {
+	case "HOLD":
+		o.track(pair, decision, nil)
+		if !protect {
+			return nil, nil
+		}
+		// a level the decision leaves out stays, like the guard keeps it
+		prev, _ := o.levels(ctx, pair)
+		levels, changed := LevelsOf(prev, decision)
+		if !changed {
+			return nil, nil
+		}
+		return nil, o.protect(ctx, stops, pair, levels, nil)
+	case "SELL":
+		if !protect {
+			res, err := o.marketOrder(ctx, instId, "sell", decision.Amount)
+			if err == nil {
+				o.track(pair, decision, res)
+			}
+			return res, err
+		}
+		prev, known := o.levels(ctx, pair)
+		if err := stops.CancelStops(ctx, instId, o.strategy.Name); err != nil {
+			return nil, err
+		}
+		res, err := o.marketOrder(ctx, instId, "sell", decision.Amount)
+		if err != nil {
+			if !known {
+				log.Println(fmt.Sprintf("[trade.Order] %s 卖出失败, 止损价格未知, 持仓未受保护", instId))
+				return res, err
+			}
+			perr := o.placeStop(ctx, stops, pair, prev, nil)
+			o.setLevels(pair, prev, perr == nil)
+			if perr != nil {
+				log.Println(fmt.Sprintf("[trade.Order] %s 卖出失败后恢复止损失败: %s", instId, perr.Error()))
+			}
+			return res, err
+		}
+		o.track(pair, decision, res)
+		levels, _ := LevelsOf(prev, decision)
+		if levels == (Levels{}) || decision.PositionPct >= 1 {
+			// a full exit leaves at most dust, nothing to protect
+			o.setLevels(pair, levels, true)
+			return res, nil
+		}
+		// a partial exit keeps the rest protected
+		err = o.placeStop(ctx, stops, pair, levels, res)
+		o.setLevels(pair, levels, err == nil)
+		return res, err
+	case "BUY":
+		res, err := o.marketOrder(ctx, instId, "buy", decision.Amount)
+		if err != nil {
+			return res, err
+		}
+		o.track(pair, decision, res)
+		if !protect {
+			return res, nil
+		}
+		levels, _ := LevelsOf(Levels{}, decision)
+		return res, o.protect(ctx, stops, pair, levels, res)
+	}
+	return nil, fmt.Errorf("[trade.Order] unknown action %q", decision.Action)
+}
+
+// marketOrder sends the order and fails when the market canceled it without any fill.
+func (o *Service) marketOrder(ctx context.Context, instId, side, sz string) (*model.OrderResult, error) {
+	res, err := o.account().Order(ctx, instId, side, sz)
+	if err != nil {
+		return res, err
+	}
+	log.Println(fmt.Sprintf("[trade.Order] %s %s %s, 订单: %s, 状态: %s, 成交: %f, 均价: %.2f, 手续费: %f %s",
+		instId, side, sz, res.OrderId, res.State, res.FilledSize, res.AvgPrice, res.Fee, res.FeeCcy))
+	if res.State == model.OrderCanceled && res.FilledSize <= 0 {
+		return res, fmt.Errorf("[trade.Order] %s order %s canceled without fill", instId, res.OrderId)
+	}
+	return res, nil
+}
+
+// protect replaces the stop orders of the strategy on pair with one at levels covering its
+// position: the journaled one with result, the order just sent, at most the balance. Other
+// strategies and manual holdings on the account keep their coins. Without a journal that
+// tells, the stop covers the whole balance.
+func (o *Service) protect(ctx context.Context, stops StopMarket, pair currency.Pair, levels Levels, result *model.OrderResult) error {
+	instId := InstId(pair)
+	if err := stops.CancelStops(ctx, instId, o.strategy.Name); err != nil {
+		o.setLevels(pair, Levels{}, false)
+		return err
+	}
+	err := o.placeStop(ctx, stops, pair, levels, result)
+	o.setLevels(pair, levels, err == nil)
+	return err
 }
 
-func (o *Service) Order(decision model.Decision) error {
-	if decision.Action == "HOLD" {
+// placeStop places a stop at levels over the position of pair, see protect, once its
+// previous stops are canceled.
+func (o *Service) placeStop(ctx context.Context, stops StopMarket, pair currency.Pair, levels Levels, result *model.OrderResult) error {
+	instId := InstId(pair)
+	if levels == (Levels{}) {
+		log.Println(fmt.Sprintf("[trade.protect] %s 没有止损止盈价格, 持仓未受保护", instId))
 		return nil
 	}
+	balance, err := o.market.GetBalance(ctx, pair.Quote)
+	if err != nil {
+		return err
+	}
+	asset, ok := balance.AccountAssets[pair.Quote]
+	if !ok || asset.Equity <= 0 {
+		return nil
+	}
+	size := asset.Equity
+	if held, known := o.position(ctx, pair); known {
+		// the order just sent is not journaled yet
+		size = min(size, max(held+Filled(pair, result), 0))
+	}
+	if size <= 0 {
+		return nil
+	}
+	sz := strconv.FormatFloat(size, 'f', -1, 64)
+	if _, err = o.normalize(ctx, instId, "sell", sz); errors.Is(err, model.ErrBelowMinimum) {
+		// dust the exchange does not take, nothing left to protect
+		log.Println(fmt.Sprintf("[trade.protect] %s 剩余 %s 低于最小下单量, 不设止损", instId, sz))
+		return nil
+	} else if err != nil {
+		return err
+	}
+	algoId, err := stops.PlaceStop(ctx, instId, o.strategy.Name, sz, levels.StopLoss, levels.TakeProfit)
+	if err != nil {
+		return err
+	}
+	log.Println(fmt.Sprintf("[trade.protect] %s 止损: %.2f, 止盈: %.2f, 数量: %s, algoId: %s",
+		instId, levels.StopLoss, levels.TakeProfit, sz, algoId))
+	return nil
+}
 
-	return o.market.Order("BTC-USDT", strings.ToLower(decision.Action), decision.Amount)
+// InstId returns the exchange instrument id of pair, e.g. BTC-USDT.
+func InstId(pair currency.Pair) string {
+	return pair.Quote.String() + "-" + pair.Base.String()
+}
+
+// Execute runs one full cycle for pair: candles -> balance -> LLM decision -> order.
+func (o *Service) Execute(ctx context.Context, pair currency.Pair) (*model.Decision, error) {
+	series, err := o.GetContext(pair)
+	if err != nil {
+		o.record(ctx, pair, nil, nil, nil, false, nil, err)
+		return nil, err
+	}
+	balance, err := o.GetBalance(ctx, pair.Base, pair.Quote)
+	if err != nil {
+		o.record(ctx, pair, nil, nil, nil, false, nil, err)
+		return nil, err
+	}
+	decision, err := o.AnalyzeMarket(ctx, pair, balance, series[0], series[1:]...)
+	if err != nil {
+		o.record(ctx, pair, balance, nil, nil, false, nil, err)
+		return nil, err
+	}
+	result, err := o.Order(ctx, pair, *decision)
+	o.record(ctx, pair, balance, o.balanceAfter(ctx, pair.Base, pair.Quote), decision, decision.Action != "HOLD", result, err)
+	if err != nil {
+		return decision, err
+	}
+	return decision, nil
 }
 
-func (o *Service) AnalyzeMarket(ctx context.Context, pair currency.Pair, holding *model.TradeData, candle []model.CandleWithIndicator) (*model.Decision, error) {
+func (o *Service) AnalyzeMarket(ctx context.Context, pair currency.Pair, holding *model.TradeData, candle model.CandleSeries, frames ...model.CandleSeries) (*model.Decision, error) {
 	// currency.NewPair(currency.USDT, currency.BTC)
-	return o.llm.Completion(ctx, pair, holding, candle)
+	return o.llm.Completion(ctx, pair, holding, candle, frames...)
 }
@@ -1,9 +1,730 @@
 package trade
 
 import (
+	"context"
+	"errors"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/internal/service/llm"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"github.com/twoonefour/sigmaflow/pkg/indicator"
+	pkgllm "github.com/twoonefour/sigmaflow/pkg/llm"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
+type fakeMarket struct {
+	position float64
+	orders   []string
+	stops    []string
+	candles  []model.Candlestick // newest first
+	reject   bool                // cancel orders without a fill
+	locked   float64             // coins held by the stop, a sell cannot use them
+	levels   Levels              // of the last stop placed
+	err      error               // returned by GetBalance
+	fetched  []int               // candles requested by each GetCandle
+}
+
+func (f *fakeMarket) GetCandle(_ currency.Pair, _ model.Timeframe, period int) ([]model.Candlestick, error) {
+	f.fetched = append(f.fetched, period)
+	return f.candles[:min(period, len(f.candles))], nil
+}
+
+func (f *fakeMarket) GetBalance(_ context.Context, coin ...currency.Coin) (*model.TradeData, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	res := &model.TradeData{AccountAssets: make(map[currency.Coin]*model.Asset)}
+	for _, c := range coin {
+		res.AccountAssets[c] = &model.Asset{Currency: c, Equity: f.position}
+	}
+	return res, nil
+}
+
+func (f *fakeMarket) Order(_ context.Context, instId, side, sz string) (*model.OrderResult, error) {
+	f.orders = append(f.orders, instId+" "+side+" "+sz)
+	res := &model.OrderResult{OrderId: "1", InstId: instId, Side: side, State: model.OrderCanceled}
+	if f.reject {
+		return res, nil
+	}
+	if size, _ := strconv.ParseFloat(sz, 64); side == "sell" && size > f.position-f.locked {
+		return nil, errors.New("insufficient balance")
+	}
+	res.State = model.OrderFilled
+	if side == "buy" {
+		f.position = 0.5
+		res.FilledSize = 0.5
+	} else {
+		size, _ := strconv.ParseFloat(sz, 64)
+		res.FilledSize = min(size, f.position)
+		f.position -= res.FilledSize
+	}
+	return res, nil
+}
+
+func (f *fakeMarket) PlaceStop(_ context.Context, instId, _, sz string, stopLoss, takeProfit float64) (string, error) {
+	f.stops = append(f.stops, instId+" "+sz)
+	f.locked, _ = strconv.ParseFloat(sz, 64)
+	f.levels = Levels{StopLoss: stopLoss, TakeProfit: takeProfit}
+	return "1", nil
+}
+
+func (f *fakeMarket) CancelStops(_ context.Context, _, _ string) error {
+	f.stops = f.stops[:0]
+	f.locked = 0
+	return nil
+}
+
 func TestGetCandle(t *testing.T) {
+	n := DefaultStrategy.Candles()
+	market := &fakeMarket{candles: make([]model.Candlestick, n)}
+	for i := range market.candles {
+		// linear uptrend, newest first: closes run from 1 to n
+		c := float64(n - i)
+		market.candles[i] = model.Candlestick{O: c - 0.5, H: c + 1, L: c - 1, C: c, Vol: 10}
+	}
+	s := NewTradeService(market, nil, WithIndicators(indicator.NameRSI, indicator.NameMACD))
+	series, err := s.GetCandle(currency.NewPair(currency.USDT, currency.BTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if series.Timeframe != model.Day1 || len(series.Candles) != DefaultStrategy.Lookback {
+		t.Fatalf("expected %d daily candles, got %d %s", DefaultStrategy.Lookback, len(series.Candles), series.Timeframe)
+	}
+	last := series.Candles[0]
+	// mean of the last n closes of 1..231 is 231-(n-1)/2
+	if last.MA5 != 229 || last.MA50 != 206.5 || last.MA200 != 131.5 {
+		t.Errorf("unexpected moving averages %+v", last.TrendIndicators)
+	}
+	if last.BBMid != 221.5 || !(last.BBLower < last.BBMid && last.BBMid < last.BBUpper) {
+		t.Errorf("unexpected bollinger bands %+v", last.TrendIndicators)
+	}
+	names := make([]string, len(last.Extra))
+	for i, e := range last.Extra {
+		names[i] = e.Name
+	}
+	if len(names) != 4 || names[0] != "RSI14" || names[1] != "MACD" {
+		t.Fatalf("unexpected extra indicators %v", names)
+	}
+	if last.Extra[0].Value != 100 {
+		t.Errorf("RSI of a steady uptrend should be 100, got %v", last.Extra[0].Value)
+	}
+
+	s = NewTradeService(market, nil, WithStrategy(Strategy{Timeframe: model.Hour4, Lookback: 300}))
+	if _, err = s.GetCandle(currency.NewPair(currency.USDT, currency.BTC)); err == nil {
+		t.Error("expected an error when the market returns fewer candles than the strategy needs")
+	}
+
+	s = NewTradeService(market, nil, WithIndicators("FOO"))
+	if _, err = s.GetCandle(currency.NewPair(currency.USDT, currency.BTC)); err == nil {
+		t.Error("expected an error for an unknown indicator")
+	}
+}
+
+func TestGetContext(t *testing.T) {
+	market := &fakeMarket{candles: make([]model.Candlestick, DefaultStrategy.Candles())}
+	for i := range market.candles {
+		market.candles[i] = model.Candlestick{H: 2, L: 1, C: 1.5, Vol: 1}
+	}
+	s := NewTradeService(market, nil, WithContext(
+		Strategy{Timeframe: model.Week1, Indicators: []string{indicator.NameRSI}},
+		Strategy{Timeframe: model.Hour4, Lookback: 500}, // more than the market has, left out
+	))
+	series, err := s.GetContext(currency.NewPair(currency.USDT, currency.BTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 2 || series[0].Timeframe != model.Day1 || series[1].Timeframe != model.Week1 {
+		t.Fatalf("expected the daily and weekly series, got %d", len(series))
+	}
+	if len(series[0].Candles[0].Extra) != 0 || len(series[1].Candles[0].Extra) != 1 {
+		t.Errorf("each frame should use its own indicators")
+	}
+}
+
+func TestStreamSeries(t *testing.T) {
+	n := DefaultStrategy.Candles()
+	all := make([]model.Candlestick, n+3) // newest first
+	for i := range all {
+		c := 100 + 10*math.Sin(float64(i)/7)
+		all[i] = model.Candlestick{Ts: strconv.Itoa(len(all) - i), H: c + 1, L: c - 1, C: c, Vol: 1}
+	}
+	market := &fakeMarket{candles: slices.Clone(all[3:])}
+	s := NewTradeService(market, nil, WithIndicators(indicator.NameRSI, indicator.NameATR))
+	pair := currency.NewPair(currency.USDT, currency.BTC)
+
+	series, err := s.GetCandle(pair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch, err := s.batchSeries(pair, s.Strategy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(series, batch) {
+		t.Fatalf("the first run should match the batch indicators")
+	}
+
+	// two more candles, the open one of the last run closed lower
+	market.candles = slices.Clone(all[1:])
+	market.candles[2].C -= 5
+	market.fetched = nil
+	if series, err = s.GetCandle(pair); err != nil {
+		t.Fatal(err)
+	}
+	if len(market.fetched) != 1 || market.fetched[0] != streamFetch {
+		t.Errorf("only the newest candles should be fetched, got %v", market.fetched)
+	}
+	if batch, err = s.batchSeries(pair, s.Strategy()); err != nil {
+		t.Fatal(err)
+	}
+	if len(series.Candles) != DefaultStrategy.Lookback {
+		t.Fatalf("expected %d candles, got %d", DefaultStrategy.Lookback, len(series.Candles))
+	}
+	for i, c := range series.Candles {
+		b := batch.Candles[i]
+		if c.Ts != b.Ts || c.MA5 != b.MA5 || c.MA200 != b.MA200 || c.BBUpper != b.BBUpper || len(c.Extra) != 2 {
+			t.Fatalf("candle %d: got %+v, want %+v", i, c, b)
+		}
+	}
+
+	// a gap longer than a fetch seeds the stream again
+	market.candles = make([]model.Candlestick, n)
+	for i := range market.candles {
+		market.candles[i] = model.Candlestick{Ts: strconv.Itoa(1000 - i), H: 2, L: 1, C: 1.5}
+	}
+	market.fetched = nil
+	if _, err = s.GetCandle(pair); err != nil {
+		t.Fatal(err)
+	}
+	if len(market.fetched) != 2 || market.fetched[1] != n {
+		t.Errorf("expected a full fetch after the gap, got %v", market.fetched)
+	}
+}
+
+// lockCheckMarket is a fakeMarket that records whether the lock of s is held while candles
+// are fetched.
+type lockCheckMarket struct {
+	*fakeMarket
+	s      *Service
+	locked bool
+}
+
+func (m *lockCheckMarket) GetCandle(pair currency.Pair, tf model.Timeframe, period int) ([]model.Candlestick, error) {
+	if m.s.mu.TryLock() {
+		m.s.mu.Unlock()
+	} else {
+		m.locked = true
+	}
+	return m.fakeMarket.GetCandle(pair, tf, period)
+}
+
+func TestStreamSeriesUnlocked(t *testing.T) {
+	candles := make([]model.Candlestick, DefaultStrategy.Candles())
+	for i := range candles {
+		candles[i] = model.Candlestick{Ts: strconv.Itoa(len(candles) - i), H: 2, L: 1, C: 1.5}
+	}
+	market := &lockCheckMarket{fakeMarket: &fakeMarket{candles: candles}}
+	market.s = NewTradeService(market, nil, WithIndicators(indicator.NameRSI))
+	pair := currency.NewPair(currency.USDT, currency.BTC)
+	// seeded, then advanced
+	for range 2 {
+		if _, err := market.s.GetCandle(pair); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(market.fetched) != 2 || market.fetched[1] != streamFetch {
+		t.Fatalf("expected a full fetch and a stream fetch, got %v", market.fetched)
+	}
+	if market.locked {
+		t.Error("candles should be fetched without holding the lock of the service")
+	}
+}
+
+func TestOrderStops(t *testing.T) {
+	market := &fakeMarket{}
+	s := NewTradeService(market, nil)
+	pair := currency.NewPair(currency.USDT, currency.BTC)
+	ctx := context.Background()
+
+	res, err := s.Order(ctx, pair, model.Decision{Action: "BUY", Amount: "100", StopLossPrice: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res == nil || res.State != model.OrderFilled || res.FilledSize != 0.5 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if len(market.orders) != 1 || market.orders[0] != "BTC-USDT buy 100" {
+		t.Fatalf("unexpected orders: %v", market.orders)
+	}
+	if len(market.stops) != 1 || market.stops[0] != "BTC-USDT 0.5" {
+		t.Fatalf("expected one stop for the position, got %v", market.stops)
+	}
+
+	if res, err = s.Order(ctx, pair, model.Decision{Action: "HOLD", StopLossPrice: 95}); err != nil || res != nil {
+		t.Fatalf("HOLD should not send an order, got %+v, %v", res, err)
+	}
+	if len(market.stops) != 1 {
+		t.Fatalf("HOLD should replace the stop, got %v", market.stops)
+	}
+
+	// a failed sell keeps the position protected
+	market.reject = true
+	if _, err = s.Order(ctx, pair, model.Decision{Action: "SELL", Amount: "0.5"}); err == nil {
+		t.Fatal("expected an error for a canceled sell")
+	}
+	if len(market.stops) != 1 {
+		t.Fatalf("a failed SELL should keep the stop, got %v", market.stops)
+	}
+
+	market.reject = false
+	if _, err = s.Order(ctx, pair, model.Decision{Action: "SELL", Amount: "0.5"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(market.stops) != 0 {
+		t.Fatalf("SELL should cancel the stop, got %v", market.stops)
+	}
+}
+
+func TestSellLockedByStop(t *testing.T) {
+	market := &fakeMarket{}
+	s := NewTradeService(market, nil)
+	pair := currency.NewPair(currency.USDT, currency.BTC)
+	ctx := context.Background()
+	if _, err := s.Order(ctx, pair, model.Decision{Action: "BUY", Amount: "100", StopLossPrice: 90, TakeProfitPrice: 120}); err != nil {
+		t.Fatal(err)
+	}
+	if market.locked != 0.5 {
+		t.Fatalf("expected the stop to lock the position, locked %v", market.locked)
+	}
+
+	// the stop is canceled before the sell, and placed again when it fails
+	market.reject = true
+	if _, err := s.Order(ctx, pair, model.Decision{Action: "SELL", PositionPct: 1, Amount: "0.5"}); err == nil {
+		t.Fatal("expected an error for a canceled sell")
+	}
+	if len(market.stops) != 1 || market.locked != 0.5 || market.levels != (Levels{StopLoss: 90, TakeProfit: 120}) {
+		t.Fatalf("expected the previous stop to be placed again, got %v at %+v", market.stops, market.levels)
+	}
+
+	market.reject = false
+	res, err := s.Order(ctx, pair, model.Decision{Action: "SELL", PositionPct: 1, Amount: "0.5"})
+	if err != nil || res.FilledSize != 0.5 {
+		t.Fatalf("expected the whole position to be sold, got %+v, %v", res, err)
+	}
+	if len(market.stops) != 0 || market.locked != 0 {
+		t.Errorf("SELL should leave no stop, got %v", market.stops)
+	}
+}
+
+func TestHoldKeepsOmittedLevel(t *testing.T) {
+	market := &fakeMarket{}
+	s := NewTradeService(market, nil)
+	pair := currency.NewPair(currency.USDT, currency.BTC)
+	ctx := context.Background()
+	if _, err := s.Order(ctx, pair, model.Decision{Action: "BUY", Amount: "100", StopLossPrice: 90, TakeProfitPrice: 120}); err != nil {
+		t.Fatal(err)
+	}
+
+	// only a take-profit moves the stop too, and keeps the stop-loss
+	if _, err := s.Order(ctx, pair, model.Decision{Action: "HOLD", TakeProfitPrice: 130}); err != nil {
+		t.Fatal(err)
+	}
+	if len(market.stops) != 1 || market.levels != (Levels{StopLoss: 90, TakeProfit: 130}) {
+		t.Fatalf("expected the take-profit to move, got %v at %+v", market.stops, market.levels)
+	}
+
+	// only a stop-loss keeps the take-profit
+	if _, err := s.Order(ctx, pair, model.Decision{Action: "HOLD", StopLossPrice: 95}); err != nil {
+		t.Fatal(err)
+	}
+	if len(market.stops) != 1 || market.levels != (Levels{StopLoss: 95, TakeProfit: 130}) {
+		t.Fatalf("expected the stop-loss to move, got %v at %+v", market.stops, market.levels)
+	}
+
+	// the same levels leave the stop as it is
+	market.stops = append(market.stops, "untouched")
+	if _, err := s.Order(ctx, pair, model.Decision{Action: "HOLD", StopLossPrice: 95}); err != nil || len(market.stops) != 2 {
+		t.Errorf("expected the stop to be left alone, got %v (%v)", market.stops, err)
+	}
+}
+
+func TestHoldLevelsFromJournal(t *testing.T) {
+	market := &fakeMarket{position: 0.5}
+	journal := &historyJournal{recordingJournal{
+		{Pair: "BTC-USDT", Decision: &model.Decision{Action: "BUY", StopLossPrice: 90, TakeProfitPrice: 120},
+			Order: &model.OrderRequest{}, OrderResult: &model.OrderResult{Side: "buy", FilledSize: 0.5}},
+		{Pair: "BTC-USDT", Decision: &model.Decision{Action: "HOLD", StopLossPrice: 95}},
+	}}
+	s := NewTradeService(market, nil, WithJournal(journal))
+	pair := currency.NewPair(currency.USDT, currency.BTC)
+	if _, err := s.Order(context.Background(), pair, model.Decision{Action: "HOLD", TakeProfitPrice: 130}); err != nil {
+		t.Fatal(err)
+	}
+	if len(market.stops) != 1 || market.stops[0] != "BTC-USDT 0.5" || market.levels != (Levels{StopLoss: 95, TakeProfit: 130}) {
+		t.Errorf("expected the journaled stop-loss to be kept, got %v at %+v", market.stops, market.levels)
+	}
+}
+
+// historyJournal is a recordingJournal the positions are read back from.
+type historyJournal struct{ recordingJournal }
+
+func (j *historyJournal) Executed(_ context.Context, pair, strategy string) ([]*model.RunRecord, error) {
+	res := make([]*model.RunRecord, 0)
+	for i := len(j.recordingJournal) - 1; i >= 0; i-- {
+		if r := j.recordingJournal[i]; r.Pair == pair && r.Strategy == strategy {
+			res = append(res, r)
+		}
+	}
+	return res, nil
+}
+
+func TestStopSizedToPosition(t *testing.T) {
+	// 1.5 BTC of another strategy on the same account
+	market := &fakeMarket{position: 2}
+	journal := &historyJournal{recordingJournal{
+		{Pair: "BTC-USDT", Strategy: "majors", Order: &model.OrderRequest{}, OrderResult: &model.OrderResult{Side: "buy", FilledSize: 0.5}},
+		{Pair: "BTC-USDT", Strategy: "alts", Order: &model.OrderRequest{}, OrderResult: &model.OrderResult{Side: "buy", FilledSize: 1.5}},
+	}}
+	s := NewTradeService(market, nil, WithJournal(journal), WithStrategy(Strategy{Name: "majors"}))
+	pair := currency.NewPair(currency.USDT, currency.BTC)
+	if _, err := s.Order(context.Background(), pair, model.Decision{Action: "HOLD", StopLossPrice: 90}); err != nil {
+		t.Fatal(err)
+	}
+	if len(market.stops) != 1 || market.stops[0] != "BTC-USDT 0.5" {
+		t.Errorf("expected a stop for the position of the strategy, got %v", market.stops)
+	}
+
+	// nothing to tell the position from
+	s = NewTradeService(market, nil, WithStrategy(Strategy{Name: "majors"}))
+	if _, err := s.Order(context.Background(), pair, model.Decision{Action: "HOLD", StopLossPrice: 90}); err != nil {
+		t.Fatal(err)
+	}
+	if len(market.stops) != 1 || market.stops[0] != "BTC-USDT 2" {
+		t.Errorf("expected a stop for the balance, got %v", market.stops)
+	}
+}
+
+// stoppedMarket is a fakeMarket whose exchange-side stops sold by the orders in triggered.
+type stoppedMarket struct {
+	*fakeMarket
+	triggered map[string]float64
+}
+
+func (m *stoppedMarket) GetTriggeredStops(_ context.Context, _, _ string) ([]string, error) {
+	return slices.Sorted(maps.Keys(m.triggered)), nil
+}
+
+func (m *stoppedMarket) GetOrder(_ context.Context, instId, ordId string) (*model.OrderResult, error) {
+	return &model.OrderResult{OrderId: ordId, InstId: instId, Side: "sell", State: model.OrderFilled, FilledSize: m.triggered[ordId]}, nil
+}
+
+func (m *stoppedMarket) GetFills(_ context.Context, _, _ string) ([]model.OrderFill, error) {
+	return []model.OrderFill{{Ts: "1700000000000"}}, nil
+}
+
+func TestStopTriggered(t *testing.T) {
+	// the stop of the strategy sold its 0.5 BTC, the rest is another strategy's
+	market := &stoppedMarket{&fakeMarket{position: 0.5}, map[string]float64{"7": 0.5}}
+	journal := &historyJournal{recordingJournal{
+		{Pair: "BTC-USDT", Strategy: "majors", Decision: &model.Decision{Action: "BUY", StopLossPrice: 90},
+			Order: &model.OrderRequest{}, OrderResult: &model.OrderResult{OrderId: "1", Side: "buy", FilledSize: 0.5}},
+		{Pair: "BTC-USDT", Strategy: "alts", Order: &model.OrderRequest{}, OrderResult: &model.OrderResult{OrderId: "2", Side: "buy", FilledSize: 0.5}},
+	}}
+	s := NewTradeService(market, nil, WithJournal(journal), WithStrategy(Strategy{Name: "majors"}))
+	pair := currency.NewPair(currency.USDT, currency.BTC)
+	for range 2 {
+		if _, err := s.Order(context.Background(), pair, model.Decision{Action: "HOLD", StopLossPrice: 95}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(market.stops) != 0 {
+		t.Errorf("expected no stop over the position of another strategy, got %v", market.stops)
+	}
+	if len(journal.recordingJournal) != 3 {
+		t.Fatalf("expected the triggered stop to be journaled once, got %d entries", len(journal.recordingJournal))
+	}
+	r := journal.recordingJournal[2]
+	if r.Strategy != "majors" || r.OrderResult.OrderId != "7" || !r.Ts.Equal(time.UnixMilli(1700000000000)) {
+		t.Errorf("unexpected entry of the triggered stop %+v", r)
+	}
+	records, _ := journal.Executed(context.Background(), "BTC-USDT", "majors")
+	if size, known := Position(pair, records, false); size != 0 || !known {
+		t.Errorf("expected the strategy to hold nothing, got %v (%v)", size, known)
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	cases := []struct {
+		name     string
+		requests []float64
+		budget   float64
+		want     []float64
+	}{
+		{"within budget", []float64{100, 200}, 500, []float64{100, 200}},
+		{"scaled", []float64{600, 400}, 500, []float64{300, 200}},
+		{"no budget", []float64{100}, 0, []float64{0}},
+		{"negative ignored", []float64{-50, 100}, 50, []float64{0, 50}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := allocate(c.requests, c.budget)
+			for i := range c.want {
+				if got[i] != c.want[i] {
+					t.Fatalf("allocate(%v, %v) = %v, want %v", c.requests, c.budget, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+type recordingWatcher []string
+
+func (w *recordingWatcher) Track(pair currency.Pair, decision model.Decision, _ *model.OrderResult) {
+	*w = append(*w, InstId(pair)+" "+decision.Action)
+}
+
+func TestOrderWatcher(t *testing.T) {
+	watcher := &recordingWatcher{}
+	s := NewTradeService(&fakeMarket{}, nil, WithWatcher(watcher))
+	pair := currency.NewPair(currency.USDT, currency.BTC)
+	for _, d := range []model.Decision{
+		{Action: "BUY", Amount: "100", StopLossPrice: 90},
+		{Action: "HOLD"},
+		{Action: "SELL", Amount: "0.5"},
+	} {
+		if _, err := s.Order(context.Background(), pair, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(*watcher) != 3 || (*watcher)[2] != "BTC-USDT SELL" {
+		t.Errorf("every executed decision should be tracked, got %v", *watcher)
+	}
+}
+
+func TestOrderCanceled(t *testing.T) {
+	watcher := &recordingWatcher{}
+	market := &fakeMarket{reject: true}
+	s := NewTradeService(market, nil, WithWatcher(watcher))
+	pair := currency.NewPair(currency.USDT, currency.BTC)
+	res, err := s.Order(context.Background(), pair, model.Decision{Action: "BUY", Amount: "100", StopLossPrice: 90})
+	if err == nil || res == nil || res.State != model.OrderCanceled {
+		t.Fatalf("a canceled order without fill should fail, got %+v, %v", res, err)
+	}
+	if len(*watcher) != 0 || len(market.stops) != 0 {
+		t.Errorf("nothing bought, nothing to protect: watcher %v, stops %v", *watcher, market.stops)
+	}
+}
+
+type buyAdvisor struct{}
+
+func (buyAdvisor) Chat(_ context.Context, _ []pkgllm.Messages) (string, error) {
+	return `{"action":"BUY","position_pct":0.5,"stop_loss_price":200,"reason":"breakout"}`, nil
+}
+
+func TestAnalyzePortfolio(t *testing.T) {
+	n := DefaultStrategy.Candles()
+	market := &fakeMarket{position: 1000, candles: make([]model.Candlestick, n)}
+	for i := range market.candles {
+		c := float64(n - i)
+		market.candles[i] = model.Candlestick{O: c, H: c, L: c, C: c, Vol: 1}
+	}
+	llmService, _ := llm.NewClient(buyAdvisor{})
+	s := NewTradeService(market, llmService)
+	pairs := []currency.Pair{currency.NewPair(currency.USDT, currency.BTC), currency.NewPair(currency.USDT, currency.ETH)}
+	res, balance, err := s.AnalyzePortfolio(context.Background(), pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || balance == nil {
+		t.Fatalf("expected 2 decisions and the balance, got %d and %v", len(res), balance)
+	}
+	for _, r := range res {
+		if r.Err != nil || r.Decision == nil || r.Decision.Action != "BUY" {
+			t.Errorf("%s: unexpected decision %+v, err %v", InstId(r.Pair), r.Decision, r.Err)
+		}
+	}
+	if len(market.orders) != 0 {
+		t.Errorf("analysis must not place orders, got %v", market.orders)
+	}
+
+	mixed := []currency.Pair{pairs[0], currency.NewPair(currency.Coin("USDC"), currency.ETH)}
+	if _, _, err = s.AnalyzePortfolio(context.Background(), mixed); err == nil {
+		t.Errorf("expected an error for mixed quote currencies")
+	}
+
+	// three buys of half the cash each share it
+	s = NewTradeService(&pricedMarket{market}, llmService)
+	pairs = append(pairs, currency.NewPair(currency.USDT, currency.Coin("SOL")))
+	planned, err := s.PlanPortfolio(context.Background(), pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total float64
+	for _, r := range planned {
+		amount, _ := strconv.ParseFloat(r.Decision.Amount, 64)
+		if math.Abs(amount-1000.0/3) > 1e-6 {
+			t.Errorf("%s: expected the allocated amount, got %s", InstId(r.Pair), r.Decision.Amount)
+		}
+		total += amount
+	}
+	if total > 1000+1e-6 || len(market.orders) != 0 {
+		t.Errorf("the plan should stay within the budget without orders, got %g and %v", total, market.orders)
+	}
+}
+
+// pricedMarket values every coin at 1 USD.
+type pricedMarket struct {
+	*fakeMarket
+}
+
+func (p *pricedMarket) GetBalance(ctx context.Context, coin ...currency.Coin) (*model.TradeData, error) {
+	res, err := p.fakeMarket.GetBalance(ctx, coin...)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range res.AccountAssets {
+		a.EquityUSD = a.Equity
+	}
+	return res, nil
+}
+
+// normalizingMarket rounds order sizes down to whole units.
+type normalizingMarket struct {
+	fakeMarket
+}
+
+func (n *normalizingMarket) NormalizeOrder(_ context.Context, _, _, sz string) (string, error) {
+	return strings.Split(sz, ".")[0], nil
+}
+
+// minimumMarket rejects orders below minSize coins, e.g. the dust a sell leaves.
+type minimumMarket struct {
+	fakeMarket
+	minSize float64
+}
+
+func (m *minimumMarket) NormalizeOrder(_ context.Context, _, _, sz string) (string, error) {
+	if size, _ := strconv.ParseFloat(sz, 64); size < m.minSize {
+		return "", model.ErrBelowMinimum
+	}
+	return sz, nil
+}
+
+func TestSellLeavesDust(t *testing.T) {
+	market := &minimumMarket{minSize: 0.001}
+	s := NewTradeService(market, nil)
+	pair := currency.NewPair(currency.USDT, currency.BTC)
+	ctx := context.Background()
+
+	// what is left is below the minimum, the stop is not placed again
+	if _, err := s.Order(ctx, pair, model.Decision{Action: "BUY", Amount: "100", StopLossPrice: 90}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Order(ctx, pair, model.Decision{Action: "SELL", PositionPct: 0.9, Amount: "0.4999", StopLossPrice: 90}); err != nil {
+		t.Fatalf("dust should not fail the sell: %v", err)
+	}
+	if len(market.stops) != 0 {
+		t.Errorf("expected no stop on dust, got %v", market.stops)
+	}
+
+	// a full exit protects nothing, whatever the exchange takes
+	market.minSize = 0
+	if _, err := s.Order(ctx, pair, model.Decision{Action: "BUY", Amount: "100", StopLossPrice: 90}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Order(ctx, pair, model.Decision{Action: "SELL", PositionPct: 1, Amount: "0.4999", StopLossPrice: 90}); err != nil {
+		t.Fatal(err)
+	}
+	if len(market.stops) != 0 {
+		t.Errorf("expected no stop after a full exit, got %v", market.stops)
+	}
+}
+
+type recordingJournal []*model.RunRecord
+
+func (j *recordingJournal) Record(_ context.Context, r *model.RunRecord) error {
+	*j = append(*j, r)
+	return nil
+}
+
+type invalidAdvisor struct{}
+
+func (invalidAdvisor) Chat(_ context.Context, _ []pkgllm.Messages) (string, error) {
+	return `{"action":"MAYBE"}`, nil
+}
+
+func TestExecuteJournal(t *testing.T) {
+	market := &fakeMarket{candles: make([]model.Candlestick, DefaultStrategy.Candles())}
+	for i := range market.candles {
+		market.candles[i] = model.Candlestick{H: 2, L: 1, C: 1.5, Vol: 1}
+	}
+	llmService, _ := llm.NewClient(invalidAdvisor{})
+	journal := &recordingJournal{}
+	s := NewTradeService(market, llmService, WithJournal(journal), WithStrategy(Strategy{Name: "majors"}))
+	pair := currency.NewPair(currency.USDT, currency.BTC)
+
+	// the model answered, but never validly
+	if _, err := s.Execute(context.Background(), pair); err == nil {
+		t.Fatal("expected an error without a valid decision")
+	}
+	if len(*journal) != 1 {
+		t.Fatalf("expected one entry, got %d", len(*journal))
+	}
+	if r := (*journal)[0]; r.Error == "" || r.Prompt == "" || !strings.Contains(r.Response, "MAYBE") || r.Strategy != "majors" {
+		t.Errorf("the failed run should keep the prompt and answers, got %+v", r)
+	}
+
+	market.err = errors.New("timeout")
+	if _, err := s.ExecutePortfolio(context.Background(), []currency.Pair{pair}); err == nil {
+		t.Fatal("expected the balance error")
+	}
+	if len(*journal) != 2 || (*journal)[1].Error != "timeout" {
+		t.Errorf("the balance failure should be journaled, got %+v", *journal)
+	}
+}
+
+func TestAdvisory(t *testing.T) {
+	market := &normalizingMarket{fakeMarket{position: 2}}
+	market.stops = []string{"BTC-USDT 2"}
+	shadow := &fakeMarket{}
+	watcher := &recordingWatcher{}
+	journal := &recordingJournal{}
+	s := NewTradeService(market, nil, WithAdvisory(shadow), WithWatcher(watcher), WithJournal(journal))
+	pair := currency.NewPair(currency.USDT, currency.BTC)
+	if !s.Advisory() {
+		t.Fatal("expected an advisory service")
+	}
+
+	res, err := s.Order(context.Background(), pair, model.Decision{Action: "BUY", Amount: "100.7", StopLossPrice: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(market.orders) != 0 || len(market.stops) != 1 {
+		t.Errorf("the market must not be touched, got orders %v and stops %v", market.orders, market.stops)
+	}
+	if len(shadow.orders) != 1 || shadow.orders[0] != "BTC-USDT buy 100" || res.FilledSize != 0.5 {
+		t.Errorf("expected the normalized order in the shadow portfolio, got %v", shadow.orders)
+	}
+	if len(*watcher) != 1 {
+		t.Errorf("the watcher should follow the advice, got %v", *watcher)
+	}
+	// balances come from the shadow portfolio
+	balance, err := s.GetBalance(context.Background(), currency.BTC)
+	if err != nil || balance.AccountAssets[currency.BTC].Equity != 0.5 {
+		t.Errorf("expected the shadow balance, got %+v (%v)", balance, err)
+	}
 
+	s.record(context.Background(), pair, nil, nil, &model.Decision{Action: "BUY", Amount: "100.7", StopLossPrice: 90, TakeProfitPrice: 120}, true, res, nil)
+	if len(*journal) != 1 || !(*journal)[0].Advisory {
+		t.Errorf("journal entries should be advisory, got %+v", *journal)
+	}
+	if o := (*journal)[0].Order; o == nil || o.Size != "100" || o.StopLoss != 90 || o.TakeProfit != 120 {
+		t.Errorf("expected the normalized order with its levels in the journal, got %+v", o)
+	}
 }
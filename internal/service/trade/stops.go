@@ -0,0 +1,187 @@
+package trade
+
+import (
+	"context"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"log"
+	"strconv"
+	"time"
+)
+
+// TriggeredStops is implemented by stop markets that tell what the stops of a tag sold once
+// they triggered, e.g. the okx client, see Reconcile.
+type TriggeredStops interface {
+	// GetTriggeredStops returns the ids of the orders the stops of instId placed with tag
+	// triggered.
+	GetTriggeredStops(ctx context.Context, instId, tag string) ([]string, error)
+	GetOrder(ctx context.Context, instId, ordId string) (*model.OrderResult, error)
+	GetFills(ctx context.Context, instId, ordId string) ([]model.OrderFill, error)
+}
+
+// Reconcile journals the sells of the exchange-side stops of strategy on pair that triggered
+// and are not journaled yet, so that Position counts them, and returns the entries it
+// added. An entry is dated by its last fill, so it is summed up before the orders that came
+// after it. Without a market that reports triggered stops or a journal that is a History
+// too, there is nothing to reconcile.
+func Reconcile(ctx context.Context, market Market, journal Journal, pair currency.Pair, strategy string) ([]*model.RunRecord, error) {
+	stops, ok := market.(TriggeredStops)
+	history, listed := journal.(History)
+	if !ok || !listed {
+		return nil, nil
+	}
+	instId := InstId(pair)
+	ordIds, err := stops.GetTriggeredStops(ctx, instId, strategy)
+	if err != nil || len(ordIds) == 0 {
+		return nil, err
+	}
+	records, err := history.Executed(ctx, instId, strategy)
+	if err != nil {
+		return nil, err
+	}
+	journaled := make(map[string]bool, len(records))
+	for _, r := range records {
+		if r.OrderResult != nil && r.OrderResult.OrderId != "" {
+			journaled[r.OrderResult.OrderId] = true
+		}
+	}
+	added := make([]*model.RunRecord, 0)
+	for _, ordId := range ordIds {
+		if journaled[ordId] {
+			continue
+		}
+		res, err := stops.GetOrder(ctx, instId, ordId)
+		if err != nil {
+			return added, err
+		}
+		if res.FilledSize <= 0 {
+			continue
+		}
+		if res.Fills, err = stops.GetFills(ctx, instId, ordId); err != nil {
+			return added, err
+		}
+		r := &model.RunRecord{
+			Ts:          filledAt(res),
+			Pair:        instId,
+			Strategy:    strategy,
+			Decision:    &model.Decision{Action: "SELL", Reason: "exchange-side stop triggered"},
+			Order:       &model.OrderRequest{InstId: instId, Side: "sell", Size: strconv.FormatFloat(res.FilledSize, 'f', -1, 64)},
+			OrderResult: res,
+		}
+		if err = journal.Record(ctx, r); err != nil {
+			return added, err
+		}
+		log.Println(fmt.Sprintf("[trade.Reconcile] %s 交易所止损单已成交: %s, 数量: %f, 均价: %.2f", instId, ordId, res.FilledSize, res.AvgPrice))
+		added = append(added, r)
+	}
+	return added, nil
+}
+
+// filledAt returns the time of the last fill of res, now when its fills do not tell.
+func filledAt(res *model.OrderResult) time.Time {
+	var last int64
+	for _, f := range res.Fills {
+		if ts, err := strconv.ParseInt(f.Ts, 10, 64); err == nil {
+			last = max(last, ts)
+		}
+	}
+	if last == 0 {
+		return time.Now()
+	}
+	return time.UnixMilli(last)
+}
+
+// Levels are the exit prices of a position, 0 means not set.
+type Levels struct {
+	StopLoss   float64
+	TakeProfit float64
+}
+
+// LevelsOf returns the levels decision leaves a position at prev with. ok is false when the
+// decision does not change them. A BUY or SELL replaces both; a HOLD only moves the levels it
+// carries. The exchange-side stop of Order and the guard both follow this rule.
+func LevelsOf(prev Levels, decision model.Decision) (levels Levels, ok bool) {
+	levels = Levels{StopLoss: decision.StopLossPrice, TakeProfit: decision.TakeProfitPrice}
+	switch decision.Action {
+	case "BUY", "SELL":
+		// Order protects what is left after a partial SELL with the new levels
+		return levels, true
+	case "HOLD":
+		if levels.StopLoss <= 0 {
+			levels.StopLoss = prev.StopLoss
+		}
+		if levels.TakeProfit <= 0 {
+			levels.TakeProfit = prev.TakeProfit
+		}
+		return levels, levels != prev
+	}
+	return prev, false
+}
+
+// LastLevels returns the levels the executed decisions in records, newest first, left the
+// position with. Only advisory or only live entries count, as advisory says, and failed ones
+// are skipped.
+func LastLevels(records []*model.RunRecord, advisory bool) Levels {
+	var levels Levels
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if r.Advisory != advisory || r.Decision == nil || r.Error != "" || r.OrderError != "" {
+			continue
+		}
+		levels, _ = LevelsOf(levels, *r.Decision)
+	}
+	return levels
+}
+
+// levels returns the levels of the exchange-side stop of pair: the last ones Order set, or
+// the ones of the journal after a restart. known is false when neither tells.
+func (o *Service) levels(ctx context.Context, pair currency.Pair) (levels Levels, known bool) {
+	instId := InstId(pair)
+	o.mu.Lock()
+	levels, known = o.stops[instId]
+	o.mu.Unlock()
+	if known {
+		return levels, true
+	}
+	records, ok := o.executed(ctx, pair)
+	if !ok {
+		return Levels{}, false
+	}
+	return LastLevels(records, o.shadow != nil), true
+}
+
+// executed returns the executed journal entries of the strategy on pair, see History, once
+// the stops that triggered on the exchange are journaled. ok is false without a History.
+func (o *Service) executed(ctx context.Context, pair currency.Pair) (records []*model.RunRecord, ok bool) {
+	history, ok := o.journal.(History)
+	if !ok {
+		return nil, false
+	}
+	if o.shadow == nil {
+		if _, err := Reconcile(ctx, o.market, o.journal, pair, o.strategy.Name); err != nil {
+			log.Println(fmt.Sprintf("[trade.Reconcile] %s: %s", InstId(pair), err.Error()))
+		}
+	}
+	records, err := history.Executed(ctx, InstId(pair), o.strategy.Name)
+	if err != nil {
+		log.Println(fmt.Sprintf("[trade.executed] %s", err.Error()))
+		return nil, false
+	}
+	return records, true
+}
+
+// setLevels remembers the levels of pair, forgets them when known is false, e.g. after a
+// stop failed to be placed, so they are read from the journal again.
+func (o *Service) setLevels(pair currency.Pair, levels Levels, known bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !known {
+		delete(o.stops, InstId(pair))
+		return
+	}
+	if o.stops == nil {
+		o.stops = make(map[string]Levels)
+	}
+	o.stops[InstId(pair)] = levels
+}
@@ -0,0 +1,215 @@
+package trade
+
+import (
+	"fmt"
+	"github.com/twoonefour/sigmaflow/internal/model"
+	"github.com/twoonefour/sigmaflow/pkg/currency"
+	"github.com/twoonefour/sigmaflow/pkg/indicator"
+	"slices"
+	"strings"
+)
+
+// streamFetch is how many of the newest candles a run fetches for a series whose indicators
+// are kept between runs. It bridges a few missed runs, a longer gap fetches the whole
+// window again.
+const streamFetch = 10
+
+// stream keeps the indicators of one series between runs, so a run fetches only the candles
+// closed since the last one instead of the whole window. The indicators are fed closed
+// candles only: the newest candle of a fetch may still be open, it is evaluated on copies.
+type stream struct {
+	lastTs           string // of the newest candle fed
+	ma5, ma50, ma200 indicator.Indicator
+	bb               *indicator.BollingerBands
+	extras           []streamColumn
+	rows             []model.CandleWithIndicator // of the fed candles, newest first
+	lookback         int
+}
+
+// streamColumn is an extra indicator of a stream, see extraColumns for the batch version.
+type streamColumn struct {
+	name string
+	ind  indicator.Indicator
+}
+
+// streamColumns returns the streaming versions of the named indicators, false when one of
+// them has none.
+func streamColumns(names []string) ([]streamColumn, bool) {
+	res := make([]streamColumn, 0, len(names))
+	for _, name := range names {
+		switch strings.ToUpper(name) {
+		case indicator.NameEMA:
+			res = append(res, streamColumn{"EMA12", indicator.NewEMA(12)}, streamColumn{"EMA26", indicator.NewEMA(26)})
+		case indicator.NameRSI:
+			res = append(res, streamColumn{"RSI14", indicator.NewRSI(14)})
+		case indicator.NameATR:
+			res = append(res, streamColumn{"ATR14", indicator.NewATR(14)})
+		default:
+			return nil, false
+		}
+	}
+	return res, true
+}
+
+// streamable reports whether every named indicator can be streamed.
+func streamable(names []string) bool {
+	_, ok := streamColumns(names)
+	return ok
+}
+
+func newStream(strategy Strategy) *stream {
+	extras, _ := streamColumns(strategy.Indicators)
+	return &stream{
+		ma5:      indicator.NewSMA(5),
+		ma50:     indicator.NewSMA(50),
+		ma200:    indicator.NewSMA(200),
+		bb:       indicator.NewBollingerBands(20, 2.0),
+		extras:   extras,
+		lookback: strategy.Lookback,
+	}
+}
+
+func toIndicatorCandle(c model.Candlestick) indicator.Candle {
+	return indicator.Candle{High: c.H, Low: c.L, Close: c.C, Volume: c.Vol}
+}
+
+// feed adds a closed candle.
+func (s *stream) feed(c model.Candlestick) {
+	in := toIndicatorCandle(c)
+	s.ma5.Update(in)
+	s.ma50.Update(in)
+	s.ma200.Update(in)
+	s.bb.Update(in)
+	for _, e := range s.extras {
+		e.ind.Update(in)
+	}
+	s.lastTs = c.Ts
+	s.rows = append([]model.CandleWithIndicator{s.row(c, s.ma5, s.ma50, s.ma200, s.bb, s.extras)}, s.rows...)
+	if len(s.rows) >= s.lookback {
+		// the newest candle is not fed, it takes the last place
+		s.rows = s.rows[:s.lookback-1]
+	}
+}
+
+func (s *stream) row(c model.Candlestick, ma5, ma50, ma200 indicator.Indicator, bb *indicator.BollingerBands, extras []streamColumn) model.CandleWithIndicator {
+	var extra []model.IndicatorValue
+	if len(extras) > 0 {
+		extra = make([]model.IndicatorValue, len(extras))
+		for i, e := range extras {
+			extra[i] = model.IndicatorValue{Name: e.name, Value: e.ind.Value()}
+		}
+	}
+	return indicatorRow(c, ma5.Value(), ma50.Value(), ma200.Value(), bb.Bands(), extra)
+}
+
+// clone returns an independent copy of s, to be advanced without holding the lock of the
+// service.
+func (s *stream) clone() *stream {
+	extras := make([]streamColumn, len(s.extras))
+	for i, e := range s.extras {
+		extras[i] = streamColumn{e.name, e.ind.Clone()}
+	}
+	return &stream{
+		lastTs:   s.lastTs,
+		ma5:      s.ma5.Clone(),
+		ma50:     s.ma50.Clone(),
+		ma200:    s.ma200.Clone(),
+		bb:       s.bb.Clone().(*indicator.BollingerBands),
+		extras:   extras,
+		rows:     slices.Clone(s.rows),
+		lookback: s.lookback,
+	}
+}
+
+// candles returns the rows with newest, the candle after the fed ones, evaluated on copies
+// of the indicators.
+func (s *stream) candles(newest model.Candlestick) []model.CandleWithIndicator {
+	in := toIndicatorCandle(newest)
+	peek := func(ind indicator.Indicator) indicator.Indicator {
+		clone := ind.Clone()
+		clone.Update(in)
+		return clone
+	}
+	extras := make([]streamColumn, len(s.extras))
+	for i, e := range s.extras {
+		extras[i] = streamColumn{e.name, peek(e.ind)}
+	}
+	res := make([]model.CandleWithIndicator, 0, s.lookback)
+	res = append(res, s.row(newest, peek(s.ma5), peek(s.ma50), peek(s.ma200), peek(s.bb).(*indicator.BollingerBands), extras))
+	return append(res, s.rows...)
+}
+
+// advance feeds the candles closed since the last run, c is newest first. It returns false
+// when c does not reach back to the last fed candle, the stream has to be seeded again.
+func (s *stream) advance(c []model.Candlestick) bool {
+	last := -1
+	for i, candle := range c {
+		if candle.Ts == s.lastTs {
+			last = i
+			break
+		}
+	}
+	if last < 1 {
+		return false
+	}
+	for i := last - 1; i >= 1; i-- {
+		s.feed(c[i])
+	}
+	return true
+}
+
+func streamKey(pair currency.Pair, strategy Strategy) string {
+	return fmt.Sprintf("%s %s %d %s", InstId(pair), strategy.Timeframe, strategy.Lookback, strings.Join(strategy.Indicators, ","))
+}
+
+// streamSeries is series on the kept indicators of pair and strategy. The first run, or one
+// after a gap, fetches the whole window and feeds it; later runs fetch streamFetch candles.
+// The candles are fetched without holding the lock, a run advances a copy of the stream.
+func (o *Service) streamSeries(pair currency.Pair, strategy Strategy) (model.CandleSeries, error) {
+	series := model.CandleSeries{Timeframe: strategy.Timeframe}
+	key := streamKey(pair, strategy)
+	o.mu.Lock()
+	s, ok := o.streams[key]
+	if ok {
+		s = s.clone()
+	}
+	o.mu.Unlock()
+	if ok {
+		c, err := o.market.GetCandle(pair, strategy.Timeframe, min(streamFetch, strategy.Candles()))
+		if err != nil {
+			return series, err
+		}
+		if s.advance(c) {
+			o.setStream(key, s)
+			series.Candles = s.candles(c[0])
+			return series, nil
+		}
+	}
+
+	need := strategy.Candles()
+	c, err := o.market.GetCandle(pair, strategy.Timeframe, need)
+	if err != nil {
+		return series, err
+	}
+	if len(c) < need {
+		return series, fmt.Errorf("[trade.GetCandle] need %d candles, got %d", need, len(c))
+	}
+	s = newStream(strategy)
+	// oldest first, all but the newest, which may still be open
+	for i := len(c) - 1; i >= 1; i-- {
+		s.feed(c[i])
+	}
+	o.setStream(key, s)
+	series.Candles = s.candles(c[0])
+	return series, nil
+}
+
+// setStream keeps s as the stream of key.
+func (o *Service) setStream(key string, s *stream) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.streams == nil {
+		o.streams = make(map[string]*stream)
+	}
+	o.streams[key] = s
+}
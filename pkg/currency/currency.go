@@ -1,10 +1,13 @@
 package currency
 
+import "strings"
+
 type Coin string
 
 const (
 	BTC  Coin = "BTC"
 	ETH  Coin = "ETH"
+	SOL  Coin = "SOL"
 	USDT Coin = "USDT"
 	USD  Coin = "USD"
 )
@@ -26,6 +29,19 @@ func NewPair(base, quote Coin) Pair {
 	return Pair{Base: base, Quote: quote}
 }
 
+// ParsePairs turns a comma separated coin list such as "BTC,ETH,SOL" into pairs quoted in quote.
+func ParsePairs(coins string, quote Coin) []Pair {
+	res := make([]Pair, 0)
+	for _, c := range strings.Split(coins, ",") {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if c == "" {
+			continue
+		}
+		res = append(res, NewPair(quote, Coin(c)))
+	}
+	return res
+}
+
 func (p Pair) String() string {
 	return string(p.Base) + "_" + string(p.Quote) // 比如用下划线区分
 }
@@ -68,3 +68,241 @@ func CalculateMA(data []float64, period int) []float64 {
 
 	return sma
 }
+
+// The functions below return a slice aligned with their input: result[i] belongs to
+// input[i], and positions before the indicator's warm-up period are left at zero.
+
+type MACD struct {
+	Line      float64
+	Signal    float64
+	Histogram float64
+}
+
+type Stochastic struct {
+	K float64
+	D float64
+}
+
+// CalculateEMA seeds the average with the SMA of the first period values.
+func CalculateEMA(data []float64, period int) []float64 {
+	results := make([]float64, len(data))
+	if period <= 0 || len(data) < period {
+		return results
+	}
+	ema := 0.0
+	for i := 0; i < period; i++ {
+		ema += data[i]
+	}
+	ema /= float64(period)
+	results[period-1] = ema
+	alpha := 2 / float64(period+1)
+	for i := period; i < len(data); i++ {
+		ema += alpha * (data[i] - ema)
+		results[i] = ema
+	}
+	return results
+}
+
+// CalculateRSI uses Wilder's smoothing; the first value is at index period.
+func CalculateRSI(closes []float64, period int) []float64 {
+	results := make([]float64, len(closes))
+	if period <= 0 || len(closes) <= period {
+		return results
+	}
+	var gain, loss float64
+	for i := 1; i <= period; i++ {
+		change := closes[i] - closes[i-1]
+		if change > 0 {
+			gain += change
+		} else {
+			loss -= change
+		}
+	}
+	gain /= float64(period)
+	loss /= float64(period)
+	results[period] = rsi(gain, loss)
+	for i := period + 1; i < len(closes); i++ {
+		change := closes[i] - closes[i-1]
+		gain = (gain*float64(period-1) + math.Max(change, 0)) / float64(period)
+		loss = (loss*float64(period-1) + math.Max(-change, 0)) / float64(period)
+		results[i] = rsi(gain, loss)
+	}
+	return results
+}
+
+func rsi(gain, loss float64) float64 {
+	if loss == 0 {
+		if gain == 0 {
+			return 50
+		}
+		return 100
+	}
+	return 100 - 100/(1+gain/loss)
+}
+
+// CalculateMACD returns the fast-slow EMA line, its signal EMA and their difference.
+// The line starts at index slow-1 and the signal at index slow+signal-2.
+func CalculateMACD(closes []float64, fast, slow, signal int) []MACD {
+	results := make([]MACD, len(closes))
+	if fast <= 0 || slow <= fast || signal <= 0 || len(closes) < slow {
+		return results
+	}
+	fastEMA := CalculateEMA(closes, fast)
+	slowEMA := CalculateEMA(closes, slow)
+	line := make([]float64, len(closes)-slow+1)
+	for i := range line {
+		line[i] = fastEMA[i+slow-1] - slowEMA[i+slow-1]
+		results[i+slow-1].Line = line[i]
+	}
+	signalEMA := CalculateEMA(line, signal)
+	for i := signal - 1; i < len(line); i++ {
+		results[i+slow-1].Signal = signalEMA[i]
+		results[i+slow-1].Histogram = line[i] - signalEMA[i]
+	}
+	return results
+}
+
+func trueRange(high, low, closes []float64, i int) float64 {
+	if i == 0 {
+		return high[0] - low[0]
+	}
+	return math.Max(high[i]-low[i], math.Max(math.Abs(high[i]-closes[i-1]), math.Abs(low[i]-closes[i-1])))
+}
+
+// CalculateATR is Wilder's average true range; the first value at index period averages
+// the true ranges of bars 1..period.
+func CalculateATR(high, low, closes []float64, period int) []float64 {
+	results := make([]float64, len(closes))
+	if period <= 0 || len(closes) <= period {
+		return results
+	}
+	atr := 0.0
+	for i := 1; i <= period; i++ {
+		atr += trueRange(high, low, closes, i)
+	}
+	atr /= float64(period)
+	results[period] = atr
+	for i := period + 1; i < len(closes); i++ {
+		atr = (atr*float64(period-1) + trueRange(high, low, closes, i)) / float64(period)
+		results[i] = atr
+	}
+	return results
+}
+
+// CalculateStochastic returns %K over kPeriod bars and %D, the SMA of %K over dPeriod.
+func CalculateStochastic(high, low, closes []float64, kPeriod, dPeriod int) []Stochastic {
+	results := make([]Stochastic, len(closes))
+	if kPeriod <= 0 || dPeriod <= 0 || len(closes) < kPeriod {
+		return results
+	}
+	k := make([]float64, 0, len(closes)-kPeriod+1)
+	for i := kPeriod - 1; i < len(closes); i++ {
+		hh, ll := high[i], low[i]
+		for j := i - kPeriod + 1; j < i; j++ {
+			hh = math.Max(hh, high[j])
+			ll = math.Min(ll, low[j])
+		}
+		value := 50.0
+		if hh > ll {
+			value = 100 * (closes[i] - ll) / (hh - ll)
+		}
+		k = append(k, value)
+		results[i].K = value
+	}
+	d := CalculateMA(k, dPeriod)
+	for i, v := range d {
+		results[i+kPeriod+dPeriod-2].D = v
+	}
+	return results
+}
+
+// CalculateADX is Wilder's average directional index. The first value, at index
+// 2*period-1, averages the DX of bars period..2*period-1.
+func CalculateADX(high, low, closes []float64, period int) []float64 {
+	results := make([]float64, len(closes))
+	if period <= 0 || len(closes) < 2*period {
+		return results
+	}
+	var tr, plusDM, minusDM, adx float64
+	for i := 1; i < len(closes); i++ {
+		up := high[i] - high[i-1]
+		down := low[i-1] - low[i]
+		pdm, mdm := 0.0, 0.0
+		if up > down && up > 0 {
+			pdm = up
+		}
+		if down > up && down > 0 {
+			mdm = down
+		}
+		if i <= period {
+			tr += trueRange(high, low, closes, i)
+			plusDM += pdm
+			minusDM += mdm
+			if i < period {
+				continue
+			}
+		} else {
+			tr = tr - tr/float64(period) + trueRange(high, low, closes, i)
+			plusDM = plusDM - plusDM/float64(period) + pdm
+			minusDM = minusDM - minusDM/float64(period) + mdm
+		}
+
+		dx := 0.0
+		if tr > 0 {
+			plusDI := 100 * plusDM / tr
+			minusDI := 100 * minusDM / tr
+			if plusDI+minusDI > 0 {
+				dx = 100 * math.Abs(plusDI-minusDI) / (plusDI + minusDI)
+			}
+		}
+		switch {
+		case i < 2*period-1:
+			adx += dx
+		case i == 2*period-1:
+			adx = (adx + dx) / float64(period)
+			results[i] = adx
+		default:
+			adx = (adx*float64(period-1) + dx) / float64(period)
+			results[i] = adx
+		}
+	}
+	return results
+}
+
+// CalculateOBV accumulates volume on up closes and subtracts it on down closes, starting at 0.
+func CalculateOBV(closes, volume []float64) []float64 {
+	results := make([]float64, len(closes))
+	for i := 1; i < len(closes); i++ {
+		results[i] = results[i-1]
+		switch {
+		case closes[i] > closes[i-1]:
+			results[i] += volume[i]
+		case closes[i] < closes[i-1]:
+			results[i] -= volume[i]
+		}
+	}
+	return results
+}
+
+// CalculateVWAP is the volume weighted typical price (H+L+C)/3 over a rolling window of
+// period bars, or since the first bar when period <= 0.
+func CalculateVWAP(high, low, closes, volume []float64, period int) []float64 {
+	results := make([]float64, len(closes))
+	var pv, vol float64
+	for i := range closes {
+		pv += (high[i] + low[i] + closes[i]) / 3 * volume[i]
+		vol += volume[i]
+		if period > 0 && i >= period {
+			j := i - period
+			pv -= (high[j] + low[j] + closes[j]) / 3 * volume[j]
+			vol -= volume[j]
+		}
+		if period > 0 && i < period-1 {
+			continue
+		}
+		if vol > 0 {
+			results[i] = pv / vol
+		}
+	}
+	return results
+}
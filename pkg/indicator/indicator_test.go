@@ -0,0 +1,100 @@
+package indicator
+
+import (
+	"math"
+	"testing"
+)
+
+// Wilder's RSI example series, also used by StockCharts.
+var closes = []float64{44.34, 44.09, 44.15, 43.61, 44.33, 44.83, 45.10, 45.42, 45.84, 46.08, 45.89, 46.03, 45.61, 46.28, 46.28, 46.00, 46.03, 46.41, 46.22, 45.64, 46.21, 46.25, 45.71, 46.45, 45.78, 45.35, 44.03, 44.18, 44.22, 44.57, 43.42, 42.66, 43.13}
+
+var (
+	high  = []float64{48.70, 48.72, 48.90, 48.87, 48.82, 49.05, 49.20, 49.35, 49.92, 50.19, 50.12, 49.66, 49.88, 50.19, 50.36, 50.57, 50.65, 50.43, 49.63, 50.33, 50.29, 50.17, 49.32, 48.50, 48.32, 46.80, 47.80, 48.39, 48.66, 48.79}
+	low   = []float64{47.79, 48.14, 48.39, 48.37, 48.24, 48.64, 48.94, 48.86, 49.50, 49.87, 49.20, 48.90, 49.43, 49.73, 49.26, 50.09, 50.30, 49.21, 48.98, 49.61, 49.20, 49.43, 48.08, 47.64, 41.55, 44.28, 47.31, 47.20, 47.90, 47.73}
+	close = []float64{48.16, 48.61, 48.75, 48.63, 48.74, 49.03, 49.07, 49.32, 49.91, 50.13, 49.53, 49.50, 49.75, 50.03, 50.31, 50.52, 50.41, 49.34, 49.37, 50.23, 49.24, 49.93, 48.43, 48.18, 46.57, 45.41, 47.77, 47.72, 48.62, 47.85}
+)
+
+func volume() []float64 {
+	v := make([]float64, len(close))
+	for i := range v {
+		v[i] = float64(1000 + 37*i%211)
+	}
+	return v
+}
+
+func TestIndicators(t *testing.T) {
+	vol := volume()
+	macd := CalculateMACD(closes, 3, 6, 4)
+	stoch := CalculateStochastic(high, low, close, 14, 3)
+	cases := []struct {
+		name   string
+		values []float64
+		index  int
+		want   float64
+	}{
+		{"RSI14 first", CalculateRSI(closes, 14), 14, 70.4641},
+		{"RSI14 second", CalculateRSI(closes, 14), 15, 66.2496},
+		{"RSI14 last", CalculateRSI(closes, 14), 32, 37.7888},
+		{"RSI14 warm-up", CalculateRSI(closes, 14), 13, 0},
+		{"EMA10 seed", CalculateEMA(closes, 10), 9, 44.7790},
+		{"EMA10 next", CalculateEMA(closes, 10), 10, 44.9810},
+		{"EMA10 last", CalculateEMA(closes, 10), 32, 44.1193},
+		{"EMA10 warm-up", CalculateEMA(closes, 10), 8, 0},
+		{"MACD line", pick(macd, func(m MACD) float64 { return m.Line }), 8, 0.4138},
+		{"MACD signal", pick(macd, func(m MACD) float64 { return m.Signal }), 8, 0.3328},
+		{"MACD histogram", pick(macd, func(m MACD) float64 { return m.Histogram }), 8, 0.0809},
+		{"MACD last histogram", pick(macd, func(m MACD) float64 { return m.Histogram }), 32, -0.0025},
+		{"MACD signal warm-up", pick(macd, func(m MACD) float64 { return m.Signal }), 7, 0},
+		{"ATR14 first", CalculateATR(high, low, close, 14), 14, 0.5679},
+		{"ATR14 next", CalculateATR(high, low, close, 14), 15, 0.5616},
+		{"ATR14 last", CalculateATR(high, low, close, 14), 29, 1.3080},
+		{"Stochastic %K", pick(stoch, func(s Stochastic) float64 { return s.K }), 13, 93.3333},
+		{"Stochastic %K last", pick(stoch, func(s Stochastic) float64 { return s.K }), 29, 69.2308},
+		{"Stochastic %D", pick(stoch, func(s Stochastic) float64 { return s.D }), 15, 96.3117},
+		{"Stochastic %D last", pick(stoch, func(s Stochastic) float64 { return s.D }), 29, 71.5751},
+		{"ADX5 first", CalculateADX(high, low, close, 5), 9, 70.8495},
+		{"ADX5 next", CalculateADX(high, low, close, 5), 10, 58.4627},
+		{"ADX5 last", CalculateADX(high, low, close, 5), 29, 49.0622},
+		{"ADX5 warm-up", CalculateADX(high, low, close, 5), 8, 0},
+		{"OBV", CalculateOBV(close, vol), 2, 2111},
+		{"OBV last", CalculateOBV(close, vol), 29, 5141},
+		{"VWAP5 first", CalculateVWAP(high, low, close, vol, 5), 4, 48.5282},
+		{"VWAP5 last", CalculateVWAP(high, low, close, vol, 5), 29, 47.4977},
+		{"VWAP cumulative", CalculateVWAP(high, low, close, vol, 0), 29, 48.9198},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.values[c.index]; math.Abs(got-c.want) > 1e-4 {
+				t.Errorf("value[%d] = %.4f, want %.4f", c.index, got, c.want)
+			}
+		})
+	}
+}
+
+func TestShortInput(t *testing.T) {
+	short := closes[:5]
+	for name, values := range map[string][]float64{
+		"EMA": CalculateEMA(short, 10),
+		"RSI": CalculateRSI(short, 14),
+		"ATR": CalculateATR(short, short, short, 14),
+		"ADX": CalculateADX(short, short, short, 14),
+	} {
+		if len(values) != len(short) {
+			t.Errorf("%s: expected %d values, got %d", name, len(short), len(values))
+		}
+		for _, v := range values {
+			if v != 0 {
+				t.Errorf("%s: expected zeros for a short input, got %v", name, values)
+				break
+			}
+		}
+	}
+}
+
+func pick[T any](values []T, get func(T) float64) []float64 {
+	res := make([]float64, len(values))
+	for i, v := range values {
+		res[i] = get(v)
+	}
+	return res
+}
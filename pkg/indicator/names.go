@@ -0,0 +1,16 @@
+package indicator
+
+// Names of the optional indicators a strategy can add to its candles.
+const (
+	NameEMA   = "EMA"   // EMA12, EMA26
+	NameRSI   = "RSI"   // RSI14
+	NameMACD  = "MACD"  // MACD(12,26,9) line, signal and histogram
+	NameATR   = "ATR"   // ATR14
+	NameStoch = "STOCH" // Stochastic(14,3) %K and %D
+	NameADX   = "ADX"   // ADX14
+	NameOBV   = "OBV"
+	NameVWAP  = "VWAP" // rolling 20 bar VWAP
+)
+
+// Names lists the optional indicators.
+var Names = []string{NameEMA, NameRSI, NameMACD, NameATR, NameStoch, NameADX, NameOBV, NameVWAP}
@@ -0,0 +1,287 @@
+package indicator
+
+import (
+	"math"
+)
+
+// Candle is the input of the streaming indicators. Feed only closed candles, in
+// chronological order.
+type Candle struct {
+	High   float64
+	Low    float64
+	Close  float64
+	Volume float64
+}
+
+// Indicator is a stateful indicator updated one candle at a time. After feeding
+// candles 0..i its Value equals result[i] of the matching Calculate* function,
+// including the zero values during warm-up.
+type Indicator interface {
+	Update(c Candle)
+	Value() float64
+	// Ready reports whether the warm-up period has passed.
+	Ready() bool
+	// Clone returns an independent copy, e.g. to evaluate a candle that has not
+	// closed yet without feeding it.
+	Clone() Indicator
+}
+
+// window is a fixed size ring buffer of the latest values.
+type window struct {
+	values []float64
+	next   int
+	count  int
+}
+
+func newWindow(size int) window {
+	return window{values: make([]float64, size)}
+}
+
+// push adds v and returns the value it evicted, if the window was already full.
+func (w *window) push(v float64) (float64, bool) {
+	old, full := w.values[w.next], w.count == len(w.values)
+	w.values[w.next] = v
+	w.next = (w.next + 1) % len(w.values)
+	if !full {
+		w.count++
+	}
+	return old, full
+}
+
+func (w window) clone() window {
+	w.values = append([]float64(nil), w.values...)
+	return w
+}
+
+func (w *window) full() bool {
+	return w.count == len(w.values)
+}
+
+// each calls fn with the buffered values from oldest to newest.
+func (w *window) each(fn func(v float64)) {
+	start := 0
+	if w.full() {
+		start = w.next
+	}
+	for i := 0; i < w.count; i++ {
+		fn(w.values[(start+i)%len(w.values)])
+	}
+}
+
+// SMA matches CalculateMA, which drops the warm-up: after candle i its Value is
+// result[i-period+1].
+type SMA struct {
+	window window
+	sum    float64
+	value  float64
+}
+
+func NewSMA(period int) *SMA {
+	return &SMA{window: newWindow(period)}
+}
+
+func (s *SMA) Update(c Candle) {
+	if old, evicted := s.window.push(c.Close); evicted {
+		s.sum -= old
+	}
+	s.sum += c.Close
+	if s.window.full() {
+		s.value = s.sum / float64(len(s.window.values))
+	}
+}
+
+func (s *SMA) Value() float64 {
+	return s.value
+}
+
+func (s *SMA) Ready() bool {
+	return s.window.full()
+}
+
+func (s *SMA) Clone() Indicator {
+	clone := *s
+	clone.window = s.window.clone()
+	return &clone
+}
+
+// EMA is seeded with the SMA of the first period closes, like CalculateEMA.
+type EMA struct {
+	period int
+	count  int
+	alpha  float64
+	value  float64
+}
+
+func NewEMA(period int) *EMA {
+	return &EMA{period: period, alpha: 2 / float64(period+1)}
+}
+
+func (e *EMA) Update(c Candle) {
+	e.count++
+	switch {
+	case e.count < e.period:
+		e.value += c.Close
+	case e.count == e.period:
+		e.value = (e.value + c.Close) / float64(e.period)
+	default:
+		e.value += e.alpha * (c.Close - e.value)
+	}
+}
+
+func (e *EMA) Value() float64 {
+	if !e.Ready() {
+		return 0
+	}
+	return e.value
+}
+
+func (e *EMA) Ready() bool {
+	return e.count >= e.period
+}
+
+func (e *EMA) Clone() Indicator {
+	clone := *e
+	return &clone
+}
+
+// BollingerBands keeps the last period closes; Value is the middle band.
+type BollingerBands struct {
+	window window
+	k      float64
+	bands  Bollinger
+}
+
+func NewBollingerBands(period int, k float64) *BollingerBands {
+	return &BollingerBands{window: newWindow(period), k: k}
+}
+
+func (b *BollingerBands) Update(c Candle) {
+	b.window.push(c.Close)
+	if !b.window.full() {
+		return
+	}
+	period := float64(len(b.window.values))
+	sum := 0.0
+	b.window.each(func(v float64) { sum += v })
+	ma := sum / period
+	varianceSum := 0.0
+	b.window.each(func(v float64) { varianceSum += math.Pow(v-ma, 2) })
+	stdDev := math.Sqrt(varianceSum / period)
+	b.bands = Bollinger{
+		Middle: ma,
+		Upper:  ma + (b.k * stdDev),
+		Lower:  ma - (b.k * stdDev),
+	}
+}
+
+func (b *BollingerBands) Value() float64 {
+	return b.bands.Middle
+}
+
+func (b *BollingerBands) Bands() Bollinger {
+	return b.bands
+}
+
+func (b *BollingerBands) Ready() bool {
+	return b.window.full()
+}
+
+func (b *BollingerBands) Clone() Indicator {
+	clone := *b
+	clone.window = b.window.clone()
+	return &clone
+}
+
+// RSI uses Wilder's smoothing like CalculateRSI, so it needs period+1 candles.
+type RSI struct {
+	period    int
+	count     int
+	prevClose float64
+	gain      float64
+	loss      float64
+	value     float64
+}
+
+func NewRSI(period int) *RSI {
+	return &RSI{period: period}
+}
+
+func (r *RSI) Update(c Candle) {
+	r.count++
+	change := c.Close - r.prevClose
+	r.prevClose = c.Close
+	switch {
+	case r.count == 1:
+	case r.count <= r.period+1:
+		if change > 0 {
+			r.gain += change
+		} else {
+			r.loss -= change
+		}
+		if r.count == r.period+1 {
+			r.gain /= float64(r.period)
+			r.loss /= float64(r.period)
+			r.value = rsi(r.gain, r.loss)
+		}
+	default:
+		r.gain = (r.gain*float64(r.period-1) + math.Max(change, 0)) / float64(r.period)
+		r.loss = (r.loss*float64(r.period-1) + math.Max(-change, 0)) / float64(r.period)
+		r.value = rsi(r.gain, r.loss)
+	}
+}
+
+func (r *RSI) Value() float64 {
+	return r.value
+}
+
+func (r *RSI) Ready() bool {
+	return r.count > r.period
+}
+
+func (r *RSI) Clone() Indicator {
+	clone := *r
+	return &clone
+}
+
+// ATR is Wilder's average true range like CalculateATR, so it needs period+1 candles.
+type ATR struct {
+	period    int
+	count     int
+	prevClose float64
+	value     float64
+}
+
+func NewATR(period int) *ATR {
+	return &ATR{period: period}
+}
+
+func (a *ATR) Update(c Candle) {
+	a.count++
+	tr := math.Max(c.High-c.Low, math.Max(math.Abs(c.High-a.prevClose), math.Abs(c.Low-a.prevClose)))
+	a.prevClose = c.Close
+	switch {
+	case a.count == 1:
+	case a.count <= a.period:
+		a.value += tr
+	case a.count == a.period+1:
+		a.value = (a.value + tr) / float64(a.period)
+	default:
+		a.value = (a.value*float64(a.period-1) + tr) / float64(a.period)
+	}
+}
+
+func (a *ATR) Value() float64 {
+	if !a.Ready() {
+		return 0
+	}
+	return a.value
+}
+
+func (a *ATR) Ready() bool {
+	return a.count > a.period
+}
+
+func (a *ATR) Clone() Indicator {
+	clone := *a
+	return &clone
+}
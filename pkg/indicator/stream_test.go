@@ -0,0 +1,45 @@
+package indicator
+
+import (
+	"testing"
+)
+
+func TestStreamMatchesBatch(t *testing.T) {
+	sma := CalculateMA(close, 5)
+	bb := CalculateBollingerBands(close, 20, 2)
+	cases := []struct {
+		name   string
+		stream Indicator
+		batch  []float64
+		warmup int
+	}{
+		{"SMA5", NewSMA(5), append(make([]float64, 4), sma...), 4},
+		{"EMA10", NewEMA(10), CalculateEMA(close, 10), 9},
+		{"BB20", NewBollingerBands(20, 2), nil, 19},
+		{"RSI14", NewRSI(14), CalculateRSI(close, 14), 14},
+		{"ATR14", NewATR(14), CalculateATR(high, low, close, 14), 14},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for i := range close {
+				// a clone is independent of the original
+				peek := c.stream.Clone()
+				peek.Update(Candle{High: high[i] * 2, Low: low[i], Close: close[i] * 2})
+				c.stream.Update(Candle{High: high[i], Low: low[i], Close: close[i]})
+				if c.stream.Ready() != (i >= c.warmup) {
+					t.Fatalf("Ready() = %v at %d", c.stream.Ready(), i)
+				}
+				if b, ok := c.stream.(*BollingerBands); ok {
+					if b.Bands() != bb[i] {
+						t.Fatalf("Bands() = %+v at %d, want %+v", b.Bands(), i, bb[i])
+					}
+					continue
+				}
+				// identical, not just close: both sides perform the same operations
+				if c.stream.Value() != c.batch[i] {
+					t.Fatalf("Value() = %v at %d, want %v", c.stream.Value(), i, c.batch[i])
+				}
+			}
+		})
+	}
+}
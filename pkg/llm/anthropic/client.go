@@ -0,0 +1,151 @@
+package anthropic
+
+import (
+	"context"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/pkg/llm"
+	"log"
+	"resty.dev/v3"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultBaseURL = "https://api.anthropic.com"
+	apiVersion     = "2023-06-01"
+	// output tokens left for the answer on top of the thinking budget
+	answerTokens = 8192
+	// smallest thinking budget accepted by the API
+	minThinkingBudget = 1024
+)
+
+type Client struct {
+	restClient     *resty.Client
+	model          string
+	thinkingBudget int32
+	temperature    *float64
+}
+
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type thinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int32  `json:"budget_tokens"`
+}
+
+type messagesRequest struct {
+	Model       string    `json:"model"`
+	MaxTokens   int32     `json:"max_tokens"`
+	System      string    `json:"system,omitempty"`
+	Messages    []message `json:"messages"`
+	Thinking    *thinking `json:"thinking,omitempty"`
+	Temperature *float64  `json:"temperature,omitempty"`
+}
+
+type messagesResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+}
+
+type errorResponse struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// NewClient creates a Messages API client. baseURL defaults to DefaultBaseURL; a
+// thinkingBudget of 0 disables extended thinking, like gemini.Client's thinkingBudget.
+func NewClient(baseURL, apiKey, model string, thinkingBudget int32) (*Client, error) {
+	if model == "" {
+		return nil, fmt.Errorf("[anthropic.NewClient] model is required")
+	}
+	if thinkingBudget > 0 && thinkingBudget < minThinkingBudget {
+		return nil, fmt.Errorf("[anthropic.NewClient] thinking budget must be at least %d tokens", minThinkingBudget)
+	}
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	restClient := resty.New().
+		SetTimeout(10 * time.Minute).
+		SetBaseURL(strings.TrimRight(baseURL, "/")).
+		SetHeaders(map[string]string{
+			"x-api-key":         apiKey,
+			"anthropic-version": apiVersion,
+		})
+	return &Client{
+		restClient:     restClient,
+		model:          model,
+		thinkingBudget: thinkingBudget,
+	}, nil
+}
+
+// WithTemperature returns a copy of the client sampling at temperature t. The API does not
+// accept a temperature together with extended thinking, so it is ignored, with a warning,
+// while thinking is on.
+func (c *Client) WithTemperature(t float64) *Client {
+	if c.thinkingBudget > 0 {
+		log.Println(fmt.Sprintf("[anthropic.WithTemperature] 已启用思考(预算 %d), API 不接受温度, 忽略温度 %g", c.thinkingBudget, t))
+	}
+	clone := *c
+	clone.temperature = &t
+	return &clone
+}
+
+func (c *Client) Chat(ctx context.Context, messages []llm.Messages) (string, error) {
+	body := &messagesRequest{
+		Model:     c.model,
+		MaxTokens: answerTokens,
+		Messages:  make([]message, 0, len(messages)),
+	}
+	for _, m := range messages {
+		switch m.Role {
+		case llm.RoleSystem:
+			body.System = m.Content
+		case llm.RoleUser, llm.RoleAssistant:
+			body.Messages = append(body.Messages, message{Role: m.Role.ToString(), Content: m.Content})
+		}
+	}
+	if c.thinkingBudget > 0 {
+		body.Thinking = &thinking{Type: "enabled", BudgetTokens: c.thinkingBudget}
+		body.MaxTokens += c.thinkingBudget
+	} else {
+		body.Temperature = c.temperature
+	}
+
+	result := &messagesResponse{}
+	apiErr := &errorResponse{}
+	resp, err := c.restClient.R().
+		WithContext(ctx).
+		SetBody(body).
+		SetResult(result).
+		SetError(apiErr).
+		Post("/v1/messages")
+	if err != nil {
+		return "", fmt.Errorf("[anthropic.Chat] request failed: %w", err)
+	}
+	if resp.IsError() {
+		if apiErr.Error.Message != "" {
+			return "", fmt.Errorf("[anthropic.Chat] status %d: %s: %s", resp.StatusCode(), apiErr.Error.Type, apiErr.Error.Message)
+		}
+		return "", fmt.Errorf("[anthropic.Chat] status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	// thinking blocks are dropped, only the answer is returned
+	var text strings.Builder
+	for _, block := range result.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	if text.Len() == 0 {
+		return "", fmt.Errorf("[anthropic.Chat] empty response, stop reason: %s", result.StopReason)
+	}
+	return text.String(), nil
+}
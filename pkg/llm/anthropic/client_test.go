@@ -0,0 +1,69 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/twoonefour/sigmaflow/pkg/llm"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" || r.Header.Get("x-api-key") != "key" || r.Header.Get("anthropic-version") != apiVersion {
+			t.Errorf("unexpected request %s %v", r.URL.Path, r.Header)
+		}
+		var req messagesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req.System != "be careful" {
+			t.Errorf("system prompt should be top-level, got %q", req.System)
+		}
+		if len(req.Messages) != 1 || req.Messages[0].Role != "user" || req.Messages[0].Content != "analyze" {
+			t.Errorf("unexpected messages: %+v", req.Messages)
+		}
+		if req.Thinking == nil || req.Thinking.Type != "enabled" || req.Thinking.BudgetTokens != 2048 {
+			t.Errorf("unexpected thinking: %+v", req.Thinking)
+		}
+		if req.MaxTokens <= req.Thinking.BudgetTokens {
+			t.Errorf("max_tokens %d must exceed the thinking budget", req.MaxTokens)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"thinking","thinking":"hmm"},{"type":"text","text":"{\"action\":\"BUY\"}"}],"stop_reason":"end_turn"}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, "key", "claude-sonnet-4-5", 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Chat(context.Background(), []llm.Messages{
+		{Role: llm.RoleSystem, Content: "be careful"},
+		{Role: llm.RoleUser, Content: "analyze"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != `{"action":"BUY"}` {
+		t.Errorf("unexpected content %q", res)
+	}
+}
+
+func TestChatError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`))
+	}))
+	defer server.Close()
+
+	client, _ := NewClient(server.URL, "key", "claude-sonnet-4-5", 0)
+	if _, err := client.Chat(context.Background(), []llm.Messages{{Role: llm.RoleUser, Content: "hi"}}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := NewClient("", "key", "claude-sonnet-4-5", 100); err == nil {
+		t.Fatal("expected budget validation error")
+	}
+}
@@ -10,6 +10,7 @@ type Client struct {
 	client         *genai.Client
 	model          string
 	thinkingBudget *int32
+	temperature    *float32
 }
 
 func NewClient(apiKey string, model string, thinkingBudget int32) (*Client, error) {
@@ -22,7 +23,15 @@ func NewClient(apiKey string, model string, thinkingBudget int32) (*Client, erro
 		return nil, err
 	}
 
-	return &Client{client, model, &thinkingBudget}, nil
+	return &Client{client: client, model: model, thinkingBudget: &thinkingBudget}, nil
+}
+
+// WithTemperature returns a copy of the client sampling at temperature t.
+func (c *Client) WithTemperature(t float64) *Client {
+	temperature := float32(t)
+	clone := *c
+	clone.temperature = &temperature
+	return &clone
 }
 
 func (c *Client) Chat(ctx context.Context, messages []llm.Messages) (string, error) {
@@ -48,7 +57,7 @@ func (c *Client) Chat(ctx context.Context, messages []llm.Messages) (string, err
 			})
 		}
 	}
-	config := &genai.GenerateContentConfig{}
+	config := &genai.GenerateContentConfig{Temperature: c.temperature}
 	config.ThinkingConfig = &genai.ThinkingConfig{
 		ThinkingBudget: c.thinkingBudget,
 	}
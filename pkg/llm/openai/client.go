@@ -0,0 +1,116 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"github.com/twoonefour/sigmaflow/pkg/llm"
+	"resty.dev/v3"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the OpenAI endpoint. Any server speaking /v1/chat/completions
+// (DeepSeek, vLLM, llama.cpp, Ollama, ...) can be used instead.
+const DefaultBaseURL = "https://api.openai.com/v1"
+
+type Client struct {
+	restClient  *resty.Client
+	model       string
+	jsonMode    bool
+	temperature *float64
+}
+
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type responseFormat struct {
+	Type string `json:"type"`
+}
+
+type chatRequest struct {
+	Model          string          `json:"model"`
+	Messages       []message       `json:"messages"`
+	Temperature    *float64        `json:"temperature,omitempty"`
+	ResponseFormat *responseFormat `json:"response_format,omitempty"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message      message `json:"message"`
+		FinishReason string  `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+type errorResponse struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+// NewClient creates a chat completions client. baseURL defaults to DefaultBaseURL and apiKey
+// may be empty for local servers. jsonMode requests response_format json_object.
+func NewClient(baseURL, apiKey, model string, jsonMode bool) (*Client, error) {
+	if model == "" {
+		return nil, fmt.Errorf("[openai.NewClient] model is required")
+	}
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	restClient := resty.New().
+		SetTimeout(5 * time.Minute).
+		SetBaseURL(strings.TrimRight(baseURL, "/"))
+	if apiKey != "" {
+		restClient.SetAuthToken(apiKey)
+	}
+	return &Client{
+		restClient: restClient,
+		model:      model,
+		jsonMode:   jsonMode,
+	}, nil
+}
+
+// WithTemperature returns a copy of the client sampling at temperature t.
+func (c *Client) WithTemperature(t float64) *Client {
+	clone := *c
+	clone.temperature = &t
+	return &clone
+}
+
+func (c *Client) Chat(ctx context.Context, messages []llm.Messages) (string, error) {
+	body := &chatRequest{
+		Model:       c.model,
+		Messages:    make([]message, 0, len(messages)),
+		Temperature: c.temperature,
+	}
+	for _, m := range messages {
+		body.Messages = append(body.Messages, message{Role: m.Role.ToString(), Content: m.Content})
+	}
+	if c.jsonMode {
+		body.ResponseFormat = &responseFormat{Type: "json_object"}
+	}
+
+	result := &chatResponse{}
+	apiErr := &errorResponse{}
+	resp, err := c.restClient.R().
+		WithContext(ctx).
+		SetBody(body).
+		SetResult(result).
+		SetError(apiErr).
+		Post("/chat/completions")
+	if err != nil {
+		return "", fmt.Errorf("[openai.Chat] request failed: %w", err)
+	}
+	if resp.IsError() {
+		if apiErr.Error.Message != "" {
+			return "", fmt.Errorf("[openai.Chat] status %d: %s", resp.StatusCode(), apiErr.Error.Message)
+		}
+		return "", fmt.Errorf("[openai.Chat] status %d: %s", resp.StatusCode(), resp.String())
+	}
+	if len(result.Choices) == 0 {
+		return "", fmt.Errorf("[openai.Chat] empty response")
+	}
+	return result.Choices[0].Message.Content, nil
+}
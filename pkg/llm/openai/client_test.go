@@ -0,0 +1,60 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/twoonefour/sigmaflow/pkg/llm"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("unexpected request %s %s", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		var req chatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req.Model != "deepseek-chat" || req.ResponseFormat == nil || req.ResponseFormat.Type != "json_object" {
+			t.Errorf("unexpected body: %+v", req)
+		}
+		if len(req.Messages) != 2 || req.Messages[0].Role != "system" || req.Messages[1].Role != "user" {
+			t.Errorf("unexpected messages: %+v", req.Messages)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"{\"action\":\"HOLD\"}"},"finish_reason":"stop"}]}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL+"/v1/", "key", "deepseek-chat", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Chat(context.Background(), []llm.Messages{
+		{Role: llm.RoleSystem, Content: "system"},
+		{Role: llm.RoleUser, Content: "user"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != `{"action":"HOLD"}` {
+		t.Errorf("unexpected content %q", res)
+	}
+}
+
+func TestChatError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"message":"invalid api key","type":"invalid_request_error"}}`))
+	}))
+	defer server.Close()
+
+	client, _ := NewClient(server.URL, "", "gpt-4o", false)
+	if _, err := client.Chat(context.Background(), nil); err == nil {
+		t.Fatal("expected an error")
+	}
+}